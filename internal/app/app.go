@@ -2,9 +2,12 @@ package app
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/jdecool/file-compressor/internal/compressor"
@@ -66,12 +69,12 @@ func (a *Application) SetReplaceOriginal(replace bool) {
 	a.replaceOriginal = replace
 }
 
-func (a *Application) replaceOriginalFile(originalPath, compressedPath string) error {
+func (a *Application) replaceOriginalFile(originalPath, compressedPath, replacedPath string) error {
 	if err := os.Remove(originalPath); err != nil {
 		return fmt.Errorf("failed to remove original file: %v", err)
 	}
 
-	if err := os.Rename(compressedPath, originalPath); err != nil {
+	if err := os.Rename(compressedPath, replacedPath); err != nil {
 		return fmt.Errorf("failed to rename compressed file: %v", err)
 	}
 
@@ -169,14 +172,39 @@ func (a *Application) compressFile(path string, info os.FileInfo) error {
 			return fmt.Errorf("failed to compress file %s: %v", path, err)
 		}
 
+		if result.Skipped != "" {
+			a.logger.Printf("Skipped file %s: %s\n", path, result.Skipped)
+			a.compressionResults = append(a.compressionResults, result)
+
+			return nil
+		}
+
 		a.logger.PrintfVerbose("Compressed file %s using %T compressor. Original: %d bytes, Compressed: %d bytes, Savings: %s\n",
 			path, compressor, result.OriginalSize, result.CompressedSize, result.SavingsPercentageAsHumanReadable())
+		if result.SSIM > 0 {
+			a.logger.PrintfVerbose("Encoded file %s with quality %d, SSIM: %.4f\n", path, result.Quality, result.SSIM)
+		}
+		if len(result.Removed) > 0 {
+			a.logger.PrintfVerbose("Removed from file %s: %s\n", path, strings.Join(result.Removed, ", "))
+		}
+		if result.FontSavings > 0 {
+			a.logger.PrintfVerbose("Saved %s on fonts of file %s\n", formatSize(result.FontSavings), path)
+		}
+		if result.Linearized {
+			a.logger.PrintfVerbose("Linearized file %s for fast web view\n", path)
+		}
+		if len(result.Precompressed) > 0 {
+			a.logger.PrintfVerbose("Precompressed file %s: %s\n", path, strings.Join(result.Precompressed, ", "))
+		}
 
 		// Store the compression result for summary
 		a.compressionResults = append(a.compressionResults, result)
 
-		if a.replaceOriginal && result.IsPositiveSavings() {
-			if err := a.replaceOriginalFile(path, outputPath); err != nil {
+		// Compressors changing the file format append its extension to the paths
+		compressedPath := outputPath + result.Extension
+		// Precompressed files are served next to the original file
+		if a.replaceOriginal && result.IsPositiveSavings() && len(result.Precompressed) == 0 {
+			if err := a.replaceOriginalFile(path, compressedPath, path+result.Extension); err != nil {
 				return fmt.Errorf("failed to replace original file %s: %v", path, err)
 			}
 
@@ -184,7 +212,7 @@ func (a *Application) compressFile(path string, info os.FileInfo) error {
 		}
 
 		if a.replaceOriginal {
-			_ = os.Remove(outputPath)
+			_ = os.Remove(compressedPath)
 		}
 	} else {
 		a.logger.PrintfVerbose("No compressor found for file: %s\n", path)
@@ -204,10 +232,18 @@ func (a *Application) printOperationSummary() {
 	var totalCompressedSize int64
 	var successfulCompressions int
 	var totalSavings int64
+	var totalFontSavings int64
+	skipped := map[string]int{}
 
 	for _, result := range a.compressionResults {
+		if result.Skipped != "" {
+			skipped[result.Skipped]++
+			continue
+		}
+
 		totalOriginalSize += result.OriginalSize
 		totalCompressedSize += result.CompressedSize
+		totalFontSavings += result.FontSavings
 		if result.IsPositiveSavings() {
 			successfulCompressions++
 			totalSavings += result.OriginalSize - result.CompressedSize
@@ -217,6 +253,9 @@ func (a *Application) printOperationSummary() {
 	fmt.Println("\n=== Operation Summary ===")
 	fmt.Printf("Total files processed: %d\n", totalFiles)
 	fmt.Printf("Successfully compressed: %d\n", successfulCompressions)
+	for _, reason := range slices.Sorted(maps.Keys(skipped)) {
+		fmt.Printf("Skipped (%s): %d\n", reason, skipped[reason])
+	}
 	fmt.Printf("Total original size: %s\n", formatSize(totalOriginalSize))
 	fmt.Printf("Total compressed size: %s\n", formatSize(totalCompressedSize))
 
@@ -226,6 +265,9 @@ func (a *Application) printOperationSummary() {
 	} else {
 		fmt.Println("No space savings achieved.")
 	}
+	if totalFontSavings > 0 {
+		fmt.Printf("Saved on fonts: %s\n", formatSize(totalFontSavings))
+	}
 }
 
 func formatSize(size int64) string {
@@ -16,8 +16,12 @@ import (
 
 // MockCompressor is a test compressor that simulates compression
 type MockCompressor struct {
-	mimeType string
-	success  bool
+	mimeType  string
+	success   bool
+	skipped   string
+	extension string
+	// precompressed writes a .gz sibling instead of the output file
+	precompressed bool
 }
 
 func (m *MockCompressor) GetSupportedMimeTypes() []string {
@@ -31,6 +35,10 @@ func (m *MockCompressor) CompressFile(inputPath, outputPath string) (*compressor
 		return nil, fmt.Errorf("mock compression failed")
 	}
 
+	if m.skipped != "" {
+		return &compressor.CompressionResult{OriginalFile: inputPath, Skipped: m.skipped}, nil
+	}
+
 	// Read input file to get size
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
@@ -43,8 +51,12 @@ func (m *MockCompressor) CompressFile(inputPath, outputPath string) (*compressor
 		return nil, err
 	}
 
+	if m.precompressed {
+		outputPath = inputPath + ".gz"
+	}
+
 	// Create output file (simulate compression by copying with smaller size)
-	outputFile, err := os.Create(outputPath)
+	outputFile, err := os.Create(outputPath + m.extension)
 	if err != nil {
 		return nil, err
 	}
@@ -60,10 +72,16 @@ func (m *MockCompressor) CompressFile(inputPath, outputPath string) (*compressor
 	originalSize := inputStats.Size()
 	compressedSize := originalSize / 2
 
-	return &compressor.CompressionResult{
+	result := &compressor.CompressionResult{
 		OriginalSize:    originalSize,
 		CompressedSize: compressedSize,
-	}, nil
+		Extension:      m.extension,
+	}
+	if m.precompressed {
+		result.Precompressed = []string{outputPath}
+	}
+
+	return result, nil
 }
 
 func TestNewApplication(t *testing.T) {
@@ -210,7 +228,7 @@ func TestReplaceOriginalFile(t *testing.T) {
 	}
 
 	app := NewApplication()
-	err = app.replaceOriginalFile(originalPath, compressedPath)
+	err = app.replaceOriginalFile(originalPath, compressedPath, originalPath)
 	if err != nil {
 		t.Fatalf("replaceOriginalFile failed: %v", err)
 	}
@@ -235,7 +253,7 @@ func TestReplaceOriginalFileErrorHandling(t *testing.T) {
 	app := NewApplication()
 
 	// Test with non-existent original file
-	err := app.replaceOriginalFile("/non/existent/path", "/another/path")
+	err := app.replaceOriginalFile("/non/existent/path", "/another/path", "/non/existent/path")
 	if err == nil {
 		t.Error("Expected error for non-existent original file")
 	}
@@ -254,7 +272,7 @@ func TestReplaceOriginalFileErrorHandling(t *testing.T) {
 	}
 
 	// Test with non-existent compressed file
-	err = app.replaceOriginalFile(originalPath, "/non/existent/compressed")
+	err = app.replaceOriginalFile(originalPath, "/non/existent/compressed", originalPath)
 	if err == nil {
 		t.Error("Expected error for non-existent compressed file")
 	}
@@ -460,6 +478,112 @@ func TestCompressFileReplaceOriginal(t *testing.T) {
 	}
 }
 
+func TestCompressFileReplaceOriginalWithExtension(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("This is the original content that should be replaced"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	app := NewApplication()
+	app.SetReplaceOriginal(true)
+	mockCompressor := &MockCompressor{mimeType: "text/plain", success: true, extension: ".gz"}
+	app.RegisterCompressor(mockCompressor)
+
+	fileInfo, err := os.Stat(testFile)
+	if err != nil {
+		t.Fatalf("Failed to get file info: %v", err)
+	}
+
+	if err := app.compressFile(testFile, fileInfo); err != nil {
+		t.Errorf("compressFile failed: %v", err)
+	}
+
+	// The original file is replaced by the file with the extension
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Error("Original file should have been removed")
+	}
+	if _, err := os.Stat(testFile + ".gz"); err != nil {
+		t.Errorf("Replaced file should exist: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "compressed_test.txt.gz")); !os.IsNotExist(err) {
+		t.Error("Compressed file should have been renamed")
+	}
+}
+
+func TestCompressFilePrecompressed(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testFile := filepath.Join(tempDir, "app.css")
+	originalContent := []byte("body { margin: 0; }")
+	if err := os.WriteFile(testFile, originalContent, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	app := NewApplication()
+	app.SetReplaceOriginal(true)
+	mockCompressor := &MockCompressor{mimeType: "text/css", success: true, precompressed: true}
+	app.RegisterCompressor(mockCompressor)
+
+	fileInfo, err := os.Stat(testFile)
+	if err != nil {
+		t.Fatalf("Failed to get file info: %v", err)
+	}
+
+	if err := app.compressFile(testFile, fileInfo); err != nil {
+		t.Errorf("compressFile failed: %v", err)
+	}
+
+	// The original file is kept next to its precompressed version, even in replace mode
+	finalContent, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read final file: %v", err)
+	}
+	if !bytes.Equal(finalContent, originalContent) {
+		t.Error("Precompressed file should be left untouched")
+	}
+	if _, err := os.Stat(testFile + ".gz"); err != nil {
+		t.Errorf("Precompressed file should exist: %v", err)
+	}
+}
+
+func TestCompressFileSkipped(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testFile := filepath.Join(tempDir, "test.txt")
+	originalContent := []byte("This is content that cannot be compressed")
+	if err := os.WriteFile(testFile, originalContent, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	app := NewApplication()
+	app.SetReplaceOriginal(true)
+	mockCompressor := &MockCompressor{mimeType: "text/plain", success: true, skipped: compressor.SkippedEncrypted}
+	app.RegisterCompressor(mockCompressor)
+
+	fileInfo, err := os.Stat(testFile)
+	if err != nil {
+		t.Fatalf("Failed to get file info: %v", err)
+	}
+
+	if err := app.compressFile(testFile, fileInfo); err != nil {
+		t.Errorf("compressFile failed: %v", err)
+	}
+
+	finalContent, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read final file: %v", err)
+	}
+	if string(finalContent) != string(originalContent) {
+		t.Error("Skipped file should be left untouched")
+	}
+
+	if len(app.compressionResults) != 1 || app.compressionResults[0].Skipped != compressor.SkippedEncrypted {
+		t.Errorf("Expected one result skipped as encrypted, got %v", app.compressionResults)
+	}
+}
+
 // Test the worker function
 func TestWorker(t *testing.T) {
 	// Create a temporary directory
@@ -1,6 +1,10 @@
 package compressor
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 type Compressor interface {
 	CompressFile(filePath string, outputPath string) (*CompressionResult, error)
@@ -13,8 +17,32 @@ type CompressionResult struct {
 	CompressedFile string
 	OriginalSize   int64
 	CompressedSize int64
+	// Quality is the encoder quality of a lossy output, 0 when not applicable
+	Quality int
+	// SSIM is the similarity of the output to the source when a target SSIM was requested, 0 otherwise
+	SSIM float64
+	// Removed describes the content removed from a document, such as "3 page thumbnails"
+	Removed []string
+	// FontSavings is the number of bytes saved on the fonts of a document
+	FontSavings int64
+	// Linearized reports whether a PDF document was written for fast web view
+	Linearized bool
+	// Extension is appended to the output path by the compressor, and to the
+	// original path when the output replaces it, such as ".gz"
+	Extension string
+	// Precompressed lists the files written next to the original file, which is kept
+	Precompressed []string
+	// Skipped is the reason a file was left untouched, without output, empty when it was compressed
+	Skipped string
 }
 
+// Reasons for skipping a file
+const (
+	SkippedEncrypted        = "encrypted"
+	SkippedValidationFailed = "validation failed"
+	SkippedIncompressible   = "not compressible"
+)
+
 func (r *CompressionResult) SavingsPercentage() float64 {
 	if r.OriginalSize == 0 {
 		return 0.0
@@ -35,20 +63,49 @@ func (r *CompressionResult) SavedSizeAsHumanReadable() string {
 		return "0 B"
 	}
 
+	return formatSize(savedSize)
+}
+
+func (r *CompressionResult) IsPositiveSavings() bool {
+	return r.CompressedSize < r.OriginalSize
+}
+
+func formatSize(size int64) string {
 	const unit = 1024
-	if savedSize < unit {
-		return fmt.Sprintf("%d B", savedSize)
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
 	}
 
 	div, exp := int64(unit), 0
-	for n := savedSize / unit; n >= unit; n /= unit {
+	for n := size / unit; n >= unit; n /= unit {
 		div *= unit
 		exp++
 	}
 
-	return fmt.Sprintf("%.2f %cB", float64(savedSize)/float64(div), "KMGTPE"[exp])
+	return fmt.Sprintf("%.2f %cB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
-func (r *CompressionResult) IsPositiveSavings() bool {
-	return r.CompressedSize < r.OriginalSize
+// ParseSize parses a size such as "200KB" or "1.5 MB" into bytes. Units are
+// powers of 1024, and a size without unit is in bytes.
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+
+	multiplier := int64(1)
+	for i, unit := range []string{"KB", "MB", "GB"} {
+		if strings.HasSuffix(value, unit) {
+			multiplier = int64(1) << (10 * (i + 1))
+			value = strings.TrimSuffix(value, unit)
+			break
+		}
+	}
+	if multiplier == 1 {
+		value = strings.TrimSuffix(value, "B")
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(number * float64(multiplier)), nil
 }
@@ -156,3 +156,33 @@ func TestSavedSizeAsHumanReadable(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{name: "Bytes without unit", input: "512", want: 512},
+		{name: "Bytes", input: "512B", want: 512},
+		{name: "Kilobytes", input: "200KB", want: 200 * 1024},
+		{name: "Lowercase with space", input: "1.5 mb", want: 1536 * 1024},
+		{name: "Gigabytes", input: "2GB", want: 2 * 1024 * 1024 * 1024},
+		{name: "Invalid number", input: "largeKB", wantErr: true},
+		{name: "Negative size", input: "-1KB", wantErr: true},
+		{name: "Empty", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
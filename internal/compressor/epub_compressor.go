@@ -0,0 +1,168 @@
+package compressor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+)
+
+const (
+	epubContainer  = "META-INF/container.xml"
+	epubEncryption = "META-INF/encryption.xml"
+)
+
+// EpubCompressor compresses EPUB books: their images are compressed by the
+// image compressor, their TrueType and OpenType fonts subset to the characters
+// of the book and their other entries, such as XHTML documents and style
+// sheets, deflated at the best compression level. The mimetype entry is
+// written first and uncompressed, and the resources listed as encrypted, such
+// as obfuscated fonts, are copied as is.
+type EpubCompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	imageCompressor    Compressor
+}
+
+func NewEpubCompressor() *EpubCompressor {
+	return &EpubCompressor{
+		supportedMimeTypes: []string{"application/epub+zip"},
+		logger:             logger.NewLogger(false),
+		imageCompressor:    NewImageCompressor(),
+	}
+}
+
+func (ec *EpubCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	container := &zipContainer{
+		name:             "EPUB",
+		encryptedEntries: epubEncryptedEntries,
+		validate:         validateEPUBRootFiles,
+		fontCharacters:   epubFontCharacters,
+		imageCompressor:  ec.imageCompressor,
+		logger:           ec.logger,
+	}
+
+	return container.compressFile(filePath, outputPath)
+}
+
+// epubTextExtensions are the extensions of the documents, style sheets and
+// navigation files of a book, whose characters its fonts draw
+var epubTextExtensions = []string{".xhtml", ".html", ".htm", ".svg", ".css", ".ncx", ".opf", ".xml"}
+
+// epubFontCharacters returns the characters the fonts of a book draw, nil when
+// they cannot be known: scripts may write any text, and encrypted documents or
+// documents which are not UTF-8 cannot be read
+func epubFontCharacters(r *zip.Reader, encrypted map[string]bool) (map[rune]bool, error) {
+	characters := newPackageFontCharacters()
+	for _, f := range r.File {
+		ext := strings.ToLower(path.Ext(f.Name))
+		if ext == ".js" {
+			return nil, nil
+		}
+		if !slices.Contains(epubTextExtensions, ext) {
+			continue
+		}
+		if encrypted[f.Name] {
+			return nil, nil
+		}
+
+		data, err := readZipEntry(f)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(data) || bytes.Contains(bytes.ToLower(data), []byte("<script")) {
+			return nil, nil
+		}
+
+		addTextCharacters(characters, string(data))
+	}
+
+	return characters, nil
+}
+
+// epubEncryptedEntries returns the resources referenced by the encryption data
+// of a book, which obfuscated fonts are listed in
+func epubEncryptedEntries(r *zip.Reader) (map[string]bool, error) {
+	encrypted := map[string]bool{}
+
+	f, err := r.Open(epubEncryption)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return encrypted, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var encryption struct {
+		CipherReferences []struct {
+			URI string `xml:"URI,attr"`
+		} `xml:"EncryptedData>CipherData>CipherReference"`
+	}
+	if err := xml.NewDecoder(f).Decode(&encryption); err != nil {
+		return nil, fmt.Errorf("%s: %v", epubEncryption, err)
+	}
+
+	for _, reference := range encryption.CipherReferences {
+		name, err := url.PathUnescape(reference.URI)
+		if err != nil {
+			name = reference.URI
+		}
+		encrypted[strings.TrimPrefix(name, "/")] = true
+	}
+
+	return encrypted, nil
+}
+
+// validateEPUBRootFiles checks that the container file of a book parses and
+// that the package documents it lists exist
+func validateEPUBRootFiles(r *zip.Reader) error {
+	f, err := r.Open(epubContainer)
+	if err != nil {
+		return fmt.Errorf("%s: %v", epubContainer, err)
+	}
+	defer f.Close()
+
+	var container struct {
+		RootFiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.NewDecoder(f).Decode(&container); err != nil {
+		return fmt.Errorf("%s: %v", epubContainer, err)
+	}
+
+	if len(container.RootFiles) == 0 {
+		return fmt.Errorf("%s lists no package document", epubContainer)
+	}
+
+	for _, rootFile := range container.RootFiles {
+		if _, err := fs.Stat(r, rootFile.FullPath); err != nil {
+			return fmt.Errorf("package document %s: %v", rootFile.FullPath, err)
+		}
+	}
+
+	return nil
+}
+
+func (ec *EpubCompressor) GetSupportedMimeTypes() []string {
+	return ec.supportedMimeTypes
+}
+
+func (ec *EpubCompressor) SetLogger(logger *logger.Logger) {
+	ec.logger = logger
+}
+
+// SetImageCompressor sets the compressor of the images embedded in books
+func (ec *EpubCompressor) SetImageCompressor(imageCompressor Compressor) {
+	ec.imageCompressor = imageCompressor
+}
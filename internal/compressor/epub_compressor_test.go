@@ -0,0 +1,163 @@
+package compressor
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const testEPUBContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="EPUB/package.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testEPUBEncryption = `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.idpf.org/2008/embedding"/>
+    <enc:CipherData><enc:CipherReference URI="EPUB/fonts/Book%20Font.otf"/></enc:CipherData>
+  </enc:EncryptedData>
+</encryption>`
+
+func TestEpubCompressor_CompressFile(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "guide.epub")
+	outputPath := filepath.Join(tempDir, "compressed_guide.epub")
+	chapter := []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><body>` + strings.Repeat("<p>Chapter text</p>\n", 200) + `</body></html>`)
+	obfuscated := bytes.Repeat([]byte{0x4f, 0x54, 0x54, 0x4f}, 300)
+	cover := testUncompressedPNG(t)
+
+	writeTestZipPackage(t, inputPath, []testZipEntry{
+		{"mimetype", []byte("application/epub+zip")},
+		{"META-INF/container.xml", []byte(testEPUBContainer)},
+		{"META-INF/encryption.xml", []byte(testEPUBEncryption)},
+		{"EPUB/package.opf", []byte(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0"/>`)},
+		{"EPUB/chapter1.xhtml", chapter},
+		{"EPUB/images/cover.png", cover},
+		{"EPUB/fonts/Book Font.otf", obfuscated},
+		{"EPUB/fonts/Go.ttf", goregular.TTF},
+	})
+
+	result, err := NewEpubCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Less(t, result.CompressedSize, result.OriginalSize)
+	assertZipContainerMimetype(t, outputPath, "application/epub+zip")
+
+	r, err := zip.OpenReader(outputPath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Len(t, r.File, 8)
+	for _, f := range r.File {
+		data, err := readZipEntry(f)
+		require.NoError(t, err)
+
+		switch f.Name {
+		case "EPUB/chapter1.xhtml":
+			assert.Equal(t, zip.Deflate, f.Method)
+			assert.Equal(t, chapter, data)
+		case "EPUB/images/cover.png":
+			assert.Less(t, len(data), len(cover))
+		case "EPUB/fonts/Book Font.otf":
+			// Obfuscated fonts are copied as is
+			assert.Equal(t, zip.Store, f.Method)
+			assert.Equal(t, obfuscated, data)
+		case "EPUB/fonts/Go.ttf":
+			assert.Less(t, len(data), len(goregular.TTF)/2)
+			font, err := parseTrueType(data)
+			require.NoError(t, err)
+			unicode := font.cmap(3, 1)
+			assert.NotEmpty(t, font.glyph(int(unicode['x'])))
+			assert.Empty(t, font.glyph(int(unicode['Ω'])))
+		}
+	}
+}
+
+func TestEpubCompressor_CompressFileValidationFailed(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "guide.epub")
+	outputPath := filepath.Join(tempDir, "compressed_guide.epub")
+
+	// The package document listed by the container is missing
+	writeTestZipPackage(t, inputPath, []testZipEntry{
+		{"mimetype", []byte("application/epub+zip")},
+		{"META-INF/container.xml", []byte(testEPUBContainer)},
+	})
+
+	result, err := NewEpubCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, SkippedValidationFailed, result.Skipped)
+	assert.NoFileExists(t, outputPath)
+}
+
+func TestEpubEncryptedEntries(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "guide.epub")
+	writeTestZipPackage(t, filePath, []testZipEntry{{"META-INF/encryption.xml", []byte(testEPUBEncryption)}})
+
+	r, err := zip.OpenReader(filePath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	encrypted, err := epubEncryptedEntries(&r.Reader)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"EPUB/fonts/Book Font.otf": true}, encrypted)
+
+	// Books without encryption data have no encrypted resource
+	writeTestZipPackage(t, filePath, []testZipEntry{{"mimetype", []byte("application/epub+zip")}})
+
+	r, err = zip.OpenReader(filePath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	encrypted, err = epubEncryptedEntries(&r.Reader)
+	require.NoError(t, err)
+	assert.Empty(t, encrypted)
+}
+
+func TestEpubFontCharacters(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "guide.epub")
+	writeTestZipPackage(t, filePath, []testZipEntry{
+		{"EPUB/chapter1.xhtml", []byte(`<p>caf&#233; &mdash; ß</p>`)},
+		{"EPUB/style.css", []byte(`q::before { content: "\201C" }`)},
+		{"EPUB/fonts/font.otf", []byte("Ω")},
+	})
+
+	r, err := zip.OpenReader(filePath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	characters, err := epubFontCharacters(&r.Reader, nil)
+	require.NoError(t, err)
+	for _, c := range "éÉ—“ßẞAz" {
+		assert.True(t, characters[c], "character %q", c)
+	}
+	assert.False(t, characters['Ω'])
+
+	// Encrypted documents cannot be read
+	characters, err = epubFontCharacters(&r.Reader, map[string]bool{"EPUB/chapter1.xhtml": true})
+	require.NoError(t, err)
+	assert.Nil(t, characters)
+
+	// Scripts may write any text
+	writeTestZipPackage(t, filePath, []testZipEntry{
+		{"EPUB/chapter1.xhtml", []byte(`<p>Text</p><script>document.write("Ω")</script>`)},
+	})
+
+	r, err = zip.OpenReader(filePath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	characters, err = epubFontCharacters(&r.Reader, nil)
+	require.NoError(t, err)
+	assert.Nil(t, characters)
+}
@@ -0,0 +1,162 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// EXIF tags rewritten when an image is re-encoded
+const (
+	exifTagImageWidth      = 0x0100
+	exifTagImageLength     = 0x0101
+	exifTagOrientation     = 0x0112
+	exifTagExifIFDPointer  = 0x8769
+	exifTagPixelXDimension = 0xa002
+	exifTagPixelYDimension = 0xa003
+)
+
+// EXIF field types
+const (
+	exifTypeASCII = 2
+	exifTypeShort = 3
+	exifTypeLong  = 4
+)
+
+// exifTIFF returns the TIFF structure held by an EXIF segment and its byte order
+func exifTIFF(segment []byte) ([]byte, binary.ByteOrder, error) {
+	const headerLen = 6 // "Exif\x00\x00"
+
+	if len(segment) < headerLen+8 || string(segment[0:4]) != "Exif" {
+		return nil, nil, fmt.Errorf("not an EXIF segment")
+	}
+
+	tiff := segment[headerLen:]
+	switch string(tiff[0:2]) {
+	case "II":
+		return tiff, binary.LittleEndian, nil
+	case "MM":
+		return tiff, binary.BigEndian, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid TIFF byte order")
+	}
+}
+
+// exifOrientation returns the EXIF Orientation tag, 1 (upright) when missing or invalid
+func exifOrientation(exifData *exif.Exif) int {
+	if exifData == nil {
+		return 1
+	}
+
+	tag, err := exifData.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+
+	return orientation
+}
+
+// orientImage transforms the pixels of an image so that it is displayed
+// upright without applying its EXIF orientation
+func orientImage(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
+}
+
+// updateEXIFSegment returns a copy of an EXIF APP1 segment whose dimension
+// tags match the given size and, when resetOrientation is set, whose
+// orientation is upright. Tags are patched in place so that offsets inside
+// the segment stay valid.
+func updateEXIFSegment(segment []byte, width, height int, resetOrientation bool) ([]byte, error) {
+	updated := make([]byte, len(segment))
+	copy(updated, segment)
+
+	tiff, order, err := exifTIFF(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[uint16]uint32{
+		exifTagImageWidth:      uint32(width),
+		exifTagImageLength:     uint32(height),
+		exifTagPixelXDimension: uint32(width),
+		exifTagPixelYDimension: uint32(height),
+	}
+	if resetOrientation {
+		values[exifTagOrientation] = 1
+	}
+
+	// IFD0 is followed by the Exif sub-IFD, if any
+	ifds := []uint32{order.Uint32(tiff[4:8])}
+	visited := map[uint32]bool{}
+	for len(ifds) > 0 {
+		offset := ifds[0]
+		ifds = ifds[1:]
+		if visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		if int(offset)+2 > len(tiff) {
+			return nil, fmt.Errorf("invalid IFD offset %d", offset)
+		}
+
+		count := int(order.Uint16(tiff[offset : offset+2]))
+		entries := int(offset) + 2
+		if entries+count*12 > len(tiff) {
+			return nil, fmt.Errorf("truncated IFD at offset %d", offset)
+		}
+
+		for i := 0; i < count; i++ {
+			entry := tiff[entries+i*12 : entries+(i+1)*12]
+			tag := order.Uint16(entry[0:2])
+			fieldType := order.Uint16(entry[2:4])
+			if order.Uint32(entry[4:8]) != 1 {
+				continue
+			}
+
+			if tag == exifTagExifIFDPointer && fieldType == exifTypeLong {
+				ifds = append(ifds, order.Uint32(entry[8:12]))
+				continue
+			}
+
+			value, exists := values[tag]
+			if !exists {
+				continue
+			}
+
+			switch fieldType {
+			case exifTypeShort:
+				order.PutUint16(entry[8:10], uint16(value))
+			case exifTypeLong:
+				order.PutUint32(entry[8:12], value)
+			}
+		}
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,188 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createEXIFSegment builds an EXIF APP1 payload with an orientation in IFD0
+// and pixel dimensions in the Exif sub-IFD
+func createEXIFSegment(order binary.ByteOrder, orientation, width, height int) []byte {
+	tiff := make([]byte, 8+2+2*12+4+2+2*12+4)
+	if order == binary.LittleEndian {
+		copy(tiff[0:2], "II")
+	} else {
+		copy(tiff[0:2], "MM")
+	}
+	order.PutUint16(tiff[2:4], 42)
+	order.PutUint32(tiff[4:8], 8)
+
+	writeEntry := func(offset int, tag, fieldType uint16, value uint32) {
+		order.PutUint16(tiff[offset:], tag)
+		order.PutUint16(tiff[offset+2:], fieldType)
+		order.PutUint32(tiff[offset+4:], 1)
+		if fieldType == exifTypeShort {
+			order.PutUint16(tiff[offset+8:], uint16(value))
+		} else {
+			order.PutUint32(tiff[offset+8:], value)
+		}
+	}
+
+	exifIFD := uint32(8 + 2 + 2*12 + 4)
+	order.PutUint16(tiff[8:], 2)
+	writeEntry(10, exifTagOrientation, exifTypeShort, uint32(orientation))
+	writeEntry(22, exifTagExifIFDPointer, exifTypeLong, exifIFD)
+
+	order.PutUint16(tiff[exifIFD:], 2)
+	writeEntry(int(exifIFD)+2, exifTagPixelXDimension, exifTypeLong, uint32(width))
+	writeEntry(int(exifIFD)+14, exifTagPixelYDimension, exifTypeShort, uint32(height))
+
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// createJPEGWithEXIF writes a 20x10 JPEG, red on its left half and blue on its right half
+func createJPEGWithEXIF(t *testing.T, path string, orientation int) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			if x < 10 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+	data := buf.Bytes()
+
+	segment := createEXIFSegment(binary.BigEndian, orientation, 20, 10)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	output := append([]byte{}, data[:2]...)
+	output = append(output, app1...)
+	output = append(output, segment...)
+	output = append(output, data[2:]...)
+	require.NoError(t, os.WriteFile(path, output, 0644))
+}
+
+func readEXIFInt(t *testing.T, x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	require.NoError(t, err)
+	value, err := tag.Int(0)
+	require.NoError(t, err)
+
+	return value
+}
+
+func TestOrientImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{0, 0, 255, 255})
+
+	red := color.NRGBA{255, 0, 0, 255}
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		redAt       image.Point
+	}{
+		{1, image.Pt(2, 1), image.Pt(0, 0)},
+		{2, image.Pt(2, 1), image.Pt(1, 0)},
+		{3, image.Pt(2, 1), image.Pt(1, 0)},
+		{6, image.Pt(1, 2), image.Pt(0, 0)},
+		{8, image.Pt(1, 2), image.Pt(0, 1)},
+	}
+
+	for _, tt := range tests {
+		oriented := orientImage(img, tt.orientation)
+		assert.Equal(t, tt.size, oriented.Bounds().Size(), "orientation %d", tt.orientation)
+		assert.Equal(t, red, color.NRGBAModel.Convert(oriented.At(tt.redAt.X, tt.redAt.Y)), "orientation %d", tt.orientation)
+	}
+}
+
+func TestUpdateEXIFSegment(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		segment := createEXIFSegment(order, 6, 4000, 3000)
+
+		updated, err := updateEXIFSegment(segment, 1500, 2000, true)
+		require.NoError(t, err)
+		assert.Len(t, updated, len(segment))
+
+		x, err := exif.Decode(bytes.NewReader(updated[6:]))
+		require.NoError(t, err)
+		assert.Equal(t, 1, readEXIFInt(t, x, exif.Orientation))
+		assert.Equal(t, 1500, readEXIFInt(t, x, exif.PixelXDimension))
+		assert.Equal(t, 2000, readEXIFInt(t, x, exif.PixelYDimension))
+
+		kept, err := updateEXIFSegment(segment, 1500, 2000, false)
+		require.NoError(t, err)
+		x, err = exif.Decode(bytes.NewReader(kept[6:]))
+		require.NoError(t, err)
+		assert.Equal(t, 6, readEXIFInt(t, x, exif.Orientation))
+	}
+
+	_, err := updateEXIFSegment([]byte("Exif\x00\x00XX"), 1, 1, true)
+	assert.Error(t, err)
+}
+
+func TestImageCompressor_CompressFile_AutoOrient(t *testing.T) {
+	tests := []struct {
+		name                string
+		autoOrient          bool
+		expectedWidth       int
+		expectedHeight      int
+		expectedOrientation int
+	}{
+		{"Auto-orient", true, 10, 20, 1},
+		{"Keep orientation", false, 20, 10, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "photo.jpg")
+			outputPath := filepath.Join(tempDir, "compressed_photo.jpg")
+			createJPEGWithEXIF(t, inputPath, 6)
+
+			compressor := NewImageCompressor()
+			compressor.SetAutoOrient(tt.autoOrient)
+			_, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			file, err := os.Open(outputPath)
+			require.NoError(t, err)
+			defer file.Close()
+
+			img, err := jpeg.Decode(file)
+			require.NoError(t, err)
+			assert.Equal(t, image.Pt(tt.expectedWidth, tt.expectedHeight), img.Bounds().Size())
+
+			_, err = file.Seek(0, 0)
+			require.NoError(t, err)
+			x, err := exif.Decode(file)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOrientation, readEXIFInt(t, x, exif.Orientation))
+			assert.Equal(t, tt.expectedWidth, readEXIFInt(t, x, exif.PixelXDimension))
+			assert.Equal(t, tt.expectedHeight, readEXIFInt(t, x, exif.PixelYDimension))
+
+			if tt.autoOrient {
+				// Rotated clockwise, the red left half is now on top
+				r, _, b, _ := img.At(5, 2).RGBA()
+				assert.Greater(t, r, b)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package compressor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// gifCompositor replays the frames of a GIF onto a canvas, honoring their
+// disposal methods, to rebuild the image a viewer displays after each frame
+type gifCompositor struct {
+	g        *gif.GIF
+	canvas   *image.RGBA
+	previous *image.RGBA
+}
+
+func newGIFCompositor(g *gif.GIF) *gifCompositor {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	return &gifCompositor{
+		g:      g,
+		canvas: image.NewRGBA(bounds),
+	}
+}
+
+func (c *gifCompositor) disposal(i int) byte {
+	if i < len(c.g.Disposal) {
+		return c.g.Disposal[i]
+	}
+
+	return 0
+}
+
+// render returns the canvas once frame i is drawn. Frames must be rendered in order.
+func (c *gifCompositor) render(i int) *image.RGBA {
+	if i > 0 {
+		switch c.disposal(i - 1) {
+		case gif.DisposalBackground:
+			draw.Draw(c.canvas, c.g.Image[i-1].Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			if c.previous != nil {
+				copy(c.canvas.Pix, c.previous.Pix)
+			}
+		}
+	}
+
+	if c.disposal(i) == gif.DisposalPrevious {
+		c.previous = cloneRGBA(c.canvas)
+	}
+
+	frame := c.g.Image[i]
+	draw.Draw(c.canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+	return cloneRGBA(c.canvas)
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+
+	return clone
+}
+
+// resizeGIFFrame shrinks a rendered frame to the given dimensions. GIF pixels
+// are either opaque or transparent, so resampled edges are snapped to one or
+// the other.
+func resizeGIFFrame(canvas *image.RGBA, width, height int, filter imaging.ResampleFilter) *image.RGBA {
+	resized := imaging.Resize(canvas, width, height, filter)
+	out := image.NewRGBA(resized.Bounds())
+	for i := 0; i < len(resized.Pix); i += 4 {
+		if resized.Pix[i+3] < 128 {
+			continue
+		}
+
+		copy(out.Pix[i:i+3], resized.Pix[i:i+3])
+		out.Pix[i+3] = 255
+	}
+
+	return out
+}
+
+// compressGIF re-encodes a GIF frame by frame so that animations survive the compression
+func (ic *ImageCompressor) compressGIF(src io.Reader, outputPath string) error {
+	g, err := gif.DecodeAll(src)
+	if err != nil {
+		return fmt.Errorf("failed to decode GIF: %v", err)
+	}
+
+	var resize func(*image.RGBA) *image.RGBA
+	if width, height, ok := ic.resizeDimensions(g.Config.Width, g.Config.Height); ok {
+		resize = func(canvas *image.RGBA) *image.RGBA {
+			return resizeGIFFrame(canvas, width, height, ic.resampleFilter)
+		}
+	}
+
+	optimized := optimizeGIF(g, resize)
+
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer outFile.Close()
+
+	if err := gif.EncodeAll(outFile, optimized); err != nil {
+		return fmt.Errorf("failed to encode GIF: %v", err)
+	}
+
+	ic.logger.PrintfVerbose("Image Compressor: Optimized GIF with %d frame(s)\n", len(optimized.Image))
+
+	return nil
+}
+
+// optimizeGIF rebuilds a GIF that plays back exactly like g. Every frame is
+// cropped to the area that differs from what is already displayed, pixels
+// left unchanged inside that area are made transparent so that they compress
+// well, and palettes are reduced to the colors actually used.
+func optimizeGIF(g *gif.GIF, resize func(*image.RGBA) *image.RGBA) *gif.GIF {
+	compositor := newGIFCompositor(g)
+	render := func(i int) *image.RGBA {
+		canvas := compositor.render(i)
+		if resize != nil {
+			canvas = resize(canvas)
+		}
+
+		return canvas
+	}
+
+	current := render(0)
+	bounds := current.Bounds()
+
+	out := &gif.GIF{
+		LoopCount: g.LoopCount,
+		Config: image.Config{
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		},
+	}
+
+	// Without resampling, every displayed color comes from a source palette,
+	// so a single global palette fits when there are few enough of them
+	var global color.Palette
+	if resize == nil {
+		global = globalGIFPalette(g)
+		if global != nil {
+			out.Config.ColorModel = global
+		}
+	}
+
+	// state is what the viewer displays once the last emitted frame is disposed
+	state := image.NewRGBA(bounds)
+
+	for i := range g.Image {
+		var next *image.RGBA
+		if i+1 < len(g.Image) {
+			next = render(i + 1)
+		}
+
+		// Pixels that disappear in the next frame can only be cleared by
+		// disposing this frame to the background
+		cleared := clearedBounds(current, next)
+		rect := changedBounds(state, current).Union(cleared)
+		if rect.Empty() {
+			rect = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+1, bounds.Min.Y+1)
+		}
+
+		palette := global
+		if palette == nil {
+			palette = localGIFPalette(state, current, rect, g.Image[i].Palette)
+		}
+		out.Image = append(out.Image, encodeGIFFrame(state, current, rect, palette))
+
+		var delay int
+		if i < len(g.Delay) {
+			delay = g.Delay[i]
+		}
+		out.Delay = append(out.Delay, delay)
+
+		copy(state.Pix, current.Pix)
+		if cleared.Empty() {
+			out.Disposal = append(out.Disposal, gif.DisposalNone)
+		} else {
+			out.Disposal = append(out.Disposal, gif.DisposalBackground)
+			draw.Draw(state, rect, image.Transparent, image.Point{}, draw.Src)
+		}
+
+		current = next
+	}
+
+	return out
+}
+
+// changedBounds returns the smallest rectangle holding every pixel that
+// differs between the displayed state and the target frame
+func changedBounds(state, target *image.RGBA) image.Rectangle {
+	var rect image.Rectangle
+	bounds := target.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if state.RGBAAt(x, y) != target.RGBAAt(x, y) {
+				rect = rect.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return rect
+}
+
+// clearedBounds returns the smallest rectangle holding every pixel that is
+// visible in the current frame and transparent in the next one
+func clearedBounds(current, next *image.RGBA) image.Rectangle {
+	var rect image.Rectangle
+	if next == nil {
+		return rect
+	}
+
+	bounds := current.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if current.RGBAAt(x, y).A != 0 && next.RGBAAt(x, y).A == 0 {
+				rect = rect.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return rect
+}
+
+// isGIFPixelReusable reports whether a frame pixel can be left transparent
+// because the viewer already displays the target color there
+func isGIFPixelReusable(state, target color.RGBA) bool {
+	return state == target || target.A == 0
+}
+
+// globalGIFPalette returns a palette holding every color used by the frames
+// of g, with a transparent entry first, or nil if they do not fit in one palette
+func globalGIFPalette(g *gif.GIF) color.Palette {
+	palette := color.Palette{color.RGBA{}}
+	seen := map[color.RGBA]bool{{}: true}
+
+	for _, frame := range g.Image {
+		var used [256]bool
+		for _, index := range frame.Pix {
+			used[index] = true
+		}
+
+		for index, isUsed := range used {
+			if !isUsed || index >= len(frame.Palette) {
+				continue
+			}
+
+			c := color.RGBAModel.Convert(frame.Palette[index]).(color.RGBA)
+			if c.A == 0 || seen[c] {
+				continue
+			}
+
+			seen[c] = true
+			palette = append(palette, c)
+			if len(palette) > 256 {
+				return nil
+			}
+		}
+	}
+
+	return palette
+}
+
+// localGIFPalette returns the smallest palette able to encode rect of the
+// target frame. When the frame holds too many colors, which happens after
+// resampling, the colors of the source frame palette are used instead.
+func localGIFPalette(state, target *image.RGBA, rect image.Rectangle, fallback color.Palette) color.Palette {
+	palette := color.Palette{}
+	seen := map[color.RGBA]bool{}
+	needsTransparent := false
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := target.RGBAAt(x, y)
+			if isGIFPixelReusable(state.RGBAAt(x, y), c) {
+				needsTransparent = true
+				continue
+			}
+
+			if !seen[c] {
+				seen[c] = true
+				palette = append(palette, c)
+			}
+		}
+	}
+
+	if needsTransparent {
+		palette = append(color.Palette{color.RGBA{}}, palette...)
+	}
+
+	if len(palette) <= 256 {
+		// A GIF palette holds at least two colors
+		if len(palette) < 2 {
+			palette = append(palette, color.RGBA{A: 255})
+		}
+
+		return palette
+	}
+
+	palette = color.Palette{color.RGBA{}}
+	for _, c := range fallback {
+		if rgba := color.RGBAModel.Convert(c).(color.RGBA); rgba.A != 0 && len(palette) < 256 {
+			palette = append(palette, rgba)
+		}
+	}
+
+	return palette
+}
+
+// encodeGIFFrame builds the paletted frame covering rect of the target,
+// leaving reusable pixels transparent when the palette allows it
+func encodeGIFFrame(state, target *image.RGBA, rect image.Rectangle, palette color.Palette) *image.Paletted {
+	frame := image.NewPaletted(rect, palette)
+
+	transparent := -1
+	indexes := make(map[color.RGBA]uint8, len(palette))
+	for i, c := range palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		if rgba.A == 0 && transparent < 0 {
+			transparent = i
+		}
+		if _, exists := indexes[rgba]; !exists {
+			indexes[rgba] = uint8(i)
+		}
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := target.RGBAAt(x, y)
+			if transparent >= 0 && isGIFPixelReusable(state.RGBAAt(x, y), c) {
+				frame.SetColorIndex(x, y, uint8(transparent))
+				continue
+			}
+
+			index, exists := indexes[c]
+			if !exists {
+				index = uint8(palette.Index(c))
+				indexes[c] = index
+			}
+			frame.SetColorIndex(x, y, index)
+		}
+	}
+
+	return frame
+}
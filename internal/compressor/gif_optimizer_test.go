@@ -0,0 +1,133 @@
+package compressor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createAnimatedGIF creates a 3-frame animation of a square moving over a
+// background, which then disappears and leaves a transparent hole
+func createAnimatedGIF() *gif.GIF {
+	palette := color.Palette{
+		color.RGBA{},
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{0, 0, 255, 255},
+		color.RGBA{0, 255, 0, 255}, // Unused
+	}
+
+	background := image.NewPaletted(image.Rect(0, 0, 20, 20), palette)
+	for i := range background.Pix {
+		background.Pix[i] = 1
+	}
+	for y := 2; y < 6; y++ {
+		for x := 2; x < 6; x++ {
+			background.SetColorIndex(x, y, 2)
+		}
+	}
+
+	moved := image.NewPaletted(image.Rect(0, 0, 20, 20), palette)
+	for i := range moved.Pix {
+		moved.Pix[i] = 1
+	}
+	for y := 8; y < 12; y++ {
+		for x := 8; x < 12; x++ {
+			moved.SetColorIndex(x, y, 2)
+		}
+	}
+
+	// Drawn over a background-disposed frame, so its hole stays transparent
+	hole := image.NewPaletted(image.Rect(0, 0, 20, 20), palette)
+	for i := range hole.Pix {
+		hole.Pix[i] = 1
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			hole.SetColorIndex(x, y, 0)
+		}
+	}
+
+	return &gif.GIF{
+		Image:     []*image.Paletted{background, moved, hole},
+		Delay:     []int{10, 20, 30},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		LoopCount: 3,
+		Config:    image.Config{Width: 20, Height: 20},
+	}
+}
+
+func renderAllFrames(g *gif.GIF) []*image.RGBA {
+	compositor := newGIFCompositor(g)
+	frames := make([]*image.RGBA, len(g.Image))
+	for i := range g.Image {
+		frames[i] = compositor.render(i)
+	}
+
+	return frames
+}
+
+func TestOptimizeGIF_PreservesPlayback(t *testing.T) {
+	source := createAnimatedGIF()
+
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, optimizeGIF(source, nil)))
+
+	decoded, err := gif.DecodeAll(&buf)
+	require.NoError(t, err)
+
+	assert.Len(t, decoded.Image, 3)
+	assert.Equal(t, []int{10, 20, 30}, decoded.Delay)
+	assert.Equal(t, 3, decoded.LoopCount)
+
+	expected := renderAllFrames(source)
+	actual := renderAllFrames(decoded)
+	for i := range expected {
+		assert.Equal(t, expected[i].Pix, actual[i].Pix, "Frame %d should be displayed identically", i)
+	}
+}
+
+func TestOptimizeGIF_CropsFramesAndReducesPalette(t *testing.T) {
+	optimized := optimizeGIF(createAnimatedGIF(), nil)
+
+	// The second frame only needs to cover both positions of the square, and
+	// the hole that must be cleared before the third frame
+	assert.Equal(t, image.Rect(0, 0, 12, 12), optimized.Image[1].Bounds())
+	assert.Equal(t, byte(gif.DisposalBackground), optimized.Disposal[1])
+
+	// The unused green entry is dropped from the global palette
+	palette, ok := optimized.Config.ColorModel.(color.Palette)
+	require.True(t, ok)
+	assert.Len(t, palette, 3)
+	assert.NotContains(t, palette, color.Color(color.RGBA{0, 255, 0, 255}))
+}
+
+func TestImageCompressor_CompressFile_AnimatedGIF(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "animation.gif")
+	outputPath := filepath.Join(tempDir, "compressed_animation.gif")
+
+	file, err := os.Create(inputPath)
+	require.NoError(t, err)
+	require.NoError(t, gif.EncodeAll(file, createAnimatedGIF()))
+	require.NoError(t, file.Close())
+
+	compressor := NewImageCompressor()
+	result, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, outputPath, result.CompressedFile)
+
+	output, err := os.Open(outputPath)
+	require.NoError(t, err)
+	defer output.Close()
+
+	decoded, err := gif.DecodeAll(output)
+	require.NoError(t, err)
+	assert.Len(t, decoded.Image, 3, "All frames should be kept")
+}
@@ -2,24 +2,44 @@ package compressor
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/jpeg"
-	"image/png"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/disintegration/imaging"
 	jpegstructure "github.com/dsoprea/go-jpeg-image-structure/v2"
 	"github.com/jdecool/file-compressor/internal/logger"
+	"github.com/jdecool/file-compressor/internal/webp"
 	"github.com/rwcarlsen/goexif/exif"
+	_ "golang.org/x/image/webp"
 )
 
 type ImageCompressor struct {
 	supportedMimeTypes []string
 	logger             *logger.Logger
+	jpegQuality        int
+	webpQuality        int
+	maxWidth           int
+	maxHeight          int
+	noResize           bool
+	autoOrient         bool
+	resampleFilter     imaging.ResampleFilter
+	keepMetadata       map[string]bool
+	metadataPolicy     string
+	privacy            bool
+	targetSSIM         float64
+	maxFileSize        int64
+	minQuality         int
+	pngLossy           bool
+	pngDithering       bool
+	pngMinQuality      int
 }
 
 func NewImageCompressor() *ImageCompressor {
@@ -33,7 +53,21 @@ func NewImageCompressor() *ImageCompressor {
 			"image/tiff",
 			"image/webp",
 		},
-		logger: logger.NewLogger(false),
+		logger:         logger.NewLogger(false),
+		jpegQuality:    85,
+		webpQuality:    85,
+		maxWidth:       2000,
+		maxHeight:      2000,
+		resampleFilter: imaging.Lanczos,
+		metadataPolicy: MetadataPolicyKeepAll,
+		minQuality:     40,
+		pngMinQuality:  65,
+		keepMetadata: map[string]bool{
+			MetadataEXIF: true,
+			MetadataICC:  true,
+			MetadataXMP:  true,
+			MetadataIPTC: true,
+		},
 	}
 }
 
@@ -67,42 +101,89 @@ func (ic *ImageCompressor) CompressFile(filePath string, outputPath string) (*Co
 	}
 	defer srcFile.Close()
 
+	// GIFs are optimized frame by frame so that animations are preserved
+	if _, format, err := image.DecodeConfig(srcFile); err == nil && format == "gif" {
+		if !strings.HasSuffix(strings.ToLower(outputPath), ".gif") {
+			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".gif"
+		}
+
+		if _, err := srcFile.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind image file: %v", err)
+		}
+
+		if err := ic.compressGIF(srcFile, outputPath); err != nil {
+			return nil, fmt.Errorf("failed to compress GIF: %v", err)
+		}
+
+		compressedFileInfo, err := os.Stat(outputPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get compressed file info: %v", err)
+		}
+
+		// Animations are neither lossy nor resized frame by frame, so there is nothing to lower
+		if ic.maxFileSize > 0 && compressedFileInfo.Size() > ic.maxFileSize {
+			_ = os.Remove(outputPath)
+
+			return nil, fmt.Errorf("cannot reach maximum file size of %s for GIF, smallest output is %s", formatSize(ic.maxFileSize), formatSize(compressedFileInfo.Size()))
+		}
+
+		return &CompressionResult{
+			OriginalFile:   filePath,
+			CompressedFile: outputPath,
+			OriginalSize:   originalFileInfo.Size(),
+			CompressedSize: compressedFileInfo.Size(),
+		}, nil
+	}
+
+	if _, err := srcFile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind image file: %v", err)
+	}
+
 	srcImage, format, err := image.Decode(srcFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image: %v", err)
 	}
 
+	// Pixels are turned upright, the output orientation tag is then reset.
+	// Without EXIF in the output, this is the only way to keep the image
+	// displayed the same.
+	oriented := false
+	if orientation := exifOrientation(exifData); (ic.autoOrient || !ic.keepsEXIF()) && orientation != 1 {
+		srcImage = orientImage(srcImage, orientation)
+		oriented = true
+		ic.logger.PrintfVerbose("Image Compressor: Applied EXIF orientation %d\n", orientation)
+	}
+
 	bounds := srcImage.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
 
-	if width > 2000 || height > 2000 {
-		srcImage = imaging.Resize(srcImage, 2000, 0, imaging.Lanczos) // 0 means maintain aspect ratio
+	resized := false
+	if newWidth, newHeight, ok := ic.resizeDimensions(width, height); ok {
+		srcImage = imaging.Resize(srcImage, newWidth, newHeight, ic.resampleFilter)
+		resized = true
+		ic.logger.PrintfVerbose("Image Compressor: Resized image from %dx%d to %dx%d\n", width, height, newWidth, newHeight)
 	}
 
-	// Determine the output format and ensure correct file extension
+	// Determine the output format and ensure correct file extension. Quality
+	// is only set for lossy formats.
 	var imgFormat imaging.Format
-	var encodeOptions []imaging.EncodeOption
+	var quality int
+	jpegOutput := false
 
 	switch strings.ToLower(format) {
 	case "jpeg", "jpg":
 		imgFormat = imaging.JPEG
-		encodeOptions = []imaging.EncodeOption{imaging.JPEGQuality(85)}
+		jpegOutput = true
+		quality = ic.jpegQuality
 		if !strings.HasSuffix(strings.ToLower(outputPath), ".jpg") && !strings.HasSuffix(strings.ToLower(outputPath), ".jpeg") {
 			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".jpg"
 		}
 	case "png":
-		imgFormat = imaging.PNG
-		encodeOptions = []imaging.EncodeOption{imaging.PNGCompressionLevel(png.BestCompression)}
+		// PNG is encoded by compressPNG
 		if !strings.HasSuffix(strings.ToLower(outputPath), ".png") {
 			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".png"
 		}
-	case "gif":
-		imgFormat = imaging.GIF
-		encodeOptions = []imaging.EncodeOption{imaging.GIFNumColors(256)}
-		if !strings.HasSuffix(strings.ToLower(outputPath), ".gif") {
-			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".gif"
-		}
 	case "bmp":
 		imgFormat = imaging.BMP
 		if !strings.HasSuffix(strings.ToLower(outputPath), ".bmp") {
@@ -113,33 +194,76 @@ func (ic *ImageCompressor) CompressFile(filePath string, outputPath string) (*Co
 		if !strings.HasSuffix(strings.ToLower(outputPath), ".tiff") && !strings.HasSuffix(strings.ToLower(outputPath), ".tif") {
 			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".tiff"
 		}
+	case "webp":
+		// WebP is not supported by imaging, it is encoded by compressWebP
+		lossless, err := isLosslessWebP(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WebP header: %v", err)
+		}
+		if !lossless {
+			quality = ic.webpQuality
+		}
+		if !strings.HasSuffix(strings.ToLower(outputPath), ".webp") {
+			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".webp"
+		}
 	default:
 		// For unknown formats, default to JPEG with quality compression
 		imgFormat = imaging.JPEG
-		encodeOptions = []imaging.EncodeOption{imaging.JPEGQuality(85)}
+		jpegOutput = true
+		quality = ic.jpegQuality
 		if !strings.HasSuffix(strings.ToLower(outputPath), ".jpg") && !strings.HasSuffix(strings.ToLower(outputPath), ".jpeg") {
 			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".jpg"
 		}
 	}
 
-	// For JPEG files with EXIF data, use special handling to preserve metadata
-	if (strings.ToLower(format) == "jpeg" || strings.ToLower(format) == "jpg") && exifData != nil {
-		err = ic.compressJPEGWithEXIF(srcImage, outputPath, exifData, filePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to compress JPEG with EXIF: %v", err)
-		}
-	} else {
-		// Standard compression without EXIF preservation
-		outFile, err := os.Create(outputPath)
+	// Search the lowest JPEG quality meeting the target SSIM, if any
+	var ssim float64
+	if jpegOutput && ic.targetSSIM > 0 {
+		quality, ssim, err = searchJPEGQuality(srcImage, ic.targetSSIM)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create output file: %v", err)
+			return nil, fmt.Errorf("failed to search JPEG quality: %v", err)
 		}
-		defer outFile.Close()
 
-		err = imaging.Encode(outFile, srcImage, imgFormat, encodeOptions...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to encode compressed image: %v", err)
+		ic.logger.PrintfVerbose("Image Compressor: Selected JPEG quality %d with SSIM %.4f (target %.4f)\n", quality, ssim, ic.targetSSIM)
+	}
+
+	writeOutput := func(img image.Image, quality int, resized bool) error {
+		// For JPEG files, use special handling to preserve metadata
+		if strings.ToLower(format) == "jpeg" || strings.ToLower(format) == "jpg" {
+			if err := ic.compressJPEGWithMetadata(img, outputPath, filePath, quality, oriented); err != nil {
+				return fmt.Errorf("failed to compress JPEG with metadata: %v", err)
+			}
+		} else if strings.ToLower(format) == "png" {
+			if err := ic.compressPNG(img, outputPath, filePath, resized); err != nil {
+				return fmt.Errorf("failed to compress PNG: %v", err)
+			}
+		} else if strings.ToLower(format) == "webp" {
+			if err := ic.compressWebP(img, outputPath, quality); err != nil {
+				return fmt.Errorf("failed to compress WebP: %v", err)
+			}
+		} else {
+			// Standard compression without metadata preservation
+			var encodeOptions []imaging.EncodeOption
+			if imgFormat == imaging.JPEG {
+				encodeOptions = []imaging.EncodeOption{imaging.JPEGQuality(quality)}
+			}
+
+			outFile, err := os.Create(outputPath)
+			if err != nil {
+				return fmt.Errorf("failed to create output file: %v", err)
+			}
+			defer outFile.Close()
+
+			if err := imaging.Encode(outFile, img, imgFormat, encodeOptions...); err != nil {
+				return fmt.Errorf("failed to encode compressed image: %v", err)
+			}
 		}
+
+		return nil
+	}
+
+	if err := writeOutput(srcImage, quality, resized); err != nil {
+		return nil, err
 	}
 
 	// Get compressed file size
@@ -148,6 +272,23 @@ func (ic *ImageCompressor) CompressFile(filePath string, outputPath string) (*Co
 		return nil, fmt.Errorf("failed to get compressed file info: %v", err)
 	}
 
+	if ic.maxFileSize > 0 && compressedFileInfo.Size() > ic.maxFileSize {
+		quality, err = ic.fitMaxFileSize(srcImage, quality, outputPath, writeOutput)
+		if err != nil {
+			_ = os.Remove(outputPath)
+
+			return nil, err
+		}
+
+		// The SSIM was measured for another encoding
+		ssim = 0
+
+		compressedFileInfo, err = os.Stat(outputPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get compressed file info: %v", err)
+		}
+	}
+
 	ic.logger.PrintfVerbose("Image Compressor: Successfully compressed file to %s\n", outputPath)
 
 	return &CompressionResult{
@@ -155,19 +296,24 @@ func (ic *ImageCompressor) CompressFile(filePath string, outputPath string) (*Co
 		CompressedFile: outputPath,
 		OriginalSize:   originalFileInfo.Size(),
 		CompressedSize: compressedFileInfo.Size(),
+		Quality:        quality,
+		SSIM:           ssim,
 	}, nil
 }
 
-// compressJPEGWithEXIF compresses a JPEG image while preserving EXIF metadata
-func (ic *ImageCompressor) compressJPEGWithEXIF(img image.Image, outputPath string, exifData *exif.Exif, originalPath string) error {
+// compressJPEGWithMetadata compresses a JPEG image while preserving the
+// metadata segments of the original that are kept. The EXIF dimensions are
+// updated to match the image, and its orientation is reset when the pixels
+// were already oriented.
+func (ic *ImageCompressor) compressJPEGWithMetadata(img image.Image, outputPath string, originalPath string, quality int, oriented bool) error {
 	// Encode the processed image to a buffer
 	var buf bytes.Buffer
-	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 	if err != nil {
 		return fmt.Errorf("failed to encode JPEG: %v", err)
 	}
 
-	// Read the original file to get the full EXIF segment
+	// Read the original file to get the full metadata segments
 	originalFile, err := os.Open(originalPath)
 	if err != nil {
 		return fmt.Errorf("failed to open original file: %v", err)
@@ -179,35 +325,54 @@ func (ic *ImageCompressor) compressJPEGWithEXIF(img image.Image, outputPath stri
 		return fmt.Errorf("failed to read original file: %v", err)
 	}
 
-	// Parse the original JPEG to extract EXIF segment
+	// Parse the original JPEG to extract metadata segments
 	jmp := jpegstructure.NewJpegMediaParser()
 	intfc, err := jmp.ParseBytes(originalData)
 	if err != nil {
 		// If we can't parse with the JPEG structure library, fall back to basic encoding
-		ic.logger.PrintfVerbose("Image Compressor: Could not parse JPEG structure, saving without EXIF preservation\n")
+		ic.logger.PrintfVerbose("Image Compressor: Could not parse JPEG structure, saving without metadata preservation\n")
 		return os.WriteFile(outputPath, buf.Bytes(), 0644)
 	}
 
 	sl := intfc.(*jpegstructure.SegmentList)
 
-	// Find the EXIF segment from the original
-	var exifSegmentData []byte
-	segments := sl.Segments()
-	for _, segment := range segments {
-		if segment.MarkerName == "APP1" {
-			// Check if this is an EXIF segment (starts with "Exif\x00\x00")
-			if len(segment.Data) >= 6 {
-				if string(segment.Data[0:4]) == "Exif" && segment.Data[4] == 0 && segment.Data[5] == 0 {
-					exifSegmentData = segment.Data
-					break
-				}
+	// Find the metadata segments to keep from the original, in their original order
+	var metadataSegments []*jpegstructure.Segment
+	var keptKinds []string
+	for _, segment := range sl.Segments() {
+		kind := jpegMetadataKind(segment.MarkerId, segment.Data)
+		if kind == "" {
+			continue
+		}
+
+		data := ic.jpegMetadataSegment(kind, segment.Data)
+		if data == nil {
+			continue
+		}
+
+		if kind == MetadataEXIF {
+			// Update the dimensions and orientation of the EXIF segment
+			updated, err := updateEXIFSegment(data, img.Bounds().Dx(), img.Bounds().Dy(), oriented)
+			if err != nil {
+				ic.logger.PrintfVerbose("Image Compressor: Could not update EXIF tags, copying them unchanged: %v\n", err)
+			} else {
+				data = updated
 			}
 		}
+
+		metadataSegments = append(metadataSegments, &jpegstructure.Segment{
+			MarkerId:   segment.MarkerId,
+			MarkerName: segment.MarkerName,
+			Data:       data,
+		})
+		if !slices.Contains(keptKinds, kind) {
+			keptKinds = append(keptKinds, kind)
+		}
 	}
 
-	if exifSegmentData == nil {
-		// No EXIF segment found in original
-		ic.logger.PrintfVerbose("Image Compressor: No EXIF APP1 segment found in original\n")
+	if len(metadataSegments) == 0 {
+		// No metadata segment to keep from the original
+		ic.logger.PrintfVerbose("Image Compressor: No metadata segment to preserve from original\n")
 		return os.WriteFile(outputPath, buf.Bytes(), 0644)
 	}
 
@@ -219,7 +384,7 @@ func (ic *ImageCompressor) compressJPEGWithEXIF(img image.Image, outputPath stri
 
 	newSl := intfc2.(*jpegstructure.SegmentList)
 
-	// Insert the EXIF segment into the compressed image
+	// Insert the metadata segments into the compressed image
 	// Find the SOI (Start of Image) marker and insert after it
 	segments2 := newSl.Segments()
 	if len(segments2) < 2 {
@@ -227,27 +392,17 @@ func (ic *ImageCompressor) compressJPEGWithEXIF(img image.Image, outputPath stri
 		return os.WriteFile(outputPath, buf.Bytes(), 0644)
 	}
 
-	// Create a new segment list with the EXIF segment inserted after SOI
-	newSegments := make([]*jpegstructure.Segment, 0, len(segments2)+1)
+	// Create a new segment list with the metadata segments inserted after SOI
+	newSegments := make([]*jpegstructure.Segment, 0, len(segments2)+len(metadataSegments))
 	newSegments = append(newSegments, segments2[0]) // SOI
+	newSegments = append(newSegments, metadataSegments...)
 
-	// Create new EXIF segment
-	exifSeg := &jpegstructure.Segment{
-		MarkerId:   0xe1, // APP1
-		MarkerName: "APP1",
-		Offset:     0,
-		Data:       exifSegmentData,
-	}
-	newSegments = append(newSegments, exifSeg)
-
-	// Add remaining segments (skip any existing EXIF segments)
+	// Add remaining segments (skip any existing metadata segments)
 	for i := 1; i < len(segments2); i++ {
 		seg := segments2[i]
-		// Skip existing APP1/EXIF segments to avoid duplicates
-		if seg.MarkerName == "APP1" {
-			if len(seg.Data) >= 6 && string(seg.Data[0:4]) == "Exif" {
-				continue // Skip existing EXIF
-			}
+		// Skip existing metadata segments to avoid duplicates
+		if jpegMetadataKind(seg.MarkerId, seg.Data) != "" {
+			continue
 		}
 		newSegments = append(newSegments, seg)
 	}
@@ -255,7 +410,7 @@ func (ic *ImageCompressor) compressJPEGWithEXIF(img image.Image, outputPath stri
 	// Build new segment list
 	newSl2 := jpegstructure.NewSegmentList(newSegments)
 
-	// Write the final JPEG with EXIF to file
+	// Write the final JPEG with metadata to file
 	outputFile, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %v", err)
@@ -264,14 +419,209 @@ func (ic *ImageCompressor) compressJPEGWithEXIF(img image.Image, outputPath stri
 
 	err = newSl2.Write(outputFile)
 	if err != nil {
-		return fmt.Errorf("failed to write JPEG with EXIF: %v", err)
+		return fmt.Errorf("failed to write JPEG with metadata: %v", err)
 	}
 
-	ic.logger.PrintfVerbose("Image Compressor: EXIF metadata preserved\n")
+	ic.logger.PrintfVerbose("Image Compressor: Metadata preserved (%s)\n", strings.Join(keptKinds, ", "))
 
 	return nil
 }
 
+// jpegMetadataSegment returns the payload of a JPEG metadata segment once the
+// metadata settings are applied, or nil when the segment is removed
+func (ic *ImageCompressor) jpegMetadataSegment(kind string, data []byte) []byte {
+	if !ic.keepMetadata[kind] {
+		return nil
+	}
+
+	switch kind {
+	case MetadataEXIF:
+		filtered, err := applyEXIFPolicy(data, ic.metadataPolicy, ic.privacy)
+		if err != nil {
+			// A segment that cannot be parsed cannot be scrubbed either
+			ic.logger.PrintfVerbose("Image Compressor: Could not apply metadata policy to EXIF, removing it: %v\n", err)
+			return nil
+		}
+
+		return filtered
+	case MetadataXMP:
+		packet, ok := bytes.CutPrefix(data, []byte(jpegXMPHeader))
+		if !ok {
+			// Extended XMP is split across segments and checksummed, it is kept as is or not at all
+			if ic.metadataPolicy == MetadataPolicyKeepAll && !ic.privacy {
+				return data
+			}
+
+			return nil
+		}
+
+		packet = applyXMPPolicy(packet, ic.metadataPolicy, ic.privacy, imagePrivacyXMPProperties)
+		if packet == nil {
+			return nil
+		}
+
+		return append([]byte(jpegXMPHeader), packet...)
+	case MetadataIPTC:
+		filtered, err := applyIPTCPolicy(data, ic.metadataPolicy)
+		if err != nil {
+			ic.logger.PrintfVerbose("Image Compressor: Could not apply metadata policy to IPTC, removing it: %v\n", err)
+			return nil
+		}
+
+		return filtered
+	default:
+		if ic.metadataPolicy == MetadataPolicyStripAll {
+			return nil
+		}
+
+		return data
+	}
+}
+
+// keepsEXIF reports whether EXIF metadata, and thus the orientation tag, is written to compressed images
+func (ic *ImageCompressor) keepsEXIF() bool {
+	return ic.keepMetadata[MetadataEXIF] && ic.metadataPolicy != MetadataPolicyStripAll
+}
+
+// compressWebP encodes a WebP image, losslessly when quality is 0
+func (ic *ImageCompressor) compressWebP(img image.Image, outputPath string, quality int) error {
+	lossless := quality == 0
+
+	var buf bytes.Buffer
+	err := webp.Encode(&buf, img, &webp.Options{Lossless: lossless, Quality: quality})
+	if err != nil {
+		return fmt.Errorf("failed to encode WebP: %v", err)
+	}
+
+	if lossless {
+		ic.logger.PrintfVerbose("Image Compressor: Re-encoded lossless WebP\n")
+	} else {
+		ic.logger.PrintfVerbose("Image Compressor: Re-encoded lossy WebP with quality %d\n", quality)
+	}
+
+	return os.WriteFile(outputPath, buf.Bytes(), 0644)
+}
+
+// isLosslessWebP reports whether the WebP file stores its image with the
+// lossless (VP8L) bitstream
+func isLosslessWebP(filePath string) (bool, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return false, fmt.Errorf("not a WebP file")
+	}
+
+	// Walk the chunks, the image data is either in a "VP8 " or a "VP8L" chunk
+	for offset := 12; offset+8 <= len(data); {
+		fourCC := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		switch fourCC {
+		case "VP8L":
+			return true, nil
+		case "VP8 ":
+			return false, nil
+		}
+		offset += 8 + size + size%2
+	}
+
+	return false, fmt.Errorf("no image data found")
+}
+
+// resizeDimensions returns the dimensions fitting an image within the
+// maximum width and height while keeping its aspect ratio, and whether the
+// image must be resized at all. Images are never upscaled.
+func (ic *ImageCompressor) resizeDimensions(width, height int) (int, int, bool) {
+	if ic.noResize {
+		return width, height, false
+	}
+
+	scale := 1.0
+	if ic.maxWidth > 0 && width > ic.maxWidth {
+		scale = min(scale, float64(ic.maxWidth)/float64(width))
+	}
+	if ic.maxHeight > 0 && height > ic.maxHeight {
+		scale = min(scale, float64(ic.maxHeight)/float64(height))
+	}
+
+	if scale == 1.0 {
+		return width, height, false
+	}
+
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5)), true
+}
+
+// Steps taken to reach the maximum file size: the quality is lowered by
+// maxFileSizeQualityStep down to the minimum quality, then the dimensions are
+// reduced by maxFileSizeScaleStep down to maxFileSizeMinScale of the image
+const (
+	maxFileSizeQualityStep = 5
+	maxFileSizeScaleStep   = 0.9
+	maxFileSizeMinScale    = 0.25
+)
+
+// fitMaxFileSize re-encodes an image, lowering its quality then its
+// dimensions, until the output fits in the maximum file size. It returns the
+// quality of the output that fits.
+func (ic *ImageCompressor) fitMaxFileSize(img image.Image, quality int, outputPath string, write func(image.Image, int, bool) error) (int, error) {
+	fits := func() (bool, int64, error) {
+		info, err := os.Stat(outputPath)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to get compressed file info: %v", err)
+		}
+
+		return info.Size() <= ic.maxFileSize, info.Size(), nil
+	}
+
+	// Quality is only set for lossy formats
+	for quality > ic.minQuality {
+		quality = max(ic.minQuality, quality-maxFileSizeQualityStep)
+		if err := write(img, quality, true); err != nil {
+			return 0, err
+		}
+
+		ok, size, err := fits()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			ic.logger.PrintfVerbose("Image Compressor: Reached maximum file size with quality %d (%s)\n", quality, formatSize(size))
+
+			return quality, nil
+		}
+	}
+
+	bounds := img.Bounds()
+	var size int64
+	for scale := maxFileSizeScaleStep; scale >= maxFileSizeMinScale; scale *= maxFileSizeScaleStep {
+		width := max(1, int(float64(bounds.Dx())*scale+0.5))
+		height := max(1, int(float64(bounds.Dy())*scale+0.5))
+		if err := write(imaging.Resize(img, width, height, ic.resampleFilter), quality, true); err != nil {
+			return 0, err
+		}
+
+		var ok bool
+		var err error
+		ok, size, err = fits()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			ic.logger.PrintfVerbose("Image Compressor: Reached maximum file size with quality %d at %dx%d (%s)\n", quality, width, height, formatSize(size))
+
+			return quality, nil
+		}
+	}
+
+	if quality > 0 {
+		return 0, fmt.Errorf("cannot reach maximum file size of %s above quality %d, smallest output is %s", formatSize(ic.maxFileSize), ic.minQuality, formatSize(size))
+	}
+
+	return 0, fmt.Errorf("cannot reach maximum file size of %s, smallest output is %s", formatSize(ic.maxFileSize), formatSize(size))
+}
+
 func (ic *ImageCompressor) GetSupportedMimeTypes() []string {
 	return ic.supportedMimeTypes
 }
@@ -279,3 +629,222 @@ func (ic *ImageCompressor) GetSupportedMimeTypes() []string {
 func (ic *ImageCompressor) SetLogger(logger *logger.Logger) {
 	ic.logger = logger
 }
+
+func (ic *ImageCompressor) SetJPEGQuality(quality int) error {
+	if quality < 1 {
+		ic.jpegQuality = 1
+
+		return fmt.Errorf("JPEG quality must be at least 1, setting to 1")
+	}
+
+	if quality > 100 {
+		ic.jpegQuality = 100
+
+		return fmt.Errorf("JPEG quality cannot exceed 100, setting to 100")
+	}
+
+	ic.jpegQuality = quality
+
+	return nil
+}
+
+// SetTargetSSIM enables the search of the lowest JPEG quality whose output
+// reaches the given SSIM against the source, 0 disables it
+func (ic *ImageCompressor) SetTargetSSIM(score float64) error {
+	if score < 0 {
+		ic.targetSSIM = 0
+
+		return fmt.Errorf("target SSIM cannot be negative, setting to 0 (disabled)")
+	}
+
+	if score > 1 {
+		ic.targetSSIM = 1
+
+		return fmt.Errorf("target SSIM cannot exceed 1, setting to 1")
+	}
+
+	ic.targetSSIM = score
+
+	return nil
+}
+
+// SetMaxFileSize sets the size in bytes that compressed images must not exceed, 0 means no limit
+func (ic *ImageCompressor) SetMaxFileSize(size int64) error {
+	if size < 0 {
+		ic.maxFileSize = 0
+
+		return fmt.Errorf("max file size cannot be negative, setting to 0 (no limit)")
+	}
+
+	ic.maxFileSize = size
+
+	return nil
+}
+
+// SetMinQuality sets the lowest quality used to reach the maximum file size
+func (ic *ImageCompressor) SetMinQuality(quality int) error {
+	if quality < 1 {
+		ic.minQuality = 1
+
+		return fmt.Errorf("minimum quality must be at least 1, setting to 1")
+	}
+
+	if quality > 100 {
+		ic.minQuality = 100
+
+		return fmt.Errorf("minimum quality cannot exceed 100, setting to 100")
+	}
+
+	ic.minQuality = quality
+
+	return nil
+}
+
+// SetMaxWidth sets the width above which images are downscaled, 0 means no limit
+func (ic *ImageCompressor) SetMaxWidth(width int) error {
+	if width < 0 {
+		ic.maxWidth = 0
+
+		return fmt.Errorf("max width cannot be negative, setting to 0 (no limit)")
+	}
+
+	ic.maxWidth = width
+
+	return nil
+}
+
+// SetMaxHeight sets the height above which images are downscaled, 0 means no limit
+func (ic *ImageCompressor) SetMaxHeight(height int) error {
+	if height < 0 {
+		ic.maxHeight = 0
+
+		return fmt.Errorf("max height cannot be negative, setting to 0 (no limit)")
+	}
+
+	ic.maxHeight = height
+
+	return nil
+}
+
+// SetKeepMetadata sets whether a kind of metadata is carried from the
+// original image to the compressed one, see MetadataKinds
+func (ic *ImageCompressor) SetKeepMetadata(kind string, keep bool) error {
+	kind = strings.ToLower(kind)
+	if !slices.Contains(MetadataKinds, kind) {
+		return fmt.Errorf("unknown metadata kind %q, expected one of: %s", kind, strings.Join(MetadataKinds, ", "))
+	}
+
+	ic.keepMetadata[kind] = keep
+
+	return nil
+}
+
+// SetMetadataPolicy sets which metadata is carried from the original image to
+// the compressed one, see MetadataPolicies. Kinds removed by SetKeepMetadata
+// stay removed whatever the policy.
+func (ic *ImageCompressor) SetMetadataPolicy(policy string) error {
+	policy, err := parseMetadataPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	ic.metadataPolicy = policy
+
+	return nil
+}
+
+// SetPrivacy removes GPS coordinates and camera serial numbers from the
+// metadata kept in compressed images
+func (ic *ImageCompressor) SetPrivacy(privacy bool) {
+	ic.privacy = privacy
+}
+
+// SetAutoOrient rotates and flips the pixels of images according to their
+// EXIF orientation, which is then reset to upright
+func (ic *ImageCompressor) SetAutoOrient(autoOrient bool) {
+	ic.autoOrient = autoOrient
+}
+
+// SetNoResize keeps every image at its original dimensions, whatever the maximum width and height
+func (ic *ImageCompressor) SetNoResize(noResize bool) {
+	ic.noResize = noResize
+}
+
+// resampleFilters maps the names accepted by SetResampleFilter to imaging filters
+var resampleFilters = map[string]imaging.ResampleFilter{
+	"nearest":    imaging.NearestNeighbor,
+	"box":        imaging.Box,
+	"linear":     imaging.Linear,
+	"hermite":    imaging.Hermite,
+	"mitchell":   imaging.MitchellNetravali,
+	"catmullrom": imaging.CatmullRom,
+	"bspline":    imaging.BSpline,
+	"gaussian":   imaging.Gaussian,
+	"lanczos":    imaging.Lanczos,
+}
+
+// SetResampleFilter sets the filter used to downscale images by its name
+func (ic *ImageCompressor) SetResampleFilter(name string) error {
+	filter, exists := resampleFilters[strings.ToLower(name)]
+	if !exists {
+		names := make([]string, 0, len(resampleFilters))
+		for n := range resampleFilters {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		return fmt.Errorf("unknown resample filter %q, expected one of: %s", name, strings.Join(names, ", "))
+	}
+
+	ic.resampleFilter = filter
+
+	return nil
+}
+
+func (ic *ImageCompressor) SetWebPQuality(quality int) error {
+	if quality < 1 {
+		ic.webpQuality = 1
+
+		return fmt.Errorf("WebP quality must be at least 1, setting to 1")
+	}
+
+	if quality > 100 {
+		ic.webpQuality = 100
+
+		return fmt.Errorf("WebP quality cannot exceed 100, setting to 100")
+	}
+
+	ic.webpQuality = quality
+
+	return nil
+}
+
+// SetPNGLossy enables the quantization of truecolor PNG images to a 256 color palette
+func (ic *ImageCompressor) SetPNGLossy(enabled bool) {
+	ic.pngLossy = enabled
+}
+
+// SetPNGDithering enables Floyd–Steinberg dithering when quantizing PNG images
+func (ic *ImageCompressor) SetPNGDithering(enabled bool) {
+	ic.pngDithering = enabled
+}
+
+// SetPNGMinQuality sets the quality (0-100) below which a quantized PNG image
+// is discarded in favor of the lossless encoding
+func (ic *ImageCompressor) SetPNGMinQuality(quality int) error {
+	if quality < 0 {
+		ic.pngMinQuality = 0
+
+		return fmt.Errorf("PNG minimum quality must be at least 0, setting to 0")
+	}
+
+	if quality > 100 {
+		ic.pngMinQuality = 100
+
+		return fmt.Errorf("PNG minimum quality cannot exceed 100, setting to 100")
+	}
+
+	ic.pngMinQuality = quality
+
+	return nil
+}
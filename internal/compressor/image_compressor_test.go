@@ -9,8 +9,10 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/jdecool/file-compressor/internal/webp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	xwebp "golang.org/x/image/webp"
 )
 
 func TestNewImageCompressor(t *testing.T) {
@@ -77,6 +79,10 @@ func createTestImage(t *testing.T, path string, format string) {
 		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 90})
 	case "png":
 		err = png.Encode(file, img)
+	case "webp":
+		err = webp.Encode(file, img, &webp.Options{Quality: 90})
+	case "webp-lossless":
+		err = webp.Encode(file, img, &webp.Options{Lossless: true})
 	case "gif":
 		// For GIF, we need to use a different approach since standard lib doesn't support GIF encoding
 		// We'll fall back to JPEG for this test
@@ -116,6 +122,7 @@ func TestImageCompressor_FormatPreservation(t *testing.T) {
 		{"JPEG format preservation", "jpeg", ".jpg", ".jpg"},
 		{"JPG format preservation", "jpeg", ".jpeg", ".jpg"},
 		{"PNG format preservation", "png", ".png", ".png"},
+		{"WebP format preservation", "webp", ".webp", ".webp"},
 	}
 
 	for _, tc := range testCases {
@@ -166,4 +173,313 @@ func TestImageCompressor_FormatPreservation_WithOutputExtension(t *testing.T) {
 	assert.NotNil(t, result)
 	assert.Equal(t, outputPath, result.CompressedFile, "Output path should be preserved when extension is already correct")
 	assert.FileExists(t, outputPath)
-}
\ No newline at end of file
+}
+
+func TestImageCompressor_CompressFile_WebP(t *testing.T) {
+	testCases := []struct {
+		name   string
+		format string
+	}{
+		{"Lossy WebP", "webp"},
+		{"Lossless WebP", "webp-lossless"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "test.webp")
+			outputPath := filepath.Join(tempDir, "compressed_test.webp")
+			createTestImage(t, inputPath, tc.format)
+
+			compressor := NewImageCompressor()
+			result, err := compressor.CompressFile(inputPath, outputPath)
+
+			require.NoError(t, err)
+			assert.Equal(t, outputPath, result.CompressedFile)
+
+			lossless, err := isLosslessWebP(outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.format == "webp-lossless", lossless, "Output should keep the source bitstream kind")
+
+			file, err := os.Open(outputPath)
+			require.NoError(t, err)
+			defer file.Close()
+
+			decoded, err := xwebp.Decode(file)
+			require.NoError(t, err)
+			assert.Equal(t, 10, decoded.Bounds().Dx())
+			assert.Equal(t, 10, decoded.Bounds().Dy())
+		})
+	}
+}
+
+func TestImageCompressor_SetWebPQuality(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	tests := []struct {
+		name        string
+		input       int
+		expected    int
+		expectError bool
+	}{
+		{"Valid quality", 60, 60, false},
+		{"Too low quality", 0, 1, true},
+		{"Too high quality", 101, 100, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compressor.SetWebPQuality(tt.input)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, compressor.webpQuality)
+		})
+	}
+}
+
+func TestImageCompressor_SetPNGMinQuality(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	tests := []struct {
+		name        string
+		input       int
+		expected    int
+		expectError bool
+	}{
+		{"Valid quality", 80, 80, false},
+		{"Too low quality", -1, 0, true},
+		{"Too high quality", 101, 100, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compressor.SetPNGMinQuality(tt.input)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, compressor.pngMinQuality)
+		})
+	}
+}
+
+func TestImageCompressor_ResizeDimensions(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxWidth       int
+		maxHeight      int
+		noResize       bool
+		width, height  int
+		expectedWidth  int
+		expectedHeight int
+		expectedResize bool
+	}{
+		{"Fits within limits", 2000, 2000, false, 1500, 1000, 1500, 1000, false},
+		{"Too wide", 2000, 2000, false, 4000, 1000, 2000, 500, true},
+		{"Too tall", 2000, 2000, false, 1000, 4000, 500, 2000, true},
+		{"Width limit only", 1200, 0, false, 2400, 6000, 1200, 3000, true},
+		{"No limits", 0, 0, false, 8000, 6000, 8000, 6000, false},
+		{"Never resize", 1200, 1200, true, 4000, 3000, 4000, 3000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressor := NewImageCompressor()
+			compressor.SetMaxWidth(tt.maxWidth)
+			compressor.SetMaxHeight(tt.maxHeight)
+			compressor.SetNoResize(tt.noResize)
+
+			width, height, resize := compressor.resizeDimensions(tt.width, tt.height)
+			assert.Equal(t, tt.expectedWidth, width)
+			assert.Equal(t, tt.expectedHeight, height)
+			assert.Equal(t, tt.expectedResize, resize)
+		})
+	}
+}
+
+func TestImageCompressor_CompressFile_MaxDimensions(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "test.jpg")
+	outputPath := filepath.Join(tempDir, "compressed_test.jpg")
+	createTestImage(t, inputPath, "jpeg")
+
+	compressor := NewImageCompressor()
+	compressor.SetMaxWidth(5)
+	require.NoError(t, compressor.SetResampleFilter("catmullrom"))
+
+	_, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+
+	file, err := os.Open(outputPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	require.NoError(t, err)
+	assert.Equal(t, 5, config.Width)
+	assert.Equal(t, 5, config.Height)
+}
+
+func TestImageCompressor_SetJPEGQuality(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	tests := []struct {
+		name        string
+		input       int
+		expected    int
+		expectError bool
+	}{
+		{"Valid quality", 75, 75, false},
+		{"Too low quality", 0, 1, true},
+		{"Too high quality", 101, 100, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compressor.SetJPEGQuality(tt.input)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, compressor.jpegQuality)
+		})
+	}
+}
+
+func TestImageCompressor_SetResampleFilter(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	assert.NoError(t, compressor.SetResampleFilter("Linear"))
+	assert.Error(t, compressor.SetResampleFilter("unknown"))
+}
+
+func TestImageCompressor_SetTargetSSIM(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	tests := []struct {
+		name        string
+		input       float64
+		expected    float64
+		expectError bool
+	}{
+		{"Valid score", 0.95, 0.95, false},
+		{"Disabled", 0, 0, false},
+		{"Negative score", -0.5, 0, true},
+		{"Too high score", 1.5, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compressor.SetTargetSSIM(tt.input)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, compressor.targetSSIM)
+		})
+	}
+}
+
+func TestImageCompressor_CompressFile_MaxFileSize(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "photo.jpg")
+
+	file, err := os.Create(inputPath)
+	require.NoError(t, err)
+	require.NoError(t, jpeg.Encode(file, createPhotoImage(300, 200), &jpeg.Options{Quality: 95}))
+	require.NoError(t, file.Close())
+
+	unlimited, err := NewImageCompressor().CompressFile(inputPath, filepath.Join(tempDir, "unlimited.jpg"))
+	require.NoError(t, err)
+
+	t.Run("Lowers quality", func(t *testing.T) {
+		compressor := NewImageCompressor()
+		require.NoError(t, compressor.SetMaxFileSize(unlimited.CompressedSize*3/4))
+
+		result, err := compressor.CompressFile(inputPath, filepath.Join(tempDir, "quality.jpg"))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, result.CompressedSize, unlimited.CompressedSize*3/4)
+		assert.Less(t, result.Quality, unlimited.Quality)
+	})
+
+	t.Run("Lowers dimensions", func(t *testing.T) {
+		compressor := NewImageCompressor()
+		require.NoError(t, compressor.SetMaxFileSize(unlimited.CompressedSize/4))
+		require.NoError(t, compressor.SetMinQuality(80))
+
+		outputPath := filepath.Join(tempDir, "dimensions.jpg")
+		result, err := compressor.CompressFile(inputPath, outputPath)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, result.CompressedSize, unlimited.CompressedSize/4)
+		assert.Equal(t, 80, result.Quality)
+
+		output, err := os.Open(outputPath)
+		require.NoError(t, err)
+		defer output.Close()
+
+		config, _, err := image.DecodeConfig(output)
+		require.NoError(t, err)
+		assert.Less(t, config.Width, 300)
+	})
+
+	t.Run("Unreachable size", func(t *testing.T) {
+		compressor := NewImageCompressor()
+		require.NoError(t, compressor.SetMaxFileSize(100))
+		require.NoError(t, compressor.SetMinQuality(90))
+
+		outputPath := filepath.Join(tempDir, "unreachable.jpg")
+		result, err := compressor.CompressFile(inputPath, outputPath)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot reach maximum file size of 100 B above quality 90")
+		assert.Nil(t, result)
+		assert.NoFileExists(t, outputPath)
+	})
+}
+
+func TestImageCompressor_SetMaxFileSize(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	assert.NoError(t, compressor.SetMaxFileSize(200*1024))
+	assert.Equal(t, int64(200*1024), compressor.maxFileSize)
+
+	assert.Error(t, compressor.SetMaxFileSize(-1))
+	assert.Equal(t, int64(0), compressor.maxFileSize)
+}
+
+func TestImageCompressor_SetMinQuality(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	tests := []struct {
+		name        string
+		input       int
+		expected    int
+		expectError bool
+	}{
+		{"Valid quality", 50, 50, false},
+		{"Too low quality", 0, 1, true},
+		{"Too high quality", 101, 100, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compressor.SetMinQuality(tt.input)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, compressor.minQuality)
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+)
+
+// JsonCompressor removes the insignificant whitespace of JSON documents and,
+// when canonicalizing, sorts the keys of their objects. Numbers and strings
+// keep their values, and documents which do not parse are never written.
+type JsonCompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	canonicalize       bool
+}
+
+func NewJsonCompressor() *JsonCompressor {
+	return &JsonCompressor{
+		supportedMimeTypes: []string{"application/json"},
+		logger:             logger.NewLogger(false),
+	}
+}
+
+func (jc *JsonCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	jc.logger.PrintfVerbose("JSON Compressor: Compressing file %s to %s\n", filepath.Base(filePath), filepath.Base(outputPath))
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON file: %v", err)
+	}
+
+	// The byte order mark is not part of the JSON grammar
+	document := bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var minified bytes.Buffer
+	if jc.canonicalize {
+		err = canonicalizeJSON(document, &minified)
+	} else {
+		err = json.Compact(&minified, document)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+	}
+
+	if err := os.WriteFile(outputPath, minified.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write JSON file: %v", err)
+	}
+
+	return &CompressionResult{
+		OriginalFile:   filePath,
+		CompressedFile: outputPath,
+		OriginalSize:   int64(len(data)),
+		CompressedSize: int64(minified.Len()),
+	}, nil
+}
+
+// canonicalizeJSON writes a JSON document without whitespace and with the keys
+// of its objects sorted, members with the same key keeping their order
+func canonicalizeJSON(data []byte, w *bytes.Buffer) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	if err := writeCanonicalJSON(d, w); err != nil {
+		return err
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after top-level value")
+	}
+
+	return nil
+}
+
+func writeCanonicalJSON(d *json.Decoder, w *bytes.Buffer) error {
+	token, err := d.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			w.WriteByte('[')
+			for i := 0; d.More(); i++ {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				if err := writeCanonicalJSON(d, w); err != nil {
+					return err
+				}
+			}
+			w.WriteByte(']')
+		} else {
+			type member struct {
+				key   string
+				value bytes.Buffer
+			}
+			var members []*member
+			for d.More() {
+				key, err := d.Token()
+				if err != nil {
+					return err
+				}
+				m := &member{key: key.(string)}
+				if err := writeCanonicalJSON(d, &m.value); err != nil {
+					return err
+				}
+				members = append(members, m)
+			}
+			slices.SortStableFunc(members, func(a, b *member) int {
+				return strings.Compare(a.key, b.key)
+			})
+
+			w.WriteByte('{')
+			for i, m := range members {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				writeJSONString(w, m.key)
+				w.WriteByte(':')
+				w.Write(m.value.Bytes())
+			}
+			w.WriteByte('}')
+		}
+
+		// Closing delimiter
+		_, err := d.Token()
+		return err
+	case string:
+		writeJSONString(w, token)
+	case json.Number:
+		w.WriteString(token.String())
+	case bool:
+		w.WriteString(fmt.Sprint(token))
+	case nil:
+		w.WriteString("null")
+	}
+
+	return nil
+}
+
+// writeJSONString writes a JSON string, without escaping HTML characters
+func writeJSONString(w *bytes.Buffer, s string) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+
+	w.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+func (jc *JsonCompressor) GetSupportedMimeTypes() []string {
+	return jc.supportedMimeTypes
+}
+
+func (jc *JsonCompressor) SetLogger(logger *logger.Logger) {
+	jc.logger = logger
+}
+
+// SetCanonicalize enables the sorting of the keys of objects
+func (jc *JsonCompressor) SetCanonicalize(canonicalize bool) {
+	jc.canonicalize = canonicalize
+}
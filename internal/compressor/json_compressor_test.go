@@ -0,0 +1,114 @@
+package compressor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrettyJSON = "\xef\xbb\xbf" + `{
+  "name": "export",
+  "items": [
+    { "id": 2, "price": 1.50e2, "tags": [] },
+    { "id": 1, "label": "<a & b>", "text": "café" }
+  ],
+  "empty": {},
+  "active": true,
+  "parent": null
+}
+`
+
+func TestJsonCompressor_CompressFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		canonicalize bool
+		expected     string
+	}{
+		{
+			"Minified",
+			false,
+			`{"name":"export","items":[{"id":2,"price":1.50e2,"tags":[]},{"id":1,"label":"<a & b>","text":"café"}],"empty":{},"active":true,"parent":null}`,
+		},
+		{
+			"Canonicalized",
+			true,
+			`{"active":true,"empty":{},"items":[{"id":2,"price":1.50e2,"tags":[]},{"id":1,"label":"<a & b>","text":"café"}],"name":"export","parent":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "export.json")
+			outputPath := filepath.Join(tempDir, "compressed_export.json")
+			require.NoError(t, os.WriteFile(inputPath, []byte(testPrettyJSON), 0644))
+
+			compressor := NewJsonCompressor()
+			compressor.SetCanonicalize(tt.canonicalize)
+
+			result, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			output, err := os.ReadFile(outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(output))
+			assert.Equal(t, int64(len(testPrettyJSON)), result.OriginalSize)
+			assert.Equal(t, int64(len(output)), result.CompressedSize)
+		})
+	}
+}
+
+func TestJsonCompressor_CanonicalizeDuplicateKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "data.json")
+	outputPath := filepath.Join(tempDir, "compressed_data.json")
+	require.NoError(t, os.WriteFile(inputPath, []byte(`[ {"b": 1, "a": 2, "b": 3}, "x", -0.0 ]`), 0644))
+
+	compressor := NewJsonCompressor()
+	compressor.SetCanonicalize(true)
+
+	_, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, `[{"a":2,"b":1,"b":3},"x",-0.0]`, string(output))
+}
+
+func TestJsonCompressor_CompressFileInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"Truncated", `{"name": "export", "items": [`},
+		{"Trailing comma", `{"name": "export",}`},
+		{"Several values", `{} {}`},
+		{"Empty", ``},
+	}
+
+	for _, tt := range tests {
+		for _, canonicalize := range []bool{false, true} {
+			t.Run(tt.name, func(t *testing.T) {
+				tempDir := t.TempDir()
+				inputPath := filepath.Join(tempDir, "broken.json")
+				outputPath := filepath.Join(tempDir, "compressed_broken.json")
+				require.NoError(t, os.WriteFile(inputPath, []byte(tt.document), 0644))
+
+				compressor := NewJsonCompressor()
+				compressor.SetCanonicalize(canonicalize)
+
+				_, err := compressor.CompressFile(inputPath, outputPath)
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "failed to parse JSON")
+				assert.NoFileExists(t, outputPath)
+			})
+		}
+	}
+}
+
+func TestJsonCompressor_GetSupportedMimeTypes(t *testing.T) {
+	assert.Equal(t, []string{"application/json"}, NewJsonCompressor().GetSupportedMimeTypes())
+}
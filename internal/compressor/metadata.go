@@ -0,0 +1,200 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kinds of metadata carried from the original image to the compressed one
+const (
+	MetadataEXIF = "exif"
+	// MetadataICC covers ICC profiles, and the other color space chunks of PNG images
+	MetadataICC  = "icc"
+	MetadataXMP  = "xmp"
+	MetadataIPTC = "iptc"
+)
+
+// jpegXMPHeader prefixes the XMP packet of a JPEG APP1 segment
+const jpegXMPHeader = "http://ns.adobe.com/xap/1.0/\x00"
+
+// MetadataKinds lists every kind of metadata that can be kept or stripped
+var MetadataKinds = []string{MetadataEXIF, MetadataICC, MetadataXMP, MetadataIPTC}
+
+// jpegMetadataKind returns the kind of metadata held by a JPEG segment, or an
+// empty string when it holds none
+func jpegMetadataKind(marker byte, data []byte) string {
+	switch {
+	case marker == 0xe1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")):
+		return MetadataEXIF
+	case marker == 0xe1 && (bytes.HasPrefix(data, []byte(jpegXMPHeader)) ||
+		bytes.HasPrefix(data, []byte("http://ns.adobe.com/xmp/extension/\x00"))):
+		return MetadataXMP
+	case marker == 0xe2 && bytes.HasPrefix(data, []byte("ICC_PROFILE\x00")):
+		return MetadataICC
+	case marker == 0xed && bytes.HasPrefix(data, []byte("Photoshop 3.0\x00")):
+		return MetadataIPTC
+	default:
+		return ""
+	}
+}
+
+// pngChunk is a chunk of a PNG file, without its length and CRC
+type pngChunk struct {
+	chunkType string
+	data      []byte
+}
+
+// pngMetadataKind returns the kind of metadata held by a PNG chunk, or an
+// empty string when it holds none
+func pngMetadataKind(chunk pngChunk) string {
+	switch chunk.chunkType {
+	case "iCCP", "sRGB", "gAMA", "cHRM":
+		return MetadataICC
+	case "eXIf":
+		return MetadataEXIF
+	case "tEXt", "zTXt", "iTXt":
+		keyword, _, _ := bytes.Cut(chunk.data, []byte{0})
+		switch strings.ToLower(string(keyword)) {
+		case "xml:com.adobe.xmp":
+			return MetadataXMP
+		case "raw profile type exif", "raw profile type app1":
+			return MetadataEXIF
+		case "raw profile type iptc", "raw profile type 8bim":
+			return MetadataIPTC
+		case "raw profile type icc", "raw profile type icm":
+			return MetadataICC
+		}
+	}
+
+	return ""
+}
+
+// pngText returns the keyword and the uncompressed text of a tEXt, zTXt or iTXt chunk
+func pngText(chunk pngChunk) (string, []byte, error) {
+	keyword, rest, found := bytes.Cut(chunk.data, []byte{0})
+	if !found {
+		return "", nil, fmt.Errorf("missing %s keyword", chunk.chunkType)
+	}
+
+	switch chunk.chunkType {
+	case "tEXt":
+		return string(keyword), rest, nil
+	case "zTXt":
+		if len(rest) < 1 {
+			return "", nil, fmt.Errorf("truncated zTXt chunk")
+		}
+
+		text, err := inflatePNGText(rest[1:])
+
+		return string(keyword), text, err
+	case "iTXt":
+		if len(rest) < 2 {
+			return "", nil, fmt.Errorf("truncated iTXt chunk")
+		}
+
+		// Compression flag and method, then the language tag and the translated keyword
+		compressed := rest[0] == 1
+		rest = rest[2:]
+		for range 2 {
+			if _, rest, found = bytes.Cut(rest, []byte{0}); !found {
+				return "", nil, fmt.Errorf("truncated iTXt chunk")
+			}
+		}
+
+		if !compressed {
+			return string(keyword), rest, nil
+		}
+
+		text, err := inflatePNGText(rest)
+
+		return string(keyword), text, err
+	default:
+		return "", nil, fmt.Errorf("not a text chunk")
+	}
+}
+
+// inflatePNGText decompresses the text of a zTXt or compressed iTXt chunk
+func inflatePNGText(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// newPNGITXtChunk builds an uncompressed iTXt chunk without language tag
+func newPNGITXtChunk(keyword string, text []byte) pngChunk {
+	data := append([]byte(keyword), 0, 0, 0, 0, 0)
+
+	return pngChunk{chunkType: "iTXt", data: append(data, text...)}
+}
+
+// parsePNGChunks splits a PNG file into its chunks
+func parsePNGChunks(data []byte) ([]pngChunk, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+
+	var chunks []pngChunk
+	for offset := len(signature); offset < len(data); {
+		if offset+8 > len(data) {
+			return nil, fmt.Errorf("truncated chunk header at offset %d", offset)
+		}
+
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		chunkType := string(data[offset+4 : offset+8])
+		if length < 0 || offset+12+length > len(data) {
+			return nil, fmt.Errorf("truncated %s chunk at offset %d", chunkType, offset)
+		}
+
+		chunks = append(chunks, pngChunk{chunkType: chunkType, data: data[offset+8 : offset+8+length]})
+		offset += 12 + length
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return chunks, nil
+}
+
+// writePNGChunks builds a PNG file from its chunks
+func writePNGChunks(chunks []pngChunk) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	for _, chunk := range chunks {
+		if err := writePNGChunk(&buf, chunk.chunkType, chunk.data); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// insertPNGMetadata inserts metadata chunks right after the IHDR chunk of a
+// PNG file, which places them before PLTE and IDAT as the color chunks require
+func insertPNGMetadata(data []byte, metadata []pngChunk) ([]byte, error) {
+	if len(metadata) == 0 {
+		return data, nil
+	}
+
+	chunks, err := parsePNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].chunkType != "IHDR" {
+		return nil, fmt.Errorf("missing IHDR chunk")
+	}
+
+	withMetadata := append([]pngChunk{chunks[0]}, metadata...)
+	withMetadata = append(withMetadata, chunks[1:]...)
+
+	return writePNGChunks(withMetadata)
+}
@@ -0,0 +1,506 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Metadata policies, from the most to the least permissive
+const (
+	MetadataPolicyKeepAll = "keep-all"
+	// MetadataPolicyKeepCopyrightAndColor keeps the author and copyright
+	// notices, the color profiles and the image orientation only
+	MetadataPolicyKeepCopyrightAndColor = "keep-copyright-and-color"
+	MetadataPolicyStripAll              = "strip-all"
+)
+
+// MetadataPolicies lists every metadata policy
+var MetadataPolicies = []string{MetadataPolicyKeepAll, MetadataPolicyKeepCopyrightAndColor, MetadataPolicyStripAll}
+
+// parseMetadataPolicy validates and normalizes the name of a metadata policy
+func parseMetadataPolicy(policy string) (string, error) {
+	policy = strings.ToLower(policy)
+	if !slices.Contains(MetadataPolicies, policy) {
+		return "", fmt.Errorf("unknown metadata policy %q, expected one of: %s", policy, strings.Join(MetadataPolicies, ", "))
+	}
+
+	return policy, nil
+}
+
+// EXIF tags affected by the metadata policies
+const (
+	exifTagArtist             = 0x013b
+	exifTagCopyright          = 0x8298
+	exifTagGPSIFDPointer      = 0x8825
+	exifTagMakerNote          = 0x927c
+	exifTagBodySerialNumber   = 0xa431
+	exifTagLensSerialNumber   = 0xa435
+	exifTagCameraSerialNumber = 0xc62f
+)
+
+// exifPrivacyTags are removed in privacy mode. Maker notes are included as
+// most cameras store their serial number there.
+var exifPrivacyTags = map[uint16]bool{
+	exifTagGPSIFDPointer:      true,
+	exifTagMakerNote:          true,
+	exifTagBodySerialNumber:   true,
+	exifTagLensSerialNumber:   true,
+	exifTagCameraSerialNumber: true,
+}
+
+// exifCopyrightTags are kept by the keep-copyright-and-color policy
+var exifCopyrightTags = []uint16{exifTagOrientation, exifTagArtist, exifTagCopyright}
+
+// XMP properties removed in privacy mode from images and from PDF documents
+var (
+	imagePrivacyXMPProperties = []string{"exif:GPS*", "exifEX:BodySerialNumber", "exifEX:LensSerialNumber", "aux:SerialNumber", "aux:LensSerialNumber"}
+	pdfPrivacyXMPProperties   = []string{"dc:creator", "pdf:Author"}
+)
+
+// copyrightXMPProperties are kept by the keep-copyright-and-color policy
+var copyrightXMPProperties = []string{"dc:creator", "dc:rights", "xmpRights:*"}
+
+// IPTC datasets kept by the keep-copyright-and-color policy: coded character
+// set, record version, by-line, credit and copyright notice
+var copyrightIPTCDatasets = map[[2]byte]bool{
+	{1, 90}:  true,
+	{2, 0}:   true,
+	{2, 80}:  true,
+	{2, 110}: true,
+	{2, 116}: true,
+}
+
+// applyEXIFPolicy returns an EXIF segment as kept by a metadata policy, or nil when it is removed
+func applyEXIFPolicy(segment []byte, policy string, privacy bool) ([]byte, error) {
+	switch policy {
+	case MetadataPolicyStripAll:
+		return nil, nil
+	case MetadataPolicyKeepCopyrightAndColor:
+		return copyrightEXIFSegment(segment)
+	}
+
+	if privacy {
+		return removeEXIFTags(segment, exifPrivacyTags)
+	}
+
+	return segment, nil
+}
+
+// applyXMPPolicy returns an XMP packet as kept by a metadata policy, or nil when it is removed
+func applyXMPPolicy(packet []byte, policy string, privacy bool, privacyProperties []string) []byte {
+	switch policy {
+	case MetadataPolicyStripAll:
+		return nil
+	case MetadataPolicyKeepCopyrightAndColor:
+		packet = copyrightXMPPacket(packet)
+	}
+
+	if privacy && packet != nil {
+		packet = removeXMPProperties(packet, privacyProperties)
+	}
+
+	return packet
+}
+
+// applyIPTCPolicy returns a Photoshop IRB segment as kept by a metadata policy, or nil when it is removed
+func applyIPTCPolicy(segment []byte, policy string) ([]byte, error) {
+	switch policy {
+	case MetadataPolicyStripAll:
+		return nil, nil
+	case MetadataPolicyKeepCopyrightAndColor:
+		return copyrightIPTCSegment(segment)
+	}
+
+	return segment, nil
+}
+
+// exifTypeSizes maps the EXIF field types to the size of one of their values
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// exifEntry is an IFD entry, with its value bytes
+type exifEntry struct {
+	tag       uint16
+	fieldType uint16
+	count     uint32
+	value     []byte
+}
+
+// readEXIFEntry returns the entry at an offset of an IFD, with the bytes of
+// its value and their offset when they are stored out of the entry
+func readEXIFEntry(tiff []byte, order binary.ByteOrder, offset int) (exifEntry, int, error) {
+	entry := exifEntry{
+		tag:       order.Uint16(tiff[offset : offset+2]),
+		fieldType: order.Uint16(tiff[offset+2 : offset+4]),
+		count:     order.Uint32(tiff[offset+4 : offset+8]),
+	}
+
+	size := exifTypeSizes[entry.fieldType] * int(entry.count)
+	if size <= 4 {
+		entry.value = tiff[offset+8 : offset+8+size]
+
+		return entry, -1, nil
+	}
+
+	valueOffset := int(order.Uint32(tiff[offset+8 : offset+12]))
+	if valueOffset+size > len(tiff) || size < 0 {
+		return entry, -1, fmt.Errorf("invalid value offset for tag 0x%04x", entry.tag)
+	}
+	entry.value = tiff[valueOffset : valueOffset+size]
+
+	return entry, valueOffset, nil
+}
+
+// removeEXIFTags returns a copy of an EXIF segment without the given tags of
+// IFD0 and of the Exif sub-IFD. Removed values are zeroed in place so that
+// the offsets of the other values stay valid, and the sub-IFD of a removed
+// pointer tag, such as the GPS IFD, is zeroed as a whole.
+func removeEXIFTags(segment []byte, tags map[uint16]bool) ([]byte, error) {
+	updated := make([]byte, len(segment))
+	copy(updated, segment)
+
+	tiff, order, err := exifTIFF(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	visited := map[uint32]bool{}
+	var scrub func(offset uint32, removeAll bool) error
+	scrub = func(offset uint32, removeAll bool) error {
+		if visited[offset] {
+			return nil
+		}
+		visited[offset] = true
+
+		if int(offset)+2 > len(tiff) {
+			return fmt.Errorf("invalid IFD offset %d", offset)
+		}
+
+		count := int(order.Uint16(tiff[offset : offset+2]))
+		entries := int(offset) + 2
+		end := entries + count*12
+		if end > len(tiff) {
+			return fmt.Errorf("truncated IFD at offset %d", offset)
+		}
+
+		kept := make([]byte, 0, count*12)
+		for i := 0; i < count; i++ {
+			raw := tiff[entries+i*12 : entries+(i+1)*12]
+			entry, valueOffset, err := readEXIFEntry(tiff, order, entries+i*12)
+			if err != nil {
+				return err
+			}
+
+			remove := removeAll || tags[entry.tag]
+			if (entry.tag == exifTagExifIFDPointer || entry.tag == exifTagGPSIFDPointer) && entry.fieldType == exifTypeLong {
+				if err := scrub(order.Uint32(raw[8:12]), remove); err != nil {
+					return err
+				}
+			}
+
+			if !remove {
+				kept = append(kept, raw...)
+				continue
+			}
+			if valueOffset >= 0 {
+				clear(entry.value)
+			}
+		}
+
+		// The entries are packed, followed by the pointer to the next IFD
+		var next []byte
+		if end+4 <= len(tiff) {
+			next = slices.Clone(tiff[end : end+4])
+		}
+
+		order.PutUint16(tiff[offset:offset+2], uint16(len(kept)/12))
+		copy(tiff[entries:], kept)
+		clear(tiff[entries+len(kept) : end])
+		if next != nil {
+			clear(tiff[end : end+4])
+			copy(tiff[entries+len(kept):], next)
+		}
+
+		return nil
+	}
+
+	if err := scrub(order.Uint32(tiff[4:8]), false); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// copyrightEXIFSegment builds an EXIF segment holding only the orientation,
+// artist and copyright tags of another one, or nil when it has none of them
+func copyrightEXIFSegment(segment []byte) ([]byte, error) {
+	tiff, order, err := exifTIFF(segment)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return nil, fmt.Errorf("invalid IFD offset %d", offset)
+	}
+
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	if offset+2+count*12 > len(tiff) {
+		return nil, fmt.Errorf("truncated IFD at offset %d", offset)
+	}
+
+	var entries []exifEntry
+	for i := 0; i < count; i++ {
+		entry, _, err := readEXIFEntry(tiff, order, offset+2+i*12)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(exifCopyrightTags, entry.tag) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	return buildEXIFSegment(order, entries), nil
+}
+
+// buildEXIFSegment builds an EXIF segment whose IFD0 holds the given entries
+func buildEXIFSegment(order binary.ByteOrder, entries []exifEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	ifdSize := 2 + len(entries)*12 + 4
+	tiff := make([]byte, 8+ifdSize)
+	if order == binary.LittleEndian {
+		copy(tiff[0:2], "II")
+	} else {
+		copy(tiff[0:2], "MM")
+	}
+	order.PutUint16(tiff[2:4], 42)
+	order.PutUint32(tiff[4:8], 8)
+	order.PutUint16(tiff[8:10], uint16(len(entries)))
+
+	for i, entry := range entries {
+		raw := tiff[10+i*12 : 10+(i+1)*12]
+		order.PutUint16(raw[0:2], entry.tag)
+		order.PutUint16(raw[2:4], entry.fieldType)
+		order.PutUint32(raw[4:8], entry.count)
+
+		if len(entry.value) <= 4 {
+			copy(raw[8:12], entry.value)
+			continue
+		}
+
+		// Values are word aligned
+		if len(tiff)%2 != 0 {
+			tiff = append(tiff, 0)
+			raw = tiff[10+i*12 : 10+(i+1)*12]
+		}
+		order.PutUint32(raw[8:12], uint32(len(tiff)))
+		tiff = append(tiff, entry.value...)
+	}
+
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// copyrightIPTCSegment builds a Photoshop IRB segment holding only the IPTC
+// datasets naming the author and the copyright of another one, or nil when
+// it has none of them
+func copyrightIPTCSegment(segment []byte) ([]byte, error) {
+	const header = "Photoshop 3.0\x00"
+	if !bytes.HasPrefix(segment, []byte(header)) {
+		return nil, fmt.Errorf("not a Photoshop segment")
+	}
+
+	var datasets []byte
+	found := false
+	for offset := len(header); offset+12 <= len(segment); {
+		id := binary.BigEndian.Uint16(segment[offset+4 : offset+6])
+
+		// The resource name is a Pascal string padded to an even length
+		nameLen := int(segment[offset+6]) + 1
+		nameLen += nameLen % 2
+		sizeOffset := offset + 6 + nameLen
+		if sizeOffset+4 > len(segment) {
+			return nil, fmt.Errorf("truncated resource at offset %d", offset)
+		}
+
+		size := int(binary.BigEndian.Uint32(segment[sizeOffset : sizeOffset+4]))
+		data := sizeOffset + 4
+		if data+size > len(segment) || size < 0 {
+			return nil, fmt.Errorf("truncated resource at offset %d", offset)
+		}
+		offset = data + size + size%2
+
+		if id != 0x0404 {
+			continue
+		}
+
+		// IPTC-IIM datasets: tag marker, record, dataset and length
+		iptc := segment[data : data+size]
+		for pos := 0; pos+5 <= len(iptc) && iptc[pos] == 0x1c; {
+			record, dataset := iptc[pos+1], iptc[pos+2]
+			length := int(binary.BigEndian.Uint16(iptc[pos+3 : pos+5]))
+			start := pos + 5
+			if length&0x8000 != 0 {
+				// Extended dataset, its length is stored in the next bytes
+				lengthSize := length & 0x7fff
+				if lengthSize > 4 || start+lengthSize > len(iptc) {
+					return nil, fmt.Errorf("invalid IPTC dataset %d:%d", record, dataset)
+				}
+				length = 0
+				for _, b := range iptc[start : start+lengthSize] {
+					length = length<<8 | int(b)
+				}
+				start += lengthSize
+			}
+			if start+length > len(iptc) {
+				return nil, fmt.Errorf("truncated IPTC dataset %d:%d", record, dataset)
+			}
+
+			if copyrightIPTCDatasets[[2]byte{record, dataset}] {
+				datasets = append(datasets, iptc[pos:start+length]...)
+				found = found || record == 2 && dataset != 0
+			}
+			pos = start + length
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	resource := []byte("8BIM\x04\x04\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint32(resource[8:12], uint32(len(datasets)))
+	resource = append(resource, datasets...)
+	if len(datasets)%2 != 0 {
+		resource = append(resource, 0)
+	}
+
+	return append([]byte(header), resource...), nil
+}
+
+var (
+	xmpAttributePattern = regexp.MustCompile(`\s([A-Za-z][\w.-]*:[\w.-]+)\s*=\s*(?:"[^"]*"|'[^']*')`)
+	xmpElementPattern   = regexp.MustCompile(`<([A-Za-z][\w.-]*:[\w.-]+)[\s/>]`)
+)
+
+// xmpNameMatches reports whether the qualified name of an XMP property
+// matches one of the names, which may end with a wildcard
+func xmpNameMatches(name string, names []string) bool {
+	for _, n := range names {
+		if prefix, ok := strings.CutSuffix(n, "*"); ok && strings.HasPrefix(name, prefix) || name == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findXMPProperties returns the byte ranges of the properties of an XMP
+// packet matching the names, either written as attributes or as elements.
+// Properties are matched by their conventional prefix, which is how every
+// XMP writer serializes them.
+func findXMPProperties(packet []byte, names []string) (attributes, elements [][2]int) {
+	for _, match := range xmpAttributePattern.FindAllSubmatchIndex(packet, -1) {
+		if xmpNameMatches(string(packet[match[2]:match[3]]), names) {
+			attributes = append(attributes, [2]int{match[0], match[1]})
+		}
+	}
+
+	for offset := 0; offset < len(packet); {
+		match := xmpElementPattern.FindSubmatchIndex(packet[offset:])
+		if match == nil {
+			break
+		}
+
+		start := offset + match[0]
+		name := string(packet[offset+match[2] : offset+match[3]])
+		offset += match[1] - 1
+		if !xmpNameMatches(name, names) {
+			continue
+		}
+
+		tagEnd := bytes.IndexByte(packet[start:], '>')
+		if tagEnd < 0 {
+			break
+		}
+		end := start + tagEnd + 1
+		if packet[end-2] != '/' {
+			closing := bytes.Index(packet[end:], []byte("</"+name))
+			if closing < 0 {
+				continue
+			}
+			closingEnd := bytes.IndexByte(packet[end+closing:], '>')
+			if closingEnd < 0 {
+				continue
+			}
+			end += closing + closingEnd + 1
+		}
+
+		elements = append(elements, [2]int{start, end})
+		offset = end
+	}
+
+	return attributes, elements
+}
+
+// removeXMPProperties returns a copy of an XMP packet without the properties matching the names
+func removeXMPProperties(packet []byte, names []string) []byte {
+	attributes, elements := findXMPProperties(packet, names)
+	ranges := append(attributes, elements...)
+	if len(ranges) == 0 {
+		return packet
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	var buf bytes.Buffer
+	offset := 0
+	for _, r := range ranges {
+		// Attributes of a removed element are removed with it
+		if r[0] < offset {
+			continue
+		}
+		buf.Write(packet[offset:r[0]])
+		offset = r[1]
+	}
+	buf.Write(packet[offset:])
+
+	return buf.Bytes()
+}
+
+// copyrightXMPPacket builds an XMP packet holding only the author and rights
+// properties of another one, or nil when it has none of them
+func copyrightXMPPacket(packet []byte) []byte {
+	attributes, elements := findXMPProperties(packet, copyrightXMPProperties)
+	if len(attributes) == 0 && len(elements) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	buf.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/"`)
+	for _, r := range attributes {
+		// Attributes of a kept element are copied with it
+		if slices.ContainsFunc(elements, func(e [2]int) bool { return e[0] <= r[0] && r[1] <= e[1] }) {
+			continue
+		}
+		buf.Write(packet[r[0]:r[1]])
+	}
+	buf.WriteString(">")
+	for _, r := range elements {
+		buf.Write(packet[r[0]:r[1]])
+	}
+	buf.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>\n")
+	buf.WriteString(`<?xpacket end="w"?>`)
+
+	return buf.Bytes()
+}
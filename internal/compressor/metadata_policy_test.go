@@ -0,0 +1,312 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrivateXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:aux="http://ns.adobe.com/exif/1.0/aux/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/"
+ exif:GPSLatitude="48,51.5N" aux:SerialNumber='0123456' xmpRights:Marked="True" exif:ExposureTime="1/60">
+<exif:GPSLongitude>2,17.7E</exif:GPSLongitude>
+<exif:GPSAltitude/>
+<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">(c) Jane Doe</rdf:li></rdf:Alt></dc:rights>
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Holidays</rdf:li></rdf:Alt></dc:title>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+// createPrivateEXIFSegment builds an EXIF segment with an orientation, an
+// artist and a copyright in IFD0, a body serial number in the Exif sub-IFD,
+// and a GPS position
+func createPrivateEXIFSegment() []byte {
+	order := binary.BigEndian
+	ascii := func(tag uint16, s string) exifEntry {
+		return exifEntry{tag: tag, fieldType: exifTypeASCII, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+	}
+	long := func(tag uint16) exifEntry {
+		return exifEntry{tag: tag, fieldType: exifTypeLong, count: 1, value: make([]byte, 4)}
+	}
+
+	latitude := make([]byte, 24)
+	for i, v := range []uint32{48, 1, 51, 1, 30, 1} {
+		order.PutUint32(latitude[i*4:], v)
+	}
+
+	ifds := [][]exifEntry{
+		{
+			{tag: exifTagOrientation, fieldType: exifTypeShort, count: 1, value: []byte{0, 6}},
+			ascii(exifTagArtist, "Jane Doe"),
+			ascii(exifTagCopyright, "(c) Jane Doe"),
+			long(exifTagExifIFDPointer),
+			long(exifTagGPSIFDPointer),
+		},
+		{ascii(exifTagBodySerialNumber, "SN123456789")},
+		{ascii(0x0001, "N"), {tag: 0x0002, fieldType: 5, count: 3, value: latitude}},
+	}
+
+	offsets := []int{8}
+	for _, ifd := range ifds {
+		offsets = append(offsets, offsets[len(offsets)-1]+2+len(ifd)*12+4)
+	}
+	order.PutUint32(ifds[0][3].value, uint32(offsets[1]))
+	order.PutUint32(ifds[0][4].value, uint32(offsets[2]))
+
+	tiff := make([]byte, offsets[len(ifds)])
+	copy(tiff, "MM")
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	for i, ifd := range ifds {
+		order.PutUint16(tiff[offsets[i]:], uint16(len(ifd)))
+		for j, entry := range ifd {
+			raw := tiff[offsets[i]+2+j*12:]
+			order.PutUint16(raw[0:], entry.tag)
+			order.PutUint16(raw[2:], entry.fieldType)
+			order.PutUint32(raw[4:], entry.count)
+			if len(entry.value) <= 4 {
+				copy(raw[8:12], entry.value)
+				continue
+			}
+			order.PutUint32(raw[8:], uint32(len(tiff)))
+			tiff = append(tiff, entry.value...)
+		}
+	}
+
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// createIPTCSegment builds a Photoshop APP13 segment holding IPTC datasets
+func createIPTCSegment(datasets map[[2]byte]string) []byte {
+	var iptc []byte
+	for _, key := range [][2]byte{{2, 0}, {2, 5}, {2, 80}, {2, 90}, {2, 116}} {
+		value, ok := datasets[key]
+		if !ok {
+			continue
+		}
+		iptc = append(iptc, 0x1c, key[0], key[1], byte(len(value)>>8), byte(len(value)))
+		iptc = append(iptc, value...)
+	}
+
+	segment := []byte("Photoshop 3.0\x00")
+	// A resolution resource precedes the IPTC one
+	segment = append(segment, "8BIM\x03\xed\x00\x00\x00\x00\x00\x02\x00\x48"...)
+	segment = append(segment, "8BIM\x04\x04\x00\x00"...)
+	segment = binary.BigEndian.AppendUint32(segment, uint32(len(iptc)))
+	segment = append(segment, iptc...)
+	if len(iptc)%2 != 0 {
+		segment = append(segment, 0)
+	}
+
+	return segment
+}
+
+func decodeEXIFSegment(t *testing.T, segment []byte) *exif.Exif {
+	x, err := exif.Decode(bytes.NewReader(segment[6:]))
+	require.NoError(t, err)
+
+	return x
+}
+
+func TestRemoveEXIFTags(t *testing.T) {
+	segment := createPrivateEXIFSegment()
+
+	scrubbed, err := removeEXIFTags(segment, exifPrivacyTags)
+	require.NoError(t, err)
+	assert.Len(t, scrubbed, len(segment))
+	assert.NotContains(t, string(scrubbed), "SN123456789")
+
+	x := decodeEXIFSegment(t, scrubbed)
+	assert.Equal(t, 6, readEXIFInt(t, x, exif.Orientation))
+	_, err = x.Get(exif.GPSLatitude)
+	assert.Error(t, err)
+	_, err = x.Get(exif.Artist)
+	assert.NoError(t, err)
+
+	// The GPS position itself is erased, not only unreferenced
+	x = decodeEXIFSegment(t, segment)
+	latitude, err := x.Get(exif.GPSLatitude)
+	require.NoError(t, err)
+	assert.NotContains(t, string(scrubbed), string(latitude.Val))
+
+	_, err = removeEXIFTags([]byte("Exif\x00\x00XX"), exifPrivacyTags)
+	assert.Error(t, err)
+}
+
+func TestCopyrightEXIFSegment(t *testing.T) {
+	segment, err := copyrightEXIFSegment(createPrivateEXIFSegment())
+	require.NoError(t, err)
+	assert.NotContains(t, string(segment), "SN123456789")
+
+	x := decodeEXIFSegment(t, segment)
+	assert.Equal(t, 6, readEXIFInt(t, x, exif.Orientation))
+	artist, err := x.Get(exif.Artist)
+	require.NoError(t, err)
+	value, err := artist.StringVal()
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe", value)
+	copyright, err := x.Get(exif.Copyright)
+	require.NoError(t, err)
+	value, err = copyright.StringVal()
+	require.NoError(t, err)
+	assert.Equal(t, "(c) Jane Doe", value)
+	_, err = x.Get(exif.GPSLatitude)
+	assert.Error(t, err)
+
+	// Nothing to keep
+	segment, err = copyrightEXIFSegment(buildEXIFSegment(binary.LittleEndian, []exifEntry{
+		{tag: exifTagImageWidth, fieldType: exifTypeShort, count: 1, value: []byte{10, 0}},
+	}))
+	require.NoError(t, err)
+	assert.Nil(t, segment)
+}
+
+func TestCopyrightIPTCSegment(t *testing.T) {
+	segment, err := copyrightIPTCSegment(createIPTCSegment(map[[2]byte]string{
+		{2, 0}:   "\x00\x04",
+		{2, 5}:   "Holidays",
+		{2, 80}:  "Jane Doe",
+		{2, 90}:  "Paris",
+		{2, 116}: "(c) Jane Doe",
+	}))
+	require.NoError(t, err)
+	assert.Contains(t, string(segment), "Jane Doe")
+	assert.Contains(t, string(segment), "(c) Jane Doe")
+	assert.NotContains(t, string(segment), "Holidays")
+	assert.NotContains(t, string(segment), "Paris")
+	assert.Equal(t, MetadataIPTC, jpegMetadataKind(0xed, segment))
+
+	segment, err = copyrightIPTCSegment(createIPTCSegment(map[[2]byte]string{{2, 0}: "\x00\x04", {2, 5}: "Holidays"}))
+	require.NoError(t, err)
+	assert.Nil(t, segment)
+
+	_, err = copyrightIPTCSegment([]byte("Exif\x00\x00"))
+	assert.Error(t, err)
+}
+
+func TestRemoveXMPProperties(t *testing.T) {
+	packet := string(removeXMPProperties([]byte(testPrivateXMP), imagePrivacyXMPProperties))
+
+	assert.NotContains(t, packet, "GPS")
+	assert.NotContains(t, packet, "SerialNumber")
+	assert.Contains(t, packet, `exif:ExposureTime="1/60"`)
+	assert.Contains(t, packet, `xmlns:exif="http://ns.adobe.com/exif/1.0/"`)
+	assert.Contains(t, packet, "<dc:title>")
+	assert.Contains(t, packet, "</rdf:Description>")
+}
+
+func TestCopyrightXMPPacket(t *testing.T) {
+	packet := string(copyrightXMPPacket([]byte(testPrivateXMP)))
+
+	assert.Contains(t, packet, `xmpRights:Marked="True"`)
+	assert.Contains(t, packet, "(c) Jane Doe")
+	assert.NotContains(t, packet, "GPS")
+	assert.NotContains(t, packet, "Holidays")
+	assert.NotContains(t, packet, "ExposureTime")
+
+	assert.Nil(t, copyrightXMPPacket([]byte(`<x:xmpmeta><dc:title>Holidays</dc:title></x:xmpmeta>`)))
+}
+
+func TestImageCompressor_CompressFile_MetadataPolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "photo.jpg")
+	writeJPEGWithSegments(t, inputPath, []jpegSegment{
+		{0xe1, createPrivateEXIFSegment()},
+		{0xe1, append([]byte(jpegXMPHeader), testPrivateXMP...)},
+		{0xe2, testICCSegment},
+		{0xed, createIPTCSegment(map[[2]byte]string{{2, 5}: "Holidays", {2, 116}: "(c) Jane Doe"})},
+	})
+
+	tests := []struct {
+		name          string
+		policy        string
+		privacy       bool
+		expectedKinds []string
+		expectedSize  image.Point
+	}{
+		{"Keep all", MetadataPolicyKeepAll, false, []string{MetadataEXIF, MetadataXMP, MetadataICC, MetadataIPTC}, image.Pt(32, 16)},
+		{"Privacy", MetadataPolicyKeepAll, true, []string{MetadataEXIF, MetadataXMP, MetadataICC, MetadataIPTC}, image.Pt(32, 16)},
+		{"Keep copyright", MetadataPolicyKeepCopyrightAndColor, false, []string{MetadataEXIF, MetadataXMP, MetadataICC, MetadataIPTC}, image.Pt(32, 16)},
+		// Without EXIF the orientation is applied to the pixels
+		{"Strip all", MetadataPolicyStripAll, false, nil, image.Pt(16, 32)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressor := NewImageCompressor()
+			require.NoError(t, compressor.SetMetadataPolicy(tt.policy))
+			compressor.SetPrivacy(tt.privacy)
+
+			outputPath := filepath.Join(tempDir, tt.policy+".jpg")
+			_, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedKinds, jpegMetadataKinds(t, outputPath))
+
+			data, err := os.ReadFile(outputPath)
+			require.NoError(t, err)
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedSize, img.Bounds().Size())
+
+			private := tt.privacy || tt.policy != MetadataPolicyKeepAll
+			for _, secret := range []string{"SN123456789", "GPSLatitude", "GPSLongitude", "0123456"} {
+				assert.Equal(t, !private, bytes.Contains(data, []byte(secret)), secret)
+			}
+
+			copyright := tt.policy != MetadataPolicyStripAll
+			assert.Equal(t, copyright, bytes.Contains(data, []byte("(c) Jane Doe")))
+			assert.Equal(t, tt.policy == MetadataPolicyKeepAll, bytes.Contains(data, []byte("Holidays")))
+		})
+	}
+}
+
+func TestImageCompressor_CompressFile_PNGMetadataPolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "image.png")
+	createPNGWithMetadata(t, inputPath)
+
+	tests := []struct {
+		policy   string
+		kept     []string
+		stripped []string
+	}{
+		{MetadataPolicyKeepAll, []string{"gAMA", "iCCP", "iTXt", "tEXt"}, nil},
+		{MetadataPolicyKeepCopyrightAndColor, []string{"gAMA", "iCCP"}, []string{"iTXt", "tEXt"}},
+		{MetadataPolicyStripAll, nil, []string{"gAMA", "iCCP", "iTXt", "tEXt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			compressor := NewImageCompressor()
+			require.NoError(t, compressor.SetMetadataPolicy(tt.policy))
+
+			outputPath := filepath.Join(tempDir, tt.policy+".png")
+			_, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			types := pngChunkTypes(t, outputPath)
+			for _, chunkType := range tt.kept {
+				assert.Contains(t, types, chunkType)
+			}
+			for _, chunkType := range tt.stripped {
+				assert.NotContains(t, types, chunkType)
+			}
+		})
+	}
+}
+
+func TestImageCompressor_SetMetadataPolicy(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	assert.NoError(t, compressor.SetMetadataPolicy(MetadataPolicyKeepCopyrightAndColor))
+	assert.Equal(t, MetadataPolicyKeepCopyrightAndColor, compressor.metadataPolicy)
+	assert.Error(t, compressor.SetMetadataPolicy("strip-gps"))
+	assert.Equal(t, MetadataPolicyKeepCopyrightAndColor, compressor.metadataPolicy)
+}
@@ -0,0 +1,231 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jpegstructure "github.com/dsoprea/go-jpeg-image-structure/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testXMPSegment  = []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")
+	testICCSegment  = []byte("ICC_PROFILE\x00\x01\x01fake profile")
+	testIPTCSegment = []byte("Photoshop 3.0\x008BIM\x04\x04\x00\x00\x00\x00\x00\x00")
+)
+
+// jpegSegment is a JPEG marker segment written by writeJPEGWithSegments
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// writeJPEGWithSegments writes a 32x16 JPEG with the given segments right after SOI
+func writeJPEGWithSegments(t *testing.T, path string, segments []jpegSegment) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, createPhotoImage(32, 16), &jpeg.Options{Quality: 95}))
+	data := buf.Bytes()
+
+	output := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		header := []byte{0xff, segment.marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(segment.data)+2))
+		output = append(output, header...)
+		output = append(output, segment.data...)
+	}
+	output = append(output, data[2:]...)
+
+	require.NoError(t, os.WriteFile(path, output, 0644))
+}
+
+// createJPEGWithMetadata writes a JPEG holding EXIF, XMP, ICC and IPTC segments
+func createJPEGWithMetadata(t *testing.T, path string) {
+	writeJPEGWithSegments(t, path, []jpegSegment{
+		{0xe1, createEXIFSegment(binary.BigEndian, 1, 32, 16)},
+		{0xe1, testXMPSegment},
+		{0xe2, testICCSegment},
+		{0xed, testIPTCSegment},
+	})
+}
+
+// jpegMetadataKinds returns the kinds of the metadata segments found in a JPEG file
+func jpegMetadataKinds(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	intfc, err := jpegstructure.NewJpegMediaParser().ParseBytes(data)
+	require.NoError(t, err)
+
+	var kinds []string
+	for _, segment := range intfc.(*jpegstructure.SegmentList).Segments() {
+		if kind := jpegMetadataKind(segment.MarkerId, segment.Data); kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	return kinds
+}
+
+// createPNGWithMetadata writes a PNG holding color chunks and an XMP packet
+func createPNGWithMetadata(t *testing.T, path string) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, createPhotoImage(32, 16)))
+
+	data, err := insertPNGMetadata(buf.Bytes(), []pngChunk{
+		{chunkType: "gAMA", data: []byte{0, 0, 0xb1, 0x8f}},
+		{chunkType: "iCCP", data: []byte("profile\x00\x00fake")},
+		{chunkType: "iTXt", data: []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>")},
+		{chunkType: "tEXt", data: []byte("Comment\x00hello")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+func pngChunkTypes(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	chunks, err := parsePNGChunks(data)
+	require.NoError(t, err)
+
+	var types []string
+	for _, chunk := range chunks {
+		types = append(types, chunk.chunkType)
+	}
+
+	return types
+}
+
+func TestJPEGMetadataKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		marker   byte
+		data     []byte
+		expected string
+	}{
+		{"EXIF", 0xe1, []byte("Exif\x00\x00MM"), MetadataEXIF},
+		{"XMP", 0xe1, testXMPSegment, MetadataXMP},
+		{"Extended XMP", 0xe1, []byte("http://ns.adobe.com/xmp/extension/\x00data"), MetadataXMP},
+		{"ICC", 0xe2, testICCSegment, MetadataICC},
+		{"IPTC", 0xed, testIPTCSegment, MetadataIPTC},
+		{"JFIF", 0xe0, []byte("JFIF\x00"), ""},
+		{"Unknown APP1", 0xe1, []byte("other"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, jpegMetadataKind(tt.marker, tt.data))
+		})
+	}
+}
+
+func TestPNGMetadataKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunk    pngChunk
+		expected string
+	}{
+		{"ICC profile", pngChunk{"iCCP", []byte("icc\x00")}, MetadataICC},
+		{"sRGB", pngChunk{"sRGB", []byte{0}}, MetadataICC},
+		{"Gamma", pngChunk{"gAMA", nil}, MetadataICC},
+		{"EXIF", pngChunk{"eXIf", nil}, MetadataEXIF},
+		{"XMP", pngChunk{"iTXt", []byte("XML:com.adobe.xmp\x00")}, MetadataXMP},
+		{"IPTC raw profile", pngChunk{"zTXt", []byte("Raw profile type iptc\x00")}, MetadataIPTC},
+		{"Comment", pngChunk{"tEXt", []byte("Comment\x00text")}, ""},
+		{"Image data", pngChunk{"IDAT", nil}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, pngMetadataKind(tt.chunk))
+		})
+	}
+}
+
+func TestInsertPNGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))))
+
+	data, err := insertPNGMetadata(buf.Bytes(), []pngChunk{{chunkType: "sRGB", data: []byte{0}}})
+	require.NoError(t, err)
+
+	chunks, err := parsePNGChunks(data)
+	require.NoError(t, err)
+	assert.Equal(t, "IHDR", chunks[0].chunkType)
+	assert.Equal(t, "sRGB", chunks[1].chunkType)
+
+	_, err = png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+}
+
+func TestImageCompressor_CompressFile_JPEGMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "photo.jpg")
+	createJPEGWithMetadata(t, inputPath)
+
+	t.Run("Keep all", func(t *testing.T) {
+		outputPath := filepath.Join(tempDir, "all.jpg")
+		_, err := NewImageCompressor().CompressFile(inputPath, outputPath)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{MetadataEXIF, MetadataXMP, MetadataICC, MetadataIPTC}, jpegMetadataKinds(t, outputPath))
+	})
+
+	t.Run("Strip XMP and IPTC", func(t *testing.T) {
+		compressor := NewImageCompressor()
+		require.NoError(t, compressor.SetKeepMetadata(MetadataXMP, false))
+		require.NoError(t, compressor.SetKeepMetadata("IPTC", false))
+
+		outputPath := filepath.Join(tempDir, "stripped.jpg")
+		_, err := compressor.CompressFile(inputPath, outputPath)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{MetadataEXIF, MetadataICC}, jpegMetadataKinds(t, outputPath))
+	})
+}
+
+func TestImageCompressor_CompressFile_PNGMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "image.png")
+	createPNGWithMetadata(t, inputPath)
+
+	t.Run("Keep all", func(t *testing.T) {
+		outputPath := filepath.Join(tempDir, "all.png")
+		_, err := NewImageCompressor().CompressFile(inputPath, outputPath)
+		require.NoError(t, err)
+
+		types := pngChunkTypes(t, outputPath)
+		assert.Contains(t, types, "gAMA")
+		assert.Contains(t, types, "iCCP")
+		assert.Contains(t, types, "iTXt")
+	})
+
+	t.Run("Strip color", func(t *testing.T) {
+		compressor := NewImageCompressor()
+		require.NoError(t, compressor.SetKeepMetadata(MetadataICC, false))
+
+		outputPath := filepath.Join(tempDir, "stripped.png")
+		_, err := compressor.CompressFile(inputPath, outputPath)
+		require.NoError(t, err)
+
+		types := pngChunkTypes(t, outputPath)
+		assert.NotContains(t, types, "gAMA")
+		assert.NotContains(t, types, "iCCP")
+		assert.Contains(t, types, "iTXt")
+	})
+}
+
+func TestImageCompressor_SetKeepMetadata(t *testing.T) {
+	compressor := NewImageCompressor()
+
+	assert.NoError(t, compressor.SetKeepMetadata(MetadataEXIF, false))
+	assert.False(t, compressor.keepMetadata[MetadataEXIF])
+	assert.Error(t, compressor.SetKeepMetadata("thumbnail", false))
+}
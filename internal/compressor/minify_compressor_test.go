@@ -0,0 +1,118 @@
+package compressor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinifyCompressor_CompressFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected string
+	}{
+		{
+			"HTML",
+			"index.html",
+			`<!DOCTYPE html>
+<html>
+  <head>
+    <title>Home</title>
+    <!-- Page styles -->
+    <style type="text/css">
+      body { margin: 0px; color: #ff0000; }
+    </style>
+  </head>
+  <body>
+    <ul>
+      <li>First</li>
+      <li>Second</li>
+    </ul>
+    <p>Some    text</p>
+    <script>
+      var counter = 0;
+      function increment() { counter = counter + 1; return counter; }
+    </script>
+  </body>
+</html>
+`,
+			`<!doctype html><title>Home</title><style>body{margin:0;color:red}</style><ul><li>First<li>Second</ul><p>Some text</p>` +
+				`<script>var counter=0;function increment(){return++counter,counter}</script>`,
+		},
+		{
+			"CSS",
+			"style.css",
+			`/* Layout */
+.container {
+  margin: 0px auto;
+  padding: 10.50px;
+  background-color: #ffffff;
+}
+`,
+			`.container{margin:0 auto;padding:10.5px;background-color:#fff}`,
+		},
+		{
+			"JavaScript",
+			"app.js",
+			`// Greets a user
+function greet(userName) {
+  const greeting = "Hello, " + userName;
+  return greeting;
+}
+`,
+			`function greet(userName){const greeting="Hello, "+userName;return greeting}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, tt.file)
+			outputPath := filepath.Join(tempDir, "compressed_"+tt.file)
+			require.NoError(t, os.WriteFile(inputPath, []byte(tt.content), 0644))
+
+			result, err := NewMinifyCompressor().CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			output, err := os.ReadFile(outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(output))
+			assert.Equal(t, int64(len(tt.content)), result.OriginalSize)
+			assert.Equal(t, int64(len(output)), result.CompressedSize)
+			assert.True(t, result.IsPositiveSavings())
+		})
+	}
+}
+
+func TestMinifyCompressor_CompressFileInvalid(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "broken.js")
+	outputPath := filepath.Join(tempDir, "compressed_broken.js")
+	require.NoError(t, os.WriteFile(inputPath, []byte("function broken( {\n  return 1;\n"), 0644))
+
+	_, err := NewMinifyCompressor().CompressFile(inputPath, outputPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to minify text/javascript")
+	assert.NoFileExists(t, outputPath)
+}
+
+func TestMinifyCompressor_CompressFileUnsupported(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "notes.txt")
+	require.NoError(t, os.WriteFile(inputPath, []byte("Some notes"), 0644))
+
+	_, err := NewMinifyCompressor().CompressFile(inputPath, filepath.Join(tempDir, "compressed_notes.txt"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported MIME type text/plain")
+}
+
+func TestMinifyCompressor_GetSupportedMimeTypes(t *testing.T) {
+	mimeTypes := NewMinifyCompressor().GetSupportedMimeTypes()
+
+	assert.Equal(t, []string{"application/javascript", "text/css", "text/html", "text/javascript"}, mimeTypes)
+}
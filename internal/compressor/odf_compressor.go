@@ -0,0 +1,106 @@
+package compressor
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+)
+
+const odfManifest = "META-INF/manifest.xml"
+
+// odfManifestDocument is the manifest of an OpenDocument package, listing its
+// entries and how the encrypted ones are encrypted
+type odfManifestDocument struct {
+	FileEntries []struct {
+		FullPath       string    `xml:"full-path,attr"`
+		EncryptionData *struct{} `xml:"encryption-data"`
+	} `xml:"file-entry"`
+}
+
+// OdfCompressor compresses OpenDocument text documents, spreadsheets and
+// presentations (ODT, ODS and ODP): their embedded images are compressed by
+// the image compressor and their other entries, such as XML parts and fonts,
+// deflated at the best compression level. Fonts are kept whole, as the
+// documents may still be edited. The mimetype entry is written first and
+// uncompressed, and the entries of password protected documents are copied
+// as is.
+type OdfCompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	imageCompressor    Compressor
+}
+
+func NewOdfCompressor() *OdfCompressor {
+	return &OdfCompressor{
+		supportedMimeTypes: []string{
+			"application/vnd.oasis.opendocument.text",
+			"application/vnd.oasis.opendocument.spreadsheet",
+			"application/vnd.oasis.opendocument.presentation",
+		},
+		logger:          logger.NewLogger(false),
+		imageCompressor: NewImageCompressor(),
+	}
+}
+
+func (oc *OdfCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	container := &zipContainer{
+		name:             "ODF",
+		encryptedEntries: odfEncryptedEntries,
+		validate: func(r *zip.Reader) error {
+			_, err := readODFManifest(r)
+			return err
+		},
+		imageCompressor: oc.imageCompressor,
+		logger:          oc.logger,
+	}
+
+	return container.compressFile(filePath, outputPath)
+}
+
+// odfEncryptedEntries returns the entries the manifest of a package gives
+// encryption data for
+func odfEncryptedEntries(r *zip.Reader) (map[string]bool, error) {
+	manifest, err := readODFManifest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := map[string]bool{}
+	for _, entry := range manifest.FileEntries {
+		if entry.EncryptionData != nil {
+			encrypted[entry.FullPath] = true
+		}
+	}
+
+	return encrypted, nil
+}
+
+func readODFManifest(r *zip.Reader) (*odfManifestDocument, error) {
+	f, err := r.Open(odfManifest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", odfManifest, err)
+	}
+	defer f.Close()
+
+	var manifest odfManifestDocument
+	if err := xml.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%s: %v", odfManifest, err)
+	}
+
+	return &manifest, nil
+}
+
+func (oc *OdfCompressor) GetSupportedMimeTypes() []string {
+	return oc.supportedMimeTypes
+}
+
+func (oc *OdfCompressor) SetLogger(logger *logger.Logger) {
+	oc.logger = logger
+}
+
+// SetImageCompressor sets the compressor of the images embedded in documents
+func (oc *OdfCompressor) SetImageCompressor(imageCompressor Compressor) {
+	oc.imageCompressor = imageCompressor
+}
@@ -0,0 +1,135 @@
+package compressor
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testODFMediaType = "application/vnd.oasis.opendocument.text"
+
+const testODFManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.3">
+ <manifest:file-entry manifest:full-path="/" manifest:media-type="application/vnd.oasis.opendocument.text"/>
+ <manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+ <manifest:file-entry manifest:full-path="Pictures/photo.png" manifest:media-type="image/png"/>
+ <manifest:file-entry manifest:full-path="Fonts/font.ttf" manifest:media-type="application/x-font-ttf"/>
+ <manifest:file-entry manifest:full-path="Pictures/secret.png" manifest:media-type="image/png">
+  <manifest:encryption-data manifest:checksum-type="SHA1/1K" manifest:checksum="AAAA">
+   <manifest:algorithm manifest:algorithm-name="Blowfish CFB" manifest:initialisation-vector="AAAA"/>
+  </manifest:encryption-data>
+ </manifest:file-entry>
+</manifest:manifest>`
+
+func TestOdfCompressor_CompressFile(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "report.odt")
+	outputPath := filepath.Join(tempDir, "compressed_report.odt")
+	content := []byte(`<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0">` +
+		strings.Repeat("\n  <text:p>Paragraph</text:p>", 100) + `</office:document-content>`)
+	font := bytes.Repeat([]byte{0, 1, 0, 0, 0, 0x10}, 200)
+	photo := testUncompressedPNG(t)
+	secret := []byte("encrypted bytes, not an image")
+
+	// The mimetype entry is not first in the original container
+	writeTestZipPackage(t, inputPath, []testZipEntry{
+		{"content.xml", content},
+		{"mimetype", []byte(testODFMediaType)},
+		{"META-INF/manifest.xml", []byte(testODFManifest)},
+		{"Pictures/photo.png", photo},
+		{"Pictures/secret.png", secret},
+		{"Fonts/font.ttf", font},
+	})
+
+	result, err := NewOdfCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Less(t, result.CompressedSize, result.OriginalSize)
+	assertZipContainerMimetype(t, outputPath, testODFMediaType)
+
+	r, err := zip.OpenReader(outputPath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+
+		data, err := readZipEntry(f)
+		require.NoError(t, err)
+
+		switch f.Name {
+		case "content.xml":
+			assert.Equal(t, zip.Deflate, f.Method)
+			assert.Equal(t, content, data)
+		case "Fonts/font.ttf":
+			assert.Equal(t, zip.Deflate, f.Method)
+			assert.Equal(t, font, data)
+		case "Pictures/photo.png":
+			assert.Less(t, len(data), len(photo))
+			_, err := png.Decode(bytes.NewReader(data))
+			assert.NoError(t, err)
+		case "Pictures/secret.png":
+			assert.Equal(t, zip.Store, f.Method)
+			assert.Equal(t, secret, data)
+		}
+	}
+	assert.Equal(t, []string{"mimetype", "content.xml", "META-INF/manifest.xml", "Pictures/photo.png", "Pictures/secret.png", "Fonts/font.ttf"}, names)
+}
+
+func TestOdfCompressor_CompressFileInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testZipEntry
+		err     string
+	}{
+		{
+			"Without mimetype",
+			[]testZipEntry{{"META-INF/manifest.xml", []byte(testODFManifest)}},
+			"no mimetype entry",
+		},
+		{
+			"Without manifest",
+			[]testZipEntry{{"mimetype", []byte(testODFMediaType)}, {"content.xml", []byte(`<office:document-content/>`)}},
+			"META-INF/manifest.xml",
+		},
+		{
+			"Broken manifest",
+			[]testZipEntry{{"mimetype", []byte(testODFMediaType)}, {"META-INF/manifest.xml", []byte(`<manifest:manifest>`)}},
+			"META-INF/manifest.xml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "broken.odt")
+			outputPath := filepath.Join(tempDir, "compressed_broken.odt")
+			writeTestZipPackage(t, inputPath, tt.entries)
+
+			_, err := NewOdfCompressor().CompressFile(inputPath, outputPath)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+			assert.NoFileExists(t, outputPath)
+		})
+	}
+}
+
+func TestOdfEncryptedEntries(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "report.odt")
+	writeTestZipPackage(t, filePath, []testZipEntry{{"META-INF/manifest.xml", []byte(testODFManifest)}})
+
+	r, err := zip.OpenReader(filePath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	encrypted, err := odfEncryptedEntries(&r.Reader)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"Pictures/secret.png": true}, encrypted)
+}
@@ -0,0 +1,321 @@
+package compressor
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+)
+
+// ooxmlMediaDirectories are the directories of the images embedded in
+// documents, workbooks and presentations
+var ooxmlMediaDirectories = []string{"word/media/", "xl/media/", "ppt/media/"}
+
+const ooxmlContentTypes = "[Content_Types].xml"
+
+// OoxmlCompressor compresses Office Open XML packages (DOCX, XLSX and PPTX):
+// their XML parts are deflated at the best compression level, their images
+// compressed by the image compressor, and the media no relationship targets
+// removed. The written package is read back and discarded when invalid.
+type OoxmlCompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	imageCompressor    Compressor
+}
+
+func NewOoxmlCompressor() *OoxmlCompressor {
+	return &OoxmlCompressor{
+		supportedMimeTypes: []string{
+			"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		},
+		logger:          logger.NewLogger(false),
+		imageCompressor: NewImageCompressor(),
+	}
+}
+
+func (oc *OoxmlCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	oc.logger.PrintfVerbose("OOXML Compressor: Compressing file %s to %s\n", filepath.Base(filePath), filepath.Base(outputPath))
+
+	originalFileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original file info: %v", err)
+	}
+
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OOXML package: %v", err)
+	}
+	defer r.Close()
+
+	targets, err := ooxmlRelationshipTargets(r.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relationships: %v", err)
+	}
+
+	var unused []string
+	for _, f := range r.File {
+		if isOOXMLMedia(f.Name) && !targets[f.Name] {
+			unused = append(unused, f.Name)
+		}
+	}
+
+	tempDir, err := os.MkdirTemp("", "ooxml-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	images, err := oc.writePackage(r, outputPath, unused, tempDir)
+	if err != nil {
+		_ = os.Remove(outputPath)
+
+		return nil, fmt.Errorf("failed to write OOXML package: %v", err)
+	}
+	if images > 0 {
+		oc.logger.PrintfVerbose("OOXML Compressor: Compressed %s\n", countLabel(images, "image"))
+	}
+
+	// A broken output is discarded, so that it never replaces the original
+	if err := validateOOXMLPackage(outputPath); err != nil {
+		_ = os.Remove(outputPath)
+		oc.logger.PrintfVerbose("OOXML Compressor: Discarding compressed file %s, validation failed: %v\n", filepath.Base(outputPath), err)
+
+		return &CompressionResult{
+			OriginalFile:   filePath,
+			OriginalSize:   originalFileInfo.Size(),
+			CompressedSize: originalFileInfo.Size(),
+			Skipped:        SkippedValidationFailed,
+		}, nil
+	}
+
+	compressedFileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get compressed file info: %v", err)
+	}
+
+	var removed []string
+	if len(unused) > 0 {
+		removed = append(removed, countLabel(len(unused), "unused media file"))
+	}
+
+	return &CompressionResult{
+		OriginalFile:   filePath,
+		CompressedFile: outputPath,
+		OriginalSize:   originalFileInfo.Size(),
+		CompressedSize: compressedFileInfo.Size(),
+		Removed:        removed,
+	}, nil
+}
+
+// writePackage writes the parts of a package but the unused ones, and returns
+// the number of images made smaller
+func (oc *OoxmlCompressor) writePackage(r *zip.ReadCloser, outputPath string, unused []string, tempDir string) (int, error) {
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return 0, err
+	}
+	defer output.Close()
+
+	w := zip.NewWriter(output)
+	w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+	if err := w.SetComment(r.Comment); err != nil {
+		return 0, err
+	}
+
+	images := 0
+	for _, f := range r.File {
+		if slices.Contains(unused, f.Name) {
+			continue
+		}
+
+		// Other media, such as EMF drawings or videos, is copied without being inflated again
+		if isOOXMLMedia(f.Name) && !slices.Contains(packageImageExtensions, strings.ToLower(path.Ext(f.Name))) {
+			if err := copyZipEntry(w, f); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		data, err := readZipEntry(f)
+		if err != nil {
+			return 0, err
+		}
+
+		method := zip.Deflate
+		switch {
+		case isOOXMLMedia(f.Name):
+			method = f.Method
+			compressed, err := compressPackageImage(oc.imageCompressor, data, path.Ext(f.Name), tempDir)
+			if err != nil {
+				oc.logger.PrintfVerbose("OOXML Compressor: Keeping image %s: %v\n", f.Name, err)
+			} else if compressed != nil {
+				data = compressed
+				images++
+			}
+		case f.Name == ooxmlContentTypes && len(unused) > 0:
+			if data, err = removeContentTypeOverrides(data, unused); err != nil {
+				return 0, err
+			}
+		}
+
+		entry, err := w.CreateHeader(&zip.FileHeader{Name: f.Name, Method: method, Modified: f.Modified})
+		if err != nil {
+			return 0, err
+		}
+		if _, err := entry.Write(data); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	return images, output.Close()
+}
+
+// ooxmlRelationshipTargets returns the parts targeted by the internal
+// relationships of a package
+func ooxmlRelationshipTargets(files []*zip.File) (map[string]bool, error) {
+	targets := map[string]bool{}
+	parts := 0
+	for _, f := range files {
+		dir, name := path.Split(f.Name)
+		if path.Base(dir) != "_rels" || !strings.HasSuffix(name, ".rels") {
+			continue
+		}
+		parts++
+
+		data, err := readZipEntry(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var relationships struct {
+			Relationships []struct {
+				Target     string `xml:"Target,attr"`
+				TargetMode string `xml:"TargetMode,attr"`
+			} `xml:"Relationship"`
+		}
+		if err := xml.Unmarshal(data, &relationships); err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+
+		// The relationships of word/document.xml are word/_rels/document.xml.rels
+		source := path.Dir(path.Dir(f.Name))
+		for _, relationship := range relationships.Relationships {
+			if relationship.TargetMode == "External" {
+				continue
+			}
+
+			target, _, _ := strings.Cut(relationship.Target, "#")
+			if unescaped, err := url.PathUnescape(target); err == nil {
+				target = unescaped
+			}
+			if strings.HasPrefix(target, "/") {
+				target = path.Clean(target)[1:]
+			} else {
+				target = path.Join(source, target)
+			}
+			targets[target] = true
+		}
+	}
+
+	// Without relationships, every part would look unused
+	if parts == 0 {
+		return nil, fmt.Errorf("package has no relationships")
+	}
+
+	return targets, nil
+}
+
+// removeContentTypeOverrides removes the content types of removed parts
+func removeContentTypeOverrides(data []byte, removed []string) ([]byte, error) {
+	nodes, _, err := parseXML(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ooxmlContentTypes, err)
+	}
+
+	var b bytes.Buffer
+	for _, node := range nodes {
+		if node.kind == xmlElement {
+			node.children = slices.DeleteFunc(node.children, func(child *xmlNode) bool {
+				for _, attr := range child.attrs {
+					if attr.Name.Local == "PartName" {
+						return slices.Contains(removed, strings.TrimPrefix(attr.Value, "/"))
+					}
+				}
+				return false
+			})
+		}
+		node.write(&b, false, false, nil)
+	}
+
+	return b.Bytes(), nil
+}
+
+// validateOOXMLPackage checks that every part of a package reads back, and that
+// its content types and relationships parse
+func validateOOXMLPackage(filePath string) error {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	contentTypes := false
+	for _, f := range r.File {
+		data, err := readZipEntry(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+
+		if f.Name == ooxmlContentTypes {
+			if _, _, err := parseXML(bytes.NewReader(data)); err != nil {
+				return fmt.Errorf("%s: %v", f.Name, err)
+			}
+			contentTypes = true
+		}
+	}
+
+	if !contentTypes {
+		return fmt.Errorf("package has no %s part", ooxmlContentTypes)
+	}
+
+	_, err = ooxmlRelationshipTargets(r.File)
+
+	return err
+}
+
+func isOOXMLMedia(name string) bool {
+	return slices.ContainsFunc(ooxmlMediaDirectories, func(dir string) bool {
+		return strings.HasPrefix(name, dir)
+	})
+}
+
+func (oc *OoxmlCompressor) GetSupportedMimeTypes() []string {
+	return oc.supportedMimeTypes
+}
+
+func (oc *OoxmlCompressor) SetLogger(logger *logger.Logger) {
+	oc.logger = logger
+}
+
+// SetImageCompressor sets the compressor of the images embedded in packages
+func (oc *OoxmlCompressor) SetImageCompressor(imageCompressor Compressor) {
+	oc.imageCompressor = imageCompressor
+}
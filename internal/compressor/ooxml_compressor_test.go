@@ -0,0 +1,199 @@
+package compressor
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testUncompressedPNG returns a PNG image written without compression
+func testUncompressedPNG(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+
+	var b bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.NoCompression}
+	require.NoError(t, encoder.Encode(&b, img))
+
+	return b.Bytes()
+}
+
+func testDocxParts(t *testing.T) []testZipEntry {
+	return []testZipEntry{
+		{"[Content_Types].xml", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+  <Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+  <Default Extension="xml" ContentType="application/xml"/>
+  <Default Extension="png" ContentType="image/png"/>
+  <Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+  <Override PartName="/word/media/image2.png" ContentType="image/png"/>
+</Types>`)},
+		{"_rels/.rels", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>`)},
+		{"word/document.xml", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:body>
+    <w:p><w:r><w:t xml:space="preserve">Hello, world  </w:t></w:r></w:p>
+    <w:p><w:r><w:drawing/></w:r></w:p>
+  </w:body>
+</w:document>`)},
+		{"word/_rels/document.xml.rels", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/image1.png"/>
+  <Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="https://example.com/media/image2.png" TargetMode="External"/>
+</Relationships>`)},
+		{"word/media/image1.png", testUncompressedPNG(t)},
+		{"word/media/image2.png", testUncompressedPNG(t)},
+	}
+}
+
+func TestOoxmlCompressor_CompressFile(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "report.docx")
+	outputPath := filepath.Join(tempDir, "compressed_report.docx")
+	parts := testDocxParts(t)
+	writeTestZipPackage(t, inputPath, parts)
+
+	result, err := NewOoxmlCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1 unused media file"}, result.Removed)
+	assert.Less(t, result.CompressedSize, result.OriginalSize)
+
+	r, err := zip.OpenReader(outputPath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+
+		data, err := readZipEntry(f)
+		require.NoError(t, err)
+
+		switch f.Name {
+		case "word/document.xml":
+			assert.Equal(t, zip.Deflate, f.Method)
+			// XML parts are recompressed, not rewritten
+			assert.Equal(t, string(parts[2].content), string(data))
+		case "word/media/image1.png":
+			assert.Equal(t, zip.Store, f.Method)
+			assert.Less(t, len(data), len(parts[4].content))
+			_, err := png.Decode(bytes.NewReader(data))
+			assert.NoError(t, err)
+		case "[Content_Types].xml":
+			assert.NotContains(t, string(data), "image2.png")
+			assert.Contains(t, string(data), `<Override PartName="/word/document.xml"`)
+		}
+	}
+	assert.Equal(t, []string{"[Content_Types].xml", "_rels/.rels", "word/document.xml", "word/_rels/document.xml.rels", "word/media/image1.png"}, names)
+}
+
+func TestOoxmlCompressor_CompressFileKeepsOtherMedia(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "slides.pptx")
+	outputPath := filepath.Join(tempDir, "compressed_slides.pptx")
+	writeTestZipPackage(t, inputPath, []testZipEntry{
+		{"[Content_Types].xml", []byte(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`)},
+		{"_rels/.rels", []byte(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Target="/ppt/presentation.xml"/></Relationships>`)},
+		{"ppt/presentation.xml", []byte(`<p:presentation xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main"/>`)},
+		{"ppt/_rels/presentation.xml.rels", []byte(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Target="../ppt/media/my%20drawing.emf"/></Relationships>`)},
+		{"ppt/media/my drawing.emf", []byte("EMF drawing")},
+	})
+
+	result, err := NewOoxmlCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Empty(t, result.Removed)
+
+	r, err := zip.OpenReader(outputPath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Len(t, r.File, 5)
+	assert.Equal(t, "ppt/media/my drawing.emf", r.File[4].Name)
+	assert.Equal(t, zip.Store, r.File[4].Method)
+}
+
+func TestOoxmlCompressor_CompressFileInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []testZipEntry
+		err   string
+	}{
+		{
+			"Without relationships",
+			[]testZipEntry{{"[Content_Types].xml", []byte(`<Types/>`)}, {"word/media/image1.png", []byte("image")}},
+			"no relationships",
+		},
+		{
+			"Broken relationships",
+			[]testZipEntry{{"_rels/.rels", []byte(`<Relationships>`)}},
+			"_rels/.rels",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "broken.docx")
+			outputPath := filepath.Join(tempDir, "compressed_broken.docx")
+			writeTestZipPackage(t, inputPath, tt.parts)
+
+			_, err := NewOoxmlCompressor().CompressFile(inputPath, outputPath)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+			assert.NoFileExists(t, outputPath)
+		})
+	}
+}
+
+func TestOoxmlCompressor_CompressFileValidationFailed(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "report.docx")
+	outputPath := filepath.Join(tempDir, "compressed_report.docx")
+	writeTestZipPackage(t, inputPath, []testZipEntry{
+		{"_rels/.rels", []byte(`<Relationships/>`)},
+	})
+
+	result, err := NewOoxmlCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, SkippedValidationFailed, result.Skipped)
+	assert.NoFileExists(t, outputPath)
+}
+
+func TestOoxmlRelationshipTargets(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "book.xlsx")
+	writeTestZipPackage(t, filePath, []testZipEntry{
+		{"_rels/.rels", []byte(`<Relationships><Relationship Target="xl/workbook.xml"/></Relationships>`)},
+		{"xl/drawings/_rels/drawing1.xml.rels", []byte(`<Relationships>` +
+			`<Relationship Target="../media/image1.jpeg"/>` +
+			`<Relationship Target="/xl/media/image2.png"/>` +
+			`<Relationship Target="http://example.com/image3.png" TargetMode="External"/>` +
+			`</Relationships>`)},
+	})
+
+	r, err := zip.OpenReader(filePath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	targets, err := ooxmlRelationshipTargets(r.File)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"xl/workbook.xml":      true,
+		"xl/media/image1.jpeg": true,
+		"xl/media/image2.png":  true,
+	}, targets)
+}
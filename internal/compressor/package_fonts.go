@@ -0,0 +1,318 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"html"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode"
+)
+
+// packageFontExtensions are the extensions of the TrueType and OpenType fonts
+// embedded in packages which are subset to the characters of the package
+var packageFontExtensions = []string{".ttf", ".otf"}
+
+// packageFontUnsupportedTables lists the tables drawing glyphs the subsetting
+// cannot follow, color layers, math variants and AAT substitutions, or
+// holding outlines it cannot read: fonts with them are kept whole
+var packageFontUnsupportedTables = []string{"CFF2", "COLR", "MATH", "morx", "mort"}
+
+// packageFontBaseCharacters are kept in every font, as reading systems draw
+// them without the text holding them: the printable ASCII characters, used by
+// list markers among others, spaces, hyphens, bullets and ellipses
+var packageFontBaseCharacters = []rune{'\u00a0', '\u00ad', '‐', '‑', '–', '—', '•', '…', '■', '▪', '●', '◦'}
+
+// cssEscapePattern matches the hexadecimal escapes of style sheets
+var cssEscapePattern = regexp.MustCompile(`\\([0-9a-fA-F]{1,6})`)
+
+// addTextCharacters adds the characters of a text, written as they are or as
+// HTML and CSS character references, with their other cases, as style sheets
+// may transform the text
+func addTextCharacters(characters map[rune]bool, text string) {
+	found := map[rune]bool{}
+	for _, r := range text + html.UnescapeString(text) {
+		found[r] = true
+	}
+	for _, match := range cssEscapePattern.FindAllStringSubmatch(text, -1) {
+		if code, err := strconv.ParseInt(match[1], 16, 32); err == nil {
+			found[rune(code)] = true
+		}
+	}
+
+	for r := range found {
+		characters[r] = true
+		characters[unicode.ToUpper(r)] = true
+		characters[unicode.ToLower(r)] = true
+		characters[unicode.ToTitle(r)] = true
+		for folded := unicode.SimpleFold(r); folded != r; folded = unicode.SimpleFold(folded) {
+			characters[folded] = true
+		}
+	}
+}
+
+// newPackageFontCharacters returns the characters every font keeps
+func newPackageFontCharacters() map[rune]bool {
+	characters := map[rune]bool{}
+	for r := rune(0x20); r < 0x7f; r++ {
+		characters[r] = true
+	}
+	for _, r := range packageFontBaseCharacters {
+		characters[r] = true
+	}
+
+	return characters
+}
+
+// packageFont is a TrueType or OpenType font embedded in a package, whose
+// outlines are either in a glyf table or in a CFF one
+type packageFont struct {
+	tables    map[string][]byte
+	numGlyphs int
+	trueType  *trueTypeFont
+	cff       *cffFont
+}
+
+func parsePackageFont(data []byte) (*packageFont, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("truncated font")
+	}
+
+	f := &packageFont{}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+		tt, err := parseTrueType(data)
+		if err != nil {
+			return nil, err
+		}
+		f.tables, f.numGlyphs, f.trueType = tt.tables, tt.numGlyphs, tt
+	case "OTTO":
+		tables, err := parseSFNTTables(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(tables["head"]) < 54 || len(tables["maxp"]) < 6 || tables["CFF "] == nil {
+			return nil, fmt.Errorf("missing glyph tables")
+		}
+		cff, err := parseCFF(tables["CFF "])
+		if err != nil {
+			return nil, err
+		}
+		f.tables, f.numGlyphs, f.cff = tables, int(binary.BigEndian.Uint16(tables["maxp"][4:])), cff
+	default:
+		return nil, fmt.Errorf("not a TrueType or OpenType font")
+	}
+
+	for _, tag := range packageFontUnsupportedTables {
+		if f.tables[tag] != nil {
+			return nil, fmt.Errorf("%s table not supported", tag)
+		}
+	}
+
+	return f, nil
+}
+
+// glyphs returns the glyphs drawing characters, and those the substitutions
+// of the font may replace them with
+func (f *packageFont) glyphs(characters map[rune]bool) (map[uint16]bool, error) {
+	cmap := &trueTypeFont{tables: f.tables}
+
+	// Variation sequences select glyphs the Unicode mappings do not list
+	if cmap.cmap(0, 5) != nil {
+		return nil, fmt.Errorf("variation sequences not supported")
+	}
+
+	var mapping map[uint32]uint16
+	for _, encoding := range [][2]uint16{{3, 10}, {0, 4}, {3, 1}, {0, 3}} {
+		if mapping = cmap.cmap(encoding[0], encoding[1]); mapping != nil {
+			break
+		}
+	}
+	if mapping == nil {
+		return nil, fmt.Errorf("no Unicode character mapping")
+	}
+
+	gids := map[uint16]bool{0: true}
+	for r := range characters {
+		if gid, ok := mapping[uint32(r)]; ok {
+			gids[gid] = true
+		}
+	}
+	if err := addGSUBGlyphs(f.tables["GSUB"], gids); err != nil {
+		return nil, fmt.Errorf("invalid GSUB table: %v", err)
+	}
+
+	// Accented glyphs made of other glyphs by name cannot be followed
+	if f.cff != nil && !f.cff.isCID() {
+		for gid := range gids {
+			if int(gid) < len(f.cff.charStrings) && f.cff.usesSeac(int(gid)) {
+				return nil, fmt.Errorf("accented glyphs not supported")
+			}
+		}
+	}
+
+	return gids, nil
+}
+
+// subset returns the font with the outlines of the glyphs not listed removed.
+// Glyph IDs are kept, so that the character mappings, metrics and layout
+// tables stay valid, the digital signature being dropped.
+func (f *packageFont) subset(gids map[uint16]bool) []byte {
+	if f.trueType != nil {
+		return f.trueType.subsetTables(gids, []string{"DSIG"})
+	}
+
+	head := slices.Clone(f.tables["head"])
+	binary.BigEndian.PutUint32(head[8:], 0)
+
+	tables := maps.Clone(f.tables)
+	delete(tables, "DSIG")
+	tables["CFF "], tables["head"] = f.cff.subset(gids), head
+
+	font := buildSFNT(binary.BigEndian.Uint32([]byte("OTTO")), tables)
+	setSFNTChecksumAdjustment(font)
+
+	return font
+}
+
+// fontTableReader reads big-endian numbers from a font table, reads out of
+// bounds returning 0 and setting err
+type fontTableReader struct {
+	data []byte
+	err  error
+}
+
+func (r *fontTableReader) u16(pos int) int {
+	if pos < 0 || pos+2 > len(r.data) {
+		r.err = fmt.Errorf("offset %d out of bounds", pos)
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint16(r.data[pos:]))
+}
+
+func (r *fontTableReader) u32(pos int) int {
+	if pos < 0 || pos+4 > len(r.data) {
+		r.err = fmt.Errorf("offset %d out of bounds", pos)
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint32(r.data[pos:]))
+}
+
+// coverage reads a coverage table, listing glyphs by coverage index
+func (r *fontTableReader) coverage(pos int) []uint16 {
+	var glyphs []uint16
+	switch r.u16(pos) {
+	case 1:
+		for i := range r.u16(pos + 2) {
+			glyphs = append(glyphs, uint16(r.u16(pos+4+2*i)))
+		}
+	case 2:
+		for i := range r.u16(pos + 2) {
+			start, end := r.u16(pos+4+6*i), r.u16(pos+6+6*i)
+			for gid := start; gid <= end; gid++ {
+				glyphs = append(glyphs, uint16(gid))
+			}
+		}
+	}
+
+	return glyphs
+}
+
+// gsubSubtable is a substitution subtable, by lookup type and offset
+type gsubSubtable struct {
+	lookupType int
+	pos        int
+}
+
+// addGSUBGlyphs adds the glyphs the substitutions of a GSUB table may replace
+// the listed glyphs with, whatever their context, until no glyph is added
+func addGSUBGlyphs(gsub []byte, gids map[uint16]bool) error {
+	if gsub == nil {
+		return nil
+	}
+
+	r := &fontTableReader{data: gsub}
+	lookupList := r.u16(8)
+	var subtables []gsubSubtable
+	for i := range r.u16(lookupList) {
+		lookup := lookupList + r.u16(lookupList+2+2*i)
+		for j := range r.u16(lookup + 4) {
+			subtable := gsubSubtable{r.u16(lookup), lookup + r.u16(lookup+6+2*j)}
+			// Extension subtables point to a subtable of another type
+			if subtable.lookupType == 7 {
+				subtable = gsubSubtable{r.u16(subtable.pos + 2), subtable.pos + r.u32(subtable.pos+4)}
+			}
+			subtables = append(subtables, subtable)
+		}
+	}
+
+	for count := -1; count != len(gids) && r.err == nil; {
+		count = len(gids)
+		for _, subtable := range subtables {
+			r.substitute(subtable, gids)
+		}
+	}
+
+	return r.err
+}
+
+// substitute adds the glyphs a substitution subtable replaces the listed
+// glyphs with. Contextual substitutions only apply other lookups.
+func (r *fontTableReader) substitute(subtable gsubSubtable, gids map[uint16]bool) {
+	pos := subtable.pos
+	switch subtable.lookupType {
+	case 1:
+		format := r.u16(pos)
+		for i, gid := range r.coverage(pos + r.u16(pos+2)) {
+			if !gids[gid] {
+				continue
+			}
+			if format == 1 {
+				// The delta is added modulo 65536
+				gids[gid+uint16(r.u16(pos+4))] = true
+			} else if format == 2 && i < r.u16(pos+4) {
+				gids[uint16(r.u16(pos+6+2*i))] = true
+			}
+		}
+	case 2, 3:
+		// Sequences and alternate sets are both lists of glyphs
+		for i, gid := range r.coverage(pos + r.u16(pos+2)) {
+			if !gids[gid] || i >= r.u16(pos+4) {
+				continue
+			}
+			set := pos + r.u16(pos+6+2*i)
+			for j := range r.u16(set) {
+				gids[uint16(r.u16(set+2+2*j))] = true
+			}
+		}
+	case 4:
+		for i, gid := range r.coverage(pos + r.u16(pos+2)) {
+			if !gids[gid] || i >= r.u16(pos+4) {
+				continue
+			}
+			set := pos + r.u16(pos+6+2*i)
+			for j := range r.u16(set) {
+				ligature := set + r.u16(set+2+2*j)
+				components := true
+				for k := range max(r.u16(ligature+2)-1, 0) {
+					components = components && gids[uint16(r.u16(ligature+4+2*k))]
+				}
+				if components {
+					gids[uint16(r.u16(ligature))] = true
+				}
+			}
+		}
+	case 8:
+		lookahead := pos + 6 + 2*r.u16(pos+4)
+		substitutes := lookahead + 2 + 2*r.u16(lookahead)
+		for i, gid := range r.coverage(pos + r.u16(pos+2)) {
+			if gids[gid] && i < r.u16(substitutes) {
+				gids[uint16(r.u16(substitutes+2+2*i))] = true
+			}
+		}
+	}
+}
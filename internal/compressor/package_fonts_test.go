@@ -0,0 +1,142 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+)
+
+// fontUint16s encodes numbers as big-endian 16-bit words
+func fontUint16s(values ...int) []byte {
+	var data []byte
+	for _, value := range values {
+		data = binary.BigEndian.AppendUint16(data, uint16(value))
+	}
+
+	return data
+}
+
+// testGSUBLookup is a lookup made of a single subtable
+type testGSUBLookup struct {
+	lookupType int
+	subtable   []byte
+}
+
+func createTestGSUB(lookups ...testGSUBLookup) []byte {
+	list := fontUint16s(len(lookups))
+	var tables []byte
+	offset := 2 + 2*len(lookups)
+	for _, lookup := range lookups {
+		table := append(fontUint16s(lookup.lookupType, 0, 1, 8), lookup.subtable...)
+		list = append(list, fontUint16s(offset)...)
+		tables = append(tables, table...)
+		offset += len(table)
+	}
+
+	return append(append(fontUint16s(1, 0, 0, 0, 10), list...), tables...)
+}
+
+// createTestOpenType returns an OpenType font of the test CFF program, whose
+// Unicode mapping draws A, B and C with glyphs 1 to 3
+func createTestOpenType(cid bool) []byte {
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint32(head, 0x00010000)
+	binary.BigEndian.PutUint32(head[12:], 0x5f0f3cf5)
+	maxp := binary.BigEndian.AppendUint32(nil, 0x00005000)
+	maxp = binary.BigEndian.AppendUint16(maxp, 4)
+
+	// A format 12 subtable of a single group
+	subtable := fontUint16s(12, 0, 0, 28, 0, 0, 0, 1, 0, 'A', 0, 'C', 0, 1)
+	cmap := append(fontUint16s(0, 1, 3, 10, 0, 12), subtable...)
+
+	return buildSFNT(binary.BigEndian.Uint32([]byte("OTTO")), map[string][]byte{
+		"head": head, "maxp": maxp, "cmap": cmap, "CFF ": createTestCFF(cid), "DSIG": {0, 0, 0, 1},
+	})
+}
+
+func TestPackageFont_SubsetTrueType(t *testing.T) {
+	font, err := parsePackageFont(goregular.TTF)
+	require.NoError(t, err)
+
+	gids, err := font.glyphs(map[rune]bool{'A': true})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint16]bool{0: true, 36: true}, gids)
+
+	subset := font.subset(gids)
+	assert.Less(t, len(subset), len(goregular.TTF)/4)
+	assert.Equal(t, uint32(0xb1b0afba), trueTypeChecksum(subset))
+
+	parsed, err := parseTrueType(subset)
+	require.NoError(t, err)
+	assert.Equal(t, font.numGlyphs, parsed.numGlyphs)
+	assert.NotEmpty(t, parsed.glyph(36))
+	// Omega is not drawn
+	assert.Empty(t, parsed.glyph(393))
+	// Layout and hinting tables are kept
+	assert.Equal(t, slices.Sorted(maps.Keys(font.tables)), slices.Sorted(maps.Keys(parsed.tables)))
+
+	_, err = sfnt.Parse(subset)
+	assert.NoError(t, err)
+}
+
+func TestPackageFont_SubsetCFF(t *testing.T) {
+	font, err := parsePackageFont(createTestOpenType(true))
+	require.NoError(t, err)
+	assert.Equal(t, 4, font.numGlyphs)
+
+	gids, err := font.glyphs(map[rune]bool{'B': true, 'Z': true})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint16]bool{0: true, 2: true}, gids)
+
+	subset := font.subset(gids)
+	assert.Equal(t, "OTTO", string(subset[:4]))
+	assert.Equal(t, uint32(0xb1b0afba), trueTypeChecksum(subset))
+
+	parsed, err := parsePackageFont(subset)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{14}, {14}, {150, 160, 21, 14}, {14}}, parsed.cff.charStrings)
+	assert.NotContains(t, parsed.tables, "DSIG")
+	assert.Equal(t, font.tables["cmap"], parsed.tables["cmap"])
+
+	// Accented glyphs are made of other glyphs by name
+	font, err = parsePackageFont(createTestOpenType(false))
+	require.NoError(t, err)
+	_, err = font.glyphs(map[rune]bool{'C': true})
+	assert.ErrorContains(t, err, "accented glyphs")
+}
+
+func TestParsePackageFont(t *testing.T) {
+	_, err := parsePackageFont([]byte("wOF2 compressed font"))
+	assert.ErrorContains(t, err, "not a TrueType or OpenType font")
+
+	tables, err := parseSFNTTables(goregular.TTF)
+	require.NoError(t, err)
+	tables["MATH"] = []byte{0, 1, 0, 0}
+	_, err = parsePackageFont(buildTrueType(tables))
+	assert.ErrorContains(t, err, "MATH table not supported")
+}
+
+func TestAddGSUBGlyphs(t *testing.T) {
+	// Glyph 1 is replaced with glyph 5, and glyphs 5 and 6 with the ligature 9
+	single := testGSUBLookup{1, fontUint16s(2, 8, 1, 5, 1, 1, 1)}
+	ligature := testGSUBLookup{4, fontUint16s(1, 8, 1, 14, 1, 1, 5, 1, 4, 9, 2, 6)}
+	extension := testGSUBLookup{7, append(fontUint16s(1, 4, 0, 8), ligature.subtable...)}
+
+	for _, gsub := range [][]byte{createTestGSUB(single, ligature), createTestGSUB(single, extension)} {
+		gids := map[uint16]bool{1: true}
+		require.NoError(t, addGSUBGlyphs(gsub, gids))
+		assert.Equal(t, map[uint16]bool{1: true, 5: true}, gids)
+
+		gids = map[uint16]bool{1: true, 6: true}
+		require.NoError(t, addGSUBGlyphs(gsub, gids))
+		assert.Equal(t, map[uint16]bool{1: true, 5: true, 6: true, 9: true}, gids)
+	}
+
+	assert.Error(t, addGSUBGlyphs([]byte{0, 1, 0, 0, 0, 0, 0, 0, 0xff, 0xff}, map[uint16]bool{1: true}))
+}
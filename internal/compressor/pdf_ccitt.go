@@ -0,0 +1,155 @@
+package compressor
+
+// ccittCode is a variable length code of the CCITT fax encodings
+type ccittCode struct {
+	value int
+	size  int
+}
+
+// Two-dimensional coding mode codes of ITU-T T.6
+var (
+	ccittPassCode       = ccittCode{0b0001, 4}
+	ccittHorizontalCode = ccittCode{0b001, 3}
+	// ccittVerticalCodes are indexed by the offset of a1 from b1 plus 3
+	ccittVerticalCodes = [7]ccittCode{
+		{0b0000010, 7}, {0b000010, 6}, {0b010, 3}, {0b1, 1}, {0b011, 3}, {0b000011, 6}, {0b0000011, 7},
+	}
+	ccittEOL = ccittCode{0b000000000001, 12}
+)
+
+// Run length codes of ITU-T T.4, terminating codes are indexed by run length
+// and makeup codes by run length divided by 64, minus 1
+var (
+	ccittWhiteTerminatingCodes = [64]ccittCode{
+		{0b00110101, 8}, {0b000111, 6}, {0b0111, 4}, {0b1000, 4}, {0b1011, 4}, {0b1100, 4}, {0b1110, 4}, {0b1111, 4},
+		{0b10011, 5}, {0b10100, 5}, {0b00111, 5}, {0b01000, 5}, {0b001000, 6}, {0b000011, 6}, {0b110100, 6}, {0b110101, 6},
+		{0b101010, 6}, {0b101011, 6}, {0b0100111, 7}, {0b0001100, 7}, {0b0001000, 7}, {0b0010111, 7}, {0b0000011, 7}, {0b0000100, 7},
+		{0b0101000, 7}, {0b0101011, 7}, {0b0010011, 7}, {0b0100100, 7}, {0b0011000, 7}, {0b00000010, 8}, {0b00000011, 8}, {0b00011010, 8},
+		{0b00011011, 8}, {0b00010010, 8}, {0b00010011, 8}, {0b00010100, 8}, {0b00010101, 8}, {0b00010110, 8}, {0b00010111, 8}, {0b00101000, 8},
+		{0b00101001, 8}, {0b00101010, 8}, {0b00101011, 8}, {0b00101100, 8}, {0b00101101, 8}, {0b00000100, 8}, {0b00000101, 8}, {0b00001010, 8},
+		{0b00001011, 8}, {0b01010010, 8}, {0b01010011, 8}, {0b01010100, 8}, {0b01010101, 8}, {0b00100100, 8}, {0b00100101, 8}, {0b01011000, 8},
+		{0b01011001, 8}, {0b01011010, 8}, {0b01011011, 8}, {0b01001010, 8}, {0b01001011, 8}, {0b00110010, 8}, {0b00110011, 8}, {0b00110100, 8},
+	}
+	ccittWhiteMakeupCodes = [27]ccittCode{
+		{0b11011, 5}, {0b10010, 5}, {0b010111, 6}, {0b0110111, 7}, {0b00110110, 8}, {0b00110111, 8}, {0b01100100, 8}, {0b01100101, 8},
+		{0b01101000, 8}, {0b01100111, 8}, {0b011001100, 9}, {0b011001101, 9}, {0b011010010, 9}, {0b011010011, 9}, {0b011010100, 9}, {0b011010101, 9},
+		{0b011010110, 9}, {0b011010111, 9}, {0b011011000, 9}, {0b011011001, 9}, {0b011011010, 9}, {0b011011011, 9}, {0b010011000, 9}, {0b010011001, 9},
+		{0b010011010, 9}, {0b011000, 6}, {0b010011011, 9},
+	}
+	ccittBlackTerminatingCodes = [64]ccittCode{
+		{0b0000110111, 10}, {0b010, 3}, {0b11, 2}, {0b10, 2}, {0b011, 3}, {0b0011, 4}, {0b0010, 4}, {0b00011, 5},
+		{0b000101, 6}, {0b000100, 6}, {0b0000100, 7}, {0b0000101, 7}, {0b0000111, 7}, {0b00000100, 8}, {0b00000111, 8}, {0b000011000, 9},
+		{0b0000010111, 10}, {0b0000011000, 10}, {0b0000001000, 10}, {0b00001100111, 11}, {0b00001101000, 11}, {0b00001101100, 11}, {0b00000110111, 11}, {0b00000101000, 11},
+		{0b00000010111, 11}, {0b00000011000, 11}, {0b000011001010, 12}, {0b000011001011, 12}, {0b000011001100, 12}, {0b000011001101, 12}, {0b000001101000, 12}, {0b000001101001, 12},
+		{0b000001101010, 12}, {0b000001101011, 12}, {0b000011010010, 12}, {0b000011010011, 12}, {0b000011010100, 12}, {0b000011010101, 12}, {0b000011010110, 12}, {0b000011010111, 12},
+		{0b000001101100, 12}, {0b000001101101, 12}, {0b000011011010, 12}, {0b000011011011, 12}, {0b000001010100, 12}, {0b000001010101, 12}, {0b000001010110, 12}, {0b000001010111, 12},
+		{0b000001100100, 12}, {0b000001100101, 12}, {0b000001010010, 12}, {0b000001010011, 12}, {0b000000100100, 12}, {0b000000110111, 12}, {0b000000111000, 12}, {0b000000100111, 12},
+		{0b000000101000, 12}, {0b000001011000, 12}, {0b000001011001, 12}, {0b000000101011, 12}, {0b000000101100, 12}, {0b000001011010, 12}, {0b000001100110, 12}, {0b000001100111, 12},
+	}
+	ccittBlackMakeupCodes = [27]ccittCode{
+		{0b0000001111, 10}, {0b000011001000, 12}, {0b000011001001, 12}, {0b000001011011, 12}, {0b000000110011, 12}, {0b000000110100, 12}, {0b000000110101, 12}, {0b0000001101100, 13},
+		{0b0000001101101, 13}, {0b0000001001010, 13}, {0b0000001001011, 13}, {0b0000001001100, 13}, {0b0000001001101, 13}, {0b0000001110010, 13}, {0b0000001110011, 13}, {0b0000001110100, 13},
+		{0b0000001110101, 13}, {0b0000001110110, 13}, {0b0000001110111, 13}, {0b0000001010010, 13}, {0b0000001010011, 13}, {0b0000001010100, 13}, {0b0000001010101, 13}, {0b0000001011010, 13},
+		{0b0000001011011, 13}, {0b0000001100100, 13}, {0b0000001100101, 13},
+	}
+	// ccittExtendedMakeupCodes are shared by both colors, from 1792 to 2560
+	ccittExtendedMakeupCodes = [13]ccittCode{
+		{0b00000001000, 11}, {0b00000001100, 11}, {0b00000001101, 11}, {0b000000010010, 12}, {0b000000010011, 12}, {0b000000010100, 12}, {0b000000010101, 12}, {0b000000010110, 12},
+		{0b000000010111, 12}, {0b000000011100, 12}, {0b000000011101, 12}, {0b000000011110, 12}, {0b000000011111, 12},
+	}
+)
+
+// ccittWriter writes the codes of a CCITT fax encoding
+type ccittWriter struct {
+	pdfBitWriter
+}
+
+func (w *ccittWriter) code(c ccittCode) {
+	w.write(c.value, c.size)
+}
+
+// run writes the makeup and terminating codes of a run of pixels of a color
+func (w *ccittWriter) run(length int, black bool) {
+	terminating, makeup := &ccittWhiteTerminatingCodes, &ccittWhiteMakeupCodes
+	if black {
+		terminating, makeup = &ccittBlackTerminatingCodes, &ccittBlackMakeupCodes
+	}
+
+	for length >= 2560+64 {
+		w.code(ccittExtendedMakeupCodes[len(ccittExtendedMakeupCodes)-1])
+		length -= 2560
+	}
+	if length >= 64 {
+		if n := length / 64; n > len(makeup) {
+			w.code(ccittExtendedMakeupCodes[n-len(makeup)-1])
+		} else {
+			w.code(makeup[n-1])
+		}
+		length %= 64
+	}
+	w.code(terminating[length])
+}
+
+// ccittChangingElement returns the position of the first pixel of a line,
+// at or after start, whose color is not the given one, or the line width
+func ccittChangingElement(line []bool, start int, black bool) int {
+	for i := max(start, 0); i < len(line); i++ {
+		if line[i] != black {
+			return i
+		}
+	}
+
+	return len(line)
+}
+
+// encodeCCITTG4 encodes a bilevel image, given as rows of pixels being true
+// for black, with the two-dimensional coding of ITU-T T.6 (CCITT Group 4),
+// as decoded by the CCITTFaxDecode filter with a negative K
+func encodeCCITTG4(rows [][]bool, width int) []byte {
+	w := &ccittWriter{}
+	// The line above the first one is white
+	reference := make([]bool, width)
+	pixel := func(line []bool, i int) bool { return i >= 0 && i < width && line[i] }
+	for _, line := range rows {
+		// a0 starts on an imaginary white pixel before the line
+		a0, black := -1, false
+		a1 := ccittChangingElement(line, 0, false)
+		b1 := ccittChangingElement(reference, 0, false)
+		for {
+			b2 := ccittChangingElement(reference, b1, pixel(reference, b1))
+			switch {
+			case b2 < a1:
+				w.code(ccittPassCode)
+				a0 = b2
+			case a1-b1 >= -3 && a1-b1 <= 3:
+				w.code(ccittVerticalCodes[a1-b1+3])
+				a0, black = a1, !black
+			default:
+				a2 := ccittChangingElement(line, a1, pixel(line, a1))
+				w.code(ccittHorizontalCode)
+				w.run(a1-max(a0, 0), black)
+				w.run(a2-a1, !black)
+				a0 = a2
+			}
+
+			if a0 >= width {
+				break
+			}
+
+			// Changing elements are strictly after a0, b1 being of the color
+			// opposite to the one of a0
+			a1 = ccittChangingElement(line, a0, black)
+			b1 = ccittChangingElement(reference, a0, !black)
+			b1 = ccittChangingElement(reference, b1, black)
+		}
+
+		reference = line
+	}
+
+	// End of facsimile block
+	w.code(ccittEOL)
+	w.code(ccittEOL)
+	w.flush()
+
+	return w.buf.Bytes()
+}
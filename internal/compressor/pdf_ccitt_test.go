@@ -0,0 +1,65 @@
+package compressor
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/ccitt"
+)
+
+// decodeCCITTG4 decodes CCITT Group 4 data to rows of pixels, true for black
+func decodeCCITTG4(t *testing.T, data []byte, width, height int) [][]bool {
+	packed, err := io.ReadAll(ccitt.NewReader(bytes.NewReader(data), ccitt.MSB, ccitt.Group4, width, height, nil))
+	require.NoError(t, err)
+
+	stride := (width + 7) / 8
+	require.Len(t, packed, stride*height)
+
+	rows := make([][]bool, height)
+	for y := range rows {
+		rows[y] = make([]bool, width)
+		for x := range rows[y] {
+			// White is the 1 bit
+			rows[y][x] = packed[y*stride+x/8]&(0x80>>(x%8)) == 0
+		}
+	}
+
+	return rows
+}
+
+func TestEncodeCCITTG4(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		pixel  func(x, y int) bool
+	}{
+		{"white", 100, 10, func(x, y int) bool { return false }},
+		{"black", 100, 10, func(x, y int) bool { return true }},
+		{"text like", 200, 50, func(x, y int) bool { return (x/7+y/5)%4 == 0 && y%10 < 8 }},
+		{"diagonal", 64, 64, func(x, y int) bool { return x >= y && x < y+3 }},
+		{"noise", 123, 40, func(x, y int) bool { return random.Intn(3) == 0 }},
+		{"long runs", 6000, 4, func(x, y int) bool { return x >= 2700*y && x < 2700*y+1900 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := make([][]bool, tt.height)
+			for y := range rows {
+				rows[y] = make([]bool, tt.width)
+				for x := range rows[y] {
+					rows[y][x] = tt.pixel(x, y)
+				}
+			}
+
+			data := encodeCCITTG4(rows, tt.width)
+			assert.Equal(t, rows, decodeCCITTG4(t, data, tt.width, tt.height))
+		})
+	}
+}
@@ -0,0 +1,611 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// CFF dictionary operators, escaped ones being 12<<8 | second byte
+const (
+	cffCharset     = 15
+	cffEncoding    = 16
+	cffCharStrings = 17
+	cffPrivate     = 18
+	cffSubrs       = 19
+	cffROS         = 12<<8 | 30
+	cffFDArray     = 12<<8 | 36
+	cffFDSelect    = 12<<8 | 37
+)
+
+// cffDictEntry is an operator of a CFF dictionary with its operands, kept
+// encoded so that the entries which are not offsets are written back as is
+type cffDictEntry struct {
+	operator int
+	operands []float64
+	raw      []byte
+}
+
+type cffDict []cffDictEntry
+
+func (d cffDict) get(operator int) []float64 {
+	for _, entry := range d {
+		if entry.operator == operator {
+			return entry.operands
+		}
+	}
+
+	return nil
+}
+
+// cffFont is a CFF font program, as embedded by FontFile3 streams
+type cffFont struct {
+	data    []byte
+	topDict cffDict
+	// topDictStart and topDictEnd delimit the Top DICT INDEX
+	topDictStart, topDictEnd int
+	// restStart is the end of the Global Subr INDEX, the data after it being
+	// located by offsets
+	restStart   int
+	gsubrs      [][]byte
+	charStrings [][]byte
+	// charStringsStart and charStringsEnd delimit the CharStrings INDEX
+	charStringsStart, charStringsEnd int
+	// fdArray holds the Font DICTs of CID-keyed fonts
+	fdArray []cffDict
+	// localSubrs are the subroutines of the Private DICT of other fonts
+	localSubrs [][]byte
+}
+
+// cffIndex reads an INDEX, returning its items and its end
+func cffIndex(data []byte, pos int) ([][]byte, int, error) {
+	if pos+2 > len(data) {
+		return nil, 0, fmt.Errorf("truncated INDEX")
+	}
+
+	count := int(binary.BigEndian.Uint16(data[pos:]))
+	if count == 0 {
+		return nil, pos + 2, nil
+	}
+
+	if pos+3 > len(data) {
+		return nil, 0, fmt.Errorf("truncated INDEX")
+	}
+	offSize := int(data[pos+2])
+	if offSize < 1 || offSize > 4 || pos+3+(count+1)*offSize > len(data) {
+		return nil, 0, fmt.Errorf("invalid INDEX")
+	}
+
+	offset := func(i int) int {
+		value := 0
+		for _, b := range data[pos+3+i*offSize : pos+3+(i+1)*offSize] {
+			value = value<<8 | int(b)
+		}
+		return value
+	}
+
+	base := pos + 3 + (count+1)*offSize - 1
+	items := make([][]byte, count)
+	for i := range items {
+		start, end := offset(i), offset(i+1)
+		if start < 1 || end < start || base+end > len(data) {
+			return nil, 0, fmt.Errorf("invalid INDEX offsets")
+		}
+		items[i] = data[base+start : base+end]
+	}
+
+	return items, base + offset(count), nil
+}
+
+// buildCFFIndex writes an INDEX
+func buildCFFIndex(items [][]byte) []byte {
+	if len(items) == 0 {
+		return []byte{0, 0}
+	}
+
+	size := 1
+	for _, item := range items {
+		size += len(item)
+	}
+	offSize := 1
+	for size >= 1<<(8*offSize) {
+		offSize++
+	}
+
+	index := binary.BigEndian.AppendUint16(nil, uint16(len(items)))
+	index = append(index, byte(offSize))
+	offset := 1
+	for i := 0; i <= len(items); i++ {
+		for shift := 8 * (offSize - 1); shift >= 0; shift -= 8 {
+			index = append(index, byte(offset>>shift))
+		}
+		if i < len(items) {
+			offset += len(items[i])
+		}
+	}
+	for _, item := range items {
+		index = append(index, item...)
+	}
+
+	return index
+}
+
+// parseCFFDict reads the entries of a dictionary
+func parseCFFDict(data []byte) (cffDict, error) {
+	var dict cffDict
+	var operands []float64
+	start := 0
+	for pos := 0; pos < len(data); {
+		b0 := data[pos]
+		switch {
+		case b0 <= 21:
+			operator := int(b0)
+			pos++
+			if b0 == 12 {
+				if pos >= len(data) {
+					return nil, fmt.Errorf("truncated operator")
+				}
+				operator = 12<<8 | int(data[pos])
+				pos++
+			}
+			dict = append(dict, cffDictEntry{operator: operator, operands: operands, raw: data[start:pos]})
+			operands = nil
+			start = pos
+			continue
+		case b0 == 28 && pos+3 <= len(data):
+			operands = append(operands, float64(int16(binary.BigEndian.Uint16(data[pos+1:]))))
+			pos += 3
+		case b0 == 29 && pos+5 <= len(data):
+			operands = append(operands, float64(int32(binary.BigEndian.Uint32(data[pos+1:]))))
+			pos += 5
+		case b0 == 30:
+			// Real numbers are never offsets, their value is not needed
+			operands = append(operands, math.NaN())
+			for pos++; pos < len(data) && data[pos]&0x0f != 0x0f && data[pos]&0xf0 != 0xf0; pos++ {
+			}
+			pos++
+		case b0 >= 32 && b0 <= 246:
+			operands = append(operands, float64(int(b0)-139))
+			pos++
+		case b0 >= 247 && b0 <= 250 && pos+2 <= len(data):
+			operands = append(operands, float64((int(b0)-247)*256+int(data[pos+1])+108))
+			pos += 2
+		case b0 >= 251 && b0 <= 254 && pos+2 <= len(data):
+			operands = append(operands, float64(-(int(b0)-251)*256-int(data[pos+1])-108))
+			pos += 2
+		default:
+			return nil, fmt.Errorf("invalid dictionary operand")
+		}
+	}
+
+	return dict, nil
+}
+
+// build writes a dictionary, the operands of the listed operators being
+// replaced by relocated offsets, with a fixed size encoding
+func (d cffDict) build(relocate map[int]func(operands []float64) []float64) []byte {
+	var data []byte
+	for _, entry := range d {
+		fn, ok := relocate[entry.operator]
+		if !ok {
+			data = append(data, entry.raw...)
+			continue
+		}
+
+		for _, operand := range fn(entry.operands) {
+			data = append(data, 29)
+			data = binary.BigEndian.AppendUint32(data, uint32(int32(operand)))
+		}
+		if entry.operator > 0xff {
+			data = append(data, 12)
+		}
+		data = append(data, byte(entry.operator))
+	}
+
+	return data
+}
+
+func parseCFF(data []byte) (*cffFont, error) {
+	if len(data) < 4 || data[0] != 1 {
+		return nil, fmt.Errorf("not a CFF font")
+	}
+
+	f := &cffFont{data: data}
+	names, pos, err := cffIndex(data, int(data[2]))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != 1 {
+		return nil, fmt.Errorf("font sets are not supported")
+	}
+
+	f.topDictStart = pos
+	topDicts, pos, err := cffIndex(data, pos)
+	if err != nil {
+		return nil, err
+	}
+	f.topDictEnd = pos
+	if len(topDicts) != 1 {
+		return nil, fmt.Errorf("invalid Top DICT INDEX")
+	}
+	if f.topDict, err = parseCFFDict(topDicts[0]); err != nil {
+		return nil, err
+	}
+
+	// String INDEX, then Global Subr INDEX
+	if _, pos, err = cffIndex(data, pos); err != nil {
+		return nil, err
+	}
+	if f.gsubrs, f.restStart, err = cffIndex(data, pos); err != nil {
+		return nil, err
+	}
+
+	charStrings := f.topDict.get(cffCharStrings)
+	if len(charStrings) != 1 || int(charStrings[0]) < f.restStart {
+		return nil, fmt.Errorf("invalid CharStrings offset")
+	}
+	f.charStringsStart = int(charStrings[0])
+	if f.charStrings, f.charStringsEnd, err = cffIndex(data, f.charStringsStart); err != nil {
+		return nil, err
+	}
+
+	if operands := f.topDict.get(cffFDArray); len(operands) == 1 {
+		fonts, _, err := cffIndex(data, int(operands[0]))
+		if err != nil {
+			return nil, err
+		}
+		for _, font := range fonts {
+			dict, err := parseCFFDict(font)
+			if err != nil {
+				return nil, err
+			}
+			f.fdArray = append(f.fdArray, dict)
+			if err := f.addPrivate(dict, false); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := f.addPrivate(f.topDict, true); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// addPrivate checks the Private DICT of a font and, for the font of non
+// CID-keyed programs, reads its subroutines
+func (f *cffFont) addPrivate(dict cffDict, readSubrs bool) error {
+	operands := dict.get(cffPrivate)
+	if len(operands) != 2 {
+		return nil
+	}
+
+	size, offset := int(operands[0]), int(operands[1])
+	if size < 0 || offset < f.restStart || offset+size > len(f.data) {
+		return fmt.Errorf("invalid Private DICT")
+	}
+
+	private, err := parseCFFDict(f.data[offset : offset+size])
+	if err != nil {
+		return err
+	}
+
+	// Local subroutines are located relatively to their Private DICT, which
+	// must stay on the same side of the CharStrings INDEX
+	if subrs := private.get(cffSubrs); len(subrs) == 1 {
+		start := offset + int(subrs[0])
+		if offset < f.charStringsStart && start >= f.charStringsEnd {
+			return fmt.Errorf("subroutines separated from their Private DICT")
+		}
+		if readSubrs {
+			if f.localSubrs, _, err = cffIndex(f.data, start); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isCID reports whether the font is CID-keyed
+func (f *cffFont) isCID() bool {
+	return f.topDict.get(cffROS) != nil
+}
+
+// charset returns the SID, or the CID of CID-keyed fonts, of each glyph,
+// nil for predefined charsets
+func (f *cffFont) charset() []int {
+	operands := f.topDict.get(cffCharset)
+	if len(operands) != 1 || operands[0] <= 2 {
+		return nil
+	}
+
+	data := f.data
+	pos := int(operands[0])
+	ids := make([]int, 1, len(f.charStrings))
+	u16 := func(pos int) int {
+		if pos+2 > len(data) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(data[pos:]))
+	}
+
+	if pos >= len(data) {
+		return nil
+	}
+	format := data[pos]
+	pos++
+	for len(ids) < len(f.charStrings) && pos < len(data) {
+		switch format {
+		case 0:
+			ids = append(ids, u16(pos))
+			pos += 2
+		case 1, 2:
+			first, left := u16(pos), 0
+			if format == 1 && pos+2 < len(data) {
+				left = int(data[pos+2])
+				pos += 3
+			} else {
+				left = u16(pos + 2)
+				pos += 4
+			}
+			for id := first; id <= first+left && len(ids) < len(f.charStrings); id++ {
+				ids = append(ids, id)
+			}
+		default:
+			return nil
+		}
+	}
+
+	return ids
+}
+
+// glyphForCID returns the glyph of a CID, a CID being the glyph itself for
+// fonts which are not CID-keyed
+func (f *cffFont) glyphForCID(cids []int, cid int) (uint16, bool) {
+	if !f.isCID() {
+		return uint16(cid), cid < len(f.charStrings)
+	}
+
+	if cids == nil {
+		// Identity charset
+		return uint16(cid), cid < len(f.charStrings)
+	}
+
+	gid := slices.Index(cids, cid)
+
+	return uint16(gid), gid >= 0
+}
+
+// encoding returns the glyph of each code of a custom encoding, nil when
+// the font uses a predefined encoding
+func (f *cffFont) encoding() map[byte]uint16 {
+	operands := f.topDict.get(cffEncoding)
+	if len(operands) != 1 || operands[0] <= 1 || int(operands[0]) >= len(f.data) {
+		return nil
+	}
+
+	data := f.data[int(operands[0]):]
+	glyphs := map[byte]uint16{}
+	pos := 1
+	switch data[0] & 0x7f {
+	case 0:
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil
+		}
+		for i, code := range data[2 : 2+int(data[1])] {
+			glyphs[code] = uint16(i + 1)
+		}
+		pos = 2 + int(data[1])
+	case 1:
+		if len(data) < 2 || len(data) < 2+2*int(data[1]) {
+			return nil
+		}
+		gid := 1
+		for i := range int(data[1]) {
+			first, left := int(data[2+2*i]), int(data[3+2*i])
+			for code := first; code <= first+left && code < 256; code++ {
+				glyphs[byte(code)] = uint16(gid)
+				gid++
+			}
+		}
+		pos = 2 + 2*int(data[1])
+	default:
+		return nil
+	}
+
+	// Supplements map codes to glyph names, by SID
+	if data[0]&0x80 != 0 {
+		sids := f.charset()
+		if sids == nil || pos >= len(data) {
+			return nil
+		}
+		for i := range int(data[pos]) {
+			entry := pos + 1 + 3*i
+			if entry+3 > len(data) {
+				return nil
+			}
+			if gid := slices.Index(sids, int(binary.BigEndian.Uint16(data[entry+1:]))); gid > 0 {
+				glyphs[data[entry]] = uint16(gid)
+			}
+		}
+	}
+
+	return glyphs
+}
+
+// usesSeac reports whether a glyph is composed from two other glyphs by an
+// endchar operator with accent arguments, which only non CID-keyed fonts use
+func (f *cffFont) usesSeac(gid int) bool {
+	s := &type2Scanner{font: f}
+	s.run(f.charStrings[gid], 0)
+
+	return s.seac
+}
+
+// type2Scanner follows a Type 2 charstring through its subroutines far
+// enough to find endchar operators
+type type2Scanner struct {
+	font  *cffFont
+	stack []float64
+	stems int
+	done  bool
+	seac  bool
+}
+
+func type2SubrBias(count int) int {
+	switch {
+	case count < 1240:
+		return 107
+	case count < 33900:
+		return 1131
+	}
+
+	return 32768
+}
+
+func (s *type2Scanner) run(code []byte, depth int) {
+	for pos := 0; pos < len(code) && !s.done; {
+		b0 := code[pos]
+		switch {
+		case b0 == 28 && pos+3 <= len(code):
+			s.stack = append(s.stack, float64(int16(binary.BigEndian.Uint16(code[pos+1:]))))
+			pos += 3
+			continue
+		case b0 >= 32 && b0 <= 246:
+			s.stack = append(s.stack, float64(int(b0)-139))
+			pos++
+			continue
+		case b0 >= 247 && b0 <= 250 && pos+2 <= len(code):
+			s.stack = append(s.stack, float64((int(b0)-247)*256+int(code[pos+1])+108))
+			pos += 2
+			continue
+		case b0 >= 251 && b0 <= 254 && pos+2 <= len(code):
+			s.stack = append(s.stack, float64(-(int(b0)-251)*256-int(code[pos+1])-108))
+			pos += 2
+			continue
+		case b0 == 255 && pos+5 <= len(code):
+			s.stack = append(s.stack, float64(int32(binary.BigEndian.Uint32(code[pos+1:])))/65536)
+			pos += 5
+			continue
+		}
+
+		pos++
+		switch b0 {
+		case 1, 3, 18, 23: // hstem, vstem, hstemhm, vstemhm
+			s.stems += len(s.stack) / 2
+		case 19, 20: // hintmask, cntrmask
+			s.stems += len(s.stack) / 2
+			pos += (s.stems + 7) / 8
+		case 10, 29: // callsubr, callgsubr
+			if len(s.stack) == 0 || depth > 10 {
+				s.done = true
+				return
+			}
+			subrs := s.font.localSubrs
+			if b0 == 29 {
+				subrs = s.font.gsubrs
+			}
+			index := int(s.stack[len(s.stack)-1]) + type2SubrBias(len(subrs))
+			s.stack = s.stack[:len(s.stack)-1]
+			if index >= 0 && index < len(subrs) {
+				s.run(subrs[index], depth+1)
+			}
+			continue
+		case 11: // return
+			return
+		case 14: // endchar
+			s.seac = len(s.stack) >= 4
+			s.done = true
+			return
+		case 12:
+			pos++
+		}
+		s.stack = s.stack[:0]
+	}
+}
+
+// subset returns the font with the charstrings of the glyphs not listed
+// replaced by an empty glyph. Glyph IDs are kept, so that the charset, the
+// encoding and the PDF widths stay valid. The CharStrings INDEX and the
+// FDArray are moved after the other data, the offsets of the Top DICT being
+// rewritten with a fixed size encoding.
+func (f *cffFont) subset(gids map[uint16]bool) []byte {
+	charStrings := make([][]byte, len(f.charStrings))
+	for gid, charString := range f.charStrings {
+		charStrings[gid] = charString
+		if gid != 0 && !gids[uint16(gid)] {
+			// endchar
+			charStrings[gid] = []byte{14}
+		}
+	}
+	newCharStrings := buildCFFIndex(charStrings)
+	removed := f.charStringsEnd - f.charStringsStart
+
+	// The Top DICT grows or shrinks with its fixed size offsets, the data
+	// following the Global Subr INDEX moves with it
+	relocated := func(delta int) func(offset int) int {
+		return func(offset int) int {
+			if offset >= f.charStringsEnd {
+				return offset + delta - removed
+			}
+			return offset + delta
+		}
+	}
+
+	buildTop := func(delta int) []byte {
+		move := relocated(delta)
+		charStringsOffset := len(f.data) + delta - removed
+		fdArrayOffset := charStringsOffset + len(newCharStrings)
+
+		return buildCFFIndex([][]byte{f.topDict.build(map[int]func([]float64) []float64{
+			cffCharset:     relocateCFFPredefined(move, 2),
+			cffEncoding:    relocateCFFPredefined(move, 1),
+			cffCharStrings: func([]float64) []float64 { return []float64{float64(charStringsOffset)} },
+			cffPrivate:     relocateCFFPrivate(move),
+			cffFDArray:     func([]float64) []float64 { return []float64{float64(fdArrayOffset)} },
+			cffFDSelect:    relocateCFFPredefined(move, -1),
+		})})
+	}
+
+	// The size of the fixed size offsets does not depend on their value
+	delta := f.topDictStart + len(buildTop(0)) - f.topDictEnd
+	move := relocated(delta)
+
+	font := slices.Clone(f.data[:f.topDictStart])
+	font = append(font, buildTop(delta)...)
+	font = append(font, f.data[f.topDictEnd:f.charStringsStart]...)
+	font = append(font, f.data[f.charStringsEnd:]...)
+	font = append(font, newCharStrings...)
+
+	if f.fdArray != nil {
+		fonts := make([][]byte, len(f.fdArray))
+		for i, dict := range f.fdArray {
+			fonts[i] = dict.build(map[int]func([]float64) []float64{cffPrivate: relocateCFFPrivate(move)})
+		}
+		font = append(font, buildCFFIndex(fonts)...)
+	}
+
+	return font
+}
+
+// relocateCFFPredefined relocates an offset, unless it is the identifier of
+// a predefined charset or encoding
+func relocateCFFPredefined(move func(int) int, predefined int) func([]float64) []float64 {
+	return func(operands []float64) []float64 {
+		if len(operands) != 1 || int(operands[0]) <= predefined {
+			return operands
+		}
+		return []float64{float64(move(int(operands[0])))}
+	}
+}
+
+// relocateCFFPrivate relocates the offset of a Private DICT, after its size
+func relocateCFFPrivate(move func(int) int) func([]float64) []float64 {
+	return func(operands []float64) []float64 {
+		if len(operands) != 2 {
+			return operands
+		}
+		return []float64{operands[0], float64(move(int(operands[1])))}
+	}
+}
@@ -0,0 +1,142 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCharStrings are the glyphs of the test CFF fonts: .notdef, two moves
+// and, for the font which is not CID-keyed, an accented glyph composed by a
+// local subroutine
+var testCharStrings = [][]byte{{14}, {149, 159, 21, 14}, {150, 160, 21, 14}, {32, 10}}
+
+// cffOperand encodes an integer operand with a fixed size
+func cffOperand(value int) []byte {
+	return binary.BigEndian.AppendUint32([]byte{29}, uint32(int32(value)))
+}
+
+// cffEntry encodes a dictionary entry
+func cffEntry(operator int, operands ...int) []byte {
+	var entry []byte
+	for _, operand := range operands {
+		entry = append(entry, cffOperand(operand)...)
+	}
+	if operator > 0xff {
+		entry = append(entry, 12)
+	}
+
+	return append(entry, byte(operator))
+}
+
+// createTestCFF returns a CFF font, CID-keyed or with a custom encoding
+// mapping A, B and C to its glyphs. The CharStrings INDEX sits between the
+// other data, so that subsetting moves data both before and after it.
+func createTestCFF(cid bool) []byte {
+	var topDict, strings []byte
+	if cid {
+		strings = buildCFFIndex([][]byte{[]byte("Adobe"), []byte("Identity")})
+	} else {
+		strings = buildCFFIndex(nil)
+	}
+
+	prefix := append([]byte{1, 0, 4, 1}, buildCFFIndex([][]byte{[]byte("Test")})...)
+	// Every entry has a fixed size: 5 bytes per operand plus the operators
+	var topSize int
+	if cid {
+		topSize = 3*5 + 2 + 5 + 1 + 5 + 1 + 5 + 2 + 5 + 2
+	} else {
+		topSize = 5 + 1 + 5 + 1 + 2*5 + 1
+	}
+	base := len(prefix) + len(buildCFFIndex([][]byte{make([]byte, topSize)})) + len(strings) + 2
+
+	charStrings := buildCFFIndex(testCharStrings)
+	private := cffEntry(cffSubrs, 6)
+	subrs := buildCFFIndex([][]byte{{139, 139, 204, 205, 14}})
+
+	var rest []byte
+	if cid {
+		charset := []byte{0, 0, 10, 0, 20, 0, 30}
+		fdSelect := []byte{0, 0, 0, 0, 0}
+		privateOffset := base + len(charset) + len(fdSelect) + len(charStrings)
+		fdArray := buildCFFIndex([][]byte{cffEntry(cffPrivate, len(private), privateOffset)})
+
+		topDict = append(topDict, cffEntry(cffROS, 391, 392, 0)...)
+		topDict = append(topDict, cffEntry(cffCharset, base)...)
+		topDict = append(topDict, cffEntry(cffCharStrings, base+len(charset)+len(fdSelect))...)
+		topDict = append(topDict, cffEntry(cffFDArray, privateOffset+len(private)+len(subrs))...)
+		topDict = append(topDict, cffEntry(cffFDSelect, base+len(charset))...)
+		rest = append(append(append(append(append(charset, fdSelect...), charStrings...), private...), subrs...), fdArray...)
+	} else {
+		encoding := []byte{0, 3, 'A', 'B', 'C'}
+		topDict = append(topDict, cffEntry(cffEncoding, base)...)
+		topDict = append(topDict, cffEntry(cffCharStrings, base+len(encoding))...)
+		topDict = append(topDict, cffEntry(cffPrivate, len(private), base+len(encoding)+len(charStrings))...)
+		rest = append(append(append(encoding, charStrings...), private...), subrs...)
+	}
+
+	font := append(prefix, buildCFFIndex([][]byte{topDict})...)
+	font = append(font, strings...)
+	font = append(font, buildCFFIndex(nil)...)
+
+	return append(font, rest...)
+}
+
+func TestCFFFont_Subset(t *testing.T) {
+	font, err := parseCFF(createTestCFF(false))
+	require.NoError(t, err)
+	assert.False(t, font.isCID())
+	assert.Equal(t, map[byte]uint16{'A': 1, 'B': 2, 'C': 3}, font.encoding())
+	assert.False(t, font.usesSeac(1))
+	assert.True(t, font.usesSeac(3))
+
+	subset, err := parseCFF(font.subset(map[uint16]bool{1: true}))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{14}, {149, 159, 21, 14}, {14}, {14}}, subset.charStrings)
+	assert.Equal(t, font.encoding(), subset.encoding())
+	assert.Equal(t, font.localSubrs, subset.localSubrs)
+}
+
+func TestCFFFont_SubsetCID(t *testing.T) {
+	font, err := parseCFF(createTestCFF(true))
+	require.NoError(t, err)
+	assert.True(t, font.isCID())
+
+	cids := font.charset()
+	assert.Equal(t, []int{0, 10, 20, 30}, cids)
+	gid, ok := font.glyphForCID(cids, 20)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(2), gid)
+	_, ok = font.glyphForCID(cids, 5)
+	assert.False(t, ok)
+
+	subset, err := parseCFF(font.subset(map[uint16]bool{2: true}))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{14}, {14}, {150, 160, 21, 14}, {14}}, subset.charStrings)
+	assert.Equal(t, cids, subset.charset())
+
+	// The Private DICT of the FDArray still points to the subroutines
+	operands := subset.fdArray[0].get(cffPrivate)
+	require.Len(t, operands, 2)
+	private, err := parseCFFDict(subset.data[int(operands[1]) : int(operands[1])+int(operands[0])])
+	require.NoError(t, err)
+	subrs, _, err := cffIndex(subset.data, int(operands[1])+int(private.get(cffSubrs)[0]))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{139, 139, 204, 205, 14}}, subrs)
+}
+
+func TestCFFIndex(t *testing.T) {
+	items := [][]byte{[]byte("a"), make([]byte, 300), nil}
+	index := buildCFFIndex(items)
+	assert.Equal(t, byte(2), index[2], "offsets above 255 need 2 bytes")
+
+	parsed, end, err := cffIndex(append(index, 0xff), 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(index), end)
+	assert.Equal(t, [][]byte{[]byte("a"), make([]byte, 300), {}}, parsed)
+
+	_, _, err = cffIndex(index[:10], 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,237 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"maps"
+	"slices"
+
+	"github.com/pdfcpu/pdfcpu/pkg/filter"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Thresholds deciding whether an image is effectively grayscale or black and
+// white, scans and JPEG compression adding noise to both
+const (
+	// pdfGrayChromaTolerance is the largest difference between the red, green
+	// and blue components of a pixel considered gray
+	pdfGrayChromaTolerance = 24
+	// pdfGrayColoredRatio is the largest share of colored pixels in an image
+	// converted to grayscale
+	pdfGrayColoredRatio = 0.005
+	// pdfBilevelMidtoneRatio is the largest share of pixels lighter than
+	// pdfBilevelBlack and darker than pdfBilevelWhite in an image converted to
+	// black and white, the anti-aliased edges of scanned text being such
+	pdfBilevelMidtoneRatio = 0.05
+	pdfBilevelBlack        = 64
+	pdfBilevelWhite        = 192
+)
+
+// reduceImageColors converts the image XObjects painted by the document which
+// are effectively grayscale to DeviceGray, and those which are effectively
+// black and white, such as scanned text, to 1-bit CCITT G4
+func (pc *PdfCompressor) reduceImageColors(ctx *model.Context) error {
+	if !pc.reduceColors {
+		return nil
+	}
+
+	painted := map[int]bool{}
+	err := walkPDFPages(ctx, func(_ int, image pdfXObject) error {
+		painted[image.ref.ObjectNumber.Value()] = true
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, objNr := range slices.Sorted(maps.Keys(painted)) {
+		if err := pc.reduceImageColor(ctx, objNr); err != nil {
+			return fmt.Errorf("failed to reduce colors of image %d: %v", objNr, err)
+		}
+	}
+
+	return nil
+}
+
+// reduceImageColor converts an image XObject to grayscale or to black and
+// white. Images that cannot be decoded, have colors, or whose conversion is
+// not smaller, are left untouched.
+func (pc *PdfCompressor) reduceImageColor(ctx *model.Context, objNr int) error {
+	entry := ctx.Table[objNr]
+	sd, ok := entry.Object.(types.StreamDict)
+	if !ok {
+		return nil
+	}
+
+	if sd.Dict["Decode"] != nil {
+		// The decode array is bound to the number of components
+		pc.logger.PrintfVerbose("PDF Compressor: Skipping image %d: decode arrays are not converted\n", objNr)
+		return nil
+	}
+
+	lossy := len(sd.FilterPipeline) == 1 && sd.FilterPipeline[0].Name == filter.DCT
+	originalSize := len(sd.Raw)
+
+	img, err := decodePDFImage(ctx, &sd)
+	if err != nil {
+		pc.logger.PrintfVerbose("PDF Compressor: Skipping image %d: %v\n", objNr, err)
+		return nil
+	}
+
+	gray, colored := pdfImageGray(img)
+	if colored > pdfGrayColoredRatio {
+		return nil
+	}
+
+	bounds := gray.Bounds()
+	threshold, midtones := pdfBilevelThreshold(gray)
+
+	var data []byte
+	var bitsPerComponent int
+	var f types.PDFFilter
+	var kind string
+	switch _, alreadyGray := img.(*image.Gray); {
+	case midtones <= pdfBilevelMidtoneRatio:
+		rows := make([][]bool, bounds.Dy())
+		for y := range rows {
+			rows[y] = make([]bool, bounds.Dx())
+			for x := range rows[y] {
+				rows[y][x] = gray.GrayAt(bounds.Min.X+x, bounds.Min.Y+y).Y < threshold
+			}
+		}
+
+		data, bitsPerComponent, kind = encodeCCITTG4(rows, bounds.Dx()), 1, "black and white"
+		f = types.PDFFilter{Name: filter.CCITTFax, DecodeParms: types.Dict{
+			"K":       types.Integer(-1),
+			"Columns": types.Integer(bounds.Dx()),
+			"Rows":    types.Integer(bounds.Dy()),
+		}}
+	case alreadyGray:
+		return nil
+	case lossy:
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, gray, &jpeg.Options{Quality: pc.imageQuality}); err != nil {
+			return fmt.Errorf("failed to encode JPEG: %v", err)
+		}
+
+		data, bitsPerComponent, kind = buf.Bytes(), 8, "grayscale"
+		f = types.PDFFilter{Name: filter.DCT}
+	default:
+		pix := make([]byte, 0, bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			offset := gray.PixOffset(bounds.Min.X, y)
+			pix = append(pix, gray.Pix[offset:offset+bounds.Dx()]...)
+		}
+
+		if data, err = deflatePDFStream(pix); err != nil {
+			return fmt.Errorf("failed to deflate image: %v", err)
+		}
+		bitsPerComponent, kind = 8, "grayscale"
+		f = types.PDFFilter{Name: filter.Flate}
+	}
+
+	if len(data) >= originalSize {
+		pc.logger.PrintfVerbose("PDF Compressor: Keeping image %d, its %s conversion is not smaller\n", objNr, kind)
+		return nil
+	}
+
+	setPDFImageStream(&sd, data, bounds.Dx(), bounds.Dy(), bitsPerComponent, f)
+	sd.Update("ColorSpace", types.Name("DeviceGray"))
+	entry.Object = sd
+
+	pc.logger.PrintfVerbose("PDF Compressor: Converted image %d to %s\n", objNr, kind)
+
+	return nil
+}
+
+// pdfImageGray returns the luminance of an image and the share of its pixels
+// which are not a shade of gray
+func pdfImageGray(img image.Image) (*image.Gray, float64) {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray, 0
+	}
+
+	rgb := func(x, y int) (uint8, uint8, uint8) {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		return c.R, c.G, c.B
+	}
+	switch img := img.(type) {
+	case *image.YCbCr:
+		rgb = func(x, y int) (uint8, uint8, uint8) {
+			ci := img.COffset(x, y)
+			return color.YCbCrToRGB(img.Y[img.YOffset(x, y)], img.Cb[ci], img.Cr[ci])
+		}
+	case *image.NRGBA:
+		rgb = func(x, y int) (uint8, uint8, uint8) {
+			i := img.PixOffset(x, y)
+			return img.Pix[i], img.Pix[i+1], img.Pix[i+2]
+		}
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	colored := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b := rgb(x, y)
+			if max(r, g, b)-min(r, g, b) > pdfGrayChromaTolerance {
+				colored++
+			}
+			// Same weights as color.GrayModel
+			gray.Pix[gray.PixOffset(x, y)] = uint8((19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16)
+		}
+	}
+
+	return gray, float64(colored) / float64(max(1, bounds.Dx()*bounds.Dy()))
+}
+
+// pdfBilevelThreshold returns the threshold separating the dark pixels of an
+// image from its light ones, following Otsu's method, and the share of its
+// pixels which are neither black nor white
+func pdfBilevelThreshold(gray *image.Gray) (uint8, float64) {
+	var histogram [256]int
+	bounds := gray.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := gray.PixOffset(bounds.Min.X, y)
+		for _, value := range gray.Pix[offset : offset+bounds.Dx()] {
+			histogram[value]++
+		}
+	}
+
+	total, sum, midtones := 0, 0, 0
+	for value, count := range histogram {
+		total += count
+		sum += value * count
+		if value > pdfBilevelBlack && value < pdfBilevelWhite {
+			midtones += count
+		}
+	}
+
+	// The threshold maximizes the variance between the dark and light pixels
+	threshold, best := 128, 0.0
+	darkCount, darkSum := 0, 0
+	for value, count := range histogram {
+		darkCount += count
+		darkSum += value * count
+		lightCount := total - darkCount
+		if darkCount == 0 {
+			continue
+		}
+		if lightCount == 0 {
+			break
+		}
+
+		darkMean := float64(darkSum) / float64(darkCount)
+		lightMean := float64(sum-darkSum) / float64(lightCount)
+		if variance := float64(darkCount) * float64(lightCount) * (darkMean - lightMean) * (darkMean - lightMean); variance > best {
+			threshold, best = value+1, variance
+		}
+	}
+
+	return uint8(threshold), float64(midtones) / float64(max(1, total))
+}
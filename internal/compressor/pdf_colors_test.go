@@ -0,0 +1,150 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createScannedTextImage returns an RGB image of black text lines on slightly
+// gray paper, as a scanner produces
+func createScannedTextImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			paper := uint8(240 + (x*7+y*13)%8)
+			c := color.RGBA{R: paper, G: paper, B: paper - 3, A: 255}
+			if y%20 >= 6 && y%20 < 14 && (x/6)%3 != 0 && x > 20 && x < width-20 {
+				c = color.RGBA{R: 12, G: 12, B: 16, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// createGrayPhotoImage returns an RGB image of a gray gradient with details
+func createGrayPhotoImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := uint8((x+y)*200/(width+height)) + uint8(((x*2654435761^y*40503)>>9)%32)
+			img.SetRGBA(x, y, color.RGBA{R: value, G: value, B: value, A: 255})
+		}
+	}
+
+	return img
+}
+
+// jpegImageObject returns an RGB image XObject with JPEG compressed samples
+func jpegImageObject(t *testing.T, img image.Image) string {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}))
+
+	return fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n%s\nendstream",
+		img.Bounds().Dx(), img.Bounds().Dy(), buf.Len(), buf.String())
+}
+
+// createPDFWithScans writes a one page PDF painting a scanned text page, a
+// grayscale photo and a color photo, all stored in color
+func createPDFWithScans(t *testing.T, path string) {
+	content := "q 400 0 0 400 100 300 cm /Im1 Do Q q 100 0 0 100 100 100 cm /Im2 Do Q q 100 0 0 100 300 100 cm /Im3 Do Q"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /XObject << /Im1 5 0 R /Im2 6 0 R /Im3 7 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		jpegImageObject(t, createScannedTextImage(400, 400)),
+		jpegImageObject(t, createGrayPhotoImage(200, 200)),
+		flateImageObject(t, 200, 200),
+	}
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R"), 0644))
+}
+
+// readPDFImageColors counts the image XObjects of a PDF file by color space,
+// bits per component and filter, and returns the one painted the largest
+func readPDFImageColors(t *testing.T, path string) (map[string]int, *types.StreamDict) {
+	ctx, err := api.ReadContextFile(path)
+	require.NoError(t, err)
+
+	colors := map[string]int{}
+	var largest *types.StreamDict
+	err = walkPDFPages(ctx, func(_ int, image pdfXObject) error {
+		colorSpace, _ := image.sd.Dict["ColorSpace"].(types.Name)
+		filterName, _ := image.sd.Dict["Filter"].(types.Name)
+		colors[fmt.Sprintf("%s %d %s", colorSpace, *image.sd.IntEntry("BitsPerComponent"), filterName)]++
+		if image.ctm[0] == 400 {
+			largest = image.sd
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	return colors, largest
+}
+
+func TestPdfCompressor_ReduceColors(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "scan.pdf")
+	outputPath := filepath.Join(tempDir, "compressed.pdf")
+	createPDFWithScans(t, inputPath)
+
+	compressor := NewPdfCompressor()
+
+	_, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	colors, _ := readPDFImageColors(t, outputPath)
+	assert.Equal(t, map[string]int{"DeviceRGB 8 DCTDecode": 2, "DeviceRGB 8 FlateDecode": 1}, colors)
+
+	compressor.SetReduceColors(true)
+
+	_, err = compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+
+	colors, scan := readPDFImageColors(t, outputPath)
+	assert.Equal(t, map[string]int{
+		"DeviceGray 1 CCITTFaxDecode": 1,
+		"DeviceGray 8 DCTDecode":      1,
+		"DeviceRGB 8 FlateDecode":     1,
+	}, colors)
+
+	// Text is black, the 0 bit, and the paper white
+	require.NotNil(t, scan)
+	assert.Less(t, len(scan.Raw), 2000)
+	require.NoError(t, scan.Decode())
+	require.Len(t, scan.Content, 50*400)
+	assert.Equal(t, byte(0xff), scan.Content[0])
+	assert.Equal(t, byte(0x00), scan.Content[10*50+3])
+	require.NoError(t, api.ValidateFile(outputPath, nil))
+}
+
+func TestPdfBilevelThreshold(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 10, 10))
+	for i := range gray.Pix {
+		gray.Pix[i] = 230
+		if i%10 < 3 {
+			gray.Pix[i] = 30
+		}
+	}
+
+	threshold, midtones := pdfBilevelThreshold(gray)
+	assert.Greater(t, threshold, uint8(30))
+	assert.LessOrEqual(t, threshold, uint8(230))
+	assert.Zero(t, midtones)
+
+	gray.Pix[0] = 128
+	_, midtones = pdfBilevelThreshold(gray)
+	assert.InDelta(t, 0.01, midtones, 1e-9)
+}
@@ -4,21 +4,37 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/jdecool/file-compressor/internal/logger"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
-	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 )
 
 type PdfCompressor struct {
 	supportedMimeTypes []string
 	logger             *logger.Logger
+	metadataPolicy     string
+	privacy            bool
+	imageDPI           int
+	imageQuality       int
+	recompressFlate    bool
+	strip              map[string]bool
+	annotationMode     string
+	passwords          []string
+	linearize          bool
+	subsetFonts        bool
+	reduceColors       bool
 }
 
 func NewPdfCompressor() *PdfCompressor {
 	return &PdfCompressor{
 		supportedMimeTypes: []string{"application/pdf"},
 		logger:             logger.NewLogger(false),
+		metadataPolicy:     MetadataPolicyKeepAll,
+		imageQuality:       75,
+		strip:              map[string]bool{},
+		annotationMode:     AnnotationsKeep,
 	}
 }
 
@@ -31,13 +47,95 @@ func (pc *PdfCompressor) CompressFile(filePath string, outputPath string) (*Comp
 		return nil, fmt.Errorf("failed to get original file info: %v", err)
 	}
 
-	conf := model.NewDefaultConfiguration()
-	conf.Cmd = model.OPTIMIZE
-	err = api.OptimizeFile(filePath, outputPath, conf)
+	srcFile, err := os.Open(filePath)
 	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF file: %v", err)
+	}
+	defer srcFile.Close()
+
+	ctx, err := pc.readPDF(srcFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF file: %v", err)
+	}
+	if ctx == nil {
+		pc.logger.PrintfVerbose("PDF Compressor: Skipping encrypted file %s, no password opens it\n", filepath.Base(filePath))
+
+		return &CompressionResult{
+			OriginalFile:   filePath,
+			OriginalSize:   originalFileInfo.Size(),
+			CompressedSize: originalFileInfo.Size(),
+			Skipped:        SkippedEncrypted,
+		}, nil
+	}
+
+	// The output is checked against the pages of the original document
+	rendering := renderingPDFPages(ctx)
+
+	// Stripped content is removed before the optimization, which then drops
+	// the objects left unreferenced
+	removed, err := pc.stripContent(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to strip content: %v", err)
+	}
+
+	if err := api.OptimizeContext(ctx); err != nil {
 		return nil, fmt.Errorf("failed to optimize PDF file: %v", err)
 	}
 
+	fontSavings, err := pc.optimizeFonts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to optimize fonts: %v", err)
+	}
+
+	if err := pc.downsampleImages(ctx); err != nil {
+		return nil, fmt.Errorf("failed to downsample images: %v", err)
+	}
+
+	if err := pc.reduceImageColors(ctx); err != nil {
+		return nil, fmt.Errorf("failed to reduce image colors: %v", err)
+	}
+
+	if err := pc.applyMetadataPolicy(ctx); err != nil {
+		return nil, fmt.Errorf("failed to apply metadata policy: %v", err)
+	}
+
+	if pc.recompressFlate {
+		if err := pc.recompressStreams(ctx); err != nil {
+			return nil, fmt.Errorf("failed to recompress streams: %v", err)
+		}
+	}
+
+	// Linearization renumbers the objects, which encrypted strings and streams
+	// are bound to
+	linearized := pc.linearize && ctx.Encrypt == nil
+	if pc.linearize && !linearized {
+		pc.logger.PrintfVerbose("PDF Compressor: Not linearizing encrypted file %s\n", filepath.Base(filePath))
+	}
+
+	if linearized {
+		err = writeLinearizedPDF(ctx, outputPath)
+	} else {
+		err = api.WriteContextFile(ctx, outputPath)
+	}
+	if err != nil {
+		_ = os.Remove(outputPath)
+
+		return nil, fmt.Errorf("failed to write PDF file: %v", err)
+	}
+
+	// A broken output is discarded, so that it never replaces the original
+	if err := validatePDFOutput(outputPath, ctx.Configuration.UserPW, rendering); err != nil {
+		_ = os.Remove(outputPath)
+		pc.logger.PrintfVerbose("PDF Compressor: Discarding compressed file %s, validation failed: %v\n", filepath.Base(outputPath), err)
+
+		return &CompressionResult{
+			OriginalFile:   filePath,
+			OriginalSize:   originalFileInfo.Size(),
+			CompressedSize: originalFileInfo.Size(),
+			Skipped:        SkippedValidationFailed,
+		}, nil
+	}
+
 	// Get compressed file size
 	compressedFileInfo, err := os.Stat(outputPath)
 	if err != nil {
@@ -51,6 +149,9 @@ func (pc *PdfCompressor) CompressFile(filePath string, outputPath string) (*Comp
 		CompressedFile: outputPath,
 		OriginalSize:   originalFileInfo.Size(),
 		CompressedSize: compressedFileInfo.Size(),
+		Removed:        removed,
+		Linearized:     linearized,
+		FontSavings:    fontSavings,
 	}, nil
 }
 
@@ -58,6 +159,117 @@ func (pc *PdfCompressor) GetSupportedMimeTypes() []string {
 	return pc.supportedMimeTypes
 }
 
+// SetMetadataPolicy sets which metadata is carried from the original document
+// to the compressed one, see MetadataPolicies
+func (pc *PdfCompressor) SetMetadataPolicy(policy string) error {
+	policy, err := parseMetadataPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	pc.metadataPolicy = policy
+
+	return nil
+}
+
+// SetPrivacy removes the author from the document information and from the
+// XMP metadata of compressed documents
+func (pc *PdfCompressor) SetPrivacy(privacy bool) {
+	pc.privacy = privacy
+}
+
+// SetImageDPI sets the resolution images are downsampled to when they are
+// painted above it, 0 disables the downsampling
+func (pc *PdfCompressor) SetImageDPI(dpi int) error {
+	if dpi < 0 {
+		pc.imageDPI = 0
+
+		return fmt.Errorf("image DPI must be at least 0, setting to 0")
+	}
+
+	pc.imageDPI = dpi
+
+	return nil
+}
+
+// SetImageQuality sets the JPEG quality of downsampled images and of JPEG
+// images converted to grayscale
+func (pc *PdfCompressor) SetImageQuality(quality int) error {
+	if quality < 1 {
+		pc.imageQuality = 1
+
+		return fmt.Errorf("image quality must be at least 1, setting to 1")
+	}
+
+	if quality > 100 {
+		pc.imageQuality = 100
+
+		return fmt.Errorf("image quality cannot exceed 100, setting to 100")
+	}
+
+	pc.imageQuality = quality
+
+	return nil
+}
+
+// SetRecompressFlate deflates unfiltered and Flate streams again at the best
+// compression level
+func (pc *PdfCompressor) SetRecompressFlate(recompress bool) {
+	pc.recompressFlate = recompress
+}
+
+// SetSubsetFonts reduces the fully embedded TrueType and CFF fonts to the
+// glyphs painted by the document
+func (pc *PdfCompressor) SetSubsetFonts(subset bool) {
+	pc.subsetFonts = subset
+}
+
+// SetReduceColors converts the images which are effectively grayscale to
+// DeviceGray, and those which are effectively black and white, such as
+// scanned text, to 1-bit CCITT G4
+func (pc *PdfCompressor) SetReduceColors(reduce bool) {
+	pc.reduceColors = reduce
+}
+
+// SetStrip sets whether a kind of non-essential content is removed from
+// compressed documents, see PdfContentKinds
+func (pc *PdfCompressor) SetStrip(kind string, strip bool) error {
+	kind = strings.ToLower(kind)
+	if !slices.Contains(PdfContentKinds, kind) {
+		return fmt.Errorf("unknown PDF content kind %q, expected one of: %s", kind, strings.Join(PdfContentKinds, ", "))
+	}
+
+	pc.strip[kind] = strip
+
+	return nil
+}
+
+// SetAnnotationMode sets whether annotations and form fields are kept,
+// flattened into the page content or removed, see AnnotationModes
+func (pc *PdfCompressor) SetAnnotationMode(mode string) error {
+	mode = strings.ToLower(mode)
+	if !slices.Contains(AnnotationModes, mode) {
+		return fmt.Errorf("unknown annotation mode %q, expected one of: %s", mode, strings.Join(AnnotationModes, ", "))
+	}
+
+	pc.annotationMode = mode
+
+	return nil
+}
+
+// SetPasswords sets the passwords tried, as user or owner passwords, to open
+// encrypted documents
+func (pc *PdfCompressor) SetPasswords(passwords []string) {
+	pc.passwords = passwords
+}
+
+// SetLinearize writes compressed documents linearized, so that viewers can
+// display the first page before the whole file is downloaded. Encrypted
+// documents are written as is.
+func (pc *PdfCompressor) SetLinearize(linearize bool) {
+	pc.linearize = linearize
+}
+
 func (pc *PdfCompressor) SetLogger(logger *logger.Logger) {
 	pc.logger = logger
 }
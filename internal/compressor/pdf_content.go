@@ -0,0 +1,567 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfName is a name operand of a content stream, without its leading slash
+type pdfName string
+
+// maxPDFFormDepth bounds the nesting of form XObjects, which guards against
+// forms painting themselves
+const maxPDFFormDepth = 16
+
+// pdfContentScanner reads the operands and operators of a content stream.
+// Operands are float64 numbers, pdfName names, []byte strings, []any arrays,
+// map[pdfName]any dictionaries, booleans and nil.
+type pdfContentScanner struct {
+	data []byte
+	pos  int
+}
+
+// parsePDFContent calls fn for every operator of a content stream, with its
+// operands. The data of inline images is skipped, BI is reported with the
+// image dictionary as its only operand.
+func parsePDFContent(data []byte, fn func(operator string, operands []any) error) error {
+	s := &pdfContentScanner{data: data}
+
+	var operands []any
+	for {
+		value, operator, err := s.next()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case operator == "" && s.pos >= len(s.data) && value == nil:
+			return nil
+		case operator == "":
+			operands = append(operands, value)
+			continue
+		case operator == "BI":
+			dict, err := s.inlineImage()
+			if err != nil {
+				return err
+			}
+			operands = []any{dict}
+		}
+
+		if err := fn(operator, operands); err != nil {
+			return err
+		}
+		operands = operands[:0]
+	}
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+// skipSpace skips the whitespaces and the comments
+func (s *pdfContentScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; {
+		case isPDFWhitespace(c):
+			s.pos++
+		case c == '%':
+			for s.pos < len(s.data) && s.data[s.pos] != '\n' && s.data[s.pos] != '\r' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// regular returns the run of regular characters at the current position
+func (s *pdfContentScanner) regular() string {
+	start := s.pos
+	for s.pos < len(s.data) && !isPDFWhitespace(s.data[s.pos]) && !isPDFDelimiter(s.data[s.pos]) {
+		s.pos++
+	}
+
+	return string(s.data[start:s.pos])
+}
+
+// next returns the next operand, or the next operator keyword. Both are
+// empty at the end of the data.
+func (s *pdfContentScanner) next() (any, string, error) {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return nil, "", nil
+	}
+
+	switch c := s.data[s.pos]; c {
+	case '(':
+		value, err := s.literalString()
+		return value, "", err
+	case '<':
+		if s.pos+1 < len(s.data) && s.data[s.pos+1] == '<' {
+			value, err := s.dictionary()
+			return value, "", err
+		}
+		value, err := s.hexString()
+		return value, "", err
+	case '[':
+		s.pos++
+		var array []any
+		for {
+			s.skipSpace()
+			if s.pos >= len(s.data) {
+				return nil, "", fmt.Errorf("unterminated array")
+			}
+			if s.data[s.pos] == ']' {
+				s.pos++
+				return array, "", nil
+			}
+
+			value, operator, err := s.next()
+			if err != nil {
+				return nil, "", err
+			}
+			if operator != "" {
+				return nil, "", fmt.Errorf("unexpected operator %s in array", operator)
+			}
+			array = append(array, value)
+		}
+	case '/':
+		s.pos++
+		return pdfName(decodePDFName(s.regular())), "", nil
+	case ']', '>', ')', '{', '}':
+		// Stray delimiters are skipped, as viewers do
+		s.pos++
+		return s.next()
+	}
+
+	token := s.regular()
+	switch token {
+	case "true":
+		return true, "", nil
+	case "false":
+		return false, "", nil
+	case "null":
+		return nil, "", nil
+	}
+
+	if c := token[0]; c == '+' || c == '-' || c == '.' || c >= '0' && c <= '9' {
+		if number, err := strconv.ParseFloat(token, 64); err == nil {
+			return number, "", nil
+		}
+	}
+
+	return nil, token, nil
+}
+
+// decodePDFName decodes the #xx escapes of a name
+func decodePDFName(name string) string {
+	if !bytes.ContainsRune([]byte(name), '#') {
+		return name
+	}
+
+	var decoded []byte
+	for i := 0; i < len(name); i++ {
+		if name[i] == '#' && i+2 < len(name) {
+			if b, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				decoded = append(decoded, byte(b))
+				i += 2
+				continue
+			}
+		}
+		decoded = append(decoded, name[i])
+	}
+
+	return string(decoded)
+}
+
+func (s *pdfContentScanner) literalString() ([]byte, error) {
+	s.pos++
+
+	var value []byte
+	depth := 1
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		s.pos++
+
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return value, nil
+			}
+		case '\\':
+			if s.pos >= len(s.data) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			c = s.data[s.pos]
+			s.pos++
+
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation
+				if c == '\r' && s.pos < len(s.data) && s.data[s.pos] == '\n' {
+					s.pos++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					octal := int(c - '0')
+					for i := 0; i < 2 && s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '7'; i++ {
+						octal = octal*8 + int(s.data[s.pos]-'0')
+						s.pos++
+					}
+					c = byte(octal)
+				}
+			}
+		}
+
+		value = append(value, c)
+	}
+
+	return nil, fmt.Errorf("unterminated string")
+}
+
+func (s *pdfContentScanner) hexString() ([]byte, error) {
+	end := bytes.IndexByte(s.data[s.pos:], '>')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated hex string")
+	}
+
+	var digits []byte
+	for _, c := range s.data[s.pos+1 : s.pos+end] {
+		if !isPDFWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 != 0 {
+		digits = append(digits, '0')
+	}
+	s.pos += end + 1
+
+	value := make([]byte, len(digits)/2)
+	for i := range value {
+		b, err := strconv.ParseUint(string(digits[i*2:i*2+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex string")
+		}
+		value[i] = byte(b)
+	}
+
+	return value, nil
+}
+
+func (s *pdfContentScanner) dictionary() (map[pdfName]any, error) {
+	s.pos += 2
+
+	dict := map[pdfName]any{}
+	for {
+		s.skipSpace()
+		if s.pos+1 < len(s.data) && s.data[s.pos] == '>' && s.data[s.pos+1] == '>' {
+			s.pos += 2
+			return dict, nil
+		}
+
+		key, _, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, fmt.Errorf("invalid dictionary key")
+		}
+
+		value, operator, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		if operator != "" {
+			return nil, fmt.Errorf("unexpected operator %s in dictionary", operator)
+		}
+		dict[name] = value
+	}
+}
+
+// inlineImage reads the dictionary of an inline image and skips its data
+func (s *pdfContentScanner) inlineImage() (map[pdfName]any, error) {
+	dict := map[pdfName]any{}
+	for {
+		key, operator, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		if operator == "ID" {
+			break
+		}
+		if operator != "" || key == nil {
+			return nil, fmt.Errorf("invalid inline image")
+		}
+
+		value, _, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := key.(pdfName); ok {
+			dict[name] = value
+		}
+	}
+
+	// A single whitespace separates ID from the data, which ends with EI
+	// surrounded by whitespaces
+	s.pos++
+	for i := s.pos; i+1 < len(s.data); i++ {
+		if s.data[i] == 'E' && s.data[i+1] == 'I' && isPDFWhitespace(s.data[i-1]) &&
+			(i+2 == len(s.data) || isPDFWhitespace(s.data[i+2])) {
+			s.pos = i + 2
+			return dict, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unterminated inline image")
+}
+
+// pdfMatrix is an affine transformation [a b c d e f] of PDF coordinates
+type pdfMatrix [6]float64
+
+var identityPDFMatrix = pdfMatrix{1, 0, 0, 1, 0, 0}
+
+// multiply returns the transformation applying m, then n
+func (m pdfMatrix) multiply(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// scale returns the lengths the unit vectors of x and y are transformed to
+func (m pdfMatrix) scale() (float64, float64) {
+	return math.Hypot(m[0], m[1]), math.Hypot(m[2], m[3])
+}
+
+// apply transforms a point
+func (m pdfMatrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// pdfMatrixOperand converts an array operand to a matrix
+func pdfMatrixOperand(operands []any) (pdfMatrix, bool) {
+	if len(operands) != 6 {
+		return pdfMatrix{}, false
+	}
+
+	var m pdfMatrix
+	for i, operand := range operands {
+		number, ok := operand.(float64)
+		if !ok {
+			return pdfMatrix{}, false
+		}
+		m[i] = number
+	}
+
+	return m, true
+}
+
+// pdfPageContent returns the content of a page, its content streams being
+// joined by a newline so that tokens cannot merge across them
+func pdfPageContent(ctx *model.Context, page types.Dict) ([]byte, error) {
+	o, err := ctx.Dereference(page["Contents"])
+	if err != nil || o == nil {
+		return nil, err
+	}
+
+	streams := []types.Object{o}
+	if array, ok := o.(types.Array); ok {
+		streams = array
+	}
+
+	var content []byte
+	for _, stream := range streams {
+		sd, _, err := ctx.DereferenceStreamDict(stream)
+		if err != nil {
+			return nil, err
+		}
+		if sd == nil {
+			continue
+		}
+		if err := sd.Decode(); err != nil {
+			return nil, err
+		}
+		content = append(content, sd.Content...)
+		content = append(content, '\n')
+	}
+
+	return content, nil
+}
+
+// pdfXObject is an XObject painted by a content stream
+type pdfXObject struct {
+	ref types.IndirectRef
+	sd  *types.StreamDict
+	// ctm maps the unit square of images, or the form space, to the page
+	ctm pdfMatrix
+}
+
+// walkPDFPages calls fn for every image XObject painted on the pages of a
+// document, including the images painted by form XObjects
+func walkPDFPages(ctx *model.Context, fn func(pageNr int, image pdfXObject) error) error {
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		err := walkPDFPage(ctx, pageNr, func(image pdfXObject) error {
+			return fn(pageNr, image)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkPDFPage calls fn for every image XObject painted on a page
+func walkPDFPage(ctx *model.Context, pageNr int, fn func(image pdfXObject) error) error {
+	page, _, inherited, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return fmt.Errorf("failed to read page %d: %v", pageNr, err)
+	}
+
+	content, err := pdfPageContent(ctx, page)
+	if err != nil {
+		return fmt.Errorf("failed to read content of page %d: %v", pageNr, err)
+	}
+
+	if err := walkPDFContent(ctx, content, inherited.Resources, identityPDFMatrix, 0, fn); err != nil {
+		return fmt.Errorf("failed to interpret content of page %d: %v", pageNr, err)
+	}
+
+	return nil
+}
+
+// walkPDFContent interprets the transformations of a content stream and
+// calls fn for every image XObject it paints, recursing into form XObjects
+func walkPDFContent(ctx *model.Context, content []byte, resources types.Dict, ctm pdfMatrix, depth int, fn func(image pdfXObject) error) error {
+	if depth > maxPDFFormDepth {
+		return nil
+	}
+
+	var xObjects types.Dict
+	if resources != nil {
+		d, err := ctx.DereferenceDict(resources["XObject"])
+		if err != nil {
+			return err
+		}
+		xObjects = d
+	}
+
+	var stack []pdfMatrix
+	return parsePDFContent(content, func(operator string, operands []any) error {
+		switch operator {
+		case "q":
+			stack = append(stack, ctm)
+		case "Q":
+			if len(stack) > 0 {
+				ctm = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if m, ok := pdfMatrixOperand(operands); ok {
+				ctm = m.multiply(ctm)
+			}
+		case "Do":
+			if len(operands) != 1 || xObjects == nil {
+				return nil
+			}
+			name, ok := operands[0].(pdfName)
+			if !ok {
+				return nil
+			}
+
+			// Only XObjects stored as objects of their own can be rewritten
+			ref, ok := xObjects[string(name)].(types.IndirectRef)
+			if !ok {
+				return nil
+			}
+			sd, _, err := ctx.DereferenceStreamDict(ref)
+			if err != nil || sd == nil {
+				return err
+			}
+
+			switch subtype := sd.Subtype(); {
+			case subtype != nil && *subtype == "Image":
+				return fn(pdfXObject{ref: ref, sd: sd, ctm: ctm})
+			case subtype != nil && *subtype == "Form":
+				formCTM := ctm
+				if array := sd.ArrayEntry("Matrix"); array != nil {
+					if m, ok := pdfMatrixArray(array); ok {
+						formCTM = m.multiply(ctm)
+					}
+				}
+
+				formResources := resources
+				if d, err := ctx.DereferenceDict(sd.Dict["Resources"]); err == nil && d != nil {
+					formResources = d
+				}
+
+				if err := sd.Decode(); err != nil {
+					return err
+				}
+
+				return walkPDFContent(ctx, sd.Content, formResources, formCTM, depth+1, fn)
+			}
+		}
+
+		return nil
+	})
+}
+
+// pdfMatrixArray converts a matrix array of a PDF object
+func pdfMatrixArray(array types.Array) (pdfMatrix, bool) {
+	if len(array) != 6 {
+		return pdfMatrix{}, false
+	}
+
+	var m pdfMatrix
+	for i, o := range array {
+		n, ok := pdfNumber(o)
+		if !ok {
+			return pdfMatrix{}, false
+		}
+		m[i] = n
+	}
+
+	return m, true
+}
+
+// pdfNumber converts an integer or real PDF object
+func pdfNumber(o types.Object) (float64, bool) {
+	switch n := o.(type) {
+	case types.Integer:
+		return float64(n), true
+	case types.Float:
+		return float64(n), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package compressor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePDFContent(t *testing.T) {
+	content := "% comment\nq 1 0 0 1 -2.5 .5 cm /F#31 12 Tf (a\\(b\\)\\101\\\nc (nested)) Tj <48 65 6c6c 6f> Tj\n" +
+		"[(x) -120 (y)] TJ /Span <</MCID 3 /Alt (z)>> BDC EMC\n" +
+		"BI /W 2 /H 1 /CS /G /BPC 8 ID \x01EI EI Q\n"
+
+	type op struct {
+		operator string
+		operands []any
+	}
+	var ops []op
+	err := parsePDFContent([]byte(content), func(operator string, operands []any) error {
+		ops = append(ops, op{operator, append([]any(nil), operands...)})
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []op{
+		{"q", nil},
+		{"cm", []any{1.0, 0.0, 0.0, 1.0, -2.5, 0.5}},
+		{"Tf", []any{pdfName("F1"), 12.0}},
+		{"Tj", []any{[]byte("a(b)Ac (nested)")}},
+		{"Tj", []any{[]byte("Hello")}},
+		{"TJ", []any{[]any{[]byte("x"), -120.0, []byte("y")}}},
+		{"BDC", []any{pdfName("Span"), map[pdfName]any{"MCID": 3.0, "Alt": []byte("z")}}},
+		{"EMC", nil},
+		{"BI", []any{map[pdfName]any{"W": 2.0, "H": 1.0, "CS": pdfName("G"), "BPC": 8.0}}},
+		{"Q", nil},
+	}, ops)
+
+	err = parsePDFContent([]byte("(unterminated Tj"), func(string, []any) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestPDFMatrix(t *testing.T) {
+	scale := pdfMatrix{2, 0, 0, 3, 0, 0}
+	translate := pdfMatrix{1, 0, 0, 1, 10, 20}
+
+	// Scaling, then translating
+	assert.Equal(t, pdfMatrix{2, 0, 0, 3, 10, 20}, scale.multiply(translate))
+	assert.Equal(t, pdfMatrix{2, 0, 0, 3, 20, 60}, translate.multiply(scale))
+
+	width, height := pdfMatrix{0, 4, -5, 0, 0, 0}.scale()
+	assert.Equal(t, 4.0, width)
+	assert.Equal(t, 5.0, height)
+}
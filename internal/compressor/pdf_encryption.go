@@ -0,0 +1,71 @@
+package compressor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// readPDF reads and validates a document. Encrypted documents are tried
+// without password, then with every password in turn, as a user or owner
+// password. It returns a nil context when no password opens the document.
+// The decryption key is kept in the context, so the document is written back
+// encrypted with the same algorithm, permissions and passwords.
+func (pc *PdfCompressor) readPDF(rs io.ReadSeeker) (*model.Context, error) {
+	candidates := append([]string{""}, pc.passwords...)
+	for i, password := range candidates {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		conf := model.NewDefaultConfiguration()
+		conf.Cmd = model.OPTIMIZE
+		conf.UserPW = password
+		conf.OwnerPW = password
+
+		ctx, err := api.ReadAndValidate(rs, conf)
+		if err == nil {
+			if i > 0 {
+				pc.logger.PrintfVerbose("PDF Compressor: Opened encrypted file with password %d\n", i)
+			}
+
+			return ctx, nil
+		}
+		if !errors.Is(err, pdfcpu.ErrWrongPassword) {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// ReadPasswordFile reads the passwords of a file, one per line. Empty lines
+// are ignored, other whitespace is part of the passwords.
+func ReadPasswordFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open password file: %v", err)
+	}
+	defer file.Close()
+
+	var passwords []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		password := strings.TrimSuffix(scanner.Text(), "\r")
+		if password != "" {
+			passwords = append(passwords, password)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read password file: %v", err)
+	}
+
+	return passwords, nil
+}
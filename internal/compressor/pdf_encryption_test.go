@@ -0,0 +1,107 @@
+package compressor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createEncryptedPDF writes a PDF encrypted with the user password "user",
+// the owner password "owner" and printing as only permission
+func createEncryptedPDF(t *testing.T, path string, aes bool, keyLength int) {
+	plainPath := filepath.Join(filepath.Dir(path), "plain.pdf")
+	createPDFWithMetadata(t, plainPath)
+
+	conf := model.NewRC4Configuration("user", "owner", keyLength)
+	if aes {
+		conf = model.NewAESConfiguration("user", "owner", keyLength)
+	}
+	conf.Permissions = model.PermissionPrintRev2
+
+	require.NoError(t, api.EncryptFile(plainPath, path, conf))
+}
+
+// readEncryptedPDF returns the encryption of a PDF file opened with a password
+func readEncryptedPDF(t *testing.T, path, password string) *model.Enc {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW = password
+	conf.OwnerPW = password
+	ctx, err := api.ReadContext(file, conf)
+	require.NoError(t, err)
+	require.NotNil(t, ctx.E)
+
+	return ctx.E
+}
+
+func TestPdfCompressor_EncryptedPDF(t *testing.T) {
+	tests := []struct {
+		name       string
+		aes        bool
+		keyLength  int
+		passwords  []string
+		expectSkip bool
+	}{
+		{name: "No password", aes: true, keyLength: 256, expectSkip: true},
+		{name: "Wrong password", aes: true, keyLength: 256, passwords: []string{"guess"}, expectSkip: true},
+		{name: "User password AES-256", aes: true, keyLength: 256, passwords: []string{"guess", "user"}},
+		{name: "Owner password AES-128", aes: true, keyLength: 128, passwords: []string{"owner"}},
+		{name: "User password RC4-128", keyLength: 128, passwords: []string{"user"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "encrypted.pdf")
+			outputPath := filepath.Join(tempDir, "compressed.pdf")
+			createEncryptedPDF(t, inputPath, tt.aes, tt.keyLength)
+
+			compressor := NewPdfCompressor()
+			compressor.SetPasswords(tt.passwords)
+
+			result, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			if tt.expectSkip {
+				assert.Equal(t, SkippedEncrypted, result.Skipped)
+				assert.Equal(t, result.OriginalSize, result.CompressedSize)
+				assert.NoFileExists(t, outputPath)
+				return
+			}
+
+			assert.Empty(t, result.Skipped)
+
+			_, err = api.ReadContextFile(outputPath)
+			assert.Error(t, err, "output must stay encrypted")
+
+			original := readEncryptedPDF(t, inputPath, "user")
+			for _, password := range []string{"user", "owner"} {
+				compressed := readEncryptedPDF(t, outputPath, password)
+				assert.Equal(t, original.V, compressed.V)
+				assert.Equal(t, original.R, compressed.R)
+				assert.Equal(t, original.L, compressed.L)
+				assert.Equal(t, original.P, compressed.P)
+			}
+		})
+	}
+}
+
+func TestReadPasswordFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwords.txt")
+	require.NoError(t, os.WriteFile(path, []byte("first\r\n\n pass with spaces \nlast"), 0600))
+
+	passwords, err := ReadPasswordFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", " pass with spaces ", "last"}, passwords)
+
+	_, err = ReadPasswordFile(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
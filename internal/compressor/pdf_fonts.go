@@ -0,0 +1,724 @@
+package compressor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+
+	"github.com/pdfcpu/pdfcpu/pkg/filter"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// pdfFontFileKeys lists the font descriptor entries embedding font programs
+var pdfFontFileKeys = []string{"FontFile", "FontFile2", "FontFile3"}
+
+// optimizeFonts deduplicates identical font programs and, when enabled,
+// subsets the fully embedded TrueType and CFF ones to the glyphs painted by
+// the document. It returns the number of bytes saved on fonts.
+func (pc *PdfCompressor) optimizeFonts(ctx *model.Context) (int64, error) {
+	saved, err := pc.deduplicateFontPrograms(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if pc.subsetFonts {
+		subsetSaved, err := pc.subsetFontPrograms(ctx)
+		if err != nil {
+			return 0, err
+		}
+		saved += subsetSaved
+	}
+
+	pc.logger.PrintfVerbose("PDF Compressor: Fonts saved %s\n", formatSize(saved))
+
+	return saved, nil
+}
+
+// sortedObjectNumbers returns the object numbers of the table in order
+func sortedObjectNumbers(ctx *model.Context) []int {
+	return slices.Sorted(maps.Keys(ctx.Table))
+}
+
+// deduplicateFontPrograms points the font descriptors embedding the same
+// font program to a single copy of it, the others being dropped when the
+// document is written
+func (pc *PdfCompressor) deduplicateFontPrograms(ctx *model.Context) (int64, error) {
+	originals := map[[sha256.Size]byte]int{}
+	duplicates := map[int]int{}
+	var saved int64
+	for _, objNr := range sortedObjectNumbers(ctx) {
+		entry := ctx.Table[objNr]
+		if entry == nil || entry.Free {
+			continue
+		}
+
+		descriptor, ok := entry.Object.(types.Dict)
+		if !ok || descriptor.Type() == nil || *descriptor.Type() != "FontDescriptor" {
+			continue
+		}
+
+		for _, key := range pdfFontFileKeys {
+			ref, ok := descriptor[key].(types.IndirectRef)
+			if !ok {
+				continue
+			}
+
+			programNr := ref.ObjectNumber.Value()
+			if original, ok := duplicates[programNr]; ok {
+				descriptor[key] = *types.NewIndirectRef(original, 0)
+				continue
+			}
+
+			sd, _, err := ctx.DereferenceStreamDict(ref)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read font program %d: %v", programNr, err)
+			}
+			if sd == nil {
+				continue
+			}
+			if err := sd.Decode(); err != nil {
+				pc.logger.PrintfVerbose("PDF Compressor: Skipping font program %d: %v\n", programNr, err)
+				continue
+			}
+
+			hash := sha256.New()
+			fmt.Fprintf(hash, "%s %v %v %v %v\n", key, sd.Dict["Subtype"], sd.Dict["Length1"], sd.Dict["Length2"], sd.Dict["Length3"])
+			hash.Write(sd.Content)
+			sum := [sha256.Size]byte(hash.Sum(nil))
+
+			original, ok := originals[sum]
+			if !ok {
+				originals[sum] = programNr
+				continue
+			}
+			if original != programNr {
+				duplicates[programNr] = original
+				descriptor[key] = *types.NewIndirectRef(original, 0)
+				saved += int64(len(sd.Raw))
+			}
+		}
+	}
+
+	if len(duplicates) > 0 {
+		pc.logger.PrintfVerbose("PDF Compressor: Removed %s\n", countLabel(len(duplicates), "duplicate font program"))
+	}
+
+	return saved, nil
+}
+
+// pdfFont is a font dictionary embedding a TrueType or CFF font program
+type pdfFont struct {
+	objNr int
+	dict  types.Dict
+	// descendant is the CIDFont of Type0 fonts
+	descendant types.Dict
+	descriptor types.Dict
+	programKey string
+}
+
+// embeddedPDFFonts returns the fonts embedding a TrueType or CFF font
+// program, by program object number
+func embeddedPDFFonts(ctx *model.Context) (map[int][]pdfFont, error) {
+	fonts := map[int][]pdfFont{}
+	for _, objNr := range sortedObjectNumbers(ctx) {
+		entry := ctx.Table[objNr]
+		if entry == nil || entry.Free {
+			continue
+		}
+
+		d, ok := entry.Object.(types.Dict)
+		if !ok || d.Type() == nil || *d.Type() != "Font" {
+			continue
+		}
+
+		font := pdfFont{objNr: objNr, dict: d}
+		switch subtype := d.Subtype(); {
+		case subtype == nil || *subtype == "CIDFontType0" || *subtype == "CIDFontType2":
+			// Descendants are handled with their Type0 font
+			continue
+		case *subtype == "Type0":
+			descendants, err := ctx.DereferenceArray(d["DescendantFonts"])
+			if err != nil || len(descendants) != 1 {
+				continue
+			}
+			if font.descendant, err = ctx.DereferenceDict(descendants[0]); err != nil || font.descendant == nil {
+				continue
+			}
+			if font.descriptor, err = ctx.DereferenceDict(font.descendant["FontDescriptor"]); err != nil {
+				return nil, err
+			}
+		default:
+			descriptor, err := ctx.DereferenceDict(d["FontDescriptor"])
+			if err != nil {
+				return nil, err
+			}
+			font.descriptor = descriptor
+		}
+
+		for _, key := range []string{"FontFile2", "FontFile3"} {
+			if ref, ok := font.descriptor[key].(types.IndirectRef); ok {
+				font.programKey = key
+				fonts[ref.ObjectNumber.Value()] = append(fonts[ref.ObjectNumber.Value()], font)
+			}
+		}
+	}
+
+	return fonts, nil
+}
+
+// name returns the PostScript name of a font
+func (font pdfFont) name() string {
+	d := font.dict
+	if font.descendant != nil {
+		d = font.descendant
+	}
+	if name := d.NameEntry("BaseFont"); name != nil {
+		return *name
+	}
+
+	return ""
+}
+
+// hasPDFSubsetTag reports whether a font name starts with the six uppercase
+// letters and the plus sign marking subset fonts
+func hasPDFSubsetTag(name string) bool {
+	if len(name) < 7 || name[6] != '+' {
+		return false
+	}
+	for _, c := range name[:6] {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pdfSubsetTag returns the tag of a subset, derived from its glyphs
+func pdfSubsetTag(gids map[uint16]bool) string {
+	hash := fnv.New64a()
+	for _, gid := range slices.Sorted(maps.Keys(gids)) {
+		hash.Write([]byte{byte(gid >> 8), byte(gid)})
+	}
+
+	sum := hash.Sum64()
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + byte(sum%26)
+		sum /= 26
+	}
+
+	return string(tag)
+}
+
+// tagPDFFontName prefixes the name entry of a dictionary with a subset tag
+func tagPDFFontName(d types.Dict, key, tag string) {
+	if name := d.NameEntry(key); name != nil && !hasPDFSubsetTag(*name) {
+		d[key] = types.Name(tag + "+" + *name)
+	}
+}
+
+// subsetFontPrograms subsets the font programs whose painted glyphs are
+// known, that is the programs of fonts only used by content streams
+func (pc *PdfCompressor) subsetFontPrograms(ctx *model.Context) (int64, error) {
+	usage, err := collectPDFGlyphUsage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	fonts, err := embeddedPDFFonts(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read fonts: %v", err)
+	}
+
+	var saved int64
+	for _, programNr := range slices.Sorted(maps.Keys(fonts)) {
+		programSaved, err := pc.subsetFontProgram(ctx, programNr, fonts[programNr], usage)
+		if err != nil {
+			return 0, fmt.Errorf("failed to subset font program %d: %v", programNr, err)
+		}
+		saved += programSaved
+	}
+
+	return saved, nil
+}
+
+// subsetFontProgram subsets a font program to the glyphs painted through its
+// fonts, keeping it when they cannot be determined
+func (pc *PdfCompressor) subsetFontProgram(ctx *model.Context, programNr int, fonts []pdfFont, usage *pdfGlyphUsage) (int64, error) {
+	for _, font := range fonts {
+		if hasPDFSubsetTag(font.name()) || usage.unknown[font.objNr] || font.programKey != fonts[0].programKey {
+			return 0, nil
+		}
+	}
+
+	entry, found := ctx.Find(programNr)
+	if !found {
+		return 0, nil
+	}
+	sd, ok := entry.Object.(types.StreamDict)
+	if !ok {
+		return 0, nil
+	}
+	if err := sd.Decode(); err != nil {
+		pc.logger.PrintfVerbose("PDF Compressor: Skipping font program %d: %v\n", programNr, err)
+		return 0, nil
+	}
+
+	var program interface {
+		subset(gids map[uint16]bool) []byte
+	}
+	var numGlyphs int
+	gids := map[uint16]bool{0: true}
+	switch subtype := sd.Dict.NameEntry("Subtype"); {
+	case fonts[0].programKey == "FontFile2":
+		tt, err := parseTrueType(sd.Content)
+		if err != nil {
+			pc.logger.PrintfVerbose("PDF Compressor: Skipping font program %d: %v\n", programNr, err)
+			return 0, nil
+		}
+		for _, font := range fonts {
+			if !font.trueTypeGlyphs(ctx, tt, usage.codes[font.objNr], gids) {
+				return 0, nil
+			}
+		}
+		program, numGlyphs = tt, tt.numGlyphs
+	case subtype != nil && (*subtype == "Type1C" || *subtype == "CIDFontType0C"):
+		cff, err := parseCFF(sd.Content)
+		if err != nil {
+			pc.logger.PrintfVerbose("PDF Compressor: Skipping font program %d: %v\n", programNr, err)
+			return 0, nil
+		}
+		for _, font := range fonts {
+			if !font.cffGlyphs(cff, usage.codes[font.objNr], gids) {
+				return 0, nil
+			}
+		}
+		// Accented glyphs made of other glyphs by name cannot be followed
+		if !cff.isCID() {
+			for gid := range gids {
+				if int(gid) < len(cff.charStrings) && cff.usesSeac(int(gid)) {
+					return 0, nil
+				}
+			}
+		}
+		program, numGlyphs = cff, len(cff.charStrings)
+	default:
+		return 0, nil
+	}
+
+	subset := program.subset(gids)
+	data, err := deflatePDFStream(subset)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) >= len(sd.Raw) {
+		return 0, nil
+	}
+
+	saved := int64(len(sd.Raw) - len(data))
+	length := int64(len(data))
+	sd.Raw = data
+	sd.Content = subset
+	sd.StreamLength = &length
+	sd.FilterPipeline = []types.PDFFilter{{Name: filter.Flate}}
+	sd.Update("Length", types.Integer(length))
+	sd.Update("Filter", types.Name(filter.Flate))
+	sd.Delete("DecodeParms")
+	if fonts[0].programKey == "FontFile2" {
+		sd.Update("Length1", types.Integer(len(subset)))
+	}
+	entry.Object = sd
+
+	tag := pdfSubsetTag(gids)
+	for _, font := range fonts {
+		tagPDFFontName(font.dict, "BaseFont", tag)
+		if font.descendant != nil {
+			tagPDFFontName(font.descendant, "BaseFont", tag)
+		}
+		tagPDFFontName(font.descriptor, "FontName", tag)
+	}
+
+	pc.logger.PrintfVerbose("PDF Compressor: Subset font %s to %d of %d glyphs\n", fonts[0].name(), len(gids), numGlyphs)
+
+	return saved, nil
+}
+
+// identityCIDs reports whether a Type0 font uses its 2-byte codes as CIDs
+func (font pdfFont) identityCIDs() bool {
+	encoding := font.dict.NameEntry("Encoding")
+
+	return encoding != nil && (*encoding == "Identity-H" || *encoding == "Identity-V")
+}
+
+// trueTypeGlyphs adds the glyphs of a TrueType program painted by codes of
+// a font, and reports whether they could be determined
+func (font pdfFont) trueTypeGlyphs(ctx *model.Context, tt *trueTypeFont, codes map[uint16]bool, gids map[uint16]bool) bool {
+	if font.descendant != nil {
+		if !font.identityCIDs() {
+			return false
+		}
+
+		// CIDs map to glyphs through a 2-byte big-endian table, or identically
+		cidToGID, err := ctx.Dereference(font.descendant["CIDToGIDMap"])
+		if err != nil {
+			return false
+		}
+		var table []byte
+		if sd, ok := cidToGID.(types.StreamDict); ok {
+			if err := sd.Decode(); err != nil {
+				return false
+			}
+			table = sd.Content
+		} else if name, ok := cidToGID.(types.Name); cidToGID != nil && (!ok || name != "Identity") {
+			return false
+		}
+
+		for cid := range codes {
+			switch {
+			case table == nil:
+				gids[cid] = true
+			case 2*int(cid)+2 <= len(table):
+				gids[uint16(table[2*cid])<<8|uint16(table[2*cid+1])] = true
+			}
+		}
+
+		return true
+	}
+
+	if font.dict.NameEntry("Subtype") == nil || *font.dict.NameEntry("Subtype") != "TrueType" {
+		return false
+	}
+
+	toUnicode, ok := pdfSimpleEncoding(ctx, font.dict["Encoding"])
+	symbolic, mac, unicode := tt.cmap(3, 0), tt.cmap(1, 0), tt.cmap(3, 1)
+	for code := range codes {
+		add := func(mapping map[uint32]uint16, c uint32) {
+			if gid, ok := mapping[c]; ok {
+				gids[gid] = true
+			}
+		}
+
+		// Viewers look up symbolic fonts in the (3,0) subtable, within the
+		// private use area, and others in the (3,1) or (1,0) subtables
+		for _, c := range []uint32{uint32(code), 0xf000 | uint32(code), 0xf100 | uint32(code), 0xf200 | uint32(code)} {
+			add(symbolic, c)
+		}
+		add(mac, uint32(code))
+		if ok {
+			for _, r := range toUnicode(byte(code)) {
+				add(unicode, uint32(r))
+			}
+		} else if unicode != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pdfSimpleEncoding returns the characters a code of a simple font may stand
+// for, false when its encoding is not supported
+func pdfSimpleEncoding(ctx *model.Context, o types.Object) (func(code byte) []rune, bool) {
+	o, err := ctx.Dereference(o)
+	if err != nil {
+		return nil, false
+	}
+
+	name, ok := o.(types.Name)
+	if d, isDict := o.(types.Dict); isDict {
+		if d["Differences"] != nil {
+			return nil, false
+		}
+		name, ok = d["BaseEncoding"].(types.Name)
+		if d["BaseEncoding"] == nil {
+			o = nil
+		}
+	}
+
+	switch {
+	case o == nil:
+		// The standard encoding matches ASCII, but for quotes
+		return func(code byte) []rune {
+			switch {
+			case code == '\'':
+				return []rune{'\'', '’'}
+			case code == '`':
+				return []rune{'`', '‘'}
+			case code < 0x80:
+				return []rune{rune(code)}
+			}
+			return nil
+		}, true
+	case ok && name == "WinAnsiEncoding":
+		return func(code byte) []rune { return []rune{charmap.Windows1252.DecodeByte(code)} }, true
+	case ok && name == "MacRomanEncoding":
+		return func(code byte) []rune { return []rune{charmap.Macintosh.DecodeByte(code)} }, true
+	}
+
+	return nil, false
+}
+
+// cffGlyphs adds the glyphs of a CFF program painted by codes of a font, and
+// reports whether they could be determined
+func (font pdfFont) cffGlyphs(cff *cffFont, codes map[uint16]bool, gids map[uint16]bool) bool {
+	if font.descendant != nil {
+		if !font.identityCIDs() {
+			return false
+		}
+
+		cids := cff.charset()
+		for cid := range codes {
+			if gid, ok := cff.glyphForCID(cids, int(cid)); ok {
+				gids[gid] = true
+			}
+		}
+
+		return true
+	}
+
+	// Simple fonts are only supported with the encoding built into the program
+	encoding := cff.encoding()
+	if font.dict["Encoding"] != nil || encoding == nil {
+		return false
+	}
+	for code := range codes {
+		if gid, ok := encoding[byte(code)]; ok {
+			gids[gid] = true
+		}
+	}
+
+	return true
+}
+
+// pdfGlyphUsage holds the codes painted through each font, by object number
+type pdfGlyphUsage struct {
+	ctx   *model.Context
+	codes map[int]map[uint16]bool
+	// unknown holds the fonts used where their codes cannot be read
+	unknown map[int]bool
+}
+
+// collectPDFGlyphUsage reads the codes painted by the content streams of the
+// pages, of the form XObjects, patterns and appearance streams, and of Type3
+// glyphs. The fonts of the interactive form are marked unknown, as fields
+// may be filled with any character.
+func collectPDFGlyphUsage(ctx *model.Context) (*pdfGlyphUsage, error) {
+	u := &pdfGlyphUsage{ctx: ctx, codes: map[int]map[uint16]bool{}, unknown: map[int]bool{}}
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		page, _, inherited, err := ctx.PageDict(pageNr, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %v", pageNr, err)
+		}
+
+		content, err := pdfPageContent(ctx, page)
+		if err != nil {
+			u.markUnknown(inherited.Resources)
+			continue
+		}
+		u.scan(content, inherited.Resources, 0)
+	}
+
+	for _, objNr := range sortedObjectNumbers(ctx) {
+		entry := ctx.Table[objNr]
+		if entry == nil || entry.Free {
+			continue
+		}
+
+		switch o := entry.Object.(type) {
+		case types.StreamDict:
+			resources, err := ctx.DereferenceDict(o.Dict["Resources"])
+			if err != nil || resources == nil {
+				continue
+			}
+			if err := o.Decode(); err != nil {
+				u.markUnknown(resources)
+				continue
+			}
+			u.scan(o.Content, resources, 0)
+		case types.Dict:
+			if subtype := o.Subtype(); subtype == nil || *subtype != "Type3" {
+				continue
+			}
+			resources, err := ctx.DereferenceDict(o["Resources"])
+			if err != nil || resources == nil {
+				continue
+			}
+			procs, err := ctx.DereferenceDict(o["CharProcs"])
+			if err != nil {
+				continue
+			}
+			for _, proc := range procs {
+				sd, _, err := ctx.DereferenceStreamDict(proc)
+				if err != nil || sd == nil || sd.Decode() != nil {
+					u.markUnknown(resources)
+					continue
+				}
+				u.scan(sd.Content, resources, 0)
+			}
+		}
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if form, err := ctx.DereferenceDict(catalog["AcroForm"]); err == nil && form != nil {
+		if resources, err := ctx.DereferenceDict(form["DR"]); err == nil {
+			u.markUnknown(resources)
+		}
+	}
+
+	return u, nil
+}
+
+// markUnknown marks the fonts of resources unknown
+func (u *pdfGlyphUsage) markUnknown(resources types.Dict) {
+	if resources == nil {
+		return
+	}
+
+	fonts, err := u.ctx.DereferenceDict(resources["Font"])
+	if err != nil {
+		return
+	}
+	for _, o := range fonts {
+		if ref, ok := o.(types.IndirectRef); ok {
+			u.unknown[ref.ObjectNumber.Value()] = true
+		}
+	}
+}
+
+// record adds the codes of a string shown with a font
+func (u *pdfGlyphUsage) record(objNr int, text []byte) {
+	if objNr == 0 {
+		return
+	}
+
+	codes := u.codes[objNr]
+	if codes == nil {
+		codes = map[uint16]bool{}
+		u.codes[objNr] = codes
+	}
+
+	font, err := u.ctx.DereferenceDict(*types.NewIndirectRef(objNr, 0))
+	if err != nil || font == nil {
+		return
+	}
+
+	if subtype := font.Subtype(); subtype != nil && *subtype == "Type0" {
+		// 2-byte codes of the Identity encodings, others are not supported
+		if (pdfFont{dict: font}).identityCIDs() {
+			for i := 0; i+1 < len(text); i += 2 {
+				codes[uint16(text[i])<<8|uint16(text[i+1])] = true
+			}
+		} else {
+			u.unknown[objNr] = true
+		}
+		return
+	}
+
+	for _, code := range text {
+		codes[uint16(code)] = true
+	}
+}
+
+// scan reads the codes painted by a content stream, following the form
+// XObjects which share its resources
+func (u *pdfGlyphUsage) scan(content []byte, resources types.Dict, depth int) {
+	if depth > maxPDFFormDepth || resources == nil {
+		return
+	}
+
+	fonts, _ := u.ctx.DereferenceDict(resources["Font"])
+	xObjects, _ := u.ctx.DereferenceDict(resources["XObject"])
+	extGStates, _ := u.ctx.DereferenceDict(resources["ExtGState"])
+
+	font := 0
+	var stack []int
+	err := parsePDFContent(content, func(operator string, operands []any) error {
+		switch operator {
+		case "q":
+			stack = append(stack, font)
+		case "Q":
+			if len(stack) > 0 {
+				font = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "Tf":
+			font = 0
+			if len(operands) == 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					if ref, ok := fonts[string(name)].(types.IndirectRef); ok {
+						font = ref.ObjectNumber.Value()
+					}
+				}
+			}
+		case "gs":
+			if len(operands) != 1 {
+				return nil
+			}
+			name, ok := operands[0].(pdfName)
+			if !ok {
+				return nil
+			}
+			d, err := u.ctx.DereferenceDict(extGStates[string(name)])
+			if err != nil || d == nil {
+				return nil
+			}
+			if array, ok := d["Font"].(types.Array); ok && len(array) == 2 {
+				if ref, ok := array[0].(types.IndirectRef); ok {
+					font = ref.ObjectNumber.Value()
+				}
+			}
+		case "Tj", "'", "\"":
+			if len(operands) > 0 {
+				if text, ok := operands[len(operands)-1].([]byte); ok {
+					u.record(font, text)
+				}
+			}
+		case "TJ":
+			if len(operands) == 1 {
+				if array, ok := operands[0].([]any); ok {
+					for _, item := range array {
+						if text, ok := item.([]byte); ok {
+							u.record(font, text)
+						}
+					}
+				}
+			}
+		case "Do":
+			if len(operands) != 1 {
+				return nil
+			}
+			name, ok := operands[0].(pdfName)
+			if !ok {
+				return nil
+			}
+
+			// Forms with resources of their own are scanned on their own
+			sd, _, err := u.ctx.DereferenceStreamDict(xObjects[string(name)])
+			if err != nil || sd == nil || sd.Dict["Resources"] != nil {
+				return nil
+			}
+			if subtype := sd.Subtype(); subtype == nil || *subtype != "Form" {
+				return nil
+			}
+			if err := sd.Decode(); err != nil {
+				u.markUnknown(resources)
+				return nil
+			}
+			u.scan(sd.Content, resources, depth+1)
+		}
+
+		return nil
+	})
+	if err != nil {
+		u.markUnknown(resources)
+	}
+}
@@ -0,0 +1,191 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// createPDFWithFonts writes a one page PDF painting text with a WinAnsi
+// TrueType font, a TrueType font without encoding and a Type0 font, each
+// embedding its own copy of the same font program. With a form, the first
+// font is also the default font of its fields.
+func createPDFWithFonts(t *testing.T, path string, form bool) {
+	var program bytes.Buffer
+	w := zlib.NewWriter(&program)
+	_, err := w.Write(goregular.TTF)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	fontFile := fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream", program.Len(), len(goregular.TTF), program.Bytes())
+	descriptor := func(fontFile int) string {
+		return fmt.Sprintf("<< /Type /FontDescriptor /FontName /GoRegular /Flags 32 /FontBBox [0 -200 1000 900] /ItalicAngle 0 "+
+			"/Ascent 900 /Descent -200 /CapHeight 700 /StemV 80 /FontFile2 %d 0 R >>", fontFile)
+	}
+
+	catalog, annotations := "<< /Type /Catalog /Pages 2 0 R >>", ""
+	if form {
+		catalog = "<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [15 0 R] /DR << /Font << /F1 5 0 R >> >> >> >>"
+		annotations = "/Annots [15 0 R] "
+	}
+	content := "BT /F1 12 Tf 72 700 Td (Hello) Tj /F2 12 Tf (World) Tj /F3 12 Tf <0024> Tj ET"
+
+	objects := []string{
+		catalog,
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R " + annotations +
+			"/Resources << /Font << /F1 5 0 R /F2 6 0 R /F3 7 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /TrueType /BaseFont /GoRegular /Encoding /WinAnsiEncoding /FirstChar 32 /LastChar 32 /Widths [250] /FontDescriptor 8 0 R >>",
+		"<< /Type /Font /Subtype /TrueType /BaseFont /GoRegular /FirstChar 32 /LastChar 32 /Widths [250] /FontDescriptor 9 0 R >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /GoRegular /Encoding /Identity-H /DescendantFonts [10 0 R] >>",
+		descriptor(11),
+		descriptor(12),
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /GoRegular /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> " +
+			"/FontDescriptor 13 0 R /CIDToGIDMap /Identity >>",
+		fontFile,
+		fontFile,
+		descriptor(14),
+		fontFile,
+	}
+	if form {
+		objects = append(objects, "<< /Type /Annot /Subtype /Widget /FT /Tx /T (name) /DA (/F1 10 Tf 0 g) /Rect [100 100 200 120] /P 3 0 R >>")
+	}
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R"), 0644))
+}
+
+// readPDFFonts returns the names of the fonts of a PDF file and its decoded
+// TrueType font programs
+func readPDFFonts(t *testing.T, path string) ([]string, [][]byte) {
+	ctx, err := api.ReadContextFile(path)
+	require.NoError(t, err)
+
+	var names []string
+	var programs [][]byte
+	seen := map[int]bool{}
+	for _, objNr := range sortedObjectNumbers(ctx) {
+		d, ok := ctx.Table[objNr].Object.(types.Dict)
+		if !ok || d.Type() == nil {
+			continue
+		}
+
+		switch *d.Type() {
+		case "Font":
+			names = append(names, *d.NameEntry("BaseFont"))
+		case "FontDescriptor":
+			names = append(names, *d.NameEntry("FontName"))
+			ref := d.IndirectRefEntry("FontFile2")
+			require.NotNil(t, ref)
+			if seen[ref.ObjectNumber.Value()] {
+				continue
+			}
+			seen[ref.ObjectNumber.Value()] = true
+
+			sd, _, err := ctx.DereferenceStreamDict(*ref)
+			require.NoError(t, err)
+			require.NoError(t, sd.Decode())
+			programs = append(programs, sd.Content)
+		}
+	}
+
+	return names, programs
+}
+
+// hasOutline reports whether a glyph of a font program has contours
+func hasOutline(t *testing.T, program []byte, gid sfnt.GlyphIndex) bool {
+	font, err := sfnt.Parse(program)
+	require.NoError(t, err)
+
+	segments, err := font.LoadGlyph(nil, gid, fixed.I(12), nil)
+	require.NoError(t, err)
+
+	return len(segments) > 0
+}
+
+func TestPdfCompressor_OptimizeFonts(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "document.pdf")
+	outputPath := filepath.Join(tempDir, "compressed.pdf")
+	createPDFWithFonts(t, inputPath, false)
+
+	compressor := NewPdfCompressor()
+
+	result, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Positive(t, result.FontSavings)
+
+	names, programs := readPDFFonts(t, outputPath)
+	require.Len(t, programs, 1)
+	assert.True(t, bytes.Equal(goregular.TTF, programs[0]))
+	for _, name := range names {
+		assert.Equal(t, "GoRegular", name)
+	}
+
+	compressor.SetSubsetFonts(true)
+
+	subsetResult, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Greater(t, subsetResult.FontSavings, result.FontSavings)
+
+	names, programs = readPDFFonts(t, outputPath)
+	require.Len(t, programs, 1)
+	assert.Less(t, len(programs[0]), len(goregular.TTF)/2)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	require.Len(t, names, 1)
+	assert.Regexp(t, `^[A-Z]{6}\+GoRegular$`, names[0])
+
+	original, err := parseTrueType(goregular.TTF)
+	require.NoError(t, err)
+	unicode := original.cmap(3, 1)
+	for _, r := range "HeloWrd" {
+		assert.True(t, hasOutline(t, programs[0], sfnt.GlyphIndex(unicode[uint32(r)])), "glyph of %q", r)
+	}
+	assert.True(t, hasOutline(t, programs[0], 0x24), "glyph painted by the Type0 font")
+	assert.False(t, hasOutline(t, programs[0], sfnt.GlyphIndex(unicode['Z'])))
+}
+
+func TestPdfCompressor_SubsetFontsWithForm(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "form.pdf")
+	outputPath := filepath.Join(tempDir, "compressed.pdf")
+	createPDFWithFonts(t, inputPath, true)
+
+	compressor := NewPdfCompressor()
+	compressor.SetSubsetFonts(true)
+
+	_, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+
+	// Form fields may type any character with their default font
+	names, programs := readPDFFonts(t, outputPath)
+	require.Len(t, programs, 1)
+	assert.True(t, bytes.Equal(goregular.TTF, programs[0]))
+	for _, name := range names {
+		assert.False(t, strings.Contains(name, "+"), name)
+	}
+}
+
+func TestPdfSubsetTag(t *testing.T) {
+	tag := pdfSubsetTag(map[uint16]bool{1: true, 5: true})
+	assert.Regexp(t, `^[A-Z]{6}$`, tag)
+	assert.Equal(t, tag, pdfSubsetTag(map[uint16]bool{5: true, 1: true}))
+	assert.NotEqual(t, tag, pdfSubsetTag(map[uint16]bool{1: true}))
+
+	assert.True(t, hasPDFSubsetTag("ABCDEF+Font"))
+	assert.False(t, hasPDFSubsetTag("Font"))
+	assert.False(t, hasPDFSubsetTag("abcdef+Font"))
+}
@@ -0,0 +1,255 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"slices"
+
+	"github.com/disintegration/imaging"
+	"github.com/pdfcpu/pdfcpu/pkg/filter"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfImageDownsampleThreshold is how much an image resolution may exceed the
+// target resolution before it is downsampled, as in Ghostscript
+const pdfImageDownsampleThreshold = 1.5
+
+// pdfLosslessFilters are the filters decoded before an image is recompressed
+var pdfLosslessFilters = []string{filter.Flate, filter.LZW, filter.RunLength, filter.ASCII85, filter.ASCIIHex}
+
+// pdfImageUse is the largest size, in points, an image XObject is painted at
+type pdfImageUse struct {
+	width  float64
+	height float64
+}
+
+// downsampleImages downsamples the image XObjects painted above the target
+// resolution and recompresses them as JPEG. Images are rewritten in place,
+// so every page painting them gets the new version.
+func (pc *PdfCompressor) downsampleImages(ctx *model.Context) error {
+	if pc.imageDPI == 0 {
+		return nil
+	}
+
+	// An image painted several times is sized for its largest use
+	uses := map[int]*pdfImageUse{}
+	err := walkPDFPages(ctx, func(_ int, image pdfXObject) error {
+		width, height := image.ctm.scale()
+		objNr := image.ref.ObjectNumber.Value()
+		if use, exists := uses[objNr]; exists {
+			use.width = max(use.width, width)
+			use.height = max(use.height, height)
+		} else {
+			uses[objNr] = &pdfImageUse{width: width, height: height}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	objNrs := make([]int, 0, len(uses))
+	for objNr := range uses {
+		objNrs = append(objNrs, objNr)
+	}
+	slices.Sort(objNrs)
+
+	for _, objNr := range objNrs {
+		if err := pc.downsampleImage(ctx, objNr, uses[objNr]); err != nil {
+			return fmt.Errorf("failed to downsample image %d: %v", objNr, err)
+		}
+	}
+
+	return nil
+}
+
+// downsampleImage downsamples and recompresses an image XObject when its
+// resolution exceeds the target one. Images that cannot be decoded, or
+// whose recompression is not smaller, are left untouched.
+func (pc *PdfCompressor) downsampleImage(ctx *model.Context, objNr int, use *pdfImageUse) error {
+	entry := ctx.Table[objNr]
+	sd, ok := entry.Object.(types.StreamDict)
+	if !ok {
+		return nil
+	}
+
+	width, height := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if width == nil || height == nil || *width <= 0 || *height <= 0 || use.width <= 0 || use.height <= 0 {
+		return nil
+	}
+
+	// Resolution along the least dense axis, images are scaled uniformly
+	dpi := min(float64(*width)/(use.width/72), float64(*height)/(use.height/72))
+	if dpi <= float64(pc.imageDPI)*pdfImageDownsampleThreshold {
+		return nil
+	}
+
+	img, err := decodePDFImage(ctx, &sd)
+	if err != nil {
+		pc.logger.PrintfVerbose("PDF Compressor: Skipping image %d: %v\n", objNr, err)
+		return nil
+	}
+
+	scale := float64(pc.imageDPI) / dpi
+	newWidth := max(1, int(math.Round(float64(*width)*scale)))
+	newHeight := max(1, int(math.Round(float64(*height)*scale)))
+
+	var resized image.Image = imaging.Resize(img, newWidth, newHeight, imaging.Lanczos)
+	if _, gray := img.(*image.Gray); gray {
+		grayImage := image.NewGray(resized.Bounds())
+		draw.Draw(grayImage, grayImage.Bounds(), resized, image.Point{}, draw.Src)
+		resized = grayImage
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: pc.imageQuality}); err != nil {
+		return fmt.Errorf("failed to encode JPEG: %v", err)
+	}
+
+	if buf.Len() >= len(sd.Raw) {
+		pc.logger.PrintfVerbose("PDF Compressor: Keeping image %d, its recompression is not smaller\n", objNr)
+		return nil
+	}
+
+	setPDFImageStream(&sd, buf.Bytes(), newWidth, newHeight, 8, types.PDFFilter{Name: filter.DCT})
+	entry.Object = sd
+
+	pc.logger.PrintfVerbose("PDF Compressor: Downsampled image %d from %dx%d (%.0f DPI) to %dx%d\n", objNr, *width, *height, dpi, newWidth, newHeight)
+
+	return nil
+}
+
+// setPDFImageStream replaces the samples of an image XObject with data
+// encoded by a single filter
+func setPDFImageStream(sd *types.StreamDict, data []byte, width, height, bitsPerComponent int, f types.PDFFilter) {
+	length := int64(len(data))
+	sd.Raw = data
+	sd.Content = nil
+	sd.StreamLength = &length
+	sd.FilterPipeline = []types.PDFFilter{f}
+
+	sd.Update("Length", types.Integer(length))
+	sd.Update("Width", types.Integer(width))
+	sd.Update("Height", types.Integer(height))
+	sd.Update("BitsPerComponent", types.Integer(bitsPerComponent))
+	sd.Update("Filter", types.Name(f.Name))
+	if f.DecodeParms != nil {
+		sd.Update("DecodeParms", f.DecodeParms)
+	} else {
+		sd.Delete("DecodeParms")
+	}
+}
+
+// pdfImageComponents returns the number of color components of an image
+// color space that JPEG can encode as is, gray or RGB, or 0 otherwise
+func pdfImageComponents(ctx *model.Context, colorSpace types.Object) int {
+	o, err := ctx.Dereference(colorSpace)
+	if err != nil {
+		return 0
+	}
+
+	switch cs := o.(type) {
+	case types.Name:
+		switch cs {
+		case "DeviceGray", "G":
+			return 1
+		case "DeviceRGB", "RGB":
+			return 3
+		}
+	case types.Array:
+		if len(cs) < 2 {
+			return 0
+		}
+		family, _ := cs[0].(types.Name)
+		switch family {
+		case "CalGray":
+			return 1
+		case "CalRGB":
+			return 3
+		case "ICCBased":
+			sd, _, err := ctx.DereferenceStreamDict(cs[1])
+			if err != nil || sd == nil {
+				return 0
+			}
+			if n := sd.IntEntry("N"); n != nil && (*n == 1 || *n == 3) {
+				return *n
+			}
+		}
+	}
+
+	return 0
+}
+
+// decodePDFImage decodes an 8-bit gray or RGB image XObject, stored either as
+// JPEG or with lossless filters
+func decodePDFImage(ctx *model.Context, sd *types.StreamDict) (image.Image, error) {
+	if imageMask := sd.BooleanEntry("ImageMask"); imageMask != nil && *imageMask {
+		return nil, fmt.Errorf("stencil masks are not recompressed")
+	}
+	if _, ok := sd.Dict["Mask"].(types.Array); ok {
+		// Lossy compression would break the color key masking
+		return nil, fmt.Errorf("color key masked images are not recompressed")
+	}
+
+	components := pdfImageComponents(ctx, sd.Dict["ColorSpace"])
+	if components == 0 {
+		return nil, fmt.Errorf("unsupported color space")
+	}
+
+	if len(sd.FilterPipeline) == 1 && sd.FilterPipeline[0].Name == filter.DCT {
+		img, err := jpeg.Decode(bytes.NewReader(sd.Raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JPEG: %v", err)
+		}
+
+		switch img.(type) {
+		case *image.Gray:
+			if components == 1 {
+				return img, nil
+			}
+		case *image.YCbCr:
+			if components == 3 {
+				return img, nil
+			}
+		}
+
+		return nil, fmt.Errorf("unsupported JPEG color model")
+	}
+
+	for _, f := range sd.FilterPipeline {
+		if !slices.Contains(pdfLosslessFilters, f.Name) {
+			return nil, fmt.Errorf("unsupported filter %s", f.Name)
+		}
+	}
+
+	if bpc := sd.IntEntry("BitsPerComponent"); bpc == nil || *bpc != 8 {
+		return nil, fmt.Errorf("unsupported bits per component")
+	}
+
+	if err := sd.Decode(); err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	width, height := *sd.IntEntry("Width"), *sd.IntEntry("Height")
+	if len(sd.Content) < width*height*components {
+		return nil, fmt.Errorf("truncated image data")
+	}
+
+	if components == 1 {
+		return &image.Gray{Pix: sd.Content[:width*height], Stride: width, Rect: image.Rect(0, 0, width, height)}, nil
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		copy(img.Pix[i*4:i*4+3], sd.Content[i*3:i*3+3])
+		img.Pix[i*4+3] = 0xff
+	}
+
+	return img, nil
+}
@@ -0,0 +1,145 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flateImageObject returns an RGB image XObject with Flate compressed samples
+func flateImageObject(t *testing.T, width, height int) string {
+	img := createPhotoImage(width, height)
+	samples := make([]byte, 0, width*height*3)
+	for i := 0; i < len(img.Pix); i += 4 {
+		samples = append(samples, img.Pix[i:i+3]...)
+	}
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write(samples)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+		width, height, buf.Len(), buf.String())
+}
+
+// createPDFWithImages writes a one page PDF painting a 600x600 image at 300 DPI
+// and, through a form XObject, a 64x64 image at 72 DPI
+func createPDFWithImages(t *testing.T, path string) {
+	content := "q 144 0 0 144 72 600 cm /Im1 Do Q q 1 0 0 1 300 300 cm /Fm1 Do Q"
+	form := "q 64 0 0 64 0 0 cm /Im2 Do Q"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /XObject << /Im1 5 0 R /Fm1 6 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		flateImageObject(t, 600, 600),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Form /BBox [0 0 64 64] /Resources << /XObject << /Im2 7 0 R >> >> /Length %d >>\nstream\n%s\nendstream", len(form), form),
+		flateImageObject(t, 64, 64),
+	}
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R"), 0644))
+}
+
+// readPDFImages returns the width and filter of the image XObjects of a PDF file
+func readPDFImages(t *testing.T, path string) map[string][2]string {
+	ctx, err := api.ReadContextFile(path)
+	require.NoError(t, err)
+
+	images := map[string][2]string{}
+	err = walkPDFPages(ctx, func(_ int, image pdfXObject) error {
+		width := image.sd.IntEntry("Width")
+		require.NotNil(t, width)
+		filterName := ""
+		if name, ok := image.sd.Dict["Filter"].(types.Name); ok {
+			filterName = name.Value()
+		}
+		images[fmt.Sprintf("%dx%d", int(image.ctm[0]), int(image.ctm[3]))] = [2]string{fmt.Sprint(*width), filterName}
+		return nil
+	})
+	require.NoError(t, err)
+
+	return images
+}
+
+func TestPdfCompressor_DownsampleImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		dpi      int
+		expected map[string][2]string
+	}{
+		{
+			name: "Disabled",
+			dpi:  0,
+			expected: map[string][2]string{
+				"144x144": {"600", "FlateDecode"},
+				"64x64":   {"64", "FlateDecode"},
+			},
+		},
+		{
+			name: "Above threshold",
+			dpi:  150,
+			expected: map[string][2]string{
+				"144x144": {"300", "DCTDecode"},
+				"64x64":   {"64", "FlateDecode"},
+			},
+		},
+		{
+			name: "Within threshold",
+			dpi:  250,
+			expected: map[string][2]string{
+				"144x144": {"600", "FlateDecode"},
+				"64x64":   {"64", "FlateDecode"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "document.pdf")
+			outputPath := filepath.Join(tempDir, "compressed.pdf")
+			createPDFWithImages(t, inputPath)
+
+			compressor := NewPdfCompressor()
+			require.NoError(t, compressor.SetImageDPI(tt.dpi))
+
+			result, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected, readPDFImages(t, outputPath))
+			if tt.expected["144x144"][1] == "DCTDecode" {
+				assert.Less(t, result.CompressedSize, result.OriginalSize/2)
+			}
+		})
+	}
+}
+
+func TestPdfCompressor_SetImageDPI(t *testing.T) {
+	compressor := NewPdfCompressor()
+
+	assert.NoError(t, compressor.SetImageDPI(150))
+	assert.Equal(t, 150, compressor.imageDPI)
+	assert.Error(t, compressor.SetImageDPI(-1))
+	assert.Equal(t, 0, compressor.imageDPI)
+}
+
+func TestPdfCompressor_SetImageQuality(t *testing.T) {
+	compressor := NewPdfCompressor()
+
+	assert.NoError(t, compressor.SetImageQuality(60))
+	assert.Equal(t, 60, compressor.imageQuality)
+	assert.Error(t, compressor.SetImageQuality(0))
+	assert.Equal(t, 1, compressor.imageQuality)
+	assert.Error(t, compressor.SetImageQuality(101))
+	assert.Equal(t, 100, compressor.imageQuality)
+}
@@ -0,0 +1,498 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math/bits"
+	"os"
+	"slices"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// writeLinearizedPDF writes a document linearized for fast web view. The
+// objects are written uncompressed, since object streams cannot be read before
+// the whole file has been downloaded.
+func writeLinearizedPDF(ctx *model.Context, outputPath string) error {
+	ctx.WriteObjectStream = false
+	ctx.WriteXRefStream = false
+
+	var buf bytes.Buffer
+	if err := api.WriteContext(ctx, &buf); err != nil {
+		return err
+	}
+
+	data, err := linearizePDF(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to linearize: %v", err)
+	}
+
+	return os.WriteFile(outputPath, data, 0644)
+}
+
+// pdfObjectCollector lists the objects reachable from values, in the order
+// they are found
+type pdfObjectCollector struct {
+	ctx    *model.Context
+	stop   func(objNr int) bool
+	seen   map[int]bool
+	objNrs []int
+}
+
+func newPDFObjectCollector(ctx *model.Context, stop func(objNr int) bool) *pdfObjectCollector {
+	return &pdfObjectCollector{ctx: ctx, stop: stop, seen: map[int]bool{}}
+}
+
+func (c *pdfObjectCollector) add(o types.Object) {
+	switch o := o.(type) {
+	case types.IndirectRef:
+		objNr := o.ObjectNumber.Value()
+		if c.seen[objNr] || c.stop != nil && c.stop(objNr) {
+			return
+		}
+
+		entry, found := c.ctx.Find(objNr)
+		if !found || entry.Free || entry.Object == nil {
+			return
+		}
+
+		c.seen[objNr] = true
+		c.objNrs = append(c.objNrs, objNr)
+		c.add(entry.Object)
+	case types.Dict:
+		for _, key := range slices.Sorted(maps.Keys(o)) {
+			c.add(o[key])
+		}
+	case types.StreamDict:
+		c.add(o.Dict)
+	case types.Array:
+		for _, item := range o {
+			c.add(item)
+		}
+	}
+}
+
+// renumberPDFObject returns a copy of an object with its references renumbered,
+// dangling references becoming null
+func renumberPDFObject(o types.Object, numbers map[int]int) types.Object {
+	switch o := o.(type) {
+	case types.IndirectRef:
+		objNr, ok := numbers[o.ObjectNumber.Value()]
+		if !ok {
+			return nil
+		}
+		return *types.NewIndirectRef(objNr, 0)
+	case types.Dict:
+		d := types.NewDict()
+		for key, value := range o {
+			d[key] = renumberPDFObject(value, numbers)
+		}
+		return d
+	case types.StreamDict:
+		d := renumberPDFObject(o.Dict, numbers).(types.Dict)
+		// Lengths are written as direct values
+		d["Length"] = types.Integer(len(o.Raw))
+		return types.StreamDict{Dict: d, Raw: o.Raw}
+	case types.Array:
+		a := make(types.Array, len(o))
+		for i, item := range o {
+			a[i] = renumberPDFObject(item, numbers)
+		}
+		return a
+	}
+
+	return o
+}
+
+// pdfObjectBytes serializes an indirect object
+func pdfObjectBytes(objNr int, o types.Object) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d 0 obj\n", objNr)
+	if sd, ok := o.(types.StreamDict); ok {
+		buf.WriteString(sd.Dict.PDFString())
+		buf.WriteString("\nstream\n")
+		buf.Write(sd.Raw)
+		buf.WriteString("\nendstream")
+	} else if o == nil {
+		buf.WriteString("null")
+	} else {
+		buf.WriteString(o.PDFString())
+	}
+	buf.WriteString("\nendobj\n")
+
+	return buf.Bytes()
+}
+
+// pdfBitWriter packs the big-endian bit fields of hint tables
+type pdfBitWriter struct {
+	buf     bytes.Buffer
+	current byte
+	count   int
+}
+
+func (w *pdfBitWriter) write(value, size int) {
+	for i := size - 1; i >= 0; i-- {
+		w.current = w.current<<1 | byte(value>>i&1)
+		w.count++
+		if w.count == 8 {
+			w.buf.WriteByte(w.current)
+			w.current, w.count = 0, 0
+		}
+	}
+}
+
+// flush pads the last byte, hint table items start on a byte boundary
+func (w *pdfBitWriter) flush() {
+	if w.count > 0 {
+		w.write(0, 8-w.count)
+	}
+}
+
+// bitsFor returns the number of bits needed to represent a value
+func bitsFor(value int) int {
+	return bits.Len(uint(value))
+}
+
+// linearizePDF rewrites a document, written without object and cross-reference
+// streams, following ISO 32000-1 Annex F: the linearization parameters, the
+// first page cross-reference table, the catalog, the hint stream and the first
+// page come first, followed by the other pages, the objects they share and the
+// rest of the document.
+func linearizePDF(data []byte) ([]byte, error) {
+	ctx, err := api.ReadContext(bytes.NewReader(data), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Encrypt != nil {
+		return nil, fmt.Errorf("encrypted documents are not supported")
+	}
+
+	if err := ctx.EnsurePageCount(); err != nil {
+		return nil, err
+	}
+
+	if ctx.PageCount == 0 {
+		return nil, fmt.Errorf("document has no pages")
+	}
+
+	rootNr := ctx.Root.ObjectNumber.Value()
+	pageTree := map[int]bool{}
+	for objNr, entry := range ctx.Table {
+		if d, ok := entry.Object.(types.Dict); ok && d.Type() != nil && (*d.Type() == "Page" || *d.Type() == "Pages") {
+			pageTree[objNr] = true
+		}
+	}
+
+	// Objects needed by each page, its page object first, without crossing over
+	// to the catalog, the page tree or other pages
+	pages := make([][]int, ctx.PageCount)
+	for i := range pages {
+		d, ref, _, err := ctx.PageDict(i+1, false)
+		if err != nil {
+			return nil, err
+		}
+
+		pageNr := ref.ObjectNumber.Value()
+		collector := newPDFObjectCollector(ctx, func(objNr int) bool {
+			return objNr == rootNr || pageTree[objNr] && objNr != pageNr
+		})
+		collector.add(*ref)
+
+		// Inherited resources
+		for parent := d["Parent"]; d["Resources"] == nil && parent != nil; parent = d["Parent"] {
+			if d, err = ctx.DereferenceDict(parent); err != nil || d == nil {
+				break
+			}
+			collector.add(d["Resources"])
+		}
+
+		pages[i] = collector.objNrs
+	}
+
+	firstPage := pages[0]
+	inFirstPage := map[int]bool{}
+	for _, objNr := range firstPage {
+		inFirstPage[objNr] = true
+	}
+
+	usage := map[int]int{}
+	for _, objNrs := range pages[1:] {
+		for _, objNr := range objNrs {
+			if !inFirstPage[objNr] {
+				usage[objNr]++
+			}
+		}
+	}
+
+	var shared []int
+	sharedSeen := map[int]bool{}
+	ownObjects := make([][]int, len(pages))
+	for i, objNrs := range pages[1:] {
+		for _, objNr := range objNrs {
+			switch {
+			case inFirstPage[objNr]:
+			case usage[objNr] == 1:
+				ownObjects[i+1] = append(ownObjects[i+1], objNr)
+			case !sharedSeen[objNr]:
+				sharedSeen[objNr] = true
+				shared = append(shared, objNr)
+			}
+		}
+	}
+
+	// The other objects: page tree, outlines, document information...
+	assigned := map[int]bool{rootNr: true}
+	for _, objNr := range firstPage {
+		assigned[objNr] = true
+	}
+	for _, objNr := range slices.Concat(slices.Concat(ownObjects...), shared) {
+		assigned[objNr] = true
+	}
+
+	document := newPDFObjectCollector(ctx, nil)
+	document.add(*ctx.Root)
+	if ctx.Info != nil {
+		document.add(*ctx.Info)
+	}
+
+	var others []int
+	for _, objNr := range document.objNrs {
+		if !assigned[objNr] {
+			others = append(others, objNr)
+		}
+	}
+
+	// The main cross-reference table lists the objects after the first page,
+	// the first page one those before, numbered after them
+	mainSection := slices.Concat(slices.Concat(ownObjects...), shared, others)
+	numbers := map[int]int{}
+	for i, objNr := range mainSection {
+		numbers[objNr] = i + 1
+	}
+
+	linearizationNr := len(mainSection) + 1
+	numbers[rootNr] = linearizationNr + 1
+	hintNr := linearizationNr + 2
+	for i, objNr := range firstPage {
+		numbers[objNr] = hintNr + 1 + i
+	}
+	size := hintNr + 1 + len(firstPage)
+
+	serialize := func(objNrs []int) [][]byte {
+		serialized := make([][]byte, len(objNrs))
+		for i, objNr := range objNrs {
+			entry, _ := ctx.Find(objNr)
+			serialized[i] = pdfObjectBytes(numbers[objNr], renumberPDFObject(entry.Object, numbers))
+		}
+		return serialized
+	}
+
+	catalog := serialize([]int{rootNr})[0]
+	firstPageObjects := serialize(firstPage)
+	mainObjects := serialize(mainSection)
+
+	trailer := fmt.Sprintf("/Size %d /Root %d 0 R", size, numbers[rootNr])
+	if ctx.Info != nil {
+		if infoNr, ok := numbers[ctx.Info.ObjectNumber.Value()]; ok {
+			trailer += fmt.Sprintf(" /Info %d 0 R", infoNr)
+		}
+	}
+	if ctx.ID != nil {
+		trailer += " /ID " + ctx.ID.PDFString()
+	}
+
+	// Numbers whose value depends on the layout have a fixed width
+	header := fmt.Sprintf("%%PDF-%s\n%%\xe2\xe3\xcf\xd3\n", ctx.XRefTable.Version().String())
+	linearizationFormat := "%d 0 obj\n<< /Linearized 1 /L %010d /H [%010d %010d] /O %d /E %010d /N %d /T %010d >>\nendobj\n"
+	firstXRefFormat := "trailer\n<< %s /Prev %010d >>\nstartxref\n0\n%%%%EOF\n"
+	linearizationLength := len(fmt.Sprintf(linearizationFormat, linearizationNr, 0, 0, 0, numbers[firstPage[0]], 0, len(pages), 0))
+	firstXRefOffset := len(header) + linearizationLength
+	firstXRefLength := len(fmt.Sprintf("xref\n%d %d\n", linearizationNr, size-linearizationNr)) +
+		20*(size-linearizationNr) + len(fmt.Sprintf(firstXRefFormat, trailer, 0))
+
+	// Offsets in hint tables ignore the hint stream
+	hintOffset := firstXRefOffset + firstXRefLength + len(catalog)
+	offsets := map[int]int{}
+	offset := hintOffset
+	for i, objNr := range firstPage {
+		offsets[objNr] = offset
+		offset += len(firstPageObjects[i])
+	}
+	firstPageEnd := offset
+	for i, objNr := range mainSection {
+		offsets[objNr] = offset
+		offset += len(mainObjects[i])
+	}
+	mainXRefOffset := offset
+
+	objectLength := func(objNr int) int {
+		if i := slices.Index(firstPage, objNr); i >= 0 {
+			return len(firstPageObjects[i])
+		}
+		return len(mainObjects[slices.Index(mainSection, objNr)])
+	}
+
+	hint := pdfHintStream(pages, ownObjects, firstPage, shared, numbers, offsets, firstPageEnd, objectLength)
+	hintObject := pdfObjectBytes(hintNr, hint)
+
+	for objNr := range offsets {
+		offsets[objNr] += len(hintObject)
+	}
+	firstPageEnd += len(hintObject)
+	mainXRefOffset += len(hintObject)
+	mainXRefHeader := fmt.Sprintf("xref\n0 %d\n", linearizationNr)
+
+	var out bytes.Buffer
+	out.WriteString(header)
+
+	mainXRef := bytes.NewBufferString(mainXRefHeader)
+	mainXRef.WriteString("0000000000 65535 f \n")
+	for _, objNr := range mainSection {
+		fmt.Fprintf(mainXRef, "%010d 00000 n \n", offsets[objNr])
+	}
+	fmt.Fprintf(mainXRef, "trailer\n<< /Size %d >>\nstartxref\n%d\n%%%%EOF\n", linearizationNr, firstXRefOffset)
+
+	fileLength := mainXRefOffset + mainXRef.Len()
+	fmt.Fprintf(&out, linearizationFormat, linearizationNr, fileLength, hintOffset, len(hintObject),
+		numbers[firstPage[0]], firstPageEnd, len(pages), mainXRefOffset+len(mainXRefHeader)-1)
+
+	fmt.Fprintf(&out, "xref\n%d %d\n", linearizationNr, size-linearizationNr)
+	fmt.Fprintf(&out, "%010d 00000 n \n", len(header))
+	fmt.Fprintf(&out, "%010d 00000 n \n", firstXRefOffset+firstXRefLength)
+	fmt.Fprintf(&out, "%010d 00000 n \n", hintOffset)
+	for _, objNr := range firstPage {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offsets[objNr])
+	}
+	fmt.Fprintf(&out, firstXRefFormat, trailer, mainXRefOffset)
+
+	out.Write(catalog)
+	out.Write(hintObject)
+	for _, object := range firstPageObjects {
+		out.Write(object)
+	}
+	for _, object := range mainObjects {
+		out.Write(object)
+	}
+	out.Write(mainXRef.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// pdfHintStream builds the page offset and shared object hint tables. Every
+// first page object and every shared object is a group of its own, and as
+// content stream positions are not tracked, pages are given as their content.
+func pdfHintStream(pages, ownObjects [][]int, firstPage, shared []int, numbers, offsets map[int]int, firstPageEnd int, objectLength func(int) int) types.StreamDict {
+	groups := map[int]int{}
+	for i, objNr := range slices.Concat(firstPage, shared) {
+		groups[objNr] = i
+	}
+
+	objectCounts := make([]int, len(pages))
+	lengths := make([]int, len(pages))
+	references := make([][]int, len(pages))
+	objectCounts[0] = len(firstPage)
+	lengths[0] = firstPageEnd - offsets[firstPage[0]]
+	for i := 1; i < len(pages); i++ {
+		objectCounts[i] = len(ownObjects[i])
+		for _, objNr := range ownObjects[i] {
+			lengths[i] += objectLength(objNr)
+		}
+		for _, objNr := range pages[i] {
+			if group, ok := groups[objNr]; ok {
+				references[i] = append(references[i], group)
+			}
+		}
+	}
+
+	minObjects, maxObjects := slices.Min(objectCounts), slices.Max(objectCounts)
+	minLength, maxLength := slices.Min(lengths), slices.Max(lengths)
+	maxReferences := 0
+	for _, refs := range references {
+		maxReferences = max(maxReferences, len(refs))
+	}
+	objectBits := bitsFor(maxObjects - minObjects)
+	lengthBits := bitsFor(maxLength - minLength)
+	referenceBits := bitsFor(maxReferences)
+	groupBits := bitsFor(len(groups) - 1)
+
+	// Page offset hint table
+	w := &pdfBitWriter{}
+	w.write(minObjects, 32)
+	w.write(offsets[firstPage[0]], 32)
+	w.write(objectBits, 16)
+	w.write(minLength, 32)
+	w.write(lengthBits, 16)
+	w.write(0, 32)
+	w.write(0, 16)
+	w.write(minLength, 32)
+	w.write(lengthBits, 16)
+	w.write(referenceBits, 16)
+	w.write(groupBits, 16)
+	w.write(0, 16)
+	w.write(1, 16)
+
+	for _, count := range objectCounts {
+		w.write(count-minObjects, objectBits)
+	}
+	w.flush()
+	for _, length := range lengths {
+		w.write(length-minLength, lengthBits)
+	}
+	w.flush()
+	for _, refs := range references {
+		w.write(len(refs), referenceBits)
+	}
+	w.flush()
+	for _, refs := range references {
+		for _, group := range refs {
+			w.write(group, groupBits)
+		}
+	}
+	w.flush()
+	for _, length := range lengths {
+		w.write(length-minLength, lengthBits)
+	}
+	w.flush()
+
+	sharedOffset := w.buf.Len()
+
+	// Shared object hint table
+	groupLengths := make([]int, 0, len(groups))
+	for _, objNr := range slices.Concat(firstPage, shared) {
+		groupLengths = append(groupLengths, objectLength(objNr))
+	}
+	minGroupLength, maxGroupLength := slices.Min(groupLengths), slices.Max(groupLengths)
+	groupLengthBits := bitsFor(maxGroupLength - minGroupLength)
+
+	firstShared, firstSharedOffset := 0, 0
+	if len(shared) > 0 {
+		firstShared, firstSharedOffset = numbers[shared[0]], offsets[shared[0]]
+	}
+	w.write(firstShared, 32)
+	w.write(firstSharedOffset, 32)
+	w.write(len(firstPage), 32)
+	w.write(len(groupLengths), 32)
+	w.write(0, 16)
+	w.write(minGroupLength, 32)
+	w.write(groupLengthBits, 16)
+
+	for _, length := range groupLengths {
+		w.write(length-minGroupLength, groupLengthBits)
+	}
+	w.flush()
+	// No MD5 signatures
+	for range groupLengths {
+		w.write(0, 1)
+	}
+	w.flush()
+
+	d := types.NewDict()
+	d.Insert("Length", types.Integer(w.buf.Len()))
+	d.Insert("S", types.Integer(sharedOffset))
+
+	return types.StreamDict{Dict: d, Raw: w.buf.Bytes()}
+}
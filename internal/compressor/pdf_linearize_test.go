@@ -0,0 +1,128 @@
+package compressor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createPDFWithPages writes a three page PDF: a font used by every page, a font
+// shared by the last two pages and an image only painted on the last one
+func createPDFWithPages(t *testing.T, path string) {
+	contents := []string{
+		"BT /F1 24 Tf 100 700 Td (First) Tj ET",
+		"BT /F1 24 Tf 100 700 Td (Second) Tj /F2 12 Tf (page) Tj ET",
+		"BT /F2 12 Tf 100 700 Td (Third) Tj ET q 64 0 0 64 72 72 cm /Im1 Do Q",
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 /MediaBox [0 0 612 792] >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R /Resources << /Font << /F1 9 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R /Resources << /Font << /F1 9 0 R /F2 10 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 8 0 R /Resources << /Font << /F2 10 0 R >> /XObject << /Im1 11 0 R >> >> >>",
+	}
+	for _, content := range contents {
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+	objects = append(objects,
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+		flateImageObject(t, 64, 64),
+		"<< /Title (Pages) >>",
+	)
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R /Info 12 0 R"), 0644))
+}
+
+// readLinearizationParameters returns the entries of the linearization
+// parameter dictionary starting a PDF file
+func readLinearizationParameters(t *testing.T, data []byte) map[string]int {
+	match := regexp.MustCompile(`^%PDF-\d\.\d\n%\S+\n(\d+) 0 obj\n<< /Linearized 1 /L (\d+) /H \[(\d+) (\d+)\] /O (\d+) /E (\d+) /N (\d+) /T (\d+) >>`).FindSubmatch(data)
+	require.NotNil(t, match, "linearization parameters must start the file")
+
+	parameters := map[string]int{}
+	for i, key := range []string{"Nr", "L", "H", "HLength", "O", "E", "N", "T"} {
+		value, err := strconv.Atoi(string(match[i+1]))
+		require.NoError(t, err)
+		parameters[key] = value
+	}
+
+	return parameters
+}
+
+func TestPdfCompressor_Linearize(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "document.pdf")
+	outputPath := filepath.Join(tempDir, "compressed.pdf")
+	createPDFWithPages(t, inputPath)
+
+	compressor := NewPdfCompressor()
+
+	result, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.False(t, result.Linearized)
+
+	compressor.SetLinearize(true)
+
+	result, err = compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.True(t, result.Linearized)
+
+	data, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	parameters := readLinearizationParameters(t, data)
+	assert.Equal(t, len(data), parameters["L"])
+	assert.Equal(t, 3, parameters["N"])
+	assert.Equal(t, "xref", string(data[parameters["T"]-len("xref\n0 0"):][:4]))
+	assert.Regexp(t, `^\d+ 0 obj\n<</Length \d+/S \d+>>\nstream\n`, string(data[parameters["H"]:]))
+	assert.Contains(t, string(data[parameters["H"]+parameters["HLength"]:parameters["E"]]), "(First) Tj")
+	assert.NotContains(t, string(data[:parameters["E"]]), "(Second) Tj")
+
+	require.NoError(t, api.ValidateFile(outputPath, nil))
+	ctx, err := api.ReadContextFile(outputPath)
+	require.NoError(t, err)
+	assert.True(t, ctx.Read.Linearized)
+	assert.Equal(t, 3, ctx.PageCount)
+	_, ref, _, err := ctx.PageDict(1, false)
+	require.NoError(t, err)
+	assert.Equal(t, parameters["O"], ref.ObjectNumber.Value())
+
+	info, _ := readPDFMetadata(t, outputPath)
+	assert.Equal(t, "(Pages)", info["Title"])
+}
+
+func TestPdfCompressor_LinearizeEncrypted(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "encrypted.pdf")
+	outputPath := filepath.Join(tempDir, "compressed.pdf")
+	createEncryptedPDF(t, inputPath, true, 256)
+
+	compressor := NewPdfCompressor()
+	compressor.SetPasswords([]string{"user"})
+	compressor.SetLinearize(true)
+
+	result, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.False(t, result.Linearized)
+	assert.NotNil(t, readEncryptedPDF(t, outputPath, "user"))
+}
+
+func TestPDFBitWriter(t *testing.T) {
+	w := &pdfBitWriter{}
+	w.write(5, 3)
+	w.write(1, 1)
+	w.flush()
+	w.write(0x1234, 16)
+	w.flush()
+
+	assert.Equal(t, []byte{0xb0, 0x12, 0x34}, w.buf.Bytes())
+	assert.Equal(t, 0, bitsFor(0))
+	assert.Equal(t, 3, bitsFor(5))
+}
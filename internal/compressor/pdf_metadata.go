@@ -0,0 +1,118 @@
+package compressor
+
+import (
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// applyMetadataPolicy removes the document information and the XMP metadata
+// of a PDF document that the metadata policy and the privacy mode do not
+// keep. The copyright policy keeps the author of the document, its rights
+// and its output intents, strip-all removes all of them. pdfcpu always
+// writes its own producer and dates in the document information.
+func (pc *PdfCompressor) applyMetadataPolicy(ctx *model.Context) error {
+	if pc.metadataPolicy == MetadataPolicyKeepAll && !pc.privacy {
+		return nil
+	}
+
+	if ctx.Info != nil {
+		info, err := ctx.DereferenceDict(*ctx.Info)
+		if err != nil {
+			return fmt.Errorf("failed to read document information: %v", err)
+		}
+
+		for key := range info {
+			keep := pc.metadataPolicy == MetadataPolicyKeepAll ||
+				pc.metadataPolicy == MetadataPolicyKeepCopyrightAndColor && key == "Author"
+			if !keep || pc.privacy && key == "Author" {
+				delete(info, key)
+				pc.logger.PrintfVerbose("PDF Compressor: Removed document information %s\n", key)
+			}
+		}
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %v", err)
+	}
+
+	if pc.metadataPolicy == MetadataPolicyStripAll && catalog.Delete("OutputIntents") != nil {
+		pc.logger.PrintfVerbose("PDF Compressor: Removed output intents\n")
+	}
+
+	// XMP metadata streams may be attached to the catalog, and to any other
+	// object such as pages or images
+	for objNr, entry := range ctx.Table {
+		if entry.Free || entry.Object == nil {
+			continue
+		}
+
+		var d types.Dict
+		switch obj := entry.Object.(type) {
+		case types.Dict:
+			d = obj
+		case types.StreamDict:
+			d = obj.Dict
+		default:
+			continue
+		}
+
+		ref := d.IndirectRefEntry("Metadata")
+		if ref == nil {
+			continue
+		}
+
+		// Only the document level metadata holds the copyright of the document
+		isCatalog := ctx.Root != nil && objNr == ctx.Root.ObjectNumber.Value()
+		if pc.metadataPolicy == MetadataPolicyStripAll || pc.metadataPolicy == MetadataPolicyKeepCopyrightAndColor && !isCatalog {
+			d.Delete("Metadata")
+			continue
+		}
+
+		keep, err := pc.filterXMPStream(ctx, *ref)
+		if err != nil {
+			return fmt.Errorf("failed to filter XMP metadata of object %d: %v", objNr, err)
+		}
+		if !keep {
+			d.Delete("Metadata")
+		}
+	}
+
+	return nil
+}
+
+// filterXMPStream applies the metadata policy to an XMP metadata stream, and
+// reports whether it is kept at all
+func (pc *PdfCompressor) filterXMPStream(ctx *model.Context, ref types.IndirectRef) (bool, error) {
+	sd, _, err := ctx.DereferenceStreamDict(ref)
+	if err != nil {
+		return false, err
+	}
+	if sd == nil {
+		return false, nil
+	}
+
+	if err := sd.Decode(); err != nil {
+		return false, err
+	}
+
+	packet := applyXMPPolicy(sd.Content, pc.metadataPolicy, pc.privacy, pdfPrivacyXMPProperties)
+	if packet == nil {
+		return false, nil
+	}
+
+	sd.Content = packet
+	if err := sd.Encode(); err != nil {
+		return false, err
+	}
+
+	entry, found := ctx.FindTableEntryForIndRef(&ref)
+	if !found {
+		return false, fmt.Errorf("missing object %s", ref)
+	}
+	entry.Object = *sd
+
+	return true, nil
+}
@@ -0,0 +1,175 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPDFXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="Writer">
+<dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">All rights reserved</rdf:li></rdf:Alt></dc:rights>
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Report</rdf:li></rdf:Alt></dc:title>
+</rdf:Description></rdf:RDF></x:xmpmeta>
+<?xpacket end="w"?>`
+
+// buildPDF assembles a PDF file from its objects, numbered from 1, with a
+// valid cross-reference table
+func buildPDF(objects []string, trailer string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+
+	return buf.Bytes()
+}
+
+// createPDFWithMetadata writes a one page PDF with document information and XMP metadata
+func createPDFWithMetadata(t *testing.T, path string) {
+	content := "BT /F1 24 Tf 100 700 Td (Hello) Tj ET"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /Metadata 6 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(testPDFXMP), testPDFXMP),
+		"<< /Title (Report) /Author (Jane Doe) /Subject (Quarterly) >>",
+	}
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R /Info 7 0 R"), 0644))
+}
+
+// readPDFMetadata returns the document information and the XMP packet of a PDF file
+func readPDFMetadata(t *testing.T, path string) (map[string]string, string) {
+	ctx, err := api.ReadContextFile(path)
+	require.NoError(t, err)
+
+	info := map[string]string{}
+	if ctx.Info != nil {
+		d, err := ctx.DereferenceDict(*ctx.Info)
+		require.NoError(t, err)
+		for key, value := range d {
+			info[key] = value.String()
+		}
+	}
+
+	catalog, err := ctx.Catalog()
+	require.NoError(t, err)
+
+	var xmp string
+	if ref := catalog.IndirectRefEntry("Metadata"); ref != nil {
+		sd, _, err := ctx.DereferenceStreamDict(*ref)
+		require.NoError(t, err)
+		require.NoError(t, sd.Decode())
+		xmp = string(sd.Content)
+	}
+
+	return info, xmp
+}
+
+func TestPdfCompressor_MetadataPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		privacy       bool
+		expectedInfo  []string
+		removedInfo   []string
+		expectedXMP   []string
+		removedXMP    []string
+		expectMissing bool
+	}{
+		{
+			name:         "Keep all",
+			policy:       MetadataPolicyKeepAll,
+			expectedInfo: []string{"Title", "Author", "Subject"},
+			expectedXMP:  []string{"dc:creator", "dc:rights", "dc:title", "xmp:CreatorTool"},
+		},
+		{
+			name:         "Keep all with privacy",
+			policy:       MetadataPolicyKeepAll,
+			privacy:      true,
+			expectedInfo: []string{"Title", "Subject"},
+			removedInfo:  []string{"Author"},
+			expectedXMP:  []string{"dc:rights", "dc:title"},
+			removedXMP:   []string{"dc:creator", "Jane Doe"},
+		},
+		{
+			name:         "Keep copyright",
+			policy:       MetadataPolicyKeepCopyrightAndColor,
+			expectedInfo: []string{"Author"},
+			removedInfo:  []string{"Title", "Subject"},
+			expectedXMP:  []string{"dc:creator", "dc:rights"},
+			removedXMP:   []string{"dc:title", "CreatorTool"},
+		},
+		{
+			name:          "Strip all",
+			policy:        MetadataPolicyStripAll,
+			removedInfo:   []string{"Title", "Author", "Subject"},
+			expectMissing: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "document.pdf")
+			outputPath := filepath.Join(tempDir, "compressed.pdf")
+			createPDFWithMetadata(t, inputPath)
+
+			compressor := NewPdfCompressor()
+			require.NoError(t, compressor.SetMetadataPolicy(tt.policy))
+			compressor.SetPrivacy(tt.privacy)
+
+			_, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			info, xmp := readPDFMetadata(t, outputPath)
+			for _, key := range tt.expectedInfo {
+				assert.Contains(t, info, key)
+			}
+			for _, key := range tt.removedInfo {
+				assert.NotContains(t, info, key)
+			}
+
+			if tt.expectMissing {
+				assert.Empty(t, xmp)
+				return
+			}
+			for _, property := range tt.expectedXMP {
+				assert.Contains(t, xmp, property)
+			}
+			for _, property := range tt.removedXMP {
+				assert.NotContains(t, xmp, property)
+			}
+		})
+	}
+}
+
+func TestPdfCompressor_SetMetadataPolicy(t *testing.T) {
+	compressor := NewPdfCompressor()
+
+	assert.NoError(t, compressor.SetMetadataPolicy("Strip-All"))
+	assert.Equal(t, MetadataPolicyStripAll, compressor.metadataPolicy)
+	assert.Error(t, compressor.SetMetadataPolicy("keep-some"))
+	assert.Equal(t, MetadataPolicyStripAll, compressor.metadataPolicy)
+}
@@ -0,0 +1,52 @@
+package compressor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PDF presets, named after the Ghostscript PDFSETTINGS they mimic
+const (
+	PdfPresetScreen   = "screen"
+	PdfPresetEbook    = "ebook"
+	PdfPresetPrinter  = "printer"
+	PdfPresetPrepress = "prepress"
+)
+
+// PdfPresets lists every PDF preset, from the smallest output to the most faithful one
+var PdfPresets = []string{PdfPresetScreen, PdfPresetEbook, PdfPresetPrinter, PdfPresetPrepress}
+
+// pdfPreset bundles the settings applied by a PDF preset
+type pdfPreset struct {
+	imageDPI        int
+	imageQuality    int
+	recompressFlate bool
+	dropThumbnails  bool
+	subsetFonts     bool
+}
+
+var pdfPresets = map[string]pdfPreset{
+	PdfPresetScreen:   {imageDPI: 72, imageQuality: 40, recompressFlate: true, dropThumbnails: true, subsetFonts: true},
+	PdfPresetEbook:    {imageDPI: 150, imageQuality: 60, recompressFlate: true, dropThumbnails: true, subsetFonts: true},
+	PdfPresetPrinter:  {imageDPI: 300, imageQuality: 85, recompressFlate: true, subsetFonts: true},
+	PdfPresetPrepress: {imageDPI: 300, imageQuality: 95},
+}
+
+// SetPreset applies the image resolution, JPEG quality, Flate recompression,
+// thumbnail and font subsetting settings of a preset, see PdfPresets
+func (pc *PdfCompressor) SetPreset(name string) error {
+	name = strings.ToLower(name)
+	if !slices.Contains(PdfPresets, name) {
+		return fmt.Errorf("unknown PDF preset %q, expected one of: %s", name, strings.Join(PdfPresets, ", "))
+	}
+
+	preset := pdfPresets[name]
+	pc.imageDPI = preset.imageDPI
+	pc.imageQuality = preset.imageQuality
+	pc.recompressFlate = preset.recompressFlate
+	pc.strip[PdfContentThumbnails] = preset.dropThumbnails
+	pc.subsetFonts = preset.subsetFonts
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package compressor
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPdfCompressor_SetPreset(t *testing.T) {
+	tests := []struct {
+		preset   string
+		expected pdfPreset
+	}{
+		{preset: "screen", expected: pdfPreset{imageDPI: 72, imageQuality: 40, recompressFlate: true, dropThumbnails: true, subsetFonts: true}},
+		{preset: "EBOOK", expected: pdfPreset{imageDPI: 150, imageQuality: 60, recompressFlate: true, dropThumbnails: true, subsetFonts: true}},
+		{preset: "printer", expected: pdfPreset{imageDPI: 300, imageQuality: 85, recompressFlate: true, subsetFonts: true}},
+		{preset: "prepress", expected: pdfPreset{imageDPI: 300, imageQuality: 95}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			compressor := NewPdfCompressor()
+			require.NoError(t, compressor.SetPreset(tt.preset))
+
+			assert.Equal(t, tt.expected, pdfPreset{
+				imageDPI:        compressor.imageDPI,
+				imageQuality:    compressor.imageQuality,
+				recompressFlate: compressor.recompressFlate,
+				dropThumbnails:  compressor.strip[PdfContentThumbnails],
+				subsetFonts:     compressor.subsetFonts,
+			})
+		})
+	}
+
+	compressor := NewPdfCompressor()
+	assert.Error(t, compressor.SetPreset("tiny"))
+	assert.Equal(t, 0, compressor.imageDPI)
+}
+
+func TestPdfCompressor_Preset(t *testing.T) {
+	tests := []struct {
+		preset            string
+		expectedThumbnail string
+		expectedImages    map[string][2]string
+	}{
+		{
+			preset:            PdfPresetScreen,
+			expectedThumbnail: "",
+			expectedImages: map[string][2]string{
+				"144x144": {"144", "DCTDecode"},
+				"64x64":   {"64", "FlateDecode"},
+			},
+		},
+		{
+			preset:            PdfPresetPrepress,
+			expectedThumbnail: "none",
+			expectedImages: map[string][2]string{
+				"144x144": {"600", "FlateDecode"},
+				"64x64":   {"64", "FlateDecode"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			tempDir := t.TempDir()
+			compressor := NewPdfCompressor()
+			require.NoError(t, compressor.SetPreset(tt.preset))
+
+			inputPath := filepath.Join(tempDir, "images.pdf")
+			outputPath := filepath.Join(tempDir, "images-compressed.pdf")
+			createPDFWithImages(t, inputPath)
+
+			_, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedImages, readPDFImages(t, outputPath))
+
+			inputPath = filepath.Join(tempDir, "thumbnail.pdf")
+			outputPath = filepath.Join(tempDir, "thumbnail-compressed.pdf")
+			createPDFWithStreams(t, inputPath)
+
+			_, err = compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			_, thumbnail, _ := readPDFStreamFilters(t, outputPath)
+			assert.Equal(t, tt.expectedThumbnail, thumbnail)
+		})
+	}
+}
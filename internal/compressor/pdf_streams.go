@@ -0,0 +1,89 @@
+package compressor
+
+import (
+	"compress/zlib"
+	"fmt"
+	"slices"
+
+	"github.com/pdfcpu/pdfcpu/pkg/filter"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// recompressStreams deflates the unfiltered and Flate streams at the best
+// compression level, keeping the original data unless the result is smaller.
+// XMP metadata stays uncompressed so that it can be read outside of PDF tools.
+func (pc *PdfCompressor) recompressStreams(ctx *model.Context) error {
+	objNrs := make([]int, 0, len(ctx.Table))
+	for objNr := range ctx.Table {
+		objNrs = append(objNrs, objNr)
+	}
+	slices.Sort(objNrs)
+
+	var saved int64
+	for _, objNr := range objNrs {
+		entry := ctx.Table[objNr]
+		if entry == nil || entry.Free {
+			continue
+		}
+
+		sd, ok := entry.Object.(types.StreamDict)
+		if !ok || sd.Type() != nil && *sd.Type() == "Metadata" {
+			continue
+		}
+
+		if len(sd.FilterPipeline) > 1 || len(sd.FilterPipeline) == 1 && sd.FilterPipeline[0].Name != filter.Flate {
+			continue
+		}
+
+		if err := sd.Decode(); err != nil {
+			pc.logger.PrintfVerbose("PDF Compressor: Skipping stream %d: %v\n", objNr, err)
+			continue
+		}
+
+		data, err := deflatePDFStream(sd.Content)
+		if err != nil {
+			return fmt.Errorf("failed to deflate stream %d: %v", objNr, err)
+		}
+
+		if len(data) >= len(sd.Raw) {
+			continue
+		}
+
+		saved += int64(len(sd.Raw) - len(data))
+
+		length := int64(len(data))
+		sd.Raw = data
+		sd.StreamLength = &length
+		sd.FilterPipeline = []types.PDFFilter{{Name: filter.Flate}}
+		sd.Update("Length", types.Integer(length))
+		sd.Update("Filter", types.Name(filter.Flate))
+		// Predictors were undone by the decoding
+		sd.Delete("DecodeParms")
+		entry.Object = sd
+	}
+
+	pc.logger.PrintfVerbose("PDF Compressor: Recompressed streams saved %s\n", formatSize(saved))
+
+	return nil
+}
+
+// deflatePDFStream compresses stream data with both zlib writers at their best
+// level and returns the smaller result
+func deflatePDFStream(data []byte) ([]byte, error) {
+	standard, err := deflatePNGData(data, zlib.BestCompression, false)
+	if err != nil {
+		return nil, err
+	}
+
+	alternate, err := deflatePNGData(data, zlib.BestCompression, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(alternate) < len(standard) {
+		return alternate, nil
+	}
+
+	return standard, nil
+}
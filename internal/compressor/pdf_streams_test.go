@@ -0,0 +1,117 @@
+package compressor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createPDFWithStreams writes a one page PDF with an uncompressed content
+// stream, an uncompressed thumbnail and XMP metadata
+func createPDFWithStreams(t *testing.T, path string) {
+	content := strings.Repeat("BT /F1 12 Tf 72 700 Td (Repeated line) Tj ET\n", 200)
+	thumbnail := strings.Repeat("\x80", 16*16*3)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /Metadata 6 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> /Thumb 7 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(testPDFXMP), testPDFXMP),
+		fmt.Sprintf("<< /Width 16 /Height 16 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Length %d >>\nstream\n%s\nendstream", len(thumbnail), thumbnail),
+	}
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R"), 0644))
+}
+
+// readPDFStreamFilters returns the filter of the content stream, of the
+// thumbnail, empty when it is missing, and of the XMP metadata of a PDF file
+func readPDFStreamFilters(t *testing.T, path string) (string, string, string) {
+	ctx, err := api.ReadContextFile(path)
+	require.NoError(t, err)
+
+	filterName := func(o types.Object) string {
+		sd, _, err := ctx.DereferenceStreamDict(o)
+		require.NoError(t, err)
+		require.NotNil(t, sd)
+		if len(sd.FilterPipeline) == 0 {
+			return "none"
+		}
+		return sd.FilterPipeline[0].Name
+	}
+
+	page, _, _, err := ctx.PageDict(1, false)
+	require.NoError(t, err)
+
+	thumbnail := ""
+	if ref := page.IndirectRefEntry("Thumb"); ref != nil {
+		thumbnail = filterName(*ref)
+	}
+
+	catalog, err := ctx.Catalog()
+	require.NoError(t, err)
+
+	return filterName(page["Contents"]), thumbnail, filterName(catalog["Metadata"])
+}
+
+func TestPdfCompressor_RecompressStreams(t *testing.T) {
+	tests := []struct {
+		name       string
+		recompress bool
+		expected   string
+	}{
+		{
+			name:       "Disabled",
+			recompress: false,
+			expected:   "none",
+		},
+		{
+			name:       "Enabled",
+			recompress: true,
+			expected:   "FlateDecode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "document.pdf")
+			outputPath := filepath.Join(tempDir, "compressed.pdf")
+			createPDFWithStreams(t, inputPath)
+
+			compressor := NewPdfCompressor()
+			compressor.SetRecompressFlate(tt.recompress)
+
+			_, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			content, thumbnail, metadata := readPDFStreamFilters(t, outputPath)
+			assert.Equal(t, tt.expected, content)
+			assert.Equal(t, tt.expected, thumbnail)
+			assert.Equal(t, "none", metadata)
+
+			_, xmp := readPDFMetadata(t, outputPath)
+			assert.Contains(t, xmp, "dc:title")
+		})
+	}
+}
+
+func TestDeflatePDFStream(t *testing.T) {
+	data := []byte(strings.Repeat("0 0 m 100 100 l S\n", 100))
+
+	compressed, err := deflatePDFStream(data)
+	require.NoError(t, err)
+	assert.Less(t, len(compressed), len(data)/10)
+
+	sd := types.NewStreamDict(types.Dict{"Filter": types.Name("FlateDecode")}, 0, nil, nil, []types.PDFFilter{{Name: "FlateDecode"}})
+	sd.Raw = compressed
+	require.NoError(t, sd.Decode())
+	assert.Equal(t, data, sd.Content)
+}
@@ -0,0 +1,554 @@
+package compressor
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Kinds of non-essential content that can be stripped from PDF documents
+const (
+	PdfContentThumbnails  = "thumbnails"
+	PdfContentAttachments = "attachments"
+	PdfContentJavaScript  = "javascript"
+	PdfContentPrivateData = "private-data"
+)
+
+// PdfContentKinds lists every kind of content that can be stripped from PDF
+// documents. Unused fonts have no kind of their own, the optimization already
+// drops the page resources that no content stream uses.
+var PdfContentKinds = []string{PdfContentThumbnails, PdfContentAttachments, PdfContentJavaScript, PdfContentPrivateData}
+
+// Annotation modes, links are kept in every mode
+const (
+	AnnotationsKeep    = "keep"
+	AnnotationsFlatten = "flatten"
+	AnnotationsStrip   = "strip"
+)
+
+// AnnotationModes lists every annotation mode
+var AnnotationModes = []string{AnnotationsKeep, AnnotationsFlatten, AnnotationsStrip}
+
+// Annotation flags hiding an annotation
+const (
+	pdfAnnotationHidden = 1 << 1
+	pdfAnnotationNoView = 1 << 5
+)
+
+// countLabel formats a count followed by a noun, in plural when needed
+func countLabel(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+// stripContent removes the content selected with SetStrip and
+// SetAnnotationMode, and returns a description of every removal
+func (pc *PdfCompressor) stripContent(ctx *model.Context) ([]string, error) {
+	var removed []string
+
+	if pc.strip[PdfContentThumbnails] {
+		count, err := removeThumbnails(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove thumbnails: %v", err)
+		}
+		if count > 0 {
+			removed = append(removed, countLabel(count, "page thumbnail"))
+		}
+	}
+
+	if pc.strip[PdfContentAttachments] {
+		files, annotations, err := removeAttachments(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove attachments: %v", err)
+		}
+		if files > 0 {
+			removed = append(removed, countLabel(files, "embedded file"))
+		}
+		if annotations > 0 {
+			removed = append(removed, countLabel(annotations, "file attachment annotation"))
+		}
+	}
+
+	if pc.strip[PdfContentJavaScript] {
+		count, err := removeJavaScript(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove JavaScript: %v", err)
+		}
+		if count > 0 {
+			removed = append(removed, countLabel(count, "document script"))
+		}
+	}
+
+	if pc.strip[PdfContentPrivateData] {
+		if count := removePrivateData(ctx); count > 0 {
+			removed = append(removed, "piece info of "+countLabel(count, "object"))
+		}
+	}
+
+	if pc.annotationMode != AnnotationsKeep {
+		flattened, stripped, form, err := pc.processAnnotations(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to %s annotations: %v", pc.annotationMode, err)
+		}
+		if flattened > 0 {
+			removed = append(removed, countLabel(flattened, "flattened annotation"))
+		}
+		if stripped > 0 {
+			removed = append(removed, countLabel(stripped, "annotation"))
+		}
+		if form {
+			removed = append(removed, "interactive form")
+		}
+	}
+
+	for _, removal := range removed {
+		pc.logger.PrintfVerbose("PDF Compressor: Removed %s\n", removal)
+	}
+
+	return removed, nil
+}
+
+// removeThumbnails removes the page thumbnails, viewers render them from the
+// pages when needed
+func removeThumbnails(ctx *model.Context) (int, error) {
+	count := 0
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		page, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil {
+			return 0, err
+		}
+
+		if page != nil && page.Delete("Thumb") != nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// countPDFNameTree returns the number of entries of a name tree
+func countPDFNameTree(ctx *model.Context, o types.Object, depth int) (int, error) {
+	if depth > maxPDFFormDepth {
+		return 0, nil
+	}
+
+	node, err := ctx.DereferenceDict(o)
+	if err != nil || node == nil {
+		return 0, err
+	}
+
+	count := len(node.ArrayEntry("Names")) / 2
+	for _, kid := range node.ArrayEntry("Kids") {
+		n, err := countPDFNameTree(ctx, kid, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+
+	return count, nil
+}
+
+// pdfNameTree returns the root of a name tree of the document catalog, or nil
+func pdfNameTree(ctx *model.Context, name string) (types.Object, error) {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := ctx.DereferenceDict(catalog["Names"])
+	if err != nil || names == nil {
+		return nil, err
+	}
+
+	return names[name], nil
+}
+
+// removeAttachments removes the embedded files of the document, the files
+// associated with the catalog and the file attachment annotations. It
+// returns the number of files and of annotations removed.
+func removeAttachments(ctx *model.Context) (int, int, error) {
+	files := 0
+
+	tree, err := pdfNameTree(ctx, "EmbeddedFiles")
+	if err != nil {
+		return 0, 0, err
+	}
+	if tree != nil {
+		if files, err = countPDFNameTree(ctx, tree, 0); err != nil {
+			return 0, 0, err
+		}
+		if err := ctx.RemoveEmbeddedFilesNameTree(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return 0, 0, err
+	}
+	if associated, err := ctx.DereferenceArray(catalog["AF"]); err == nil && associated != nil {
+		files += len(associated)
+		catalog.Delete("AF")
+	}
+
+	annotations, err := filterPDFAnnotations(ctx, func(_ int, annotation types.Dict) (bool, error) {
+		subtype := annotation.Subtype()
+		return subtype == nil || *subtype != "FileAttachment", nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return files, annotations, nil
+}
+
+// removeJavaScript removes the document level scripts, run when the document
+// is opened or on document events, and returns their number
+func removeJavaScript(ctx *model.Context) (int, error) {
+	count := 0
+
+	tree, err := pdfNameTree(ctx, "JavaScript")
+	if err != nil {
+		return 0, err
+	}
+	if tree != nil {
+		if count, err = countPDFNameTree(ctx, tree, 0); err != nil {
+			return 0, err
+		}
+		// The cached tree would be written back otherwise
+		delete(ctx.Names, "JavaScript")
+		if err := ctx.RemoveNameTree("JavaScript"); err != nil {
+			return 0, err
+		}
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return 0, err
+	}
+
+	if action, err := ctx.DereferenceDict(catalog["OpenAction"]); err == nil && action != nil {
+		if s := action.NameEntry("S"); s != nil && *s == "JavaScript" {
+			catalog.Delete("OpenAction")
+			count++
+		}
+	}
+
+	if actions, err := ctx.DereferenceDict(catalog["AA"]); err == nil && actions != nil {
+		count += len(actions)
+		catalog.Delete("AA")
+	}
+
+	return count, nil
+}
+
+// removePrivateData removes the page-piece dictionaries, where applications
+// such as Illustrator store their private data, and returns the number of
+// objects they were removed from. The optimization drops those of the
+// catalog, the pages and the forms anyway, but silently.
+func removePrivateData(ctx *model.Context) int {
+	count := 0
+	for _, entry := range ctx.Table {
+		if entry == nil || entry.Free {
+			continue
+		}
+
+		var d types.Dict
+		switch o := entry.Object.(type) {
+		case types.Dict:
+			d = o
+		case types.StreamDict:
+			d = o.Dict
+		}
+
+		if d != nil && d.Delete("PieceInfo") != nil {
+			d.Delete("LastModified")
+			count++
+		}
+	}
+
+	return count
+}
+
+// filterPDFAnnotations keeps the annotations of every page for which keep
+// returns true, and returns the number of annotations removed
+func filterPDFAnnotations(ctx *model.Context, keep func(pageNr int, annotation types.Dict) (bool, error)) (int, error) {
+	count := 0
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		page, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil {
+			return 0, err
+		}
+
+		annotations, err := ctx.DereferenceArray(page["Annots"])
+		if err != nil || annotations == nil {
+			continue
+		}
+
+		var kept types.Array
+		for _, o := range annotations {
+			annotation, err := ctx.DereferenceDict(o)
+			if err != nil || annotation == nil {
+				continue
+			}
+
+			ok, err := keep(pageNr, annotation)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				kept = append(kept, o)
+			} else {
+				count++
+			}
+		}
+
+		if len(kept) == len(annotations) {
+			continue
+		}
+		if len(kept) == 0 {
+			page.Delete("Annots")
+		} else {
+			page["Annots"] = kept
+		}
+	}
+
+	return count, nil
+}
+
+// processAnnotations flattens or strips every annotation but links, and
+// removes the interactive form. It returns the number of annotations
+// flattened and removed, and whether the form was removed.
+func (pc *PdfCompressor) processAnnotations(ctx *model.Context) (int, int, bool, error) {
+	appearances := map[int][]pdfAppearance{}
+
+	removed, err := filterPDFAnnotations(ctx, func(pageNr int, annotation types.Dict) (bool, error) {
+		subtype := annotation.Subtype()
+		if subtype != nil && *subtype == "Link" {
+			return true, nil
+		}
+
+		if pc.annotationMode == AnnotationsFlatten {
+			if appearance, ok := pdfAnnotationAppearance(ctx, annotation); ok {
+				appearances[pageNr] = append(appearances[pageNr], appearance)
+			}
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	flattened := 0
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		if len(appearances[pageNr]) == 0 {
+			continue
+		}
+
+		count, err := flattenPDFAppearances(ctx, pageNr, appearances[pageNr])
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("failed to flatten annotations of page %d: %v", pageNr, err)
+		}
+		flattened += count
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	form := catalog.Delete("AcroForm") != nil
+
+	return flattened, removed - flattened, form, nil
+}
+
+// pdfAppearance is the normal appearance of an annotation and its rectangle
+type pdfAppearance struct {
+	ref  types.IndirectRef
+	rect [4]float64
+}
+
+// pdfAnnotationAppearance returns the normal appearance of a visible
+// annotation, in its current state for annotations with several states
+func pdfAnnotationAppearance(ctx *model.Context, annotation types.Dict) (pdfAppearance, bool) {
+	if flags := annotation.IntEntry("F"); flags != nil && *flags&(pdfAnnotationHidden|pdfAnnotationNoView) != 0 {
+		return pdfAppearance{}, false
+	}
+
+	rect, ok := pdfRectArray(annotation.ArrayEntry("Rect"))
+	if !ok {
+		return pdfAppearance{}, false
+	}
+
+	appearances, err := ctx.DereferenceDict(annotation["AP"])
+	if err != nil || appearances == nil {
+		return pdfAppearance{}, false
+	}
+
+	normal := appearances["N"]
+	if states, err := ctx.DereferenceDict(normal); err == nil && states != nil && states.Type() == nil {
+		state := annotation.NameEntry("AS")
+		if state == nil {
+			return pdfAppearance{}, false
+		}
+		normal = states[*state]
+	}
+
+	ref, ok := normal.(types.IndirectRef)
+	if !ok {
+		return pdfAppearance{}, false
+	}
+
+	return pdfAppearance{ref: ref, rect: rect}, true
+}
+
+// pdfRectArray converts a rectangle array of a PDF object, normalized so that
+// its first corner is the lower left one
+func pdfRectArray(array types.Array) ([4]float64, bool) {
+	if len(array) != 4 {
+		return [4]float64{}, false
+	}
+
+	var r [4]float64
+	for i, o := range array {
+		n, ok := pdfNumber(o)
+		if !ok {
+			return [4]float64{}, false
+		}
+		r[i] = n
+	}
+
+	return [4]float64{min(r[0], r[2]), min(r[1], r[3]), max(r[0], r[2]), max(r[1], r[3])}, true
+}
+
+// flattenPDFAppearances paints appearance streams on a page, after its
+// content, and returns the number of appearances painted. Following the
+// PDF specification, the form bounding box, transformed by the form matrix,
+// is mapped to the annotation rectangle.
+func flattenPDFAppearances(ctx *model.Context, pageNr int, appearances []pdfAppearance) (int, error) {
+	page, _, inherited, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return 0, err
+	}
+
+	resources, err := ctx.DereferenceDict(page["Resources"])
+	if err != nil {
+		return 0, err
+	}
+	if resources == nil {
+		// Inherited resources may be shared by other pages
+		resources = types.NewDict()
+		for key, value := range inherited.Resources {
+			resources[key] = value
+		}
+		page["Resources"] = resources
+	}
+
+	xObjects, err := ctx.DereferenceDict(resources["XObject"])
+	if err != nil {
+		return 0, err
+	}
+	if xObjects == nil {
+		xObjects = types.NewDict()
+		resources["XObject"] = xObjects
+	}
+
+	var content strings.Builder
+	content.WriteString("Q\n")
+
+	count := 0
+	for _, appearance := range appearances {
+		sd, _, err := ctx.DereferenceStreamDict(appearance.ref)
+		if err != nil || sd == nil {
+			continue
+		}
+
+		bbox, ok := pdfRectArray(sd.ArrayEntry("BBox"))
+		if !ok {
+			continue
+		}
+		matrix := identityPDFMatrix
+		if array := sd.ArrayEntry("Matrix"); array != nil {
+			if m, ok := pdfMatrixArray(array); ok {
+				matrix = m
+			}
+		}
+
+		// Bounding box of the transformed corners of the form bounding box
+		box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for _, corner := range [][2]float64{{bbox[0], bbox[1]}, {bbox[0], bbox[3]}, {bbox[2], bbox[1]}, {bbox[2], bbox[3]}} {
+			x, y := matrix.apply(corner[0], corner[1])
+			box = [4]float64{min(box[0], x), min(box[1], y), max(box[2], x), max(box[3], y)}
+		}
+
+		rect := appearance.rect
+		if box[2]-box[0] <= 0 || box[3]-box[1] <= 0 || rect[2]-rect[0] <= 0 || rect[3]-rect[1] <= 0 {
+			continue
+		}
+		sx := (rect[2] - rect[0]) / (box[2] - box[0])
+		sy := (rect[3] - rect[1]) / (box[3] - box[1])
+
+		// Appearance streams are form XObjects, some writers omit their type
+		sd.Dict["Subtype"] = types.Name("Form")
+
+		name := ""
+		for i := len(xObjects) + 1; name == "" || xObjects[name] != nil; i++ {
+			name = fmt.Sprintf("Annot%d", i)
+		}
+		xObjects[name] = appearance.ref
+
+		fmt.Fprintf(&content, "q %s 0 0 %s %s %s cm /%s Do Q\n",
+			formatPDFNumber(sx), formatPDFNumber(sy), formatPDFNumber(rect[0]-box[0]*sx), formatPDFNumber(rect[1]-box[1]*sy), name)
+		count++
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	// The page content is isolated so that its graphics state does not leak
+	// into the appearances
+	contents := types.Array{}
+	for _, data := range []string{"q\n", content.String()} {
+		sd, err := ctx.NewStreamDictForBuf([]byte(data))
+		if err != nil {
+			return 0, err
+		}
+		if err := sd.Encode(); err != nil {
+			return 0, err
+		}
+		ref, err := ctx.IndRefForNewObject(*sd)
+		if err != nil {
+			return 0, err
+		}
+		contents = append(contents, *ref)
+	}
+
+	existing, err := ctx.Dereference(page["Contents"])
+	if err != nil {
+		return 0, err
+	}
+	switch o := existing.(type) {
+	case types.Array:
+		contents = slices.Insert(contents, 1, o...)
+	case types.StreamDict:
+		contents = slices.Insert(contents, 1, page["Contents"])
+	}
+	page["Contents"] = contents
+
+	return count, nil
+}
+
+// formatPDFNumber formats a number of a content stream, without exponent
+func formatPDFNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
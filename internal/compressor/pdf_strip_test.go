@@ -0,0 +1,220 @@
+package compressor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createPDFWithExtras writes a one page PDF carrying a thumbnail, an embedded
+// file, document scripts, piece info, a form field, a link, a file attachment
+// and a note
+func createPDFWithExtras(t *testing.T, path string) {
+	stream := func(dict, data string) string {
+		return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /Names << /EmbeddedFiles 10 0 R /JavaScript 11 0 R >> /OpenAction 12 0 R " +
+			"/AcroForm << /Fields [8 0 R] /DR << /Font << /Helv 6 0 R >> >> >> /PieceInfo << /Writer << /LastModified (D:20240101000000Z) >> >> >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> " +
+			"/Thumb 7 0 R /Annots [8 0 R 9 0 R 13 0 R 14 0 R] /PieceInfo << /Illustrator << /Private 15 0 R >> >> /LastModified (D:20240101000000Z) >>",
+		stream("", "BT /F1 12 Tf 72 700 Td (Hello) Tj ET"),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		stream("/Width 2 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x00\xff\xff\x00"),
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (name) /V (Jane) /DA (/Helv 10 Tf 0 g) /Rect [100 100 200 120] /AP << /N 16 0 R >> /P 3 0 R >>",
+		"<< /Type /Annot /Subtype /Link /Rect [0 0 10 10] /A << /S /URI /URI (https://example.com) >> >>",
+		"<< /Names [(notes.txt) 17 0 R] >>",
+		"<< /Names [(init) 18 0 R] >>",
+		"<< /S /JavaScript /JS (app.alert\\(1\\)) >>",
+		"<< /Type /Annot /Subtype /FileAttachment /Rect [300 100 320 120] /FS 17 0 R >>",
+		"<< /Type /Annot /Subtype /Text /Rect [300 300 320 320] /Contents (Note) >>",
+		stream("", "private application data"),
+		stream("/Type /XObject /Subtype /Form /BBox [0 0 100 20] /Resources << /Font << /F1 5 0 R >> >>", "BT /F1 10 Tf 2 5 Td (Jane) Tj ET"),
+		"<< /Type /Filespec /F (notes.txt) /UF (notes.txt) /EF << /F 19 0 R >> >>",
+		"<< /S /JavaScript /JS (var ready = true;) >>",
+		stream("/Type /EmbeddedFile", "attached notes"),
+	}
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R"), 0644))
+}
+
+// readPDFExtras returns the optional content present in a PDF file
+func readPDFExtras(t *testing.T, path string) []string {
+	ctx, err := api.ReadContextFile(path)
+	require.NoError(t, err)
+
+	catalog, err := ctx.Catalog()
+	require.NoError(t, err)
+	page, _, _, err := ctx.PageDict(1, false)
+	require.NoError(t, err)
+
+	var extras []string
+	for _, name := range []string{"EmbeddedFiles", "JavaScript"} {
+		tree, err := pdfNameTree(ctx, name)
+		require.NoError(t, err)
+		if tree != nil {
+			extras = append(extras, name)
+		}
+	}
+	for _, key := range []string{"OpenAction", "AcroForm"} {
+		if catalog[key] != nil {
+			extras = append(extras, "catalog "+key)
+		}
+	}
+	if page["Thumb"] != nil {
+		extras = append(extras, "page Thumb")
+	}
+
+	annotations, err := ctx.DereferenceArray(page["Annots"])
+	require.NoError(t, err)
+	for _, o := range annotations {
+		annotation, err := ctx.DereferenceDict(o)
+		require.NoError(t, err)
+		extras = append(extras, "annotation "+*annotation.Subtype())
+	}
+
+	slices.Sort(extras)
+
+	return extras
+}
+
+func TestPdfCompressor_Strip(t *testing.T) {
+	all := []string{
+		"EmbeddedFiles", "JavaScript",
+		"annotation FileAttachment", "annotation Link", "annotation Text", "annotation Widget",
+		"catalog AcroForm", "catalog OpenAction",
+		"page Thumb",
+	}
+	without := func(removed ...string) []string {
+		return slices.DeleteFunc(slices.Clone(all), func(extra string) bool {
+			return slices.Contains(removed, extra)
+		})
+	}
+
+	tests := []struct {
+		name            string
+		kinds           []string
+		annotationMode  string
+		expectedRemoved []string
+		expectedExtras  []string
+	}{
+		{
+			name:           "Nothing",
+			annotationMode: AnnotationsKeep,
+			expectedExtras: all,
+		},
+		{
+			name:            "Thumbnails",
+			kinds:           []string{PdfContentThumbnails},
+			annotationMode:  AnnotationsKeep,
+			expectedRemoved: []string{"1 page thumbnail"},
+			expectedExtras:  without("page Thumb"),
+		},
+		{
+			name:            "Attachments",
+			kinds:           []string{PdfContentAttachments},
+			annotationMode:  AnnotationsKeep,
+			expectedRemoved: []string{"1 embedded file", "1 file attachment annotation"},
+			expectedExtras:  without("EmbeddedFiles", "annotation FileAttachment"),
+		},
+		{
+			name:            "JavaScript",
+			kinds:           []string{PdfContentJavaScript},
+			annotationMode:  AnnotationsKeep,
+			expectedRemoved: []string{"2 document scripts"},
+			expectedExtras:  without("JavaScript", "catalog OpenAction"),
+		},
+		{
+			name:            "Private data",
+			kinds:           []string{PdfContentPrivateData},
+			annotationMode:  AnnotationsKeep,
+			expectedRemoved: []string{"piece info of 2 objects"},
+			expectedExtras:  all,
+		},
+		{
+			name:            "Flatten annotations",
+			annotationMode:  AnnotationsFlatten,
+			expectedRemoved: []string{"1 flattened annotation", "2 annotations", "interactive form"},
+			expectedExtras:  without("annotation FileAttachment", "annotation Text", "annotation Widget", "catalog AcroForm"),
+		},
+		{
+			name:            "Strip annotations",
+			annotationMode:  AnnotationsStrip,
+			expectedRemoved: []string{"3 annotations", "interactive form"},
+			expectedExtras:  without("annotation FileAttachment", "annotation Text", "annotation Widget", "catalog AcroForm"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "document.pdf")
+			outputPath := filepath.Join(tempDir, "compressed.pdf")
+			createPDFWithExtras(t, inputPath)
+
+			compressor := NewPdfCompressor()
+			for _, kind := range tt.kinds {
+				require.NoError(t, compressor.SetStrip(kind, true))
+			}
+			require.NoError(t, compressor.SetAnnotationMode(tt.annotationMode))
+
+			result, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedRemoved, result.Removed)
+			assert.Equal(t, tt.expectedExtras, readPDFExtras(t, outputPath))
+		})
+	}
+}
+
+func TestPdfCompressor_FlattenAnnotations(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "document.pdf")
+	outputPath := filepath.Join(tempDir, "compressed.pdf")
+	createPDFWithExtras(t, inputPath)
+
+	compressor := NewPdfCompressor()
+	require.NoError(t, compressor.SetAnnotationMode(AnnotationsFlatten))
+
+	_, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+
+	ctx, err := api.ReadContextFile(outputPath)
+	require.NoError(t, err)
+	page, _, inherited, err := ctx.PageDict(1, false)
+	require.NoError(t, err)
+
+	content, err := pdfPageContent(ctx, page)
+	require.NoError(t, err)
+	assert.Equal(t, "q\n\nBT /F1 12 Tf 72 700 Td (Hello) Tj ET\nQ\nq 1 0 0 1 100 100 cm /Annot1 Do Q\n\n", string(content))
+
+	xObjects, err := ctx.DereferenceDict(inherited.Resources["XObject"])
+	require.NoError(t, err)
+	sd, _, err := ctx.DereferenceStreamDict(xObjects["Annot1"])
+	require.NoError(t, err)
+	require.NotNil(t, sd)
+	require.NoError(t, sd.Decode())
+	assert.Equal(t, "BT /F1 10 Tf 2 5 Td (Jane) Tj ET", string(sd.Content))
+}
+
+func TestPdfCompressor_SetStrip(t *testing.T) {
+	compressor := NewPdfCompressor()
+
+	assert.NoError(t, compressor.SetStrip("JavaScript", true))
+	assert.True(t, compressor.strip[PdfContentJavaScript])
+	assert.Error(t, compressor.SetStrip("bookmarks", true))
+
+	assert.NoError(t, compressor.SetAnnotationMode("Flatten"))
+	assert.Equal(t, AnnotationsFlatten, compressor.annotationMode)
+	assert.Error(t, compressor.SetAnnotationMode("hide"))
+	assert.Equal(t, AnnotationsFlatten, compressor.annotationMode)
+}
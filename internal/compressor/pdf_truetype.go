@@ -0,0 +1,358 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// trueTypeFont is a TrueType font program, as embedded by FontFile2 streams
+type trueTypeFont struct {
+	tables    map[string][]byte
+	numGlyphs int
+	// loca holds the offsets of the glyphs in the glyf table, plus its end
+	loca []int
+}
+
+// trueTypeDroppedTables lists the tables PDF viewers do not use: layout,
+// kerning and device metrics are given by the PDF text operators
+var trueTypeDroppedTables = []string{"BASE", "DSIG", "GDEF", "GPOS", "GSUB", "JSTF", "LTSH", "MATH", "PCLT", "VDMX", "feat", "hdmx", "kern", "meta", "mort", "morx"}
+
+func parseTrueType(data []byte) (*trueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("truncated font")
+	}
+
+	if version := binary.BigEndian.Uint32(data); version != 0x00010000 && version != 0x74727565 {
+		return nil, fmt.Errorf("not a TrueType font")
+	}
+
+	tables, err := parseSFNTTables(data)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &trueTypeFont{tables: tables}
+	head, maxp, loca, glyf := f.tables["head"], f.tables["maxp"], f.tables["loca"], f.tables["glyf"]
+	if len(head) < 54 || len(maxp) < 6 || loca == nil || glyf == nil {
+		return nil, fmt.Errorf("missing glyph tables")
+	}
+
+	f.numGlyphs = int(binary.BigEndian.Uint16(maxp[4:]))
+	f.loca = make([]int, f.numGlyphs+1)
+	long := binary.BigEndian.Uint16(head[50:]) == 1
+	for i := range f.loca {
+		switch {
+		case long && len(loca) >= 4*(i+1):
+			f.loca[i] = int(binary.BigEndian.Uint32(loca[4*i:]))
+		case !long && len(loca) >= 2*(i+1):
+			f.loca[i] = 2 * int(binary.BigEndian.Uint16(loca[2*i:]))
+		default:
+			return nil, fmt.Errorf("truncated loca table")
+		}
+
+		if f.loca[i] > len(glyf) || i > 0 && f.loca[i] < f.loca[i-1] {
+			return nil, fmt.Errorf("invalid loca table")
+		}
+	}
+
+	return f, nil
+}
+
+// parseSFNTTables reads the table directory of a TrueType or OpenType font
+func parseSFNTTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("truncated font")
+	}
+
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+16*numTables {
+		return nil, fmt.Errorf("truncated table directory")
+	}
+
+	tables := map[string][]byte{}
+	for i := range numTables {
+		record := data[12+16*i:]
+		offset := int(binary.BigEndian.Uint32(record[8:]))
+		length := int(binary.BigEndian.Uint32(record[12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, fmt.Errorf("table %s out of bounds", record[:4])
+		}
+		tables[string(record[:4])] = data[offset : offset+length]
+	}
+
+	return tables, nil
+}
+
+// glyph returns the outline of a glyph, empty for glyphs without contours
+func (f *trueTypeFont) glyph(gid int) []byte {
+	if gid >= f.numGlyphs {
+		return nil
+	}
+
+	return f.tables["glyf"][f.loca[gid]:f.loca[gid+1]]
+}
+
+// components returns the glyphs a composite glyph is made of
+func (f *trueTypeFont) components(gid int) []int {
+	data := f.glyph(gid)
+	if len(data) < 10 || int16(binary.BigEndian.Uint16(data)) >= 0 {
+		return nil
+	}
+
+	var components []int
+	for pos := 10; pos+4 <= len(data); {
+		flags := binary.BigEndian.Uint16(data[pos:])
+		components = append(components, int(binary.BigEndian.Uint16(data[pos+2:])))
+		pos += 4
+
+		if flags&0x0001 != 0 {
+			pos += 4
+		} else {
+			pos += 2
+		}
+
+		switch {
+		case flags&0x0008 != 0:
+			pos += 2
+		case flags&0x0040 != 0:
+			pos += 4
+		case flags&0x0080 != 0:
+			pos += 8
+		}
+
+		if flags&0x0020 == 0 {
+			break
+		}
+	}
+
+	return components
+}
+
+// cmap returns the character to glyph mapping of a cmap subtable, nil when
+// the font has none for the platform and encoding
+func (f *trueTypeFont) cmap(platformID, encodingID uint16) map[uint32]uint16 {
+	table := f.tables["cmap"]
+	if len(table) < 4 {
+		return nil
+	}
+
+	numTables := int(binary.BigEndian.Uint16(table[2:]))
+	for i := range numTables {
+		record := table[4+8*i:]
+		if len(record) < 8 || binary.BigEndian.Uint16(record) != platformID || binary.BigEndian.Uint16(record[2:]) != encodingID {
+			continue
+		}
+
+		offset := int(binary.BigEndian.Uint32(record[4:]))
+		if offset+4 > len(table) {
+			return nil
+		}
+
+		return parseTrueTypeCmap(table[offset:])
+	}
+
+	return nil
+}
+
+// parseTrueTypeCmap reads a cmap subtable of format 0, 4, 6 or 12
+func parseTrueTypeCmap(data []byte) map[uint32]uint16 {
+	u16 := func(pos int) int {
+		if pos+2 > len(data) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(data[pos:]))
+	}
+	u32 := func(pos int) int {
+		if pos+4 > len(data) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint32(data[pos:]))
+	}
+
+	mapping := map[uint32]uint16{}
+	switch u16(0) {
+	case 0:
+		for code := 0; code < 256 && 6+code < len(data); code++ {
+			mapping[uint32(code)] = uint16(data[6+code])
+		}
+	case 4:
+		segments := u16(6) / 2
+		endCodes, startCodes, deltas, rangeOffsets := 14, 16+2*segments, 16+4*segments, 16+6*segments
+		for i := range segments {
+			start, end := u16(startCodes+2*i), u16(endCodes+2*i)
+			delta, rangeOffset := u16(deltas+2*i), u16(rangeOffsets+2*i)
+			for code := start; code <= end && code != 0xffff; code++ {
+				gid := 0
+				if rangeOffset == 0 {
+					gid = code + delta
+				} else if g := u16(rangeOffsets + 2*i + rangeOffset + 2*(code-start)); g != 0 {
+					gid = g + delta
+				}
+				if gid&0xffff != 0 {
+					mapping[uint32(code)] = uint16(gid)
+				}
+			}
+		}
+	case 6:
+		first, count := u16(6), u16(8)
+		for i := range count {
+			mapping[uint32(first+i)] = uint16(u16(10 + 2*i))
+		}
+	case 12:
+		groups := u32(12)
+		for i := 0; i < groups && 16+12*i+12 <= len(data); i++ {
+			start, end, gid := u32(16+12*i), u32(20+12*i), u32(24+12*i)
+			for code := start; code <= end && code-start < 0x10000; code++ {
+				mapping[uint32(code)] = uint16(gid + code - start)
+			}
+		}
+	}
+
+	return mapping
+}
+
+// subset returns the font with the outlines of the glyphs not listed, nor
+// used by a listed composite glyph, removed. Glyph IDs are kept, so that the
+// character mappings and metrics of the font and of the PDF stay valid.
+func (f *trueTypeFont) subset(gids map[uint16]bool) []byte {
+	return f.subsetTables(gids, trueTypeDroppedTables)
+}
+
+// subsetTables subsets the font as subset does, dropping the given tables
+func (f *trueTypeFont) subsetTables(gids map[uint16]bool, dropped []string) []byte {
+	keep := map[int]bool{0: true}
+	pending := []int{0}
+	for gid := range gids {
+		pending = append(pending, int(gid))
+	}
+	for len(pending) > 0 {
+		gid := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		keep[gid] = true
+		for _, component := range f.components(gid) {
+			if !keep[component] {
+				pending = append(pending, component)
+			}
+		}
+	}
+
+	var glyf []byte
+	offsets := make([]int, f.numGlyphs+1)
+	for gid := range f.numGlyphs {
+		offsets[gid] = len(glyf)
+		if keep[gid] {
+			glyf = append(glyf, f.glyph(gid)...)
+			for len(glyf)%4 != 0 {
+				glyf = append(glyf, 0)
+			}
+		}
+	}
+	offsets[f.numGlyphs] = len(glyf)
+
+	long := len(glyf) > 0x1fffe
+	var loca []byte
+	for _, offset := range offsets {
+		if long {
+			loca = binary.BigEndian.AppendUint32(loca, uint32(offset))
+		} else {
+			loca = binary.BigEndian.AppendUint16(loca, uint16(offset/2))
+		}
+	}
+
+	head := slices.Clone(f.tables["head"])
+	binary.BigEndian.PutUint32(head[8:], 0)
+	if long {
+		binary.BigEndian.PutUint16(head[50:], 1)
+	} else {
+		binary.BigEndian.PutUint16(head[50:], 0)
+	}
+
+	tables := maps.Clone(f.tables)
+	for _, tag := range dropped {
+		delete(tables, tag)
+	}
+	tables["glyf"], tables["loca"], tables["head"] = glyf, loca, head
+
+	font := buildTrueType(tables)
+	setSFNTChecksumAdjustment(font)
+
+	return font
+}
+
+// setSFNTChecksumAdjustment sets the checksum adjustment of the head table,
+// which must be zero, making the whole font sum to a magic number
+func setSFNTChecksumAdjustment(font []byte) {
+	for tag, offset := range trueTypeTableOffsets(font) {
+		if tag == "head" {
+			binary.BigEndian.PutUint32(font[offset+8:], 0xb1b0afba-trueTypeChecksum(font))
+		}
+	}
+}
+
+// buildTrueType assembles tables into a font program
+func buildTrueType(tables map[string][]byte) []byte {
+	return buildSFNT(0x00010000, tables)
+}
+
+// buildSFNT assembles tables into a font program of the given version, the
+// version of TrueType outlines or "OTTO" for CFF ones
+func buildSFNT(version uint32, tables map[string][]byte) []byte {
+	tags := slices.Sorted(maps.Keys(tables))
+
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= len(tags) {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+
+	font := binary.BigEndian.AppendUint32(nil, version)
+	font = binary.BigEndian.AppendUint16(font, uint16(len(tags)))
+	font = binary.BigEndian.AppendUint16(font, uint16(searchRange))
+	font = binary.BigEndian.AppendUint16(font, uint16(entrySelector))
+	font = binary.BigEndian.AppendUint16(font, uint16(16*len(tags)-searchRange))
+
+	offset := 12 + 16*len(tags)
+	for _, tag := range tags {
+		table := tables[tag]
+		font = append(font, tag...)
+		font = binary.BigEndian.AppendUint32(font, trueTypeChecksum(table))
+		font = binary.BigEndian.AppendUint32(font, uint32(offset))
+		font = binary.BigEndian.AppendUint32(font, uint32(len(table)))
+		offset += (len(table) + 3) &^ 3
+	}
+
+	for _, tag := range tags {
+		font = append(font, tables[tag]...)
+		for len(font)%4 != 0 {
+			font = append(font, 0)
+		}
+	}
+
+	return font
+}
+
+// trueTypeTableOffsets returns the offsets of the tables of a font program
+func trueTypeTableOffsets(font []byte) map[string]int {
+	offsets := map[string]int{}
+	numTables := int(binary.BigEndian.Uint16(font[4:]))
+	for i := range numTables {
+		record := font[12+16*i:]
+		offsets[string(record[:4])] = int(binary.BigEndian.Uint32(record[8:]))
+	}
+
+	return offsets
+}
+
+// trueTypeChecksum sums data as big-endian 32-bit words, zero padded
+func trueTypeChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+
+	return sum
+}
@@ -0,0 +1,89 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// createCompositeTrueType returns a font of four glyphs, glyph 2 being made
+// of glyph 1, glyphs 1 and 3 being simple ones
+func createCompositeTrueType() []byte {
+	simple := []byte{0, 1, 0, 0, 0, 0, 0, 10, 0, 10, 0, 0, 0, 0, 1, 0, 0}
+	composite := []byte{0xff, 0xff, 0, 0, 0, 0, 0, 10, 0, 10, 0x00, 0x02, 0, 1, 0, 0}
+
+	var glyf, loca []byte
+	for _, glyph := range [][]byte{nil, simple, composite, simple} {
+		loca = binary.BigEndian.AppendUint16(loca, uint16(len(glyf)/2))
+		glyf = append(glyf, glyph...)
+		if len(glyf)%2 != 0 {
+			glyf = append(glyf, 0)
+		}
+	}
+	loca = binary.BigEndian.AppendUint16(loca, uint16(len(glyf)/2))
+
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint32(head, 0x00010000)
+	binary.BigEndian.PutUint32(head[12:], 0x5f0f3cf5)
+	maxp := binary.BigEndian.AppendUint32(nil, 0x00005000)
+	maxp = binary.BigEndian.AppendUint16(maxp, 4)
+
+	return buildTrueType(map[string][]byte{"head": head, "maxp": maxp, "loca": loca, "glyf": glyf, "GPOS": {0, 1, 0, 0}})
+}
+
+func TestTrueTypeFont_Subset(t *testing.T) {
+	font, err := parseTrueType(goregular.TTF)
+	require.NoError(t, err)
+
+	unicode := font.cmap(3, 1)
+	a, b := unicode['A'], unicode['B']
+	require.NotZero(t, a)
+	require.NotZero(t, b)
+
+	subset := font.subset(map[uint16]bool{a: true})
+	assert.Less(t, len(subset), len(goregular.TTF)/4)
+	assert.Equal(t, uint32(0xb1b0afba), trueTypeChecksum(subset))
+
+	parsed, err := sfnt.Parse(subset)
+	require.NoError(t, err)
+	assert.Equal(t, font.numGlyphs, parsed.NumGlyphs())
+
+	var buf sfnt.Buffer
+	gid, err := parsed.GlyphIndex(&buf, 'A')
+	require.NoError(t, err)
+	assert.Equal(t, sfnt.GlyphIndex(a), gid)
+	segments, err := parsed.LoadGlyph(&buf, gid, fixed.I(12), nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, segments)
+
+	segments, err = parsed.LoadGlyph(&buf, sfnt.GlyphIndex(b), fixed.I(12), nil)
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+}
+
+func TestTrueTypeFont_SubsetComposite(t *testing.T) {
+	font, err := parseTrueType(createCompositeTrueType())
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, font.components(2))
+
+	subset, err := parseTrueType(font.subset(map[uint16]bool{2: true}))
+	require.NoError(t, err)
+	// Glyphs are padded to 4 bytes
+	assert.Equal(t, font.glyph(1), subset.glyph(1)[:len(font.glyph(1))])
+	assert.Equal(t, font.glyph(2), subset.glyph(2)[:len(font.glyph(2))])
+	assert.Empty(t, subset.glyph(3))
+	assert.NotContains(t, subset.tables, "GPOS")
+}
+
+func TestParseTrueType(t *testing.T) {
+	_, err := parseTrueType([]byte("OTTO"))
+	assert.Error(t, err)
+
+	_, err = parseTrueType(goregular.TTF[:100])
+	assert.Error(t, err)
+}
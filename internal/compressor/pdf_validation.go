@@ -0,0 +1,59 @@
+package compressor
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// renderingPDFPages reports, for every page of a document, whether its
+// content streams and those of the forms it paints decode and parse
+func renderingPDFPages(ctx *model.Context) []bool {
+	rendering := make([]bool, ctx.PageCount)
+	for i := range rendering {
+		rendering[i] = walkPDFPage(ctx, i+1, func(pdfXObject) error { return nil }) == nil
+	}
+
+	return rendering
+}
+
+// validatePDFOutput reads a compressed document back, opening it with the
+// password of the original one, and checks that pdfcpu validates it, that it
+// has the pages of the original document and that the pages rendering in the
+// original document still render
+func validatePDFOutput(outputPath, password string, rendering []bool) error {
+	file, err := os.Open(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW = password
+	conf.OwnerPW = password
+
+	ctx, err := api.ReadAndValidate(file, conf)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.EnsurePageCount(); err != nil {
+		return err
+	}
+	if ctx.PageCount != len(rendering) {
+		return fmt.Errorf("document has %d pages instead of %d", ctx.PageCount, len(rendering))
+	}
+
+	for i, renders := range rendering {
+		if !renders {
+			continue
+		}
+		if err := walkPDFPage(ctx, i+1, func(pdfXObject) error { return nil }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
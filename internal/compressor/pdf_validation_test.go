@@ -0,0 +1,101 @@
+package compressor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createPDFWithContents writes a PDF with a page per content stream
+func createPDFWithContents(t *testing.T, path string, contents ...string) {
+	kids := ""
+	for i := range contents {
+		kids += fmt.Sprintf("%d 0 R ", 3+2*i)
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 612 792] >>", kids, len(contents)),
+	}
+	for i, content := range contents {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Contents %d 0 R >>", 4+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+
+	require.NoError(t, os.WriteFile(path, buildPDF(objects, "/Root 1 0 R"), 0644))
+}
+
+func TestRenderingPDFPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "document.pdf")
+	createPDFWithContents(t, path, "0 0 m 10 10 l S", "BT (unterminated Tj ET", "q 1 0 0 1 0 0 cm Q")
+
+	ctx, err := api.ReadContextFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, []bool{true, false, true}, renderingPDFPages(ctx))
+}
+
+func TestValidatePDFOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	valid := filepath.Join(tempDir, "valid.pdf")
+	createPDFWithContents(t, valid, "0 0 m 10 10 l S", "BT /F1 12 Tf (Page) Tj ET")
+	broken := filepath.Join(tempDir, "broken.pdf")
+	createPDFWithContents(t, broken, "0 0 m 10 10 l S", "BT (unterminated Tj ET")
+	garbage := filepath.Join(tempDir, "garbage.pdf")
+	require.NoError(t, os.WriteFile(garbage, []byte("%PDF-1.7\nnot a document"), 0644))
+
+	tests := []struct {
+		name      string
+		path      string
+		rendering []bool
+		err       string
+	}{
+		{"Valid", valid, []bool{true, true}, ""},
+		{"Missing page", valid, []bool{true, true, true}, "document has 2 pages instead of 3"},
+		{"Page no longer rendering", broken, []bool{true, true}, "content of page 2"},
+		{"Page not rendering originally", broken, []bool{true, false}, ""},
+		{"Unreadable", garbage, []bool{true}, "root"},
+		{"Missing file", filepath.Join(tempDir, "missing.pdf"), []bool{true}, "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePDFOutput(tt.path, "", tt.rendering)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestPdfCompressor_ValidatesOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "document.pdf")
+	outputPath := filepath.Join(tempDir, "compressed.pdf")
+	// A form whose content cannot be decoded breaks the page in the original
+	// document already, which does not fail the validation
+	content, form := "q /Fm1 Do Q", "not deflated"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /XObject << /Fm1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Form /BBox [0 0 10 10] /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", len(form), form),
+	}
+	require.NoError(t, os.WriteFile(inputPath, buildPDF(objects, "/Root 1 0 R"), 0644))
+
+	result, err := NewPdfCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.Empty(t, result.Skipped)
+	assert.FileExists(t, outputPath)
+}
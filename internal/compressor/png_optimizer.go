@@ -0,0 +1,698 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"os"
+
+	kzlib "github.com/klauspost/compress/zlib"
+)
+
+// PNG color types
+const (
+	pngColorGray      = 0
+	pngColorRGB       = 2
+	pngColorPalette   = 3
+	pngColorGrayAlpha = 4
+	pngColorRGBA      = 6
+)
+
+// PNG filter strategies, the first five are the filter types of the specification
+const (
+	pngFilterNone = iota
+	pngFilterSub
+	pngFilterUp
+	pngFilterAverage
+	pngFilterPaeth
+	// pngFilterAdaptive picks for every scanline the filter whose output has
+	// the smallest sum of absolute values
+	pngFilterAdaptive
+)
+
+// pngEncoding describes how the pixels of an image are stored in a PNG file
+type pngEncoding struct {
+	colorType byte
+	bitDepth  int
+	palette   []color.NRGBA
+}
+
+func (e pngEncoding) String() string {
+	switch e.colorType {
+	case pngColorGray:
+		return fmt.Sprintf("%d-bit grayscale", e.bitDepth)
+	case pngColorRGB:
+		return fmt.Sprintf("%d-bit RGB", e.bitDepth)
+	case pngColorPalette:
+		return fmt.Sprintf("%d-bit palette of %d colors", e.bitDepth, len(e.palette))
+	case pngColorGrayAlpha:
+		return fmt.Sprintf("%d-bit grayscale with alpha", e.bitDepth)
+	default:
+		return fmt.Sprintf("%d-bit RGBA", e.bitDepth)
+	}
+}
+
+func (e pngEncoding) channels() int {
+	switch e.colorType {
+	case pngColorRGB:
+		return 3
+	case pngColorGrayAlpha:
+		return 2
+	case pngColorRGBA:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// filterBytesPerPixel returns the distance between the bytes compared by the
+// Sub, Average and Paeth filters
+func (e pngEncoding) filterBytesPerPixel() int {
+	return max(1, e.channels()*e.bitDepth/8)
+}
+
+// pngPixels holds the pixels of an image along with the properties deciding
+// which encodings can store them losslessly
+type pngPixels struct {
+	width, height int
+	pix           []color.NRGBA64
+	sixteenBit    bool
+	opaque        bool
+	gray          bool
+	// palette holds the colors of the image, or nil when there are more than 256 of them
+	palette []color.NRGBA
+}
+
+func analyzePNGPixels(img image.Image) *pngPixels {
+	bounds := img.Bounds()
+	p := &pngPixels{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		pix:    make([]color.NRGBA64, 0, bounds.Dx()*bounds.Dy()),
+		opaque: true,
+		gray:   true,
+	}
+
+	colors := map[color.NRGBA]bool{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := exactNRGBA64(img.At(x, y))
+			p.pix = append(p.pix, c)
+
+			if c.A != 0xffff {
+				p.opaque = false
+			}
+			if c.R != c.G || c.G != c.B {
+				p.gray = false
+			}
+			if !p.sixteenBit && (!isEightBitSample(c.R) || !isEightBitSample(c.G) || !isEightBitSample(c.B) || !isEightBitSample(c.A)) {
+				p.sixteenBit = true
+			}
+
+			if colors != nil {
+				c8 := to8BitNRGBA(c)
+				if !colors[c8] {
+					colors[c8] = true
+					if len(colors) > 256 {
+						colors = nil
+						continue
+					}
+					p.palette = append(p.palette, c8)
+				}
+			}
+		}
+	}
+
+	if colors == nil || p.sixteenBit {
+		p.palette = nil
+	} else {
+		p.palette = sortPNGPalette(p.palette)
+	}
+
+	return p
+}
+
+// exactNRGBA64 converts a color to 16-bit non-premultiplied samples. Unlike
+// color.NRGBA64Model, it does not go through premultiplied alpha, which would
+// alter the samples of translucent non-premultiplied colors.
+func exactNRGBA64(c color.Color) color.NRGBA64 {
+	switch c := c.(type) {
+	case color.NRGBA64:
+		return c
+	case color.NRGBA:
+		return color.NRGBA64{R: uint16(c.R) * 0x101, G: uint16(c.G) * 0x101, B: uint16(c.B) * 0x101, A: uint16(c.A) * 0x101}
+	default:
+		return color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	}
+}
+
+func isEightBitSample(v uint16) bool {
+	return v>>8 == v&0xff
+}
+
+func to8BitNRGBA(c color.NRGBA64) color.NRGBA {
+	return color.NRGBA{R: uint8(c.R >> 8), G: uint8(c.G >> 8), B: uint8(c.B >> 8), A: uint8(c.A >> 8)}
+}
+
+// sortPNGPalette moves the translucent colors first, so that the tRNS chunk
+// can stop after the last of them
+func sortPNGPalette(palette []color.NRGBA) []color.NRGBA {
+	sorted := make([]color.NRGBA, 0, len(palette))
+	for _, c := range palette {
+		if c.A != 0xff {
+			sorted = append(sorted, c)
+		}
+	}
+	for _, c := range palette {
+		if c.A == 0xff {
+			sorted = append(sorted, c)
+		}
+	}
+
+	return sorted
+}
+
+// grayBitDepth returns the smallest bit depth able to store the gray levels of an opaque 8-bit grayscale image
+func (p *pngPixels) grayBitDepth() int {
+	for _, depth := range []int{1, 2, 4} {
+		step := uint16(255 / (1<<depth - 1))
+		fits := true
+		for _, c := range p.pix {
+			if (c.R>>8)%step != 0 {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return depth
+		}
+	}
+
+	return 8
+}
+
+// pngCandidates returns the encodings able to store the pixels without any loss
+func pngCandidates(p *pngPixels) []pngEncoding {
+	depth := 8
+	if p.sixteenBit {
+		depth = 16
+	}
+
+	var candidates []pngEncoding
+	switch {
+	case p.gray && p.opaque:
+		if !p.sixteenBit {
+			depth = p.grayBitDepth()
+		}
+		candidates = append(candidates, pngEncoding{colorType: pngColorGray, bitDepth: depth})
+	case p.gray:
+		candidates = append(candidates, pngEncoding{colorType: pngColorGrayAlpha, bitDepth: depth})
+	case p.opaque:
+		candidates = append(candidates, pngEncoding{colorType: pngColorRGB, bitDepth: depth})
+	default:
+		candidates = append(candidates, pngEncoding{colorType: pngColorRGBA, bitDepth: depth})
+	}
+
+	if p.palette != nil {
+		paletteDepth := 8
+		for _, d := range []int{1, 2, 4} {
+			if len(p.palette) <= 1<<d {
+				paletteDepth = d
+				break
+			}
+		}
+		candidates = append(candidates, pngEncoding{colorType: pngColorPalette, bitDepth: paletteDepth, palette: p.palette})
+	}
+
+	return candidates
+}
+
+// scanlines returns the unfiltered scanlines of the pixels stored with the encoding
+func (e pngEncoding) scanlines(p *pngPixels) [][]byte {
+	rowBytes := (p.width*e.channels()*e.bitDepth + 7) / 8
+
+	var indexes map[color.NRGBA]uint8
+	if e.colorType == pngColorPalette {
+		indexes = make(map[color.NRGBA]uint8, len(e.palette))
+		for i, c := range e.palette {
+			indexes[c] = uint8(i)
+		}
+	}
+
+	rows := make([][]byte, p.height)
+	for y := range rows {
+		row := make([]byte, rowBytes)
+		samples := make([]uint16, 0, e.channels())
+		bit := 0
+
+		for x := 0; x < p.width; x++ {
+			c := p.pix[y*p.width+x]
+
+			samples = samples[:0]
+			switch e.colorType {
+			case pngColorGray:
+				samples = append(samples, c.R)
+			case pngColorRGB:
+				samples = append(samples, c.R, c.G, c.B)
+			case pngColorPalette:
+				samples = append(samples, uint16(indexes[to8BitNRGBA(c)]))
+			case pngColorGrayAlpha:
+				samples = append(samples, c.R, c.A)
+			case pngColorRGBA:
+				samples = append(samples, c.R, c.G, c.B, c.A)
+			}
+
+			for _, s := range samples {
+				switch {
+				case e.bitDepth == 16:
+					binary.BigEndian.PutUint16(row[bit/8:], s)
+				case e.colorType == pngColorPalette && e.bitDepth == 8:
+					row[bit/8] = uint8(s)
+				case e.bitDepth == 8:
+					row[bit/8] = uint8(s >> 8)
+				default:
+					// Gray levels are scaled down, palette indexes are stored as is
+					v := s
+					if e.colorType != pngColorPalette {
+						v = (s >> 8) / (255 / (1<<e.bitDepth - 1))
+					}
+					row[bit/8] |= uint8(v) << (8 - e.bitDepth - bit%8)
+				}
+				bit += e.bitDepth
+			}
+		}
+
+		rows[y] = row
+	}
+
+	return rows
+}
+
+// filterPNGScanlines returns the image data once every scanline is prefixed
+// by its filter type and filtered
+func filterPNGScanlines(rows [][]byte, bpp int, strategy int) []byte {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	rowBytes := len(rows[0])
+	out := make([]byte, 0, len(rows)*(rowBytes+1))
+	prev := make([]byte, rowBytes)
+	candidates := make([][]byte, pngFilterPaeth+1)
+	for i := range candidates {
+		candidates[i] = make([]byte, rowBytes)
+	}
+
+	for _, row := range rows {
+		filterType := strategy
+		if strategy == pngFilterAdaptive {
+			bestSum := -1
+			for ft := pngFilterNone; ft <= pngFilterPaeth; ft++ {
+				applyPNGFilter(candidates[ft], row, prev, bpp, ft)
+				sum := 0
+				for _, b := range candidates[ft] {
+					sum += absInt(int(int8(b)))
+				}
+				if bestSum < 0 || sum < bestSum {
+					bestSum = sum
+					filterType = ft
+				}
+			}
+		} else {
+			applyPNGFilter(candidates[filterType], row, prev, bpp, filterType)
+		}
+
+		out = append(out, byte(filterType))
+		out = append(out, candidates[filterType]...)
+		prev = row
+	}
+
+	return out
+}
+
+func applyPNGFilter(dst, row, prev []byte, bpp int, filterType int) {
+	for i := range row {
+		var left, upLeft byte
+		if i >= bpp {
+			left = row[i-bpp]
+			upLeft = prev[i-bpp]
+		}
+		up := prev[i]
+
+		switch filterType {
+		case pngFilterNone:
+			dst[i] = row[i]
+		case pngFilterSub:
+			dst[i] = row[i] - left
+		case pngFilterUp:
+			dst[i] = row[i] - up
+		case pngFilterAverage:
+			dst[i] = row[i] - byte((int(left)+int(up))/2)
+		case pngFilterPaeth:
+			dst[i] = row[i] - paeth(left, up, upLeft)
+		}
+	}
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa := absInt(p - int(a))
+	pb := absInt(p - int(b))
+	pc := absInt(p - int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+
+	return c
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+// deflatePNGData compresses the image data with the standard library zlib
+// writer, and with the klauspost one when useAlternate is set
+func deflatePNGData(data []byte, level int, useAlternate bool) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+	var err error
+	if useAlternate {
+		w, err = kzlib.NewWriterLevel(&buf, level)
+	} else {
+		w, err = zlib.NewWriterLevel(&buf, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writePNG writes a PNG file holding the compressed image data, without any ancillary chunk
+func writePNG(w io.Writer, width, height int, e pngEncoding, idat []byte) error {
+	if _, err := w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(height))
+	ihdr[8] = byte(e.bitDepth)
+	ihdr[9] = e.colorType
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	if e.colorType == pngColorPalette {
+		plte := make([]byte, 0, 3*len(e.palette))
+		trns := make([]byte, 0, len(e.palette))
+		for _, c := range e.palette {
+			plte = append(plte, c.R, c.G, c.B)
+			if c.A != 0xff {
+				trns = append(trns, c.A)
+			}
+		}
+
+		if err := writePNGChunk(w, "PLTE", plte); err != nil {
+			return err
+		}
+		if len(trns) > 0 {
+			if err := writePNGChunk(w, "tRNS", trns); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writePNGChunk(w, "IDAT", idat); err != nil {
+		return err
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+	copy(header[4:8], chunkType)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:8])
+	crc.Write(data)
+
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// optimizePNG returns the smallest PNG file storing exactly the pixels of
+// img. Every lossless color type and bit depth reduction is tried with every
+// filter strategy, then the best combination goes through a deflate search.
+func optimizePNG(img image.Image) ([]byte, pngEncoding, error) {
+	p := analyzePNGPixels(img)
+	if p.width == 0 || p.height == 0 {
+		return nil, pngEncoding{}, fmt.Errorf("invalid image size")
+	}
+
+	var best []byte
+	var bestEncoding pngEncoding
+
+	for _, e := range pngCandidates(p) {
+		rows := e.scanlines(p)
+
+		// Filter strategies are compared with a fast compression level
+		var filtered []byte
+		trialSize := -1
+		for strategy := pngFilterNone; strategy <= pngFilterAdaptive; strategy++ {
+			data := filterPNGScanlines(rows, e.filterBytesPerPixel(), strategy)
+			compressed, err := deflatePNGData(data, zlib.DefaultCompression, false)
+			if err != nil {
+				return nil, pngEncoding{}, fmt.Errorf("failed to compress image data: %v", err)
+			}
+
+			if trialSize < 0 || len(compressed) < trialSize {
+				trialSize = len(compressed)
+				filtered = data
+			}
+		}
+
+		var idat []byte
+		for _, useAlternate := range []bool{false, true} {
+			compressed, err := deflatePNGData(filtered, zlib.BestCompression, useAlternate)
+			if err != nil {
+				return nil, pngEncoding{}, fmt.Errorf("failed to compress image data: %v", err)
+			}
+
+			if idat == nil || len(compressed) < len(idat) {
+				idat = compressed
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := writePNG(&buf, p.width, p.height, e, idat); err != nil {
+			return nil, pngEncoding{}, fmt.Errorf("failed to write PNG: %v", err)
+		}
+
+		if best == nil || buf.Len() < len(best) {
+			best = buf.Bytes()
+			bestEncoding = e
+		}
+	}
+
+	return best, bestEncoding, nil
+}
+
+// compressPNG optimizes a PNG image, losslessly unless lossy mode is enabled,
+// and carries the metadata and text chunks of the original that are kept by
+// the metadata settings. Unless the
+// image was resized, the original file is kept when it cannot be made smaller.
+func (ic *ImageCompressor) compressPNG(img image.Image, outputPath string, originalPath string, resized bool) error {
+	data, encoding, err := optimizePNG(img)
+	if err != nil {
+		return fmt.Errorf("failed to optimize PNG: %v", err)
+	}
+
+	if ic.pngLossy {
+		lossyData, lossyEncoding, err := ic.quantizePNG(img)
+		if err != nil {
+			return fmt.Errorf("failed to quantize PNG: %v", err)
+		}
+
+		if lossyData != nil && len(lossyData) < len(data) {
+			data, encoding = lossyData, lossyEncoding
+		}
+	}
+
+	original, err := os.ReadFile(originalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read original file: %v", err)
+	}
+
+	chunks, err := parsePNGChunks(original)
+	if err != nil {
+		ic.logger.PrintfVerbose("Image Compressor: Could not parse PNG chunks, saving without metadata preservation: %v\n", err)
+	}
+
+	// Carry the metadata chunks to keep, and rebuild the original without the other ones
+	var metadata, filtered []pngChunk
+	changed := false
+	for _, chunk := range chunks {
+		kept, keep := ic.pngMetadataChunk(chunk)
+		if !keep {
+			changed = true
+			continue
+		}
+		changed = changed || !bytes.Equal(kept.data, chunk.data)
+		chunk = kept
+		filtered = append(filtered, chunk)
+
+		if pngMetadataKind(chunk) == "" && !isPNGTextChunk(chunk) {
+			continue
+		}
+		if chunk.chunkType == "eXIf" {
+			if updated, err := updateEXIFSegment(append([]byte("Exif\x00\x00"), chunk.data...), img.Bounds().Dx(), img.Bounds().Dy(), false); err == nil {
+				chunk.data = updated[6:]
+			}
+		}
+		metadata = append(metadata, chunk)
+	}
+
+	data, err = insertPNGMetadata(data, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to insert PNG metadata: %v", err)
+	}
+
+	if !resized {
+		// The original encoding, once stripped of unwanted metadata, may be smaller
+		candidate := original
+		if changed {
+			candidate, err = writePNGChunks(filtered)
+			if err != nil {
+				return fmt.Errorf("failed to strip PNG metadata: %v", err)
+			}
+		}
+
+		if len(candidate) <= len(data) {
+			ic.logger.PrintfVerbose("Image Compressor: PNG is already optimized, keeping the original encoding\n")
+
+			return os.WriteFile(outputPath, candidate, 0644)
+		}
+	}
+
+	ic.logger.PrintfVerbose("Image Compressor: Optimized PNG as %s\n", encoding)
+
+	return os.WriteFile(outputPath, data, 0644)
+}
+
+// pngMetadataChunk returns a chunk of the original PNG file once the metadata
+// settings are applied, and whether it is kept at all
+func (ic *ImageCompressor) pngMetadataChunk(chunk pngChunk) (pngChunk, bool) {
+	kind := pngMetadataKind(chunk)
+	if kind == "" {
+		switch {
+		case isPNGTextChunk(chunk):
+			// The Author and Copyright keywords are standard PNG copyright notices
+			keyword, _, _ := bytes.Cut(chunk.data, []byte{0})
+			copyright := string(keyword) == "Author" || string(keyword) == "Copyright"
+
+			return chunk, ic.metadataPolicy == MetadataPolicyKeepAll || ic.metadataPolicy == MetadataPolicyKeepCopyrightAndColor && copyright
+		case chunk.chunkType == "tIME":
+			return chunk, ic.metadataPolicy == MetadataPolicyKeepAll
+		default:
+			return chunk, true
+		}
+	}
+
+	if !ic.keepMetadata[kind] {
+		return chunk, false
+	}
+
+	switch {
+	case chunk.chunkType == "eXIf":
+		filtered, err := applyEXIFPolicy(append([]byte("Exif\x00\x00"), chunk.data...), ic.metadataPolicy, ic.privacy)
+		if err != nil {
+			ic.logger.PrintfVerbose("Image Compressor: Could not apply metadata policy to EXIF, removing it: %v\n", err)
+			return chunk, false
+		}
+		if filtered == nil {
+			return chunk, false
+		}
+
+		return pngChunk{chunkType: chunk.chunkType, data: filtered[6:]}, true
+	case kind == MetadataXMP:
+		if ic.metadataPolicy == MetadataPolicyKeepAll && !ic.privacy {
+			return chunk, true
+		}
+
+		keyword, packet, err := pngText(chunk)
+		if err != nil {
+			ic.logger.PrintfVerbose("Image Compressor: Could not apply metadata policy to XMP, removing it: %v\n", err)
+			return chunk, false
+		}
+
+		packet = applyXMPPolicy(packet, ic.metadataPolicy, ic.privacy, imagePrivacyXMPProperties)
+		if packet == nil {
+			return chunk, false
+		}
+
+		return newPNGITXtChunk(keyword, packet), true
+	case kind == MetadataICC:
+		return chunk, ic.metadataPolicy != MetadataPolicyStripAll
+	default:
+		// Raw profiles are hex encoded in text chunks, they are kept as is or not at all
+		return chunk, ic.metadataPolicy == MetadataPolicyKeepAll && !(ic.privacy && kind == MetadataEXIF)
+	}
+}
+
+// isPNGTextChunk reports whether a chunk is a tEXt, zTXt or iTXt chunk
+func isPNGTextChunk(chunk pngChunk) bool {
+	return chunk.chunkType == "tEXt" || chunk.chunkType == "zTXt" || chunk.chunkType == "iTXt"
+}
+
+// quantizePNG returns the smallest PNG file storing img reduced to a 256 color
+// palette, or nil when the image already fits in a palette or when the
+// quantization quality is below the configured floor
+func (ic *ImageCompressor) quantizePNG(img image.Image) ([]byte, pngEncoding, error) {
+	if hasAtMostColors(img, 256) {
+		return nil, pngEncoding{}, nil
+	}
+
+	quantized := quantizeImage(img, 256, ic.pngDithering)
+
+	quality := quantizationQuality(img, quantized)
+	if quality < ic.pngMinQuality {
+		ic.logger.PrintfVerbose("Image Compressor: PNG quantization quality %d is below %d, keeping lossless encoding\n", quality, ic.pngMinQuality)
+
+		return nil, pngEncoding{}, nil
+	}
+
+	ic.logger.PrintfVerbose("Image Compressor: Quantized PNG to %d colors with quality %d\n", len(quantized.Palette), quality)
+
+	return optimizePNG(quantized)
+}
@@ -0,0 +1,195 @@
+package compressor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createPNGTestImage(name string) image.Image {
+	bounds := image.Rect(0, 0, 37, 23)
+
+	switch name {
+	case "opaque rgba":
+		img := image.NewNRGBA(bounds)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 11), B: uint8(x * y), A: 255})
+			}
+		}
+		return img
+	case "translucent rgba":
+		img := image.NewNRGBA(bounds)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 11), B: uint8(x * y), A: uint8(x * 6)})
+			}
+		}
+		return img
+	case "few colors with transparency":
+		img := image.NewNRGBA(bounds)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				c := color.NRGBA{R: 200, G: 30, B: 30, A: 255}
+				if (x+y)%3 == 0 {
+					c = color.NRGBA{}
+				} else if x > 20 {
+					c = color.NRGBA{R: 10, G: 10, B: 250, A: 128}
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		return img
+	case "black and white":
+		img := image.NewRGBA(bounds)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				if (x/4+y/4)%2 == 0 {
+					img.Set(x, y, color.White)
+				} else {
+					img.Set(x, y, color.Black)
+				}
+			}
+		}
+		return img
+	case "gray with alpha":
+		img := image.NewNRGBA(bounds)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				v := uint8(x*y + x)
+				img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: uint8(y * 10)})
+			}
+		}
+		return img
+	case "16-bit gray":
+		img := image.NewGray16(bounds)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				img.SetGray16(x, y, color.Gray16{Y: uint16(x*1000 + y*7)})
+			}
+		}
+		return img
+	default:
+		panic("unknown test image " + name)
+	}
+}
+
+// pngHeader returns the bit depth and color type found in the IHDR chunk
+func pngHeader(t *testing.T, data []byte) (int, int) {
+	require.Greater(t, len(data), 26)
+	require.Equal(t, "IHDR", string(data[12:16]))
+
+	return int(data[24]), int(data[25])
+}
+
+func assertSamePixels(t *testing.T, expected, actual image.Image) {
+	require.Equal(t, expected.Bounds().Size(), actual.Bounds().Size())
+
+	eb, ab := expected.Bounds(), actual.Bounds()
+	for y := 0; y < eb.Dy(); y++ {
+		for x := 0; x < eb.Dx(); x++ {
+			e := exactNRGBA64(expected.At(eb.Min.X+x, eb.Min.Y+y))
+			a := exactNRGBA64(actual.At(ab.Min.X+x, ab.Min.Y+y))
+			if e != a {
+				t.Fatalf("pixel (%d, %d) differs: expected %v, got %v", x, y, e, a)
+			}
+		}
+	}
+}
+
+func TestOptimizePNG(t *testing.T) {
+	tests := []struct {
+		name      string
+		bitDepth  int
+		colorType int
+	}{
+		{"opaque rgba", 8, pngColorRGB},
+		{"translucent rgba", 8, pngColorRGBA},
+		{"few colors with transparency", 2, pngColorPalette},
+		{"black and white", 1, pngColorGray},
+		{"gray with alpha", 8, pngColorGrayAlpha},
+		{"16-bit gray", 16, pngColorGray},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := createPNGTestImage(tt.name)
+
+			data, encoding, err := optimizePNG(src)
+			require.NoError(t, err)
+
+			bitDepth, colorType := pngHeader(t, data)
+			assert.Equal(t, tt.bitDepth, bitDepth)
+			assert.Equal(t, tt.colorType, colorType)
+			assert.Equal(t, tt.colorType, int(encoding.colorType))
+
+			decoded, err := png.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			assertSamePixels(t, src, decoded)
+		})
+	}
+}
+
+func TestOptimizePNG_SmallerThanStandardEncoder(t *testing.T) {
+	src := createPNGTestImage("few colors with transparency")
+
+	var standard bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	require.NoError(t, encoder.Encode(&standard, src))
+
+	data, _, err := optimizePNG(src)
+	require.NoError(t, err)
+	assert.Less(t, len(data), standard.Len())
+}
+
+func TestFilterPNGScanlines(t *testing.T) {
+	rows := [][]byte{
+		{10, 20, 30, 40},
+		{15, 25, 35, 45},
+	}
+
+	tests := []struct {
+		name     string
+		strategy int
+		expected []byte
+	}{
+		{"None", pngFilterNone, []byte{0, 10, 20, 30, 40, 0, 15, 25, 35, 45}},
+		{"Sub", pngFilterSub, []byte{1, 10, 10, 10, 10, 1, 15, 10, 10, 10}},
+		{"Up", pngFilterUp, []byte{2, 10, 20, 30, 40, 2, 5, 5, 5, 5}},
+		{"Average", pngFilterAverage, []byte{3, 10, 15, 20, 25, 3, 10, 8, 8, 8}},
+		{"Paeth", pngFilterPaeth, []byte{4, 10, 10, 10, 10, 4, 5, 5, 5, 5}},
+		{"Adaptive", pngFilterAdaptive, []byte{1, 10, 10, 10, 10, 2, 5, 5, 5, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, filterPNGScanlines(rows, 1, tt.strategy))
+		})
+	}
+}
+
+func TestImageCompressor_CompressFile_PNGKeepsOptimizedOriginal(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "optimized.png")
+	outputPath := filepath.Join(tempDir, "compressed.png")
+
+	data, _, err := optimizePNG(createPNGTestImage("opaque rgba"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(inputPath, data, 0644))
+
+	compressor := NewImageCompressor()
+	result, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, result.CompressedSize, result.OriginalSize)
+
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, output)
+}
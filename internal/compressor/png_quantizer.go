@@ -0,0 +1,365 @@
+package compressor
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// quantizerIterations is the number of k-means passes refining the median cut palette
+const quantizerIterations = 4
+
+// quantColor is a histogram entry: the mean of the pixels falling in a
+// bucket, as premultiplied RGBA, and how many pixels fell in it
+type quantColor struct {
+	value [4]float64
+	count int
+}
+
+// buildQuantHistogram groups the pixels of img in buckets keeping the 5 most
+// significant bits of each premultiplied channel
+func buildQuantHistogram(img image.Image) []quantColor {
+	type bucket struct {
+		sum   [4]float64
+		count int
+	}
+
+	buckets := map[uint32]*bucket{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := premultipliedSamples(img.At(x, y))
+			key := uint32(c[0])>>3<<15 | uint32(c[1])>>3<<10 | uint32(c[2])>>3<<5 | uint32(c[3])>>3
+
+			b, exists := buckets[key]
+			if !exists {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			for i := range c {
+				b.sum[i] += c[i]
+			}
+			b.count++
+		}
+	}
+
+	histogram := make([]quantColor, 0, len(buckets))
+	for _, b := range buckets {
+		var mean [4]float64
+		for i := range mean {
+			mean[i] = b.sum[i] / float64(b.count)
+		}
+		histogram = append(histogram, quantColor{value: mean, count: b.count})
+	}
+
+	// Map iteration order is random, sorting keeps the output deterministic
+	sort.Slice(histogram, func(i, j int) bool {
+		a, b := histogram[i].value, histogram[j].value
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	return histogram
+}
+
+func premultipliedSamples(c color.Color) [4]float64 {
+	r, g, b, a := c.RGBA()
+
+	return [4]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8), float64(a >> 8)}
+}
+
+func colorDistance(a, b [4]float64) float64 {
+	var d float64
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+
+	return d
+}
+
+// medianCut splits the histogram in at most maxColors boxes, always cutting
+// the box with the widest channel range at the median of its pixels
+func medianCut(histogram []quantColor, maxColors int) [][4]float64 {
+	boxes := [][]quantColor{histogram}
+
+	for len(boxes) < maxColors {
+		widest, channel := -1, 0
+		var widestRange float64
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+
+			for ch := 0; ch < 4; ch++ {
+				lo, hi := box[0].value[ch], box[0].value[ch]
+				for _, c := range box[1:] {
+					lo = math.Min(lo, c.value[ch])
+					hi = math.Max(hi, c.value[ch])
+				}
+				if hi-lo > widestRange {
+					widest, channel, widestRange = i, ch, hi-lo
+				}
+			}
+		}
+
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		sort.SliceStable(box, func(i, j int) bool {
+			return box[i].value[channel] < box[j].value[channel]
+		})
+
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+
+		split, seen := 1, box[0].count
+		for split < len(box)-1 && seen+box[split].count <= total/2 {
+			seen += box[split].count
+			split++
+		}
+
+		boxes[widest] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make([][4]float64, len(boxes))
+	for i, box := range boxes {
+		palette[i] = histogramMean(box)
+	}
+
+	return palette
+}
+
+func histogramMean(colors []quantColor) [4]float64 {
+	var sum [4]float64
+	total := 0
+	for _, c := range colors {
+		for i := range sum {
+			sum[i] += c.value[i] * float64(c.count)
+		}
+		total += c.count
+	}
+
+	for i := range sum {
+		sum[i] /= float64(total)
+	}
+
+	return sum
+}
+
+func nearestPaletteEntry(palette [][4]float64, c [4]float64) int {
+	best, bestDistance := 0, math.MaxFloat64
+	for i, p := range palette {
+		if d := colorDistance(p, c); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+
+	return best
+}
+
+// paletteSearch finds the nearest palette entry faster than a linear scan.
+// Entries are sorted by their red sample, and the search walks away from the
+// looked up red value until the red distance alone exceeds the best match.
+type paletteSearch struct {
+	entries [][4]float64
+	indexes []int
+}
+
+func newPaletteSearch(palette [][4]float64) *paletteSearch {
+	s := &paletteSearch{indexes: make([]int, len(palette))}
+	for i := range s.indexes {
+		s.indexes[i] = i
+	}
+	sort.SliceStable(s.indexes, func(i, j int) bool {
+		return palette[s.indexes[i]][0] < palette[s.indexes[j]][0]
+	})
+
+	s.entries = make([][4]float64, len(palette))
+	for i, index := range s.indexes {
+		s.entries[i] = palette[index]
+	}
+
+	return s
+}
+
+func (s *paletteSearch) nearest(c [4]float64) int {
+	start := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i][0] >= c[0]
+	})
+
+	best, bestDistance := 0, math.MaxFloat64
+	for lo, hi := start-1, start; lo >= 0 || hi < len(s.entries); lo, hi = lo-1, hi+1 {
+		done := true
+		for _, i := range []int{lo, hi} {
+			if i < 0 || i >= len(s.entries) {
+				continue
+			}
+
+			dr := s.entries[i][0] - c[0]
+			if dr*dr >= bestDistance {
+				continue
+			}
+			done = false
+
+			if d := colorDistance(s.entries[i], c); d < bestDistance {
+				best, bestDistance = i, d
+			}
+		}
+
+		if done {
+			break
+		}
+	}
+
+	return s.indexes[best]
+}
+
+// refinePalette moves every palette entry to the mean of the colors closest
+// to it, which is one k-means iteration
+func refinePalette(histogram []quantColor, palette [][4]float64) {
+	clusters := make([][]quantColor, len(palette))
+	for _, c := range histogram {
+		i := nearestPaletteEntry(palette, c.value)
+		clusters[i] = append(clusters[i], c)
+	}
+
+	for i, cluster := range clusters {
+		if len(cluster) > 0 {
+			palette[i] = histogramMean(cluster)
+		}
+	}
+}
+
+func roundSample(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// quantizeImage reduces img to a palette of at most maxColors colors, built
+// with median cut and refined with k-means. With dither set, the quantization
+// error is spread over the neighboring pixels with Floyd–Steinberg dithering.
+func quantizeImage(img image.Image, maxColors int, dither bool) *image.Paletted {
+	histogram := buildQuantHistogram(img)
+	palette := medianCut(histogram, maxColors)
+	for i := 0; i < quantizerIterations; i++ {
+		refinePalette(histogram, palette)
+	}
+
+	// Entries are rounded to the non-premultiplied colors stored in the file
+	colorPalette := make(color.Palette, len(palette))
+	for i, p := range palette {
+		c := color.NRGBA{A: roundSample(p[3])}
+		if c.A > 0 {
+			alpha := float64(c.A) / 255
+			c.R, c.G, c.B = roundSample(p[0]/alpha), roundSample(p[1]/alpha), roundSample(p[2]/alpha)
+		}
+		colorPalette[i] = c
+		palette[i] = premultipliedSamples(c)
+	}
+
+	bounds := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), colorPalette)
+
+	search := newPaletteSearch(palette)
+	cache := map[[4]float64]uint8{}
+	lookup := func(c [4]float64) uint8 {
+		index, exists := cache[c]
+		if !exists {
+			index = uint8(search.nearest(c))
+			cache[c] = index
+		}
+		return index
+	}
+
+	if !dither {
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				out.Pix[out.PixOffset(x, y)] = lookup(premultipliedSamples(img.At(bounds.Min.X+x, bounds.Min.Y+y)))
+			}
+		}
+
+		return out
+	}
+
+	// Errors carried to the current and the next row, with a pixel of margin on each side
+	current := make([][4]float64, bounds.Dx()+2)
+	next := make([][4]float64, bounds.Dx()+2)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := premultipliedSamples(img.At(bounds.Min.X+x, bounds.Min.Y+y))
+			for i := range c {
+				c[i] = math.Max(0, math.Min(255, c[i]+current[x+1][i]))
+			}
+
+			// Dithered colors rarely repeat, so they are not cached
+			index := uint8(search.nearest(c))
+			out.Pix[out.PixOffset(x, y)] = index
+
+			for i := range c {
+				e := c[i] - palette[index][i]
+				current[x+2][i] += e * 7 / 16
+				next[x][i] += e * 3 / 16
+				next[x+1][i] += e * 5 / 16
+				next[x+2][i] += e * 1 / 16
+			}
+		}
+
+		current, next = next, current
+		for i := range next {
+			next[i] = [4]float64{}
+		}
+	}
+
+	return out
+}
+
+// quantizationQuality rates how close the quantized image is to the source,
+// from 0 to 100. It maps the PSNR of the premultiplied samples linearly, from
+// 0 at 15 dB or less to 100 at 45 dB or more.
+func quantizationQuality(src image.Image, quantized *image.Paletted) int {
+	bounds := src.Bounds()
+	if bounds.Empty() {
+		return 100
+	}
+
+	var sum float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			sum += colorDistance(premultipliedSamples(src.At(bounds.Min.X+x, bounds.Min.Y+y)), premultipliedSamples(quantized.At(x, y)))
+		}
+	}
+
+	mse := sum / float64(4*bounds.Dx()*bounds.Dy())
+	if mse == 0 {
+		return 100
+	}
+
+	psnr := 10 * math.Log10(255*255/mse)
+
+	return int(math.Max(0, math.Min(100, (psnr-15)*100/30)))
+}
+
+// hasAtMostColors reports whether img uses no more than n distinct colors
+func hasAtMostColors(img image.Image, n int) bool {
+	colors := map[color.NRGBA64]bool{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			colors[exactNRGBA64(img.At(x, y))] = true
+			if len(colors) > n {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,92 @@
+package compressor
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createGradientImage creates a screenshot-like image: a slightly noisy
+// gradient with a translucent banner, holding far more than 256 colors
+func createGradientImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			noise := uint8(((x*2654435761 ^ y*40503) >> 7) % 5)
+			c := color.NRGBA{R: uint8(x*250/width) + noise, G: uint8(y*250/height) + noise, B: 180, A: 255}
+			if y < height/4 {
+				c.A = uint8(128 + x*127/width)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func TestQuantizeImage(t *testing.T) {
+	src := createGradientImage(120, 80)
+	require.False(t, hasAtMostColors(src, 256))
+
+	for _, dither := range []bool{false, true} {
+		quantized := quantizeImage(src, 256, dither)
+
+		assert.LessOrEqual(t, len(quantized.Palette), 256)
+		assert.Equal(t, src.Bounds().Size(), quantized.Bounds().Size())
+		assert.GreaterOrEqual(t, quantizationQuality(src, quantized), 65, "dither=%v", dither)
+	}
+}
+
+func TestQuantizeImage_FewColors(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	src.SetNRGBA(3, 3, color.NRGBA{R: 255, A: 255})
+
+	quantized := quantizeImage(src, 256, false)
+
+	assert.Len(t, quantized.Palette, 2)
+	assert.Equal(t, 100, quantizationQuality(src, quantized))
+}
+
+func TestMedianCut(t *testing.T) {
+	histogram := buildQuantHistogram(createGradientImage(64, 64))
+
+	assert.Len(t, medianCut(histogram, 16), 16)
+	assert.Len(t, medianCut(histogram, 1), 1)
+}
+
+func TestImageCompressor_CompressFile_LossyPNG(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "screenshot.png")
+
+	file, err := os.Create(inputPath)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(file, createGradientImage(200, 150)))
+	require.NoError(t, file.Close())
+
+	lossless := NewImageCompressor()
+	losslessResult, err := lossless.CompressFile(inputPath, filepath.Join(tempDir, "lossless.png"))
+	require.NoError(t, err)
+
+	lossy := NewImageCompressor()
+	lossy.SetPNGLossy(true)
+	lossyResult, err := lossy.CompressFile(inputPath, filepath.Join(tempDir, "lossy.png"))
+	require.NoError(t, err)
+	assert.Less(t, lossyResult.CompressedSize, losslessResult.CompressedSize)
+
+	// A quality floor that cannot be reached keeps the lossless encoding
+	strict := NewImageCompressor()
+	strict.SetPNGLossy(true)
+	strict.SetPNGMinQuality(100)
+	strictResult, err := strict.CompressFile(inputPath, filepath.Join(tempDir, "strict.png"))
+	require.NoError(t, err)
+	assert.Equal(t, losslessResult.CompressedSize, strictResult.CompressedSize)
+}
@@ -0,0 +1,159 @@
+package compressor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+)
+
+// precompressedFormats are the formats of the files written next to web
+// assets, as nginx gzip_static and brotli_static, or Caddy precompressed, look
+// them up, at their highest level since they are compressed once and served
+// many times
+var precompressedFormats = []struct {
+	format string
+	level  int
+}{
+	{StreamFormatGzip, 9},
+	{StreamFormatBrotli, 11},
+}
+
+// Precompressor writes gzip and Brotli versions of web assets next to them,
+// keeping the original files, so that web servers send them without
+// compressing them on the fly
+type Precompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	maxRatio           float64
+}
+
+func NewPrecompressor() *Precompressor {
+	return &Precompressor{
+		supportedMimeTypes: []string{
+			"text/html",
+			"text/css",
+			"text/javascript",
+			"application/javascript",
+			"image/svg+xml",
+			"application/json",
+			"application/xml",
+			"text/xml",
+			"font/ttf",
+			"font/otf",
+			"font/collection",
+			"application/vnd.ms-fontobject",
+		},
+		logger:   logger.NewLogger(false),
+		maxRatio: 0.9,
+	}
+}
+
+// CompressFile writes the .gz and .br siblings of a file, the output path is
+// not used. Siblings larger than the maximum ratio of the original size are
+// not written, and removed when left by a previous build.
+func (p *Precompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	p.logger.PrintfVerbose("Precompressor: Precompressing file %s\n", filepath.Base(filePath))
+
+	originalFileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original file info: %v", err)
+	}
+
+	result := &CompressionResult{
+		OriginalFile:   filePath,
+		OriginalSize:   originalFileInfo.Size(),
+		CompressedSize: originalFileInfo.Size(),
+	}
+
+	for _, f := range precompressedFormats {
+		siblingPath := filePath + streamFormats[f.format].extension
+
+		size, written, err := p.writeSibling(filePath, siblingPath, f.format, f.level, originalFileInfo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", filepath.Base(siblingPath), err)
+		}
+
+		if !written {
+			p.logger.PrintfVerbose("Precompressor: Not writing %s, %s is above %.0f%% of the original size\n", filepath.Base(siblingPath), formatSize(size), p.maxRatio*100)
+			if err := os.Remove(siblingPath); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to remove stale %s: %v", filepath.Base(siblingPath), err)
+			}
+			continue
+		}
+
+		result.Precompressed = append(result.Precompressed, siblingPath)
+		if size < result.CompressedSize {
+			result.CompressedFile = siblingPath
+			result.CompressedSize = size
+		}
+	}
+
+	if len(result.Precompressed) == 0 {
+		result.Skipped = SkippedIncompressible
+	}
+
+	return result, nil
+}
+
+// writeSibling compresses a file to a temporary file, moved over the sibling
+// path with the modification time of the original file when small enough, so
+// that web servers never read a partial sibling. It returns the compressed
+// size and whether the sibling was written.
+func (p *Precompressor) writeSibling(filePath, siblingPath, format string, level int, original os.FileInfo) (int64, bool, error) {
+	tempPath := siblingPath + ".tmp"
+	if err := compressStream(filePath, tempPath, format, level); err != nil {
+		return 0, false, err
+	}
+	defer os.Remove(tempPath)
+
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if float64(info.Size()) > p.maxRatio*float64(original.Size()) {
+		return info.Size(), false, nil
+	}
+
+	// Web servers and caches compare the sibling with the original file
+	if err := os.Chtimes(tempPath, time.Time{}, original.ModTime()); err != nil {
+		return 0, false, err
+	}
+
+	if err := os.Rename(tempPath, siblingPath); err != nil {
+		return 0, false, err
+	}
+
+	return info.Size(), true, nil
+}
+
+func (p *Precompressor) GetSupportedMimeTypes() []string {
+	return p.supportedMimeTypes
+}
+
+func (p *Precompressor) SetLogger(logger *logger.Logger) {
+	p.logger = logger
+}
+
+// SetMaxRatio sets the largest size of a precompressed file relative to the
+// original one, 0.9 writing files saving at least 10%
+func (p *Precompressor) SetMaxRatio(ratio float64) error {
+	if ratio <= 0 {
+		p.maxRatio = 0.9
+
+		return fmt.Errorf("precompression ratio must be greater than 0, setting to 0.9")
+	}
+
+	if ratio > 1 {
+		p.maxRatio = 1
+
+		return fmt.Errorf("precompression ratio cannot exceed 1, setting to 1")
+	}
+
+	p.maxRatio = ratio
+
+	return nil
+}
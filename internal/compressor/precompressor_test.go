@@ -0,0 +1,128 @@
+package compressor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrecompressor_CompressFile(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "app.css")
+	content := []byte(strings.Repeat(".button { color: #333; padding: 4px 8px; }\n", 200))
+	require.NoError(t, os.WriteFile(inputPath, content, 0644))
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(inputPath, modTime, modTime))
+
+	result, err := NewPrecompressor().CompressFile(inputPath, filepath.Join(tempDir, "compressed_app.css"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Skipped)
+	assert.Equal(t, []string{inputPath + ".gz", inputPath + ".br"}, result.Precompressed)
+	assert.Equal(t, inputPath+".br", result.CompressedFile)
+	assert.True(t, result.IsPositiveSavings())
+
+	// The original file is kept and no other file is written
+	original, err := os.ReadFile(inputPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, original))
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		".gz": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		".br": func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for extension, decode := range decoders {
+		info, err := os.Stat(inputPath + extension)
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(modTime), "%s modification time", extension)
+
+		compressed, err := os.ReadFile(inputPath + extension)
+		require.NoError(t, err)
+		r, err := decode(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(content, decoded), "%s content", extension)
+	}
+}
+
+func TestPrecompressor_CompressFileIncompressible(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "font.ttf")
+	content := make([]byte, 4096)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(inputPath, content, 0644))
+
+	// Siblings of a previous build are removed
+	require.NoError(t, os.WriteFile(inputPath+".gz", []byte("stale"), 0644))
+
+	result, err := NewPrecompressor().CompressFile(inputPath, filepath.Join(tempDir, "compressed_font.ttf"))
+	require.NoError(t, err)
+	assert.Equal(t, SkippedIncompressible, result.Skipped)
+	assert.Empty(t, result.Precompressed)
+	assert.NoFileExists(t, inputPath+".gz")
+	assert.NoFileExists(t, inputPath+".br")
+	assert.NoFileExists(t, inputPath+".gz.tmp")
+}
+
+func TestPrecompressor_MaxRatio(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "data.json")
+	content := make([]byte, 2048)
+	_, err := rand.Read(content[:1024])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(inputPath, content, 0644))
+
+	// Half of the file is random, so siblings are above half its size
+	compressor := NewPrecompressor()
+	require.NoError(t, compressor.SetMaxRatio(0.5))
+	result, err := compressor.CompressFile(inputPath, filepath.Join(tempDir, "compressed_data.json"))
+	require.NoError(t, err)
+	assert.Equal(t, SkippedIncompressible, result.Skipped)
+
+	require.NoError(t, compressor.SetMaxRatio(0.9))
+	result, err = compressor.CompressFile(inputPath, filepath.Join(tempDir, "compressed_data.json"))
+	require.NoError(t, err)
+	assert.Len(t, result.Precompressed, 2)
+}
+
+func TestPrecompressor_SetMaxRatio(t *testing.T) {
+	tests := []struct {
+		name     string
+		ratio    float64
+		expected float64
+		err      bool
+	}{
+		{"Valid", 0.8, 0.8, false},
+		{"Any saving", 1, 1, false},
+		{"Zero", 0, 0.9, true},
+		{"Too high", 1.5, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressor := NewPrecompressor()
+
+			err := compressor.SetMaxRatio(tt.ratio)
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, compressor.maxRatio)
+		})
+	}
+}
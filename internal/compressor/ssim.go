@@ -0,0 +1,144 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// ssimWindowSize is the side of the square windows over which SSIM statistics
+// are computed, and ssimWindowStep the distance between two windows
+const (
+	ssimWindowSize = 8
+	ssimWindowStep = 4
+)
+
+// lumaPlane holds the luma of an image, as defined by the JFIF standard
+type lumaPlane struct {
+	width, height int
+	pix           []float64
+}
+
+func newLumaPlane(img image.Image) *lumaPlane {
+	bounds := img.Bounds()
+	l := &lumaPlane{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		pix:    make([]float64, 0, bounds.Dx()*bounds.Dy()),
+	}
+
+	// Decoded JPEG images already hold the luma plane
+	if ycbcr, ok := img.(*image.YCbCr); ok {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				l.pix = append(l.pix, float64(ycbcr.Y[ycbcr.YOffset(x, y)]))
+			}
+		}
+
+		return l
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			l.pix = append(l.pix, (0.299*float64(r)+0.587*float64(g)+0.114*float64(b))/257)
+		}
+	}
+
+	return l
+}
+
+// computeSSIM returns the mean structural similarity between the luma planes
+// of two images of the same size, from 0 (unrelated) to 1 (identical)
+func computeSSIM(a, b *lumaPlane) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	windowWidth := min(ssimWindowSize, a.width)
+	windowHeight := min(ssimWindowSize, a.height)
+
+	var total float64
+	var windows int
+	for wy := 0; wy+windowHeight <= a.height; wy += ssimWindowStep {
+		for wx := 0; wx+windowWidth <= a.width; wx += ssimWindowStep {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := wy; y < wy+windowHeight; y++ {
+				for x := wx; x < wx+windowWidth; x++ {
+					va, vb := a.pix[y*a.width+x], b.pix[y*b.width+x]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+
+			n := float64(windowWidth * windowHeight)
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*covariance + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+
+	return total / float64(windows)
+}
+
+// searchJPEGQuality binary searches the lowest JPEG quality whose decoded
+// output reaches the target SSIM against img. When even the highest quality
+// falls short of the target, it is used anyway.
+func searchJPEGQuality(img image.Image, target float64) (int, float64, error) {
+	source := newLumaPlane(img)
+
+	measure := func(quality int) (float64, error) {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return 0, fmt.Errorf("failed to encode JPEG: %v", err)
+		}
+
+		decoded, err := jpeg.Decode(&buf)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode JPEG: %v", err)
+		}
+
+		return computeSSIM(source, newLumaPlane(decoded)), nil
+	}
+
+	bestQuality := 100
+	bestScore, err := measure(bestQuality)
+	if err != nil {
+		return 0, 0, err
+	}
+	if bestScore < target {
+		return bestQuality, bestScore, nil
+	}
+
+	low, high := 1, 99
+	for low <= high {
+		quality := (low + high) / 2
+		score, err := measure(quality)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if score >= target {
+			bestQuality, bestScore = quality, score
+			high = quality - 1
+		} else {
+			low = quality + 1
+		}
+	}
+
+	return bestQuality, bestScore, nil
+}
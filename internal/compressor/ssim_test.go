@@ -0,0 +1,82 @@
+package compressor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createPhotoImage creates a photo-like image with smooth areas and fine details
+func createPhotoImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			detail := uint8(((x*2654435761 ^ y*40503) >> 9) % 32)
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x*200/width) + detail, G: uint8(y*200/height) + detail, B: 100 + detail, A: 255})
+		}
+	}
+
+	return img
+}
+
+func TestComputeSSIM(t *testing.T) {
+	src := createPhotoImage(64, 48)
+	luma := newLumaPlane(src)
+
+	assert.InDelta(t, 1.0, computeSSIM(luma, luma), 1e-9)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, src, &jpeg.Options{Quality: 10}))
+	decoded, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+
+	score := computeSSIM(luma, newLumaPlane(decoded))
+	assert.Less(t, score, 0.99)
+	assert.Greater(t, score, 0.0)
+}
+
+func TestSearchJPEGQuality(t *testing.T) {
+	src := createPhotoImage(96, 64)
+
+	lowQuality, lowScore, err := searchJPEGQuality(src, 0.80)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, lowScore, 0.80)
+
+	highQuality, highScore, err := searchJPEGQuality(src, 0.97)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, highScore, 0.97)
+	assert.Greater(t, highQuality, lowQuality)
+
+	// An unreachable target falls back to the highest quality
+	quality, score, err := searchJPEGQuality(src, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 100, quality)
+	assert.Less(t, score, 1.0)
+}
+
+func TestImageCompressor_CompressFile_TargetSSIM(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "photo.jpg")
+	outputPath := filepath.Join(tempDir, "compressed_photo.jpg")
+
+	file, err := os.Create(inputPath)
+	require.NoError(t, err)
+	require.NoError(t, jpeg.Encode(file, createPhotoImage(96, 64), &jpeg.Options{Quality: 95}))
+	require.NoError(t, file.Close())
+
+	compressor := NewImageCompressor()
+	require.NoError(t, compressor.SetTargetSSIM(0.9))
+
+	result, err := compressor.CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, result.SSIM, 0.9)
+	assert.Greater(t, result.Quality, 0)
+	assert.Less(t, result.Quality, 100)
+}
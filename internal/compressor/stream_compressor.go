@@ -0,0 +1,210 @@
+package compressor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/jdecool/file-compressor/internal/logger"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Formats of the stream compressor
+const (
+	StreamFormatGzip   = "gzip"
+	StreamFormatZstd   = "zstd"
+	StreamFormatBrotli = "brotli"
+)
+
+// StreamFormats lists the formats of the stream compressor
+var StreamFormats = []string{StreamFormatGzip, StreamFormatZstd, StreamFormatBrotli}
+
+type streamFormat struct {
+	extension    string
+	defaultLevel int
+	maxLevel     int
+}
+
+var streamFormats = map[string]streamFormat{
+	StreamFormatGzip:   {extension: ".gz", defaultLevel: 6, maxLevel: 9},
+	StreamFormatZstd:   {extension: ".zst", defaultLevel: 3, maxLevel: 22},
+	StreamFormatBrotli: {extension: ".br", defaultLevel: 6, maxLevel: 11},
+}
+
+// StreamCompressor compresses text files as a whole into a gzip, Zstandard or
+// Brotli file, the extension of the format being appended to the output path
+type StreamCompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	format             string
+	level              int
+}
+
+func NewStreamCompressor() *StreamCompressor {
+	return &StreamCompressor{
+		supportedMimeTypes: []string{
+			"text/plain",
+			"text/csv",
+			"text/html",
+			"text/css",
+			"text/javascript",
+			"application/javascript",
+			"application/json",
+			"application/xml",
+			"text/xml",
+		},
+		logger: logger.NewLogger(false),
+		format: StreamFormatGzip,
+	}
+}
+
+func (sc *StreamCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	format := streamFormats[sc.format]
+	outputPath += format.extension
+	level := sc.effectiveLevel()
+
+	sc.logger.PrintfVerbose("Stream Compressor: Compressing file %s to %s (%s level %d)\n", filepath.Base(filePath), filepath.Base(outputPath), sc.format, level)
+
+	originalFileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original file info: %v", err)
+	}
+
+	if err := compressStream(filePath, outputPath, sc.format, level); err != nil {
+		return nil, err
+	}
+
+	compressedFileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get compressed file info: %v", err)
+	}
+
+	return &CompressionResult{
+		OriginalFile:   filePath,
+		CompressedFile: outputPath,
+		OriginalSize:   originalFileInfo.Size(),
+		CompressedSize: compressedFileInfo.Size(),
+		Extension:      format.extension,
+	}, nil
+}
+
+// compressStream writes a file compressed in the given format to the output
+// path, the output being removed when the compression fails
+func compressStream(filePath, outputPath, format string, level int) error {
+	if err := writeStream(filePath, outputPath, format, level); err != nil {
+		_ = os.Remove(outputPath)
+
+		return err
+	}
+
+	return nil
+}
+
+func writeStream(filePath, outputPath, format string, level int) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	dst, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer dst.Close()
+
+	w, err := newStreamWriter(dst, format, info, level)
+	if err != nil {
+		return fmt.Errorf("failed to create %s writer: %v", format, err)
+	}
+
+	if _, err := io.Copy(w, src); err != nil {
+		return fmt.Errorf("failed to compress file: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s stream: %v", format, err)
+	}
+
+	return dst.Close()
+}
+
+func newStreamWriter(w io.Writer, format string, info os.FileInfo, level int) (io.WriteCloser, error) {
+	switch format {
+	case StreamFormatZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	case StreamFormatBrotli:
+		return brotli.NewWriterLevel(w, level), nil
+	default:
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		// Like gzip, record the name and modification time of the original file
+		gw.Name = info.Name()
+		gw.ModTime = info.ModTime()
+
+		return gw, nil
+	}
+}
+
+// effectiveLevel returns the level used with the current format, the default
+// one of the format when no level was set
+func (sc *StreamCompressor) effectiveLevel() int {
+	format := streamFormats[sc.format]
+	if sc.level == 0 {
+		return format.defaultLevel
+	}
+
+	return min(sc.level, format.maxLevel)
+}
+
+func (sc *StreamCompressor) GetSupportedMimeTypes() []string {
+	return sc.supportedMimeTypes
+}
+
+func (sc *StreamCompressor) SetLogger(logger *logger.Logger) {
+	sc.logger = logger
+}
+
+// SetFormat sets the format of the compressed files (gzip, zstd, brotli)
+func (sc *StreamCompressor) SetFormat(name string) error {
+	name = strings.ToLower(name)
+	if !slices.Contains(StreamFormats, name) {
+		return fmt.Errorf("unknown stream format %q, expected one of: %s", name, strings.Join(StreamFormats, ", "))
+	}
+
+	sc.format = name
+
+	return nil
+}
+
+// SetLevel sets the compression level, from 1 to 9 with gzip, 22 with zstd
+// and 11 with brotli. 0 selects the default level of the format.
+func (sc *StreamCompressor) SetLevel(level int) error {
+	if level < 0 {
+		sc.level = 0
+
+		return fmt.Errorf("compression level cannot be negative, setting to 0 (format default)")
+	}
+
+	if maxLevel := streamFormats[sc.format].maxLevel; level > maxLevel {
+		sc.level = maxLevel
+
+		return fmt.Errorf("%s compression level cannot exceed %d, setting to %d", sc.format, maxLevel, maxLevel)
+	}
+
+	sc.level = level
+
+	return nil
+}
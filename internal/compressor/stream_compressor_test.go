@@ -0,0 +1,150 @@
+package compressor
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStreamCompressor(t *testing.T) {
+	compressor := NewStreamCompressor()
+
+	assert.Equal(t, StreamFormatGzip, compressor.format)
+	assert.Equal(t, 6, compressor.effectiveLevel())
+	assert.Contains(t, compressor.GetSupportedMimeTypes(), "text/plain")
+	assert.Contains(t, compressor.GetSupportedMimeTypes(), "application/json")
+}
+
+func TestStreamCompressor_CompressFile(t *testing.T) {
+	content := []byte(strings.Repeat("name,city,country\nJohn,Paris,France\n", 500))
+
+	tests := []struct {
+		format    string
+		extension string
+		decode    func(r io.Reader) (io.Reader, error)
+	}{
+		{StreamFormatGzip, ".gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{StreamFormatZstd, ".zst", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{StreamFormatBrotli, ".br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "data.csv")
+			outputPath := filepath.Join(tempDir, "compressed_data.csv")
+			require.NoError(t, os.WriteFile(inputPath, content, 0644))
+
+			compressor := NewStreamCompressor()
+			require.NoError(t, compressor.SetFormat(tt.format))
+
+			result, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, outputPath+tt.extension, result.CompressedFile)
+			assert.Equal(t, tt.extension, result.Extension)
+			assert.Equal(t, int64(len(content)), result.OriginalSize)
+			assert.True(t, result.IsPositiveSavings())
+			assert.NoFileExists(t, outputPath)
+
+			compressed, err := os.ReadFile(result.CompressedFile)
+			require.NoError(t, err)
+			assert.Equal(t, result.CompressedSize, int64(len(compressed)))
+
+			r, err := tt.decode(bytes.NewReader(compressed))
+			require.NoError(t, err)
+			decoded, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(content, decoded))
+		})
+	}
+}
+
+func TestStreamCompressor_GzipHeader(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "notes.txt")
+	require.NoError(t, os.WriteFile(inputPath, []byte("some notes"), 0644))
+	info, err := os.Stat(inputPath)
+	require.NoError(t, err)
+
+	result, err := NewStreamCompressor().CompressFile(inputPath, filepath.Join(tempDir, "compressed_notes.txt"))
+	require.NoError(t, err)
+
+	file, err := os.Open(result.CompressedFile)
+	require.NoError(t, err)
+	defer file.Close()
+
+	r, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", r.Name)
+	assert.Equal(t, info.ModTime().Unix(), r.ModTime.Unix())
+}
+
+func TestStreamCompressor_CompressFileMissing(t *testing.T) {
+	tempDir := t.TempDir()
+
+	_, err := NewStreamCompressor().CompressFile(filepath.Join(tempDir, "missing.txt"), filepath.Join(tempDir, "compressed_missing.txt"))
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(tempDir, "compressed_missing.txt.gz"))
+}
+
+func TestStreamCompressor_SetFormat(t *testing.T) {
+	compressor := NewStreamCompressor()
+
+	assert.NoError(t, compressor.SetFormat("Brotli"))
+	assert.Equal(t, StreamFormatBrotli, compressor.format)
+
+	err := compressor.SetFormat("bzip2")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gzip, zstd, brotli")
+	assert.Equal(t, StreamFormatBrotli, compressor.format)
+}
+
+func TestStreamCompressor_SetLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		level    int
+		expected int
+		err      bool
+	}{
+		{"Default gzip", StreamFormatGzip, 0, 6, false},
+		{"Default zstd", StreamFormatZstd, 0, 3, false},
+		{"Default brotli", StreamFormatBrotli, 0, 6, false},
+		{"Valid gzip", StreamFormatGzip, 9, 9, false},
+		{"Valid zstd", StreamFormatZstd, 19, 19, false},
+		{"Negative", StreamFormatGzip, -1, 6, true},
+		{"Gzip too high", StreamFormatGzip, 10, 9, true},
+		{"Brotli too high", StreamFormatBrotli, 12, 11, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressor := NewStreamCompressor()
+			require.NoError(t, compressor.SetFormat(tt.format))
+
+			err := compressor.SetLevel(tt.level)
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, compressor.effectiveLevel())
+		})
+	}
+
+	// A level set for another format is capped to the maximum of the format
+	compressor := NewStreamCompressor()
+	require.NoError(t, compressor.SetFormat(StreamFormatZstd))
+	require.NoError(t, compressor.SetLevel(22))
+	require.NoError(t, compressor.SetFormat(StreamFormatGzip))
+	assert.Equal(t, 9, compressor.effectiveLevel())
+}
@@ -0,0 +1,129 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/svg"
+)
+
+// SvgCompressor optimizes SVG documents: editor data, comments and hidden
+// elements are removed, useless groups collapsed, and path data, numbers and
+// attributes minified outside of texts, whose whitespace separates words
+type SvgCompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	precision          int
+}
+
+func NewSvgCompressor() *SvgCompressor {
+	return &SvgCompressor{
+		supportedMimeTypes: []string{"image/svg+xml"},
+		logger:             logger.NewLogger(false),
+		precision:          6,
+	}
+}
+
+func (sc *SvgCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	sc.logger.PrintfVerbose("SVG Compressor: Compressing file %s to %s\n", filepath.Base(filePath), filepath.Base(outputPath))
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SVG file: %v", err)
+	}
+
+	doc, err := parseSVG(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SVG: %v", err)
+	}
+
+	stats := doc.optimize()
+	if stats.collapsedGroups > 0 {
+		sc.logger.PrintfVerbose("SVG Compressor: Collapsed %d groups\n", stats.collapsedGroups)
+	}
+
+	// Texts are left out of the minifier, with a placeholder prefix which is
+	// not in the document
+	prefix := "svg-text"
+	for bytes.Contains(data, []byte(prefix)) {
+		prefix += "-"
+	}
+	texts := protectSVGText(doc.root, prefix)
+
+	var tree bytes.Buffer
+	doc.root.write(&tree)
+
+	var optimized bytes.Buffer
+	for _, stylesheet := range doc.stylesheets {
+		optimized.WriteString("<?xml-stylesheet " + stylesheet + "?>")
+	}
+
+	m := minify.New()
+	m.AddFunc("text/css", css.Minify)
+	m.Add("image/svg+xml", &svg.Minifier{Precision: sc.precision})
+	if err := m.Minify("image/svg+xml", &optimized, &tree); err != nil {
+		return nil, fmt.Errorf("failed to minify SVG: %v", err)
+	}
+	output := []byte(texts.Replace(optimized.String()))
+
+	// A document which cannot be read back never replaces the original one
+	if _, err := parseSVG(bytes.NewReader(output)); err != nil {
+		return nil, fmt.Errorf("optimized SVG is not well-formed: %v", err)
+	}
+
+	if err := os.WriteFile(outputPath, output, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write SVG file: %v", err)
+	}
+
+	var removed []string
+	if stats.editorNodes > 0 {
+		removed = append(removed, countLabel(stats.editorNodes, "editor item"))
+	}
+	if doc.comments > 0 {
+		removed = append(removed, countLabel(doc.comments, "comment"))
+	}
+	if stats.hiddenElements > 0 {
+		removed = append(removed, countLabel(stats.hiddenElements, "hidden element"))
+	}
+
+	return &CompressionResult{
+		OriginalFile:   filePath,
+		CompressedFile: outputPath,
+		OriginalSize:   int64(len(data)),
+		CompressedSize: int64(len(output)),
+		Removed:        removed,
+	}, nil
+}
+
+func (sc *SvgCompressor) GetSupportedMimeTypes() []string {
+	return sc.supportedMimeTypes
+}
+
+func (sc *SvgCompressor) SetLogger(logger *logger.Logger) {
+	sc.logger = logger
+}
+
+// SetPrecision sets the number of significant digits kept in coordinates and
+// lengths, 0 keeping all of them
+func (sc *SvgCompressor) SetPrecision(digits int) error {
+	if digits < 0 {
+		sc.precision = 0
+
+		return fmt.Errorf("SVG precision cannot be negative, setting to 0 (all digits)")
+	}
+
+	if digits > 15 {
+		sc.precision = 15
+
+		return fmt.Errorf("SVG precision cannot exceed 15, setting to 15")
+	}
+
+	sc.precision = digits
+
+	return nil
+}
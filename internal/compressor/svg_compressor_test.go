@@ -0,0 +1,185 @@
+package compressor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testInkscapeSVG = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!-- Created with Inkscape (http://www.inkscape.org/) -->
+<svg
+   xmlns:dc="http://purl.org/dc/elements/1.1/"
+   xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+   xmlns="http://www.w3.org/2000/svg"
+   xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd"
+   xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape"
+   width="210mm"
+   height="297mm"
+   viewBox="0 0 210.00000 297.00000"
+   version="1.1"
+   inkscape:version="1.0"
+   sodipodi:docname="drawing.svg">
+  <defs>
+    <linearGradient id="gradient"><stop offset="0" style="stop-color:#ff0000;stop-opacity:1"/></linearGradient>
+  </defs>
+  <sodipodi:namedview pagecolor="#ffffff" inkscape:zoom="0.35" />
+  <metadata>
+    <rdf:RDF><dc:format>image/svg+xml</dc:format></rdf:RDF>
+  </metadata>
+  <g inkscape:label="Layer 1" inkscape:groupmode="layer">
+    <g>
+      <path style="fill:url(#gradient);stroke:#000000;stroke-width:0.26458332px" d="M 10.123456789,20.000000 L 30.500000,40.250000 L 50.000000,20.000000 Z" />
+    </g>
+    <rect width="0" height="10" fill="red"/>
+  </g>
+</svg>
+`
+
+const testIllustratorSVG = `<?xml version="1.0" encoding="utf-8"?>
+<!-- Generator: Adobe Illustrator 24.0.0, SVG Export Plug-In . SVG Version: 6.00 Build 0)  -->
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd" [
+	<!ENTITY ns_extend "http://ns.adobe.com/Extensibility/1.0/">
+	<!ENTITY ns_ai "http://ns.adobe.com/AdobeIllustrator/10.0/">
+	<!ENTITY ns_svg "http://www.w3.org/2000/svg">
+]>
+<svg version="1.1" xmlns:x="&ns_extend;" xmlns:i="&ns_ai;" xmlns="&ns_svg;" viewBox="0 0 100 100">
+<switch>
+	<foreignObject requiredExtensions="&ns_ai;" x="0" y="0" width="1" height="1">
+		<i:pgfRef i:pgfRef="adobe_illustrator_pgf"></i:pgfRef>
+	</foreignObject>
+	<g i:extraneous="self">
+		<circle fill="#0000FF" cx="50.000" cy="50.000" r="40.000"/>
+	</g>
+</switch>
+<i:pgf id="adobe_illustrator_pgf">eJzLSM3JyVcozy/KSQEAGgQEXQ==</i:pgf>
+</svg>
+`
+
+func TestSvgCompressor_CompressFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		expected string
+		removed  []string
+	}{
+		{
+			"Inkscape",
+			testInkscapeSVG,
+			`<svg xmlns="http://www.w3.org/2000/svg" width="210mm" height="297mm" viewBox="0 0 210 297">` +
+				`<defs><linearGradient id="gradient"><stop offset="0" style="stop-color:#ff0000;stop-opacity:1"/></linearGradient></defs>` +
+				`<path style="fill:url(#gradient);stroke:#000;stroke-width:.26458332px" d="M10.1235 20 30.5 40.25 50 20z"/></svg>`,
+			[]string{"10 editor items", "1 comment", "1 hidden element"},
+		},
+		{
+			"Illustrator",
+			testIllustratorSVG,
+			`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100"><switch><g><circle fill="#00F" cx="50" cy="50" r="40"/></g></switch></svg>`,
+			[]string{"5 editor items", "1 comment"},
+		},
+		{
+			"Mixed content text",
+			`<svg xmlns="http://www.w3.org/2000/svg">
+  <text x="10.000" y="20">Hello <tspan font-weight="bold">big</tspan> world</text>
+  <g><text>Tag &lt;svg-text-0/&gt;</text></g>
+</svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><text x="10.000" y="20">Hello <tspan font-weight="bold">big</tspan> world</text>` +
+				`<text>Tag &lt;svg-text-0/&gt;</text></svg>`,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "drawing.svg")
+			outputPath := filepath.Join(tempDir, "compressed_drawing.svg")
+			require.NoError(t, os.WriteFile(inputPath, []byte(tt.document), 0644))
+
+			result, err := NewSvgCompressor().CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			output, err := os.ReadFile(outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(output))
+			assert.Equal(t, tt.removed, result.Removed)
+			assert.Equal(t, int64(len(tt.document)), result.OriginalSize)
+			assert.Equal(t, int64(len(output)), result.CompressedSize)
+		})
+	}
+}
+
+func TestSvgCompressor_CompressFileKeepsStylesheets(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "icon.svg")
+	outputPath := filepath.Join(tempDir, "compressed_icon.svg")
+	document := `<?xml-stylesheet href="theme.css" type="text/css"?>
+<svg xmlns="http://www.w3.org/2000/svg"><style type="text/css">
+  .icon { fill: #ff0000; }
+</style><g><path class="icon" d="M 0 0 L 10 10"/></g></svg>`
+	require.NoError(t, os.WriteFile(inputPath, []byte(document), 0644))
+
+	_, err := NewSvgCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, `<?xml-stylesheet href="theme.css" type="text/css"?><svg xmlns="http://www.w3.org/2000/svg">`+
+		`<style>.icon{fill:red}</style><g><path class="icon" d="M0 0 10 10"/></g></svg>`, string(output))
+}
+
+func TestSvgCompressor_Precision(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "shape.svg")
+	outputPath := filepath.Join(tempDir, "compressed_shape.svg")
+	require.NoError(t, os.WriteFile(inputPath, []byte(`<svg xmlns="http://www.w3.org/2000/svg"><path d="M 1.23456789 98.7654321"/></svg>`), 0644))
+
+	tests := []struct {
+		precision int
+		expected  string
+	}{
+		{0, `d="M1.23456789 98.7654321"`},
+		{6, `d="M1.23457 98.7654"`},
+		{3, `d="M1.23 98.8"`},
+	}
+
+	for _, tt := range tests {
+		compressor := NewSvgCompressor()
+		require.NoError(t, compressor.SetPrecision(tt.precision))
+
+		_, err := compressor.CompressFile(inputPath, outputPath)
+		require.NoError(t, err)
+
+		output, err := os.ReadFile(outputPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(output), tt.expected, "precision %d", tt.precision)
+	}
+}
+
+func TestSvgCompressor_CompressFileInvalid(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "broken.svg")
+	outputPath := filepath.Join(tempDir, "compressed_broken.svg")
+	require.NoError(t, os.WriteFile(inputPath, []byte(`<svg xmlns="http://www.w3.org/2000/svg"><g></svg>`), 0644))
+
+	_, err := NewSvgCompressor().CompressFile(inputPath, outputPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse SVG")
+	assert.NoFileExists(t, outputPath)
+}
+
+func TestSvgCompressor_SetPrecision(t *testing.T) {
+	compressor := NewSvgCompressor()
+
+	assert.NoError(t, compressor.SetPrecision(4))
+	assert.Equal(t, 4, compressor.precision)
+
+	assert.Error(t, compressor.SetPrecision(-1))
+	assert.Equal(t, 0, compressor.precision)
+
+	assert.Error(t, compressor.SetPrecision(20))
+	assert.Equal(t, 15, compressor.precision)
+}
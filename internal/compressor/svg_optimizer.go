@@ -0,0 +1,551 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// svgEditorNamespaces are the namespaces of the private data drawing
+// applications store in SVG documents, which renderers ignore
+var svgEditorNamespaces = map[string]bool{
+	"http://www.inkscape.org/namespaces/inkscape":        true,
+	"http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd": true,
+	"http://ns.adobe.com/AdobeIllustrator/10.0/":         true,
+	"http://ns.adobe.com/AdobeSVGViewerExtensions/3.0/":  true,
+	"http://ns.adobe.com/Extensibility/1.0/":             true,
+	"http://ns.adobe.com/Flows/1.0/":                     true,
+	"http://ns.adobe.com/GenericCustomNamespace/1.0/":    true,
+	"http://ns.adobe.com/Graphs/1.0/":                    true,
+	"http://ns.adobe.com/ImageReplacement/1.0/":          true,
+	"http://ns.adobe.com/SaveForWeb/1.0/":                true,
+	"http://ns.adobe.com/Variables/1.0/":                 true,
+	"http://ns.adobe.com/XPath/1.0/":                     true,
+	"http://www.bohemiancoding.com/sketch/ns":            true,
+	"http://www.serif.com/":                              true,
+	"http://creativecommons.org/ns#":                     true,
+	"http://purl.org/dc/elements/1.1/":                   true,
+	"http://www.w3.org/1999/02/22-rdf-syntax-ns#":        true,
+}
+
+// svgDynamicElements make the rendering depend on more than the tree, style
+// sheets matching elements by their position, and scripts and animations
+// addressing them
+var svgDynamicElements = map[string]bool{
+	"style":            true,
+	"script":           true,
+	"animate":          true,
+	"animateColor":     true,
+	"animateMotion":    true,
+	"animateTransform": true,
+	"set":              true,
+}
+
+// svgInheritedAttributes are the presentation attributes inherited by the
+// children of a group, which can be moved from a group to its only child
+var svgInheritedAttributes = map[string]bool{
+	"clip-rule": true, "color": true, "color-interpolation": true, "color-interpolation-filters": true,
+	"color-profile": true, "color-rendering": true, "cursor": true, "direction": true,
+	"fill": true, "fill-opacity": true, "fill-rule": true, "font": true, "font-family": true,
+	"font-size": true, "font-size-adjust": true, "font-stretch": true, "font-style": true,
+	"font-variant": true, "font-weight": true, "glyph-orientation-horizontal": true,
+	"glyph-orientation-vertical": true, "image-rendering": true, "kerning": true,
+	"letter-spacing": true, "marker": true, "marker-start": true, "marker-mid": true,
+	"marker-end": true, "paint-order": true, "pointer-events": true, "shape-rendering": true,
+	"stroke": true, "stroke-dasharray": true, "stroke-dashoffset": true, "stroke-linecap": true,
+	"stroke-linejoin": true, "stroke-miterlimit": true, "stroke-opacity": true, "stroke-width": true,
+	"text-anchor": true, "text-rendering": true, "visibility": true, "word-spacing": true,
+	"writing-mode": true,
+}
+
+// svgGraphicsElements are the elements a group can hand its attributes over to
+var svgGraphicsElements = map[string]bool{
+	"g": true, "path": true, "rect": true, "circle": true, "ellipse": true, "line": true,
+	"polyline": true, "polygon": true, "text": true, "use": true, "image": true,
+}
+
+var (
+	svgEntityPattern = regexp.MustCompile(`<!ENTITY\s+([\w.:-]+)\s+(?:"([^"]*)"|'([^']*)')\s*>`)
+	svgURLPattern    = regexp.MustCompile(`url\(\s*['"]?#([^'")\s]+)`)
+)
+
+// svgNode is an element of an SVG document, or character data when its name
+// is empty. Names keep the prefix they are written with.
+type svgNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*svgNode
+	text     string
+}
+
+// svgDocument is the element tree of an SVG document, without its comments,
+// declarations and processing instructions
+type svgDocument struct {
+	root *svgNode
+	// stylesheets are the xml-stylesheet processing instructions, which style
+	// the document and are kept
+	stylesheets []string
+	comments    int
+}
+
+// svgOptimization counts the changes made to an SVG document
+type svgOptimization struct {
+	editorNodes     int
+	hiddenElements  int
+	collapsedGroups int
+}
+
+func svgQualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+// parseSVG reads the element tree of an SVG document. Entities declared by the
+// document type, such as the namespaces of Illustrator, are expanded.
+func parseSVG(r io.Reader) (*svgDocument, error) {
+	doc := &svgDocument{}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Entity = map[string]string{}
+
+	var stack []*svgNode
+	for {
+		offset := d.InputOffset()
+		token, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			attrs, err := normalizeXMLAttributes(d, data[offset:d.InputOffset()], token.Attr)
+			if err != nil {
+				return nil, err
+			}
+
+			node := &svgNode{name: svgQualifiedName(token.Name), attrs: attrs}
+			if len(stack) == 0 {
+				if doc.root != nil {
+					return nil, fmt.Errorf("document has several root elements")
+				}
+				doc.root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1].name != svgQualifiedName(token.Name) {
+				return nil, fmt.Errorf("unexpected end element %s", svgQualifiedName(token.Name))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, &svgNode{text: string(token)})
+			}
+		case xml.Comment:
+			doc.comments++
+		case xml.ProcInst:
+			if token.Target == "xml-stylesheet" {
+				doc.stylesheets = append(doc.stylesheets, string(token.Inst))
+			}
+		case xml.Directive:
+			for _, match := range svgEntityPattern.FindAllStringSubmatch(string(token), -1) {
+				d.Entity[match[1]] = match[2] + match[3]
+			}
+		}
+	}
+
+	if doc.root == nil || len(stack) > 0 {
+		return nil, fmt.Errorf("document is incomplete")
+	}
+	if doc.root.local() != "svg" {
+		return nil, fmt.Errorf("root element is %s, not svg", doc.root.name)
+	}
+
+	return doc, nil
+}
+
+// local returns the name of an element without its prefix
+func (n *svgNode) local() string {
+	if _, local, found := strings.Cut(n.name, ":"); found {
+		return local
+	}
+
+	return n.name
+}
+
+func (n *svgNode) prefix() string {
+	prefix, _, _ := strings.Cut(n.name, ":")
+	if prefix == n.name {
+		return ""
+	}
+
+	return prefix
+}
+
+func (n *svgNode) attr(name string) (string, bool) {
+	for _, attr := range n.attrs {
+		if svgQualifiedName(attr.Name) == name {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func (n *svgNode) setAttr(name, value string) {
+	for i, attr := range n.attrs {
+		if svgQualifiedName(attr.Name) == name {
+			n.attrs[i].Value = value
+			return
+		}
+	}
+
+	space, local, found := strings.Cut(name, ":")
+	if !found {
+		space, local = "", name
+	}
+	n.attrs = append(n.attrs, xml.Attr{Name: xml.Name{Space: space, Local: local}, Value: value})
+}
+
+// elements returns the child elements of a node, and whether it has text
+// other than whitespace
+func (n *svgNode) elements() ([]*svgNode, bool) {
+	var elements []*svgNode
+	text := false
+	for _, child := range n.children {
+		if child.name != "" {
+			elements = append(elements, child)
+		} else if strings.TrimSpace(child.text) != "" {
+			text = true
+		}
+	}
+
+	return elements, text
+}
+
+// walk calls fn for a node and all the elements below it
+func (n *svgNode) walk(fn func(*svgNode)) {
+	fn(n)
+	for _, child := range n.children {
+		if child.name != "" {
+			child.walk(fn)
+		}
+	}
+}
+
+// referencedBy reports whether the element or one of its descendants has an
+// identifier referenced in the document
+func (n *svgNode) referencedBy(referenced map[string]bool) bool {
+	found := false
+	n.walk(func(node *svgNode) {
+		if id, ok := node.attr("id"); ok && referenced[id] {
+			found = true
+		}
+	})
+
+	return found
+}
+
+// optimize removes editor data from the document and, when the rendering
+// depends on the tree only, removes hidden elements and collapses groups
+func (doc *svgDocument) optimize() svgOptimization {
+	var stats svgOptimization
+	stats.editorNodes = doc.removeEditorData()
+
+	dynamic := false
+	referenced := map[string]bool{}
+	doc.root.walk(func(node *svgNode) {
+		if svgDynamicElements[node.local()] {
+			dynamic = true
+		}
+		for _, attr := range node.attrs {
+			if strings.HasPrefix(attr.Name.Local, "on") {
+				dynamic = true
+			}
+			if attr.Name.Local == "href" && strings.HasPrefix(attr.Value, "#") {
+				referenced[attr.Value[1:]] = true
+			}
+			for _, match := range svgURLPattern.FindAllStringSubmatch(attr.Value, -1) {
+				referenced[match[1]] = true
+			}
+		}
+	})
+	if dynamic {
+		return stats
+	}
+
+	stats.hiddenElements = removeHiddenSVGElements(doc.root, false, referenced)
+	stats.collapsedGroups = collapseSVGGroups(doc.root, referenced)
+
+	return stats
+}
+
+// removeEditorData removes the elements and attributes in the namespaces of
+// drawing applications, the metadata elements and the foreign objects only
+// Illustrator reads, and returns the number of nodes removed
+func (doc *svgDocument) removeEditorData() int {
+	prefixes := map[string]bool{}
+	doc.root.walk(func(node *svgNode) {
+		for _, attr := range node.attrs {
+			if attr.Name.Space == "xmlns" && svgEditorNamespaces[attr.Value] {
+				prefixes[attr.Name.Local] = true
+			}
+		}
+	})
+
+	removed := 0
+	var clean func(node *svgNode)
+	clean = func(node *svgNode) {
+		attrs := node.attrs[:0]
+		for _, attr := range node.attrs {
+			if prefixes[attr.Name.Space] || attr.Name.Space == "xmlns" && prefixes[attr.Name.Local] {
+				removed++
+				continue
+			}
+			attrs = append(attrs, attr)
+		}
+		node.attrs = attrs
+
+		children := node.children[:0]
+		for _, child := range node.children {
+			if child.name != "" {
+				extensions, _ := child.attr("requiredExtensions")
+				if prefixes[child.prefix()] || child.local() == "metadata" || child.local() == "foreignObject" && svgEditorNamespaces[extensions] {
+					removed++
+					continue
+				}
+				clean(child)
+			}
+			children = append(children, child)
+		}
+		node.children = children
+	}
+	clean(doc.root)
+
+	return removed
+}
+
+// styleValue returns the value of a property in the style attribute of an
+// element
+func (n *svgNode) styleValue(property string) (string, bool) {
+	style, _ := n.attr("style")
+	for _, declaration := range strings.Split(style, ";") {
+		if name, value, found := strings.Cut(declaration, ":"); found && strings.TrimSpace(name) == property {
+			return strings.TrimSpace(value), true
+		}
+	}
+
+	return "", false
+}
+
+// property returns the value of a presentation property, the style attribute
+// taking precedence over the presentation attribute
+func (n *svgNode) property(name string) (string, bool) {
+	if value, ok := n.styleValue(name); ok {
+		return value, true
+	}
+	value, ok := n.attr(name)
+
+	return strings.TrimSpace(value), ok
+}
+
+func svgIsZero(value string) bool {
+	number, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 64)
+
+	return err == nil && number == 0
+}
+
+// hidden reports whether an element renders nothing, opacity being ignored by
+// clipping paths
+func (n *svgNode) hidden(inClipPath bool) bool {
+	if display, _ := n.property("display"); display == "none" {
+		return true
+	}
+	if opacity, ok := n.property("opacity"); ok && svgIsZero(opacity) && !inClipPath {
+		return true
+	}
+
+	zero := func(name string) bool {
+		value, ok := n.attr(name)
+		return ok && svgIsZero(value)
+	}
+	empty := func(name string) bool {
+		value, _ := n.attr(name)
+		return strings.TrimSpace(value) == ""
+	}
+
+	switch n.local() {
+	case "rect":
+		return zero("width") || zero("height")
+	case "circle":
+		return zero("r")
+	case "ellipse":
+		return zero("rx") || zero("ry")
+	case "path":
+		return empty("d")
+	case "polygon", "polyline":
+		return empty("points")
+	}
+
+	return false
+}
+
+// removeHiddenSVGElements removes the elements rendering nothing whose
+// identifiers, and those of their descendants, are not referenced, and returns
+// the number of elements removed
+func removeHiddenSVGElements(node *svgNode, inClipPath bool, referenced map[string]bool) int {
+	removed := 0
+	children := node.children[:0]
+	for _, child := range node.children {
+		if child.name != "" {
+			if child.hidden(inClipPath) && !child.referencedBy(referenced) {
+				removed++
+				continue
+			}
+			removed += removeHiddenSVGElements(child, inClipPath || child.local() == "clipPath", referenced)
+		}
+		children = append(children, child)
+	}
+	node.children = children
+
+	return removed
+}
+
+// collapseSVGGroups replaces the groups without attributes by their children,
+// moves the inherited attributes and transform of a group to its only child
+// unless the child is referenced, as the references would render them too,
+// removes the empty groups, and returns the number of groups removed
+func collapseSVGGroups(node *svgNode, referenced map[string]bool) int {
+	collapsed := 0
+	var children []*svgNode
+	for _, child := range node.children {
+		if child.name == "" {
+			children = append(children, child)
+			continue
+		}
+
+		collapsed += collapseSVGGroups(child, referenced)
+
+		// The children of a switch are alternatives
+		if child.local() != "g" || node.local() == "switch" {
+			children = append(children, child)
+			continue
+		}
+
+		elements, text := child.elements()
+		_, filter := child.attr("filter")
+		switch {
+		case text:
+			children = append(children, child)
+		case len(elements) == 0 && !filter && !child.referencedBy(referenced):
+			collapsed++
+		case len(child.attrs) == 0:
+			children = append(children, child.children...)
+			collapsed++
+		case len(elements) == 1 && !elements[0].referencedBy(referenced) && moveSVGGroupAttributes(child, elements[0]):
+			children = append(children, child.children...)
+			collapsed++
+		default:
+			children = append(children, child)
+		}
+	}
+	node.children = children
+
+	return collapsed
+}
+
+// moveSVGGroupAttributes moves the attributes of a group to its only child
+// when they are inherited attributes the child does not set, or a transform
+func moveSVGGroupAttributes(group, child *svgNode) bool {
+	if !svgGraphicsElements[child.local()] {
+		return false
+	}
+	for _, attr := range group.attrs {
+		name := svgQualifiedName(attr.Name)
+		if name == "transform" {
+			continue
+		}
+		if _, set := child.attr(name); !svgInheritedAttributes[name] || set {
+			return false
+		}
+		if _, set := child.styleValue(name); set {
+			return false
+		}
+	}
+
+	for _, attr := range group.attrs {
+		name := svgQualifiedName(attr.Name)
+		if transform, ok := child.attr("transform"); ok && name == "transform" {
+			child.setAttr(name, attr.Value+" "+transform)
+			continue
+		}
+		child.setAttr(name, attr.Value)
+	}
+
+	return true
+}
+
+// write serializes an element and its descendants
+func (n *svgNode) write(b *bytes.Buffer) {
+	if n.name == "" {
+		b.WriteString(xmlTextEscaper.Replace(n.text))
+		return
+	}
+
+	b.WriteString("<" + n.name)
+	for _, attr := range n.attrs {
+		b.WriteString(" " + svgQualifiedName(attr.Name) + `="` + xmlAttributeEscaper.Replace(attr.Value) + `"`)
+	}
+	if len(n.children) == 0 {
+		b.WriteString("/>")
+		return
+	}
+
+	b.WriteString(">")
+	for _, child := range n.children {
+		child.write(b)
+	}
+	b.WriteString("</" + n.name + ">")
+}
+
+// protectSVGText replaces the text elements below a node by empty elements
+// named after prefix, which the minifier keeps as is, and returns the replacer
+// writing the text elements back, as the minifier would remove the whitespace
+// between the words and the spans of a text
+func protectSVGText(node *svgNode, prefix string) *strings.Replacer {
+	var pairs []string
+	node.walk(func(n *svgNode) {
+		for i, child := range n.children {
+			if child.local() != "text" {
+				continue
+			}
+
+			var text bytes.Buffer
+			child.write(&text)
+
+			placeholder := &svgNode{name: fmt.Sprintf("%s-%d", prefix, len(pairs)/2)}
+			pairs = append(pairs, "<"+placeholder.name+"/>", text.String())
+			n.children[i] = placeholder
+		}
+	})
+
+	return strings.NewReplacer(pairs...)
+}
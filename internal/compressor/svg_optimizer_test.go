@@ -0,0 +1,194 @@
+package compressor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// optimizeSVG parses, optimizes and serializes an SVG document
+func optimizeSVG(t *testing.T, document string) (string, svgOptimization) {
+	doc, err := parseSVG(strings.NewReader(document))
+	require.NoError(t, err)
+
+	stats := doc.optimize()
+
+	var b bytes.Buffer
+	doc.root.write(&b)
+
+	return b.String(), stats
+}
+
+func TestParseSVG(t *testing.T) {
+	doc, err := parseSVG(strings.NewReader(`<?xml version="1.0"?>
+<?xml-stylesheet href="style.css" type="text/css"?>
+<!DOCTYPE svg [ <!ENTITY ns_svg "http://www.w3.org/2000/svg"> <!ENTITY label 'A and B'> ]>
+<!-- comment -->
+<svg xmlns="&ns_svg;"><text>&label;</text><!-- other --></svg>`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{`href="style.css" type="text/css"`}, doc.stylesheets)
+	assert.Equal(t, 2, doc.comments)
+
+	var b bytes.Buffer
+	doc.root.write(&b)
+	assert.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg"><text>A and B</text></svg>`, b.String())
+
+	tests := []struct {
+		name     string
+		document string
+		err      string
+	}{
+		{"Not SVG", `<html></html>`, "root element is html"},
+		{"Unclosed", `<svg><g></svg>`, "unexpected end element"},
+		{"Several roots", `<svg/><svg/>`, "several root elements"},
+		{"Empty", ``, "incomplete"},
+		{"Undeclared entity", `<svg>&undeclared;</svg>`, "undeclared"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSVG(strings.NewReader(tt.document))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestSVGDocument_RemoveEditorData(t *testing.T) {
+	output, stats := optimizeSVG(t, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"`+
+		` xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd"`+
+		` xmlns:i="http://ns.adobe.com/AdobeIllustrator/10.0/" inkscape:version="1.0">`+
+		`<sodipodi:namedview pagecolor="#ffffff"/>`+
+		`<metadata><title>Drawing</title></metadata>`+
+		`<switch><foreignObject requiredExtensions="http://ns.adobe.com/AdobeIllustrator/10.0/"><i:pgfRef/></foreignObject>`+
+		`<path d="M0 0h10" i:knockout="Off" inkscape:label="line"/></switch>`+
+		`<use xlink:href="#a"/></svg>`)
+
+	assert.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`+
+		`<switch><path d="M0 0h10"/></switch><use xlink:href="#a"/></svg>`, output)
+	// 4 namespace declarations and attributes of the root, 3 elements and 2 path attributes
+	assert.Equal(t, 9, stats.editorNodes)
+}
+
+func TestSVGDocument_RemoveHiddenElements(t *testing.T) {
+	tests := []struct {
+		name     string
+		element  string
+		expected int
+	}{
+		{"Display none", `<path d="M0 0h10" display="none"/>`, 1},
+		{"Display none in style", `<path d="M0 0h10" style="fill:red; display: none"/>`, 1},
+		{"Display overridden by style", `<path d="M0 0h10" display="none" style="display:inline"/>`, 0},
+		{"Transparent", `<circle r="5" opacity="0"/>`, 1},
+		{"Translucent", `<circle r="5" opacity="0.5"/>`, 0},
+		{"Empty rectangle", `<rect width="0" height="10"/>`, 1},
+		{"Rectangle", `<rect width="10" height="10"/>`, 0},
+		{"Empty circle", `<circle r="0px"/>`, 1},
+		{"Flat ellipse", `<ellipse rx="5" ry="0"/>`, 1},
+		{"Path without data", `<path d=" "/>`, 1},
+		{"Polygon without points", `<polygon/>`, 1},
+		{"Referenced", `<linearGradient id="fill" display="none"/><rect width="10" height="10" fill="url(#fill)"/>`, 0},
+		{"Referenced descendant", `<g display="none"><path id="p" d="M0 0h10"/></g><use href="#p"/>`, 0},
+		{"Transparent in clipping path", `<clipPath id="c"><rect width="10" height="10" opacity="0"/></clipPath><rect width="10" height="10" clip-path="url(#c)"/>`, 0},
+		{"Style sheet", `<style>.a{display:block}</style><path class="a" d="M0 0h10" display="none"/>`, 0},
+		{"Animation", `<circle r="0"><animate attributeName="r" to="10"/></circle>`, 0},
+		{"Script", `<rect width="0" height="10" onclick="grow(this)"/>`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stats := optimizeSVG(t, `<svg xmlns="http://www.w3.org/2000/svg">`+tt.element+`</svg>`)
+			assert.Equal(t, tt.expected, stats.hiddenElements)
+		})
+	}
+}
+
+func TestSVGDocument_CollapseGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			"Group without attributes",
+			`<g><path d="M0 0h10"/><path d="M0 5h10"/></g>`,
+			`<path d="M0 0h10"/><path d="M0 5h10"/>`,
+		},
+		{
+			"Nested groups",
+			`<g><g><g fill="red"><path d="M0 0h10"/></g></g></g>`,
+			`<path d="M0 0h10" fill="red"/>`,
+		},
+		{
+			"Transform merged",
+			`<g transform="translate(10)"><rect width="5" height="5" transform="scale(2)"/></g>`,
+			`<rect width="5" height="5" transform="translate(10) scale(2)"/>`,
+		},
+		{
+			"Attribute set by the child",
+			`<g fill="red"><path d="M0 0h10" fill="blue"/></g>`,
+			`<g fill="red"><path d="M0 0h10" fill="blue"/></g>`,
+		},
+		{
+			"Property set by the style of the child",
+			`<g fill="red"><path d="M0 0h10" style="fill:blue"/></g>`,
+			`<g fill="red"><path d="M0 0h10" style="fill:blue"/></g>`,
+		},
+		{
+			"Attribute not inherited",
+			`<g opacity="0.5"><path d="M0 0h10"/></g>`,
+			`<g opacity="0.5"><path d="M0 0h10"/></g>`,
+		},
+		{
+			"Group with an identifier",
+			`<g id="layer" fill="red"><path d="M0 0h10"/></g>`,
+			`<g id="layer" fill="red"><path d="M0 0h10"/></g>`,
+		},
+		{
+			"Child referenced by a use element",
+			`<g transform="translate(50 0)" fill="red"><path id="p" d="M0 0h10"/></g><use xlink:href="#p" fill="blue"/>`,
+			`<g transform="translate(50 0)" fill="red"><path id="p" d="M0 0h10"/></g><use xlink:href="#p" fill="blue"/>`,
+		},
+		{
+			"Several children",
+			`<g fill="red"><path d="M0 0h10"/><path d="M0 5h10"/></g>`,
+			`<g fill="red"><path d="M0 0h10"/><path d="M0 5h10"/></g>`,
+		},
+		{
+			"Empty group",
+			`<g fill="red"> </g><path d="M0 0h10"/>`,
+			`<path d="M0 0h10"/>`,
+		},
+		{
+			"Empty group with a filter",
+			`<g filter="url(#f)"/>`,
+			`<g filter="url(#f)"/>`,
+		},
+		{
+			"Alternatives of a switch",
+			`<switch><g><path d="M0 0h10"/></g></switch>`,
+			`<switch><g><path d="M0 0h10"/></g></switch>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, _ := optimizeSVG(t, `<svg>`+tt.content+`</svg>`)
+			assert.Equal(t, `<svg>`+tt.expected+`</svg>`, output)
+		})
+	}
+
+	// Style sheets may match elements by their groups
+	output, _ := optimizeSVG(t, `<svg><style>g path{fill:red}</style><g><path d="M0 0h10"/></g></svg>`)
+	assert.Contains(t, output, `<g><path d="M0 0h10"/></g>`)
+}
+
+func TestSVGNode_Write(t *testing.T) {
+	output, _ := optimizeSVG(t, `<svg><text title="&quot;a&quot; &lt; b">x &lt; y &amp;&amp; <![CDATA[y > z]]></text></svg>`)
+
+	assert.Equal(t, `<svg><text title="&quot;a&quot; &lt; b">x &lt; y &amp;&amp; y &gt; z</text></svg>`, output)
+}
@@ -0,0 +1,360 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+var (
+	// Carriage returns are escaped, parsers would read them as line feeds, and
+	// so are the tabs and line breaks of attribute values, read as spaces
+	xmlTextEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#13;")
+	xmlAttributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#9;", "\n", "&#10;", "\r", "&#13;")
+	// xmlAttributeNormalizer replaces the whitespace written in attribute
+	// values as XML parsers do, line breaks being normalized first
+	xmlAttributeNormalizer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\t", " ")
+)
+
+// xmlNode is a node of an XML document: an element, a text, a processing
+// instruction or a document type declaration
+type xmlNode struct {
+	kind     xmlNodeKind
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+}
+
+type xmlNodeKind int
+
+const (
+	xmlElement xmlNodeKind = iota
+	xmlText
+	xmlProcInst
+	xmlDirective
+)
+
+// XmlCompressor removes comments and insignificant whitespace from XML
+// documents and, when canonicalizing, sorts the attributes of their elements
+// and drops redundant namespace declarations. Whitespace is only removed
+// between the child elements of elements with no text at any depth, as it may
+// separate the words of mixed content or be the value of a leaf, unless
+// xml:space="preserve" applies, and documents which do not parse are never
+// written.
+type XmlCompressor struct {
+	supportedMimeTypes []string
+	logger             *logger.Logger
+	canonicalize       bool
+}
+
+func NewXmlCompressor() *XmlCompressor {
+	return &XmlCompressor{
+		supportedMimeTypes: []string{"application/xml", "text/xml"},
+		logger:             logger.NewLogger(false),
+	}
+}
+
+func (xc *XmlCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	xc.logger.PrintfVerbose("XML Compressor: Compressing file %s to %s\n", filepath.Base(filePath), filepath.Base(outputPath))
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read XML file: %v", err)
+	}
+
+	nodes, comments, err := parseXML(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %v", err)
+	}
+
+	var minified bytes.Buffer
+	for _, node := range nodes {
+		node.write(&minified, xc.canonicalize, false, map[string]string{"xml": xmlNamespace})
+	}
+
+	// A document which cannot be read back never replaces the original one
+	if _, _, err := parseXML(bytes.NewReader(minified.Bytes())); err != nil {
+		return nil, fmt.Errorf("minified XML is not well-formed: %v", err)
+	}
+
+	if err := os.WriteFile(outputPath, minified.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write XML file: %v", err)
+	}
+
+	var removed []string
+	if comments > 0 {
+		removed = append(removed, countLabel(comments, "comment"))
+	}
+
+	return &CompressionResult{
+		OriginalFile:   filePath,
+		CompressedFile: outputPath,
+		OriginalSize:   int64(len(data)),
+		CompressedSize: int64(minified.Len()),
+		Removed:        removed,
+	}, nil
+}
+
+func (xc *XmlCompressor) GetSupportedMimeTypes() []string {
+	return xc.supportedMimeTypes
+}
+
+func (xc *XmlCompressor) SetLogger(logger *logger.Logger) {
+	xc.logger = logger
+}
+
+// SetCanonicalize enables the sorting of attributes and the removal of
+// redundant namespace declarations
+func (xc *XmlCompressor) SetCanonicalize(canonicalize bool) {
+	xc.canonicalize = canonicalize
+}
+
+// parseXML reads the nodes of an XML document outside of its root element,
+// the root element included, and counts its comments. Entities declared by
+// the document type are expanded.
+func parseXML(r io.Reader) ([]*xmlNode, int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Entity = map[string]string{}
+
+	var nodes []*xmlNode
+	var stack []*xmlNode
+	var root bool
+	var comments int
+
+	add := func(node *xmlNode) {
+		if len(stack) == 0 {
+			nodes = append(nodes, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+		}
+	}
+
+	for {
+		offset := d.InputOffset()
+		token, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 {
+				if root {
+					return nil, 0, fmt.Errorf("several root elements")
+				}
+				root = true
+			}
+
+			attrs, err := normalizeXMLAttributes(d, data[offset:d.InputOffset()], t.Attr)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			node := &xmlNode{kind: xmlElement, name: svgQualifiedName(t.Name), attrs: slices.Clone(attrs)}
+			add(node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1].name != svgQualifiedName(t.Name) {
+				return nil, 0, fmt.Errorf("unexpected end element </%s>", svgQualifiedName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 0 {
+				if len(bytes.TrimSpace(t)) > 0 {
+					return nil, 0, fmt.Errorf("text outside of the root element")
+				}
+				continue
+			}
+			add(&xmlNode{kind: xmlText, text: string(t)})
+		case xml.ProcInst:
+			text := t.Target
+			if len(t.Inst) > 0 {
+				text += " " + string(t.Inst)
+			}
+			add(&xmlNode{kind: xmlProcInst, text: text})
+		case xml.Directive:
+			for _, match := range svgEntityPattern.FindAllStringSubmatch(string(t), -1) {
+				d.Entity[match[1]] = match[2] + match[3]
+			}
+			add(&xmlNode{kind: xmlDirective, text: string(t)})
+		case xml.Comment:
+			comments++
+		}
+	}
+
+	if !root || len(stack) > 0 {
+		return nil, 0, fmt.Errorf("document is incomplete")
+	}
+
+	return nodes, comments, nil
+}
+
+// normalizeXMLAttributes returns the attributes of a start tag as XML parsers
+// read them. The decoder keeps the tabs and line breaks written in attribute
+// values, which parsers read as spaces, and cannot tell them from character
+// references, so the tag is decoded again with its whitespace replaced.
+func normalizeXMLAttributes(d *xml.Decoder, tag []byte, attrs []xml.Attr) ([]xml.Attr, error) {
+	if !slices.ContainsFunc(attrs, func(attr xml.Attr) bool { return strings.ContainsAny(attr.Value, "\t\n\r") }) {
+		return attrs, nil
+	}
+
+	td := xml.NewDecoder(strings.NewReader(xmlAttributeNormalizer.Replace(string(tag))))
+	td.Entity = d.Entity
+
+	token, err := td.RawToken()
+	if err != nil {
+		return nil, err
+	}
+	start, ok := token.(xml.StartElement)
+	if !ok {
+		return nil, fmt.Errorf("unexpected token %T in start tag", token)
+	}
+
+	return start.Attr, nil
+}
+
+// write serializes the node, dropping the whitespace between the child
+// elements of elements with no text at any depth unless it is preserved
+func (n *xmlNode) write(b *bytes.Buffer, canonicalize bool, preserve bool, namespaces map[string]string) {
+	switch n.kind {
+	case xmlText:
+		b.WriteString(xmlTextEscaper.Replace(n.text))
+		return
+	case xmlProcInst:
+		b.WriteString("<?" + n.text + "?>")
+		return
+	case xmlDirective:
+		b.WriteString("<!" + n.text + ">")
+		return
+	}
+
+	attrs := n.attrs
+	if canonicalize {
+		attrs, namespaces = canonicalXMLAttributes(attrs, namespaces)
+	}
+
+	for _, attr := range n.attrs {
+		if attr.Name.Space == "xml" && attr.Name.Local == "space" {
+			preserve = attr.Value == "preserve"
+		}
+	}
+
+	children := n.children
+	// The only text of a leaf is its value, even when it is whitespace
+	if !preserve && !slices.ContainsFunc(children, containsText) && slices.ContainsFunc(children, isXMLElement) {
+		children = slices.DeleteFunc(slices.Clone(children), func(child *xmlNode) bool {
+			return child.kind == xmlText
+		})
+	}
+
+	b.WriteString("<" + n.name)
+	for _, attr := range attrs {
+		b.WriteString(" " + svgQualifiedName(attr.Name) + `="` + xmlAttributeEscaper.Replace(attr.Value) + `"`)
+	}
+
+	if len(children) == 0 {
+		b.WriteString("/>")
+		return
+	}
+
+	b.WriteByte('>')
+	for _, child := range children {
+		child.write(b, canonicalize, preserve, namespaces)
+	}
+	b.WriteString("</" + n.name + ">")
+}
+
+// isXMLElement tells whether a node is an element
+func isXMLElement(n *xmlNode) bool {
+	return n.kind == xmlElement
+}
+
+// containsText tells whether a node is, or contains at any depth, a text which
+// is not only XML whitespace
+func containsText(n *xmlNode) bool {
+	if n.kind == xmlText {
+		return strings.Trim(n.text, " \t\r\n") != ""
+	}
+
+	return n.kind == xmlElement && slices.ContainsFunc(n.children, containsText)
+}
+
+// canonicalXMLAttributes drops the namespace declarations already in scope and
+// sorts the others by prefix before the attributes, which are sorted by
+// namespace and local name. It returns the namespaces in scope of the element.
+func canonicalXMLAttributes(attrs []xml.Attr, inScope map[string]string) ([]xml.Attr, map[string]string) {
+	var declarations, others []xml.Attr
+	namespaces := inScope
+
+	for _, attr := range attrs {
+		if !isNamespaceDeclaration(attr) {
+			others = append(others, attr)
+			continue
+		}
+
+		prefix := declaredPrefix(attr)
+		if inScope[prefix] == attr.Value {
+			continue
+		}
+
+		if len(declarations) == 0 {
+			namespaces = maps.Clone(inScope)
+		}
+		namespaces[prefix] = attr.Value
+		declarations = append(declarations, attr)
+	}
+
+	// The default namespace declaration, named xmlns, has an empty prefix
+	slices.SortFunc(declarations, func(a, b xml.Attr) int {
+		return strings.Compare(declaredPrefix(a), declaredPrefix(b))
+	})
+
+	// Attributes without prefix are in no namespace
+	namespace := func(attr xml.Attr) string {
+		if attr.Name.Space == "" {
+			return ""
+		}
+		return namespaces[attr.Name.Space]
+	}
+	slices.SortStableFunc(others, func(a, b xml.Attr) int {
+		if c := strings.Compare(namespace(a), namespace(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name.Local, b.Name.Local)
+	})
+
+	return append(declarations, others...), namespaces
+}
+
+func isNamespaceDeclaration(attr xml.Attr) bool {
+	return attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns")
+}
+
+// declaredPrefix returns the prefix of a namespace declaration, empty for the
+// default namespace
+func declaredPrefix(attr xml.Attr) string {
+	if attr.Name.Space == "" {
+		return ""
+	}
+	return attr.Name.Local
+}
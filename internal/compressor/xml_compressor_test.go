@@ -0,0 +1,191 @@
+package compressor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrettyXML = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Nightly export -->
+<?xml-stylesheet href="export.xsl" type="text/xsl"?>
+<export xmlns="urn:export" xmlns:m="urn:meta" version="2" date="2024-01-01">
+  <item id="1" m:source="crm" xmlns:m="urn:meta">
+    <name>Widget &amp; gadget</name>
+    <description>A <b>bold</b> <i>claim</i></description>
+  </item>
+  <item id="2">
+    <code xml:space="preserve">  indented
+    text  </code>
+    <empty>   </empty>
+  </item>
+  <tags>
+    <tag/>
+    <tag/>
+  </tags>
+</export>
+`
+
+func TestXmlCompressor_CompressFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		canonicalize bool
+		expected     string
+	}{
+		{
+			"Minified",
+			false,
+			`<?xml version="1.0" encoding="UTF-8"?><?xml-stylesheet href="export.xsl" type="text/xsl"?>` +
+				`<export xmlns="urn:export" xmlns:m="urn:meta" version="2" date="2024-01-01">` + "\n  " +
+				`<item id="1" m:source="crm" xmlns:m="urn:meta">` + "\n    " + `<name>Widget &amp; gadget</name>` + "\n    " +
+				`<description>A <b>bold</b> <i>claim</i></description>` + "\n  " + `</item>` + "\n  " +
+				`<item id="2">` + "\n    " + `<code xml:space="preserve">  indented` + "\n" + `    text  </code>` + "\n    " +
+				`<empty>   </empty>` + "\n  " + `</item>` + "\n  " + `<tags><tag/><tag/></tags>` + "\n" + `</export>`,
+		},
+		{
+			"Canonicalized",
+			true,
+			`<?xml version="1.0" encoding="UTF-8"?><?xml-stylesheet href="export.xsl" type="text/xsl"?>` +
+				`<export xmlns="urn:export" xmlns:m="urn:meta" date="2024-01-01" version="2">` + "\n  " +
+				`<item id="1" m:source="crm">` + "\n    " + `<name>Widget &amp; gadget</name>` + "\n    " +
+				`<description>A <b>bold</b> <i>claim</i></description>` + "\n  " + `</item>` + "\n  " +
+				`<item id="2">` + "\n    " + `<code xml:space="preserve">  indented` + "\n" + `    text  </code>` + "\n    " +
+				`<empty>   </empty>` + "\n  " + `</item>` + "\n  " + `<tags><tag/><tag/></tags>` + "\n" + `</export>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			inputPath := filepath.Join(tempDir, "export.xml")
+			outputPath := filepath.Join(tempDir, "compressed_export.xml")
+			require.NoError(t, os.WriteFile(inputPath, []byte(testPrettyXML), 0644))
+
+			compressor := NewXmlCompressor()
+			compressor.SetCanonicalize(tt.canonicalize)
+
+			result, err := compressor.CompressFile(inputPath, outputPath)
+			require.NoError(t, err)
+
+			output, err := os.ReadFile(outputPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(output))
+			assert.Equal(t, []string{"1 comment"}, result.Removed)
+			assert.Equal(t, int64(len(testPrettyXML)), result.OriginalSize)
+			assert.Equal(t, int64(len(output)), result.CompressedSize)
+		})
+	}
+}
+
+func TestCanonicalXMLAttributes(t *testing.T) {
+	nodes, _, err := parseXML(strings.NewReader(`<r xmlns:b="urn:b" z="1" xmlns:a="urn:z" b:x="2" a:y="3" xmlns="urn:default" a="4" xmlns:c="urn:c"><e xmlns="urn:default" xmlns:a="urn:a" a:k="5" c:k="6"/></r>`))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	nodes[0].write(&b, true, false, map[string]string{"xml": xmlNamespace})
+
+	// Attributes without prefix first, then sorted by namespace URI
+	assert.Equal(t, `<r xmlns="urn:default" xmlns:a="urn:z" xmlns:b="urn:b" xmlns:c="urn:c" a="4" z="1" b:x="2" a:y="3">`+
+		`<e xmlns:a="urn:a" a:k="5" c:k="6"/></r>`, b.String())
+}
+
+func TestParseXML(t *testing.T) {
+	nodes, comments, err := parseXML(strings.NewReader(`<!DOCTYPE export [ <!ENTITY company "ACME"> ]><export>&company;<!-- note --></export>`))
+	require.NoError(t, err)
+	assert.Equal(t, 1, comments)
+
+	var b bytes.Buffer
+	for _, node := range nodes {
+		node.write(&b, false, false, nil)
+	}
+	assert.Equal(t, `<!DOCTYPE export [ <!ENTITY company "ACME"> ]><export>ACME</export>`, b.String())
+
+	tests := []struct {
+		name     string
+		document string
+		err      string
+	}{
+		{"Unclosed", `<export><item></export>`, "unexpected end element"},
+		{"Several roots", `<export/><export/>`, "several root elements"},
+		{"Text outside root", `<export/>text`, "outside of the root element"},
+		{"Empty", ``, "incomplete"},
+		{"Undeclared entity", `<export>&undeclared;</export>`, "undeclared"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseXML(strings.NewReader(tt.document))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestXmlNode_WriteWhitespace(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		expected string
+	}{
+		{"Without text", "<list>\n  <entry/>\n  <entry>\n  </entry>\n</list>", "<list><entry/><entry>\n  </entry></list>"},
+		{"Whitespace value", "<row>\n  <sep> </sep>\n  <empty></empty>\n</row>", "<row><sep> </sep><empty/></row>"},
+		{"Mixed content", "<p><b>a</b> <i>b</i></p>", "<p><b>a</b> <i>b</i></p>"},
+		{"Nested text", "<p>\n  <span><b>a</b> <i>b</i></span>\n</p>", "<p>\n  <span><b>a</b> <i>b</i></span>\n</p>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, _, err := parseXML(strings.NewReader(tt.document))
+			require.NoError(t, err)
+
+			var b bytes.Buffer
+			nodes[0].write(&b, false, false, nil)
+			assert.Equal(t, tt.expected, b.String())
+		})
+	}
+}
+
+func TestXmlCompressor_CompressFileAttributeWhitespace(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "export.xml")
+	outputPath := filepath.Join(tempDir, "compressed_export.xml")
+	document := "<export note=\"line&#10;break&#9;tab\" title=\"two\n\tlines\">carriage&#13;return</export>"
+	require.NoError(t, os.WriteFile(inputPath, []byte(document), 0644))
+
+	_, err := NewXmlCompressor().CompressFile(inputPath, outputPath)
+	require.NoError(t, err)
+
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, `<export note="line&#10;break&#9;tab" title="two  lines">carriage&#13;return</export>`, string(output))
+
+	// Parsers read the written whitespace of attribute values as spaces
+	nodes, _, err := parseXML(bytes.NewReader(output))
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	assert.Equal(t, "line\nbreak\ttab", nodes[0].attrs[0].Value)
+	assert.Equal(t, "two  lines", nodes[0].attrs[1].Value)
+	require.Len(t, nodes[0].children, 1)
+	assert.Equal(t, "carriage\rreturn", nodes[0].children[0].text)
+}
+
+func TestXmlCompressor_CompressFileInvalid(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "broken.xml")
+	outputPath := filepath.Join(tempDir, "compressed_broken.xml")
+	require.NoError(t, os.WriteFile(inputPath, []byte("<export>\n  <item>\n</export>\n"), 0644))
+
+	_, err := NewXmlCompressor().CompressFile(inputPath, outputPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse XML")
+	assert.NoFileExists(t, outputPath)
+}
+
+func TestXmlCompressor_GetSupportedMimeTypes(t *testing.T) {
+	assert.Equal(t, []string{"application/xml", "text/xml"}, NewXmlCompressor().GetSupportedMimeTypes())
+}
@@ -0,0 +1,354 @@
+package compressor
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jdecool/file-compressor/internal/logger"
+)
+
+// packageImageExtensions are the extensions of the images embedded in
+// packages which are run through the image compressor
+var packageImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff", ".webp"}
+
+const zipContainerMimetype = "mimetype"
+
+// zipContainer is a ZIP container whose first entry, named mimetype, is stored
+// uncompressed without extra field and holds the media type of the container,
+// as OpenDocument documents and EPUB books are
+type zipContainer struct {
+	// name prefixes the log messages, such as "EPUB"
+	name string
+	// encryptedEntries returns the entries encrypted or obfuscated, which are copied as is
+	encryptedEntries func(r *zip.Reader) (map[string]bool, error)
+	// validate checks the entries the container cannot miss
+	validate func(r *zip.Reader) error
+	// fontCharacters returns the characters the fonts of the container draw,
+	// nil when they cannot be known. Fonts are kept whole without it.
+	fontCharacters  func(r *zip.Reader, encrypted map[string]bool) (map[rune]bool, error)
+	imageCompressor Compressor
+	logger          *logger.Logger
+}
+
+// compressFile rewrites a container, its mimetype entry first, its images
+// compressed by the image compressor, its fonts subset to the characters they
+// draw when known and its other entries deflated at the best compression
+// level when it makes them smaller. The written container is read back and
+// discarded when invalid.
+func (c *zipContainer) compressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	c.logger.PrintfVerbose("%s Compressor: Compressing file %s to %s\n", c.name, filepath.Base(filePath), filepath.Base(outputPath))
+
+	originalFileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original file info: %v", err)
+	}
+
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s container: %v", c.name, err)
+	}
+	defer r.Close()
+
+	i := slices.IndexFunc(r.File, func(f *zip.File) bool { return f.Name == zipContainerMimetype })
+	if i < 0 {
+		return nil, fmt.Errorf("%s container has no mimetype entry", c.name)
+	}
+	mediaType, err := readZipEntry(r.File[i])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mimetype entry: %v", err)
+	}
+
+	encrypted, err := c.encryptedEntries(&r.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption data: %v", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "container-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	images, err := c.writeContainer(r, outputPath, r.File[i], mediaType, encrypted, tempDir)
+	if err != nil {
+		_ = os.Remove(outputPath)
+
+		return nil, fmt.Errorf("failed to write %s container: %v", c.name, err)
+	}
+	if images > 0 {
+		c.logger.PrintfVerbose("%s Compressor: Compressed %s\n", c.name, countLabel(images, "image"))
+	}
+
+	// A broken output is discarded, so that it never replaces the original
+	if err := c.validateContainer(outputPath, mediaType); err != nil {
+		_ = os.Remove(outputPath)
+		c.logger.PrintfVerbose("%s Compressor: Discarding compressed file %s, validation failed: %v\n", c.name, filepath.Base(outputPath), err)
+
+		return &CompressionResult{
+			OriginalFile:   filePath,
+			OriginalSize:   originalFileInfo.Size(),
+			CompressedSize: originalFileInfo.Size(),
+			Skipped:        SkippedValidationFailed,
+		}, nil
+	}
+
+	compressedFileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get compressed file info: %v", err)
+	}
+
+	return &CompressionResult{
+		OriginalFile:   filePath,
+		CompressedFile: outputPath,
+		OriginalSize:   originalFileInfo.Size(),
+		CompressedSize: compressedFileInfo.Size(),
+	}, nil
+}
+
+// writeContainer writes the entries of a container, and returns the number of
+// images made smaller
+func (c *zipContainer) writeContainer(r *zip.ReadCloser, outputPath string, mimetype *zip.File, mediaType []byte, encrypted map[string]bool, tempDir string) (int, error) {
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return 0, err
+	}
+	defer output.Close()
+
+	w := zip.NewWriter(output)
+	if err := w.SetComment(r.Comment); err != nil {
+		return 0, err
+	}
+
+	// Readers identify the container by the media type at offset 38, right
+	// after the local header of an entry without extra field
+	header := mimetype.FileHeader
+	header.Method = zip.Store
+	header.Flags = 0
+	header.Extra = nil
+	if err := writeRawZipEntry(w, &header, mediaType, mediaType); err != nil {
+		return 0, err
+	}
+
+	var characters map[rune]bool
+	if c.fontCharacters != nil {
+		if characters, err = c.fontCharacters(&r.Reader, encrypted); err != nil {
+			return 0, err
+		}
+	}
+
+	images := 0
+	for _, f := range r.File {
+		if f == mimetype {
+			continue
+		}
+
+		if encrypted[f.Name] || strings.HasSuffix(f.Name, "/") {
+			if err := copyZipEntry(w, f); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		data, err := readZipEntry(f)
+		if err != nil {
+			return 0, err
+		}
+
+		if slices.Contains(packageImageExtensions, strings.ToLower(path.Ext(f.Name))) {
+			compressed, err := compressPackageImage(c.imageCompressor, data, path.Ext(f.Name), tempDir)
+			if err != nil {
+				c.logger.PrintfVerbose("%s Compressor: Keeping image %s: %v\n", c.name, f.Name, err)
+			} else if compressed != nil {
+				data = compressed
+				images++
+			}
+		}
+
+		if characters != nil && slices.Contains(packageFontExtensions, strings.ToLower(path.Ext(f.Name))) {
+			data = c.subsetFont(f.Name, data, characters)
+		}
+
+		if err := writeSmallestZipEntry(w, f, data); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	return images, output.Close()
+}
+
+// subsetFont returns a font of the container subset to the characters it
+// draws, or the font itself when it cannot be subset or is not made smaller
+func (c *zipContainer) subsetFont(name string, data []byte, characters map[rune]bool) []byte {
+	font, err := parsePackageFont(data)
+	if err != nil {
+		c.logger.PrintfVerbose("%s Compressor: Keeping font %s: %v\n", c.name, name, err)
+		return data
+	}
+
+	gids, err := font.glyphs(characters)
+	if err != nil {
+		c.logger.PrintfVerbose("%s Compressor: Keeping font %s: %v\n", c.name, name, err)
+		return data
+	}
+
+	subset := font.subset(gids)
+	if len(subset) >= len(data) {
+		return data
+	}
+	// A subset which does not read back never replaces the font
+	if _, err := parsePackageFont(subset); err != nil {
+		c.logger.PrintfVerbose("%s Compressor: Keeping font %s: %v\n", c.name, name, err)
+		return data
+	}
+
+	c.logger.PrintfVerbose("%s Compressor: Subset font %s to %d of %d glyphs\n", c.name, name, len(gids), font.numGlyphs)
+
+	return subset
+}
+
+// validateContainer checks that the mimetype entry of a container comes first,
+// stored without extra field, that every entry reads back and that the
+// entries the container cannot miss are valid
+func (c *zipContainer) validateContainer(filePath string, mediaType []byte) error {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if len(r.File) == 0 || r.File[0].Name != zipContainerMimetype {
+		return fmt.Errorf("mimetype is not the first entry")
+	}
+	if r.File[0].Method != zip.Store || len(r.File[0].Extra) > 0 {
+		return fmt.Errorf("mimetype entry is compressed or has an extra field")
+	}
+
+	for _, f := range r.File {
+		data, err := readZipEntry(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if f.Name == zipContainerMimetype && !bytes.Equal(data, mediaType) {
+			return fmt.Errorf("mimetype entry is %q instead of %q", data, mediaType)
+		}
+	}
+
+	return c.validate(&r.Reader)
+}
+
+// compressPackageImage runs an image embedded in a package through the image
+// compressor, and returns the compressed image when it is smaller and has the
+// same format, nil otherwise
+func compressPackageImage(imageCompressor Compressor, data []byte, ext string, tempDir string) ([]byte, error) {
+	inputPath := filepath.Join(tempDir, "image"+ext)
+	outputPath := filepath.Join(tempDir, "compressed_image"+ext)
+	defer os.Remove(inputPath)
+	defer os.Remove(outputPath)
+
+	if err := os.WriteFile(inputPath, data, 0644); err != nil {
+		return nil, err
+	}
+
+	result, err := imageCompressor.CompressFile(inputPath, outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if result.CompressedFile != outputPath {
+		// The output of an image converted to another format is not referenced
+		_ = os.Remove(result.CompressedFile)
+
+		return nil, nil
+	}
+
+	compressed, err := os.ReadFile(outputPath)
+	if err != nil || len(compressed) >= len(data) {
+		return nil, err
+	}
+
+	return compressed, nil
+}
+
+// writeSmallestZipEntry writes the data of an entry deflated at the best
+// compression level, or stored when deflating does not make it smaller
+func writeSmallestZipEntry(w *zip.Writer, f *zip.File, data []byte) error {
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	// The extra fields of the original entry may describe its former sizes
+	header := f.FileHeader
+	header.Flags &^= 0x8
+	header.Extra = nil
+	if deflated.Len() < len(data) {
+		header.Method = zip.Deflate
+		return writeRawZipEntry(w, &header, data, deflated.Bytes())
+	}
+
+	header.Method = zip.Store
+	return writeRawZipEntry(w, &header, data, data)
+}
+
+// writeRawZipEntry writes an entry whose data is already compressed with the
+// method of its header
+func writeRawZipEntry(w *zip.Writer, header *zip.FileHeader, data []byte, compressed []byte) error {
+	header.CRC32 = crc32.ChecksumIEEE(data)
+	header.UncompressedSize64 = uint64(len(data))
+	header.CompressedSize64 = uint64(len(compressed))
+
+	entry, err := w.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = entry.Write(compressed)
+
+	return err
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// copyZipEntry copies an entry without decompressing it
+func copyZipEntry(w *zip.Writer, f *zip.File) error {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	entry, err := w.CreateRaw(&f.FileHeader)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, raw)
+
+	return err
+}
@@ -0,0 +1,120 @@
+package compressor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZipEntry is an entry of a test package, stored without compression
+type testZipEntry struct {
+	name    string
+	content []byte
+}
+
+func writeTestZipPackage(t *testing.T, filePath string, entries []testZipEntry) {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, entry := range entries {
+		writer, err := w.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Store})
+		require.NoError(t, err)
+		_, err = writer.Write(entry.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	require.NoError(t, os.WriteFile(filePath, b.Bytes(), 0644))
+}
+
+// convertingCompressor writes its output with another extension, as the image
+// compressor does for formats it converts
+type convertingCompressor struct{}
+
+func (convertingCompressor) CompressFile(filePath string, outputPath string) (*CompressionResult, error) {
+	outputPath += ".jpg"
+	if err := os.WriteFile(outputPath, []byte("x"), 0644); err != nil {
+		return nil, err
+	}
+
+	return &CompressionResult{OriginalFile: filePath, CompressedFile: outputPath}, nil
+}
+
+func (convertingCompressor) GetSupportedMimeTypes() []string {
+	return nil
+}
+
+func TestCompressPackageImage(t *testing.T) {
+	tempDir := t.TempDir()
+	original := testUncompressedPNG(t)
+
+	compressed, err := compressPackageImage(NewImageCompressor(), original, ".png", tempDir)
+	require.NoError(t, err)
+	assert.NotNil(t, compressed)
+	assert.Less(t, len(compressed), len(original))
+
+	// Images converted to another format are kept
+	compressed, err = compressPackageImage(convertingCompressor{}, original, ".png", tempDir)
+	require.NoError(t, err)
+	assert.Nil(t, compressed)
+
+	_, err = compressPackageImage(NewImageCompressor(), []byte("not an image"), ".png", tempDir)
+	assert.Error(t, err)
+
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestWriteSmallestZipEntry(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "input.zip")
+	outputPath := filepath.Join(tempDir, "output.zip")
+	text := []byte(strings.Repeat("compressible text ", 100))
+	noise := []byte{0x8f, 0x12, 0x7a}
+	writeTestZipPackage(t, inputPath, []testZipEntry{{"text.xml", text}, {"noise.bin", noise}})
+
+	r, err := zip.OpenReader(inputPath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, f := range r.File {
+		data, err := readZipEntry(f)
+		require.NoError(t, err)
+		require.NoError(t, writeSmallestZipEntry(w, f, data))
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(outputPath, b.Bytes(), 0644))
+
+	output, err := zip.OpenReader(outputPath)
+	require.NoError(t, err)
+	defer output.Close()
+
+	assert.Equal(t, zip.Deflate, output.File[0].Method)
+	assert.Equal(t, zip.Store, output.File[1].Method)
+	for i, expected := range [][]byte{text, noise} {
+		data, err := readZipEntry(output.File[i])
+		require.NoError(t, err)
+		assert.Equal(t, expected, data)
+	}
+}
+
+// assertZipContainerMimetype checks that a container starts with the media
+// type at offset 38, as readers identifying containers expect it
+func assertZipContainerMimetype(t *testing.T, filePath string, mediaType string) {
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Greater(t, len(data), 38+len(mediaType))
+
+	assert.Equal(t, uint32(0x04034b50), binary.LittleEndian.Uint32(data))
+	assert.Equal(t, zipContainerMimetype, string(data[30:38]))
+	assert.Equal(t, mediaType, string(data[38:38+len(mediaType)]))
+}
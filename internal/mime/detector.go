@@ -2,6 +2,7 @@ package mime
 
 import (
 	"path/filepath"
+	"strings"
 
 	"github.com/gabriel-vasile/mimetype"
 )
@@ -19,6 +20,16 @@ func (d *Detector) DetectMimeType(filePath string) string {
 		return d.detectMimeTypeFromExtension(filePath)
 	}
 
+	// Style sheets and scripts have no signature and are detected as plain text
+	if mtype.Is("text/plain") {
+		switch strings.ToLower(filepath.Ext(filePath)) {
+		case ".css":
+			return strings.Replace(mtype.String(), "text/plain", "text/css", 1)
+		case ".js", ".mjs":
+			return strings.Replace(mtype.String(), "text/plain", "text/javascript", 1)
+		}
+	}
+
 	return mtype.String()
 }
 
@@ -45,6 +45,16 @@ func TestDetectMimeType(t *testing.T) {
 			filePath: "testdata/test.xml",
 			expected: "text/xml",
 		},
+		{
+			name:     "CSS file",
+			filePath: "testdata/test.css",
+			expected: "text/css",
+		},
+		{
+			name:     "JavaScript file",
+			filePath: "testdata/test.js",
+			expected: "text/javascript",
+		},
 		{
 			name:     "Fake PDF (actually text)",
 			filePath: "testdata/fake.pdf",
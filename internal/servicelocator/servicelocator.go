@@ -3,6 +3,7 @@ package servicelocator
 import (
 	"github.com/jdecool/file-compressor/internal/compressor"
 	"github.com/jdecool/file-compressor/internal/logger"
+	"strings"
 	"sync"
 )
 
@@ -37,7 +38,7 @@ func (sl *ServiceLocator) GetCompressor(mimeType string) (compressor.Compressor,
 	sl.mu.RLock()
 	defer sl.mu.RUnlock()
 
-	compressor, exists := sl.compressors[mimeType]
+	compressor, exists := sl.compressors[baseMimeType(mimeType)]
 
 	return compressor, exists
 }
@@ -58,7 +59,15 @@ func (sl *ServiceLocator) HasCompressor(mimeType string) bool {
 	sl.mu.RLock()
 	defer sl.mu.RUnlock()
 
-	_, exists := sl.compressors[mimeType]
+	_, exists := sl.compressors[baseMimeType(mimeType)]
 
 	return exists
 }
+
+// baseMimeType removes the parameters of a MIME type, such as the charset the
+// mimetype library reports for text files
+func baseMimeType(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+
+	return strings.TrimSpace(mimeType)
+}
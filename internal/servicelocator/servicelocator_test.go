@@ -41,3 +41,25 @@ func TestServiceLocator(t *testing.T) {
 		t.Errorf("Expected 1 compressor, got %d", len(allCompressors))
 	}
 }
+
+func TestServiceLocatorMimeTypeParameters(t *testing.T) {
+	sl := NewServiceLocator()
+	sl.RegisterCompressor(compressor.NewStreamCompressor())
+
+	// Text files are found whatever their charset
+	for _, mimeType := range []string{
+		"text/plain",
+		"text/plain; charset=utf-8",
+		"text/plain; charset=iso-8859-1",
+		"text/plain; charset=utf-16le",
+		"text/html; charset=windows-1252",
+	} {
+		if !sl.HasCompressor(mimeType) {
+			t.Errorf("ServiceLocator should have a compressor for %s", mimeType)
+		}
+
+		if _, exists := sl.GetCompressor(mimeType); !exists {
+			t.Errorf("Should be able to get the compressor for %s", mimeType)
+		}
+	}
+}
@@ -0,0 +1,845 @@
+package webp
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// This file implements a VP8 key frame encoder, the lossy bitstream of WebP,
+// as specified in RFC 6386. It only uses whole-macroblock (16x16 luma, 8x8
+// chroma) prediction, which keeps the encoder small while still producing
+// streams that every conforming decoder accepts.
+
+// maxVP8Dimension is the largest width or height a VP8 frame can describe.
+const maxVP8Dimension = 16383
+
+// uniformProb represents a 50% probability that the next bit is 0.
+const uniformProb = 128
+
+const (
+	predDC = iota
+	predTM
+	predVE
+	predHE
+	nMacroblockPred
+)
+
+// boolEncoder is the boolean entropy encoder of section 7.3.
+type boolEncoder struct {
+	buf      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, bitCount: 24}
+}
+
+// writeBool writes one bit whose probability of being false is prob/256.
+func (e *boolEncoder) writeBool(prob uint8, bit bool) {
+	split := 1 + ((e.rng-1)*uint32(prob))>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.propagateCarry()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+func (e *boolEncoder) propagateCarry() {
+	i := len(e.buf) - 1
+	for i >= 0 && e.buf[i] == 0xff {
+		e.buf[i] = 0
+		i--
+	}
+	if i >= 0 {
+		e.buf[i]++
+	}
+}
+
+// writeLiteral writes the n low bits of v, most significant bit first.
+func (e *boolEncoder) writeLiteral(v uint32, n int) {
+	for n > 0 {
+		n--
+		e.writeBool(uniformProb, (v>>uint(n))&1 != 0)
+	}
+}
+
+// flush pads the stream so that a decoder can read every written bit.
+func (e *boolEncoder) flush() []byte {
+	for i := 0; i < 32; i++ {
+		e.writeBool(uniformProb, false)
+	}
+	return e.buf
+}
+
+// plane is an 8-bit sample plane.
+type plane struct {
+	pix    []uint8
+	stride int
+}
+
+func newPlane(width, height int) *plane {
+	return &plane{pix: make([]uint8, width*height), stride: width}
+}
+
+func (p *plane) at(x, y int) uint8 {
+	return p.pix[y*p.stride+x]
+}
+
+func (p *plane) set(x, y int, v uint8) {
+	p.pix[y*p.stride+x] = v
+}
+
+// quantizer holds the DC and AC quantization steps of one frame.
+type quantizer struct {
+	y1 [2]int32
+	y2 [2]int32
+	uv [2]int32
+}
+
+func newQuantizer(qi int) quantizer {
+	var q quantizer
+	q.y1[0] = int32(dequantTableDC[qi])
+	q.y1[1] = int32(dequantTableAC[qi])
+	q.y2[0] = int32(dequantTableDC[qi]) * 2
+	q.y2[1] = int32(dequantTableAC[qi]) * 155 / 100
+	if q.y2[1] < 8 {
+		q.y2[1] = 8
+	}
+	q.uv[0] = int32(dequantTableDC[min(qi, 117)])
+	q.uv[1] = int32(dequantTableAC[qi])
+	return q
+}
+
+// macroblock holds the coding decisions of one 16x16 macroblock. The levels
+// are the quantized coefficients in raster order: blocks 0 to 15 are luma,
+// 16 to 19 are Cb, 20 to 23 are Cr and 24 is the luma DC (Y2) block.
+type macroblock struct {
+	predY  uint8
+	predC  uint8
+	levels [25][16]int16
+}
+
+func (mb *macroblock) isEmpty() bool {
+	for i := range mb.levels {
+		for _, l := range mb.levels[i] {
+			if l != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// vp8Encoder encodes one image as a VP8 key frame.
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+	qi            int
+	quant         quantizer
+	src           [3]*plane
+	rec           [3]*plane
+	mbs           []macroblock
+	tokenProb     [nPlane][nBand][nContext][nProb]uint8
+}
+
+// encodeVP8 encodes m as a VP8 key frame. qi is the quantizer index, from 0
+// (finest) to 127 (coarsest).
+func encodeVP8(m *image.NRGBA, qi int) ([]byte, error) {
+	b := m.Bounds()
+	if b.Dx() > maxVP8Dimension || b.Dy() > maxVP8Dimension {
+		return nil, errors.New("webp: image is too large for the lossy format")
+	}
+
+	e := &vp8Encoder{
+		width:     b.Dx(),
+		height:    b.Dy(),
+		mbw:       (b.Dx() + 15) >> 4,
+		mbh:       (b.Dy() + 15) >> 4,
+		qi:        qi,
+		quant:     newQuantizer(qi),
+		tokenProb: defaultTokenProb,
+	}
+	e.loadSource(m)
+	e.mbs = make([]macroblock, e.mbw*e.mbh)
+	for mby := 0; mby < e.mbh; mby++ {
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby, &e.mbs[mby*e.mbw+mbx])
+		}
+	}
+
+	return e.writeFrame(), nil
+}
+
+// loadSource converts m to Y'CbCr 4:2:0 with the BT.601 limited range used by
+// VP8, padding the planes to a whole number of macroblocks.
+func (e *vp8Encoder) loadSource(m *image.NRGBA) {
+	yw, yh := e.mbw*16, e.mbh*16
+	cw, ch := e.mbw*8, e.mbh*8
+	e.src = [3]*plane{newPlane(yw, yh), newPlane(cw, ch), newPlane(cw, ch)}
+	e.rec = [3]*plane{newPlane(yw, yh), newPlane(cw, ch), newPlane(cw, ch)}
+
+	rgb := func(x, y int) (r, g, b int32) {
+		x = min(x, e.width-1)
+		y = min(y, e.height-1)
+		i := m.PixOffset(m.Rect.Min.X+x, m.Rect.Min.Y+y)
+		return int32(m.Pix[i]), int32(m.Pix[i+1]), int32(m.Pix[i+2])
+	}
+
+	for y := 0; y < yh; y++ {
+		for x := 0; x < yw; x++ {
+			r, g, b := rgb(x, y)
+			e.src[0].set(x, y, uint8((16839*r+33059*g+6420*b+(16<<16)+(1<<15))>>16))
+		}
+	}
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			var r, g, b int32
+			for j := 0; j < 2; j++ {
+				for i := 0; i < 2; i++ {
+					pr, pg, pb := rgb(2*x+i, 2*y+j)
+					r, g, b = r+pr, g+pg, b+pb
+				}
+			}
+			// The sums are four times the average, hence the extra shift.
+			e.src[1].set(x, y, clip8((-9719*r-19081*g+28800*b+(128<<18)+(1<<17))>>18))
+			e.src[2].set(x, y, clip8((28800*r-24116*g-4684*b+(128<<18)+(1<<17))>>18))
+		}
+	}
+}
+
+func clip8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// predictionEdges returns the row above, the column left of and the pixel
+// above-left of the size×size block at (x, y) in the reconstructed plane p,
+// substituting the constants of section 12.2 outside the frame.
+func predictionEdges(p *plane, x, y, size int) (top, left []uint8, corner uint8) {
+	top = make([]uint8, size)
+	left = make([]uint8, size)
+	for i := 0; i < size; i++ {
+		if y == 0 {
+			top[i] = 0x7f
+		} else {
+			top[i] = p.at(x+i, y-1)
+		}
+		if x == 0 {
+			left[i] = 0x81
+		} else {
+			left[i] = p.at(x-1, y+i)
+		}
+	}
+	switch {
+	case y == 0:
+		corner = 0x7f
+	case x == 0:
+		corner = 0x81
+	default:
+		corner = p.at(x-1, y-1)
+	}
+	return top, left, corner
+}
+
+// predict fills a size×size block with the given whole-block predictor. The
+// DC predictor ignores the edges that lie outside the frame.
+func predict(mode uint8, top, left []uint8, corner uint8, size int, hasTop, hasLeft bool) []uint8 {
+	out := make([]uint8, size*size)
+	switch mode {
+	case predDC:
+		var sum, n uint32
+		if hasTop {
+			for _, v := range top {
+				sum += uint32(v)
+			}
+			n += uint32(size)
+		}
+		if hasLeft {
+			for _, v := range left {
+				sum += uint32(v)
+			}
+			n += uint32(size)
+		}
+		dc := uint8(0x80)
+		if n > 0 {
+			dc = uint8((sum + n/2) / n)
+		}
+		for i := range out {
+			out[i] = dc
+		}
+	case predTM:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				out[j*size+i] = clip8(int32(left[j]) + int32(top[i]) - int32(corner))
+			}
+		}
+	case predVE:
+		for j := 0; j < size; j++ {
+			copy(out[j*size:], top)
+		}
+	case predHE:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				out[j*size+i] = left[j]
+			}
+		}
+	}
+	return out
+}
+
+// bestPrediction returns the whole-block predictor with the smallest squared
+// error against the source block at (x, y), along with its prediction.
+func (e *vp8Encoder) bestPrediction(planes []int, x, y, size int, hasTop, hasLeft bool) (uint8, [][]uint8) {
+	var bestMode uint8
+	var best [][]uint8
+	bestErr := int64(math.MaxInt64)
+	for mode := uint8(0); mode < nMacroblockPred; mode++ {
+		var sse int64
+		preds := make([][]uint8, len(planes))
+		for k, pi := range planes {
+			top, left, corner := predictionEdges(e.rec[pi], x, y, size)
+			preds[k] = predict(mode, top, left, corner, size, hasTop, hasLeft)
+			for j := 0; j < size; j++ {
+				for i := 0; i < size; i++ {
+					d := int64(e.src[pi].at(x+i, y+j)) - int64(preds[k][j*size+i])
+					sse += d * d
+				}
+			}
+		}
+		if sse < bestErr {
+			bestMode, best, bestErr = mode, preds, sse
+		}
+	}
+	return bestMode, best
+}
+
+// residual returns the difference between the source and the prediction for
+// the 4x4 sub-block at (bx, by) of a size×size block at (x, y).
+func residual(src *plane, pred []uint8, x, y, size, bx, by int) [16]int32 {
+	var r [16]int32
+	for j := 0; j < 4; j++ {
+		for i := 0; i < 4; i++ {
+			r[j*4+i] = int32(src.at(x+bx+i, y+by+j)) - int32(pred[(by+j)*size+bx+i])
+		}
+	}
+	return r
+}
+
+// quantize returns the quantized level of coefficient c for step q.
+func quantize(c, q int32, isDC bool) int16 {
+	bias := q * 3 / 8
+	if isDC {
+		bias = q / 2
+	}
+	neg := c < 0
+	if neg {
+		c = -c
+	}
+	level := (c + bias) / q
+	if level > 2048 {
+		level = 2048
+	}
+	if neg {
+		level = -level
+	}
+	return int16(level)
+}
+
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int, mb *macroblock) {
+	hasTop, hasLeft := mby > 0, mbx > 0
+
+	// Luma: 16x16 prediction, with the DC of each 4x4 block carried by Y2.
+	x, y := mbx*16, mby*16
+	mode, preds := e.bestPrediction([]int{0}, x, y, 16, hasTop, hasLeft)
+	mb.predY = mode
+	pred := preds[0]
+	var coeffs [16][16]int32
+	var dcs [16]int32
+	for n := 0; n < 16; n++ {
+		r := residual(e.src[0], pred, x, y, 16, (n&3)*4, (n>>2)*4)
+		coeffs[n] = forwardDCT(r)
+		dcs[n] = coeffs[n][0]
+		for i := 1; i < 16; i++ {
+			mb.levels[n][i] = quantize(coeffs[n][i], e.quant.y1[1], false)
+		}
+	}
+	y2 := forwardWHT(dcs)
+	var dq [16]int32
+	for i := 0; i < 16; i++ {
+		mb.levels[24][i] = quantize(y2[i], e.quant.y2[btoi(i > 0)], i == 0)
+		dq[i] = int32(mb.levels[24][i]) * e.quant.y2[btoi(i > 0)]
+	}
+	recDC := inverseWHT(dq)
+	for n := 0; n < 16; n++ {
+		var c [16]int32
+		c[0] = recDC[n]
+		for i := 1; i < 16; i++ {
+			c[i] = int32(mb.levels[n][i]) * e.quant.y1[1]
+		}
+		inverseDCTAdd(e.rec[0], pred, c, x, y, 16, (n&3)*4, (n>>2)*4)
+	}
+
+	// Chroma: one 8x8 predictor shared by both planes.
+	x, y = mbx*8, mby*8
+	mode, preds = e.bestPrediction([]int{1, 2}, x, y, 8, hasTop, hasLeft)
+	mb.predC = mode
+	for k, pi := range []int{1, 2} {
+		for n := 0; n < 4; n++ {
+			bx, by := (n&1)*4, (n>>1)*4
+			r := residual(e.src[pi], preds[k], x, y, 8, bx, by)
+			f := forwardDCT(r)
+			levels := &mb.levels[16+k*4+n]
+			var c [16]int32
+			for i := 0; i < 16; i++ {
+				levels[i] = quantize(f[i], e.quant.uv[btoi(i > 0)], i == 0)
+				c[i] = int32(levels[i]) * e.quant.uv[btoi(i > 0)]
+			}
+			inverseDCTAdd(e.rec[pi], preds[k], c, x, y, 8, bx, by)
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// forwardDCT is the forward transform of the reference encoder.
+func forwardDCT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := 0; i < 4; i++ {
+		ip := in[i*4 : i*4+4]
+		a1 := (ip[0] + ip[3]) * 8
+		b1 := (ip[1] + ip[2]) * 8
+		c1 := (ip[1] - ip[2]) * 8
+		d1 := (ip[0] - ip[3]) * 8
+		tmp[i*4+0] = a1 + b1
+		tmp[i*4+2] = a1 - b1
+		tmp[i*4+1] = (c1*2217 + d1*5352 + 14500) >> 12
+		tmp[i*4+3] = (d1*2217 - c1*5352 + 7500) >> 12
+	}
+	for i := 0; i < 4; i++ {
+		a1 := tmp[i] + tmp[12+i]
+		b1 := tmp[4+i] + tmp[8+i]
+		c1 := tmp[4+i] - tmp[8+i]
+		d1 := tmp[i] - tmp[12+i]
+		out[i] = (a1 + b1 + 7) >> 4
+		out[8+i] = (a1 - b1 + 7) >> 4
+		out[4+i] = (c1*2217+d1*5352+12000)>>16 + int32(btoi(d1 != 0))
+		out[12+i] = (d1*2217 - c1*5352 + 51000) >> 16
+	}
+	return out
+}
+
+// forwardWHT is the forward Walsh-Hadamard transform of the reference encoder.
+func forwardWHT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := 0; i < 4; i++ {
+		ip := in[i*4 : i*4+4]
+		a1 := (ip[0] + ip[2]) * 4
+		d1 := (ip[1] + ip[3]) * 4
+		c1 := (ip[1] - ip[3]) * 4
+		b1 := (ip[0] - ip[2]) * 4
+		tmp[i*4+0] = a1 + d1 + int32(btoi(a1 != 0))
+		tmp[i*4+1] = b1 + c1
+		tmp[i*4+2] = b1 - c1
+		tmp[i*4+3] = a1 - d1
+	}
+	for i := 0; i < 4; i++ {
+		a1 := tmp[i] + tmp[8+i]
+		d1 := tmp[4+i] + tmp[12+i]
+		c1 := tmp[4+i] - tmp[12+i]
+		b1 := tmp[i] - tmp[8+i]
+		r := [4]int32{a1 + d1, b1 + c1, b1 - c1, a1 - d1}
+		for k := range r {
+			if r[k] < 0 {
+				r[k]++
+			}
+			out[k*4+i] = (r[k] + 3) >> 3
+		}
+	}
+	return out
+}
+
+// inverseWHT mirrors the decoder's inverse Walsh-Hadamard transform and
+// returns the DC coefficient of each luma block.
+func inverseWHT(in [16]int32) [16]int32 {
+	var m, out [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[i] - in[12+i]
+		m[i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[i*4] + 3
+		a0 := dc + m[i*4+3]
+		a1 := m[i*4+1] + m[i*4+2]
+		a2 := m[i*4+1] - m[i*4+2]
+		a3 := dc - m[i*4+3]
+		out[i*4+0] = int32(int16((a0 + a1) >> 3))
+		out[i*4+1] = int32(int16((a3 + a2) >> 3))
+		out[i*4+2] = int32(int16((a0 - a1) >> 3))
+		out[i*4+3] = int32(int16((a3 - a2) >> 3))
+	}
+	return out
+}
+
+// inverseDCTAdd mirrors the decoder's inverse transform: it adds the inverse
+// DCT of c to the prediction of the 4x4 sub-block at (bx, by) and stores the
+// result in the reconstructed plane.
+func inverseDCTAdd(rec *plane, pred []uint8, c [16]int32, x, y, size, bx, by int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2).
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2).
+	)
+	for i := range c {
+		c[i] = int32(int16(c[i]))
+	}
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := c[i] + c[8+i]
+		b := c[i] - c[8+i]
+		cc := (c[4+i]*c2)>>16 - (c[12+i]*c1)>>16
+		d := (c[4+i]*c1)>>16 + (c[12+i]*c2)>>16
+		m[i][0] = a + d
+		m[i][1] = b + cc
+		m[i][2] = b - cc
+		m[i][3] = a - d
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		cc := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		out := [4]int32{(a + d) >> 3, (b + cc) >> 3, (b - cc) >> 3, (a - d) >> 3}
+		for i := 0; i < 4; i++ {
+			p := int32(pred[(by+j)*size+bx+i])
+			rec.set(x+bx+i, y+by+j, clip8(p+out[i]))
+		}
+	}
+}
+
+// residualWriter writes coefficient tokens, or only counts the branches taken
+// when enc is nil so that the token probabilities can be tuned beforehand.
+type residualWriter struct {
+	enc   *boolEncoder
+	probs *[nPlane][nBand][nContext][nProb]uint8
+	stats *[nPlane][nBand][nContext][nProb][2]uint32
+}
+
+func (w *residualWriter) token(plane, band, ctx, i int, bit bool) {
+	if w.enc == nil {
+		w.stats[plane][band][ctx][i][btoi(bit)]++
+		return
+	}
+	w.enc.writeBool(w.probs[plane][band][ctx][i], bit)
+}
+
+func (w *residualWriter) bit(prob uint8, bit bool) {
+	if w.enc != nil {
+		w.enc.writeBool(prob, bit)
+	}
+}
+
+// coefficients writes the levels of one 4x4 block from position first, as
+// specified in section 13, and returns 1 if any of them is non-zero.
+func (w *residualWriter) coefficients(plane, ctx int, levels *[16]int16, first int) uint8 {
+	last := -1
+	for n := 15; n >= first; n-- {
+		if levels[zigzag[n]] != 0 {
+			last = n
+			break
+		}
+	}
+	band := int(bands[first])
+	if last < 0 {
+		w.token(plane, band, ctx, 0, false)
+		return 0
+	}
+	w.token(plane, band, ctx, 0, true)
+	for n := first; n < 16; {
+		v := int32(levels[zigzag[n]])
+		n++
+		if v == 0 {
+			w.token(plane, band, ctx, 1, false)
+			band, ctx = int(bands[n]), 0
+			continue
+		}
+		w.token(plane, band, ctx, 1, true)
+		abs := v
+		if abs < 0 {
+			abs = -abs
+		}
+		w.magnitude(plane, band, ctx, abs)
+		band, ctx = int(bands[n]), 1+btoi(abs > 1)
+		w.bit(uniformProb, v < 0)
+		if n == 16 {
+			break
+		}
+		more := n <= last
+		w.token(plane, band, ctx, 0, more)
+		if !more {
+			break
+		}
+	}
+	return 1
+}
+
+// magnitude writes the token tree branches for a non-zero absolute level.
+func (w *residualWriter) magnitude(plane, band, ctx int, abs int32) {
+	if abs == 1 {
+		w.token(plane, band, ctx, 2, false)
+		return
+	}
+	w.token(plane, band, ctx, 2, true)
+	if abs <= 4 {
+		w.token(plane, band, ctx, 3, false)
+		if abs == 2 {
+			w.token(plane, band, ctx, 4, false)
+		} else {
+			w.token(plane, band, ctx, 4, true)
+			w.token(plane, band, ctx, 5, abs == 4)
+		}
+		return
+	}
+	w.token(plane, band, ctx, 3, true)
+	if abs <= 10 {
+		w.token(plane, band, ctx, 6, false)
+		if abs <= 6 {
+			w.token(plane, band, ctx, 7, false)
+			w.bit(159, abs == 6)
+		} else {
+			w.token(plane, band, ctx, 7, true)
+			w.bit(165, (abs-7)&2 != 0)
+			w.bit(145, (abs-7)&1 != 0)
+		}
+		return
+	}
+	w.token(plane, band, ctx, 6, true)
+	cat := 3
+	switch {
+	case abs < 19:
+		cat = 0
+	case abs < 35:
+		cat = 1
+	case abs < 67:
+		cat = 2
+	}
+	w.token(plane, band, ctx, 8, cat >= 2)
+	w.token(plane, band, ctx, 9+cat>>1, cat&1 != 0)
+	extra := abs - 3 - 8<<uint(cat)
+	tab := cat3456[cat]
+	for i, p := range tab {
+		w.bit(p, (extra>>uint(len(tab)-1-i))&1 != 0)
+	}
+}
+
+// nzContext tracks which neighbouring blocks had non-zero coefficients.
+type nzContext struct {
+	y2   uint8
+	mask uint8
+}
+
+func unpack(mask uint8) [4]uint8 {
+	return [4]uint8{mask & 1, (mask >> 1) & 1, (mask >> 2) & 1, (mask >> 3) & 1}
+}
+
+func pack(nz [4]uint8, shift int) uint8 {
+	return (nz[0] | nz[1]<<1 | nz[2]<<2 | nz[3]<<3) << uint(shift)
+}
+
+// writeResiduals writes the tokens of one macroblock in decoding order.
+func (w *residualWriter) writeResiduals(mb *macroblock, left, up *nzContext) {
+	nz := w.coefficients(planeY2, int(left.y2+up.y2), &mb.levels[24], 0)
+	left.y2, up.y2 = nz, nz
+
+	lnz, unz := unpack(left.mask&0x0f), unpack(up.mask&0x0f)
+	for y := 0; y < 4; y++ {
+		nz := lnz[y]
+		for x := 0; x < 4; x++ {
+			nz = w.coefficients(planeY1WithY2, int(nz+unz[x]), &mb.levels[y*4+x], 1)
+			unz[x] = nz
+		}
+		lnz[y] = nz
+	}
+	lmask, umask := pack(lnz, 0), pack(unz, 0)
+
+	lnz, unz = unpack(left.mask>>4), unpack(up.mask>>4)
+	for c := 0; c < 4; c += 2 {
+		for y := 0; y < 2; y++ {
+			nz := lnz[y+c]
+			for x := 0; x < 2; x++ {
+				nz = w.coefficients(planeUV, int(nz+unz[x+c]), &mb.levels[16+c*2+y*2+x], 0)
+				unz[x+c] = nz
+			}
+			lnz[y+c] = nz
+		}
+	}
+	left.mask = lmask | pack(lnz, 4)
+	up.mask = umask | pack(unz, 4)
+}
+
+// writeTokens runs w over every macroblock, skipping the empty ones when
+// useSkip is set.
+func (e *vp8Encoder) writeTokens(w *residualWriter, useSkip bool) {
+	up := make([]nzContext, e.mbw)
+	for mby := 0; mby < e.mbh; mby++ {
+		var left nzContext
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			mb := &e.mbs[mby*e.mbw+mbx]
+			if useSkip && mb.isEmpty() {
+				left, up[mbx] = nzContext{}, nzContext{}
+				continue
+			}
+			w.writeResiduals(mb, &left, &up[mbx])
+		}
+	}
+}
+
+// bitCost returns the cost in bits of coding bit with probability prob.
+func bitCost(prob uint8, bit bool) float64 {
+	p := float64(prob) / 256
+	if bit {
+		p = 1 - p
+	}
+	return -math.Log2(p)
+}
+
+// updateTokenProbs adapts the token probabilities to the frame and writes the
+// updates that pay for themselves into the first partition.
+func (e *vp8Encoder) updateTokenProbs(fp *boolEncoder, stats *[nPlane][nBand][nContext][nProb][2]uint32) {
+	for i := range e.tokenProb {
+		for j := range e.tokenProb[i] {
+			for k := range e.tokenProb[i][j] {
+				for l := range e.tokenProb[i][j][k] {
+					upd := tokenProbUpdateProb[i][j][k][l]
+					c0, c1 := stats[i][j][k][l][0], stats[i][j][k][l][1]
+					update := false
+					var np uint8
+					if total := c0 + c1; total > 0 {
+						np = uint8(min(max((256*uint64(c0)+uint64(total)/2)/uint64(total), 1), 255))
+						old := float64(c0)*bitCost(e.tokenProb[i][j][k][l], false) +
+							float64(c1)*bitCost(e.tokenProb[i][j][k][l], true) + bitCost(upd, false)
+						cost := float64(c0)*bitCost(np, false) + float64(c1)*bitCost(np, true) + bitCost(upd, true) + 8
+						update = cost < old
+					}
+					fp.writeBool(upd, update)
+					if update {
+						fp.writeLiteral(uint32(np), 8)
+						e.tokenProb[i][j][k][l] = np
+					}
+				}
+			}
+		}
+	}
+}
+
+// writeFrame serializes the encoded macroblocks as a VP8 key frame.
+func (e *vp8Encoder) writeFrame() []byte {
+	skipped := 0
+	for i := range e.mbs {
+		if e.mbs[i].isEmpty() {
+			skipped++
+		}
+	}
+	useSkip := skipped > 0
+	skipProb := uint8(0xff)
+	if useSkip {
+		skipProb = uint8(min(max((255*(len(e.mbs)-skipped)+len(e.mbs)/2)/len(e.mbs), 1), 254))
+	}
+
+	var stats [nPlane][nBand][nContext][nProb][2]uint32
+	e.writeTokens(&residualWriter{stats: &stats}, useSkip)
+
+	fp := newBoolEncoder()
+	fp.writeBool(uniformProb, false) // Color space.
+	fp.writeBool(uniformProb, false) // Clamping type.
+	fp.writeBool(uniformProb, false) // Segmentation.
+	fp.writeBool(uniformProb, false) // Normal loop filter.
+	fp.writeLiteral(uint32(e.qi*5/16), 6)
+	fp.writeLiteral(0, 3)            // Sharpness.
+	fp.writeBool(uniformProb, false) // Loop filter deltas.
+	fp.writeLiteral(0, 2)            // One token partition.
+	fp.writeLiteral(uint32(e.qi), 7)
+	for i := 0; i < 5; i++ {
+		fp.writeBool(uniformProb, false) // No quantizer deltas.
+	}
+	fp.writeBool(uniformProb, false) // Refresh entropy probabilities.
+	e.updateTokenProbs(fp, &stats)
+	fp.writeBool(uniformProb, useSkip)
+	if useSkip {
+		fp.writeLiteral(uint32(skipProb), 8)
+	}
+	for i := range e.mbs {
+		mb := &e.mbs[i]
+		if useSkip {
+			fp.writeBool(skipProb, mb.isEmpty())
+		}
+		fp.writeBool(145, true) // 16x16 luma prediction.
+		switch mb.predY {
+		case predDC:
+			fp.writeBool(156, false)
+			fp.writeBool(163, false)
+		case predVE:
+			fp.writeBool(156, false)
+			fp.writeBool(163, true)
+		case predHE:
+			fp.writeBool(156, true)
+			fp.writeBool(128, false)
+		case predTM:
+			fp.writeBool(156, true)
+			fp.writeBool(128, true)
+		}
+		fp.writeBool(142, mb.predC != predDC)
+		if mb.predC != predDC {
+			fp.writeBool(114, mb.predC != predVE)
+			if mb.predC != predVE {
+				fp.writeBool(183, mb.predC == predTM)
+			}
+		}
+	}
+	first := fp.flush()
+
+	tp := newBoolEncoder()
+	e.writeTokens(&residualWriter{enc: tp, probs: &e.tokenProb}, useSkip)
+	tokens := tp.flush()
+
+	tag := uint32(len(first))<<5 | 1<<4 // Key frame, version 0, shown.
+	out := make([]byte, 0, 10+len(first)+len(tokens))
+	out = append(out, byte(tag), byte(tag>>8), byte(tag>>16))
+	out = append(out, 0x9d, 0x01, 0x2a)
+	out = append(out, byte(e.width), byte(e.width>>8), byte(e.height), byte(e.height>>8))
+	out = append(out, first...)
+	out = append(out, tokens...)
+	return out
+}
@@ -0,0 +1,159 @@
+// Package webp implements a WebP encoder.
+//
+// Lossy images are written as a VP8 key frame, with the alpha channel, if
+// any, stored losslessly in an ALPH chunk. Lossless images are written as a
+// VP8L bitstream.
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// DefaultQuality is the lossy quality used when none is given.
+const DefaultQuality = 75
+
+// Options are the encoding parameters.
+type Options struct {
+	// Lossless selects the VP8L lossless bitstream.
+	Lossless bool
+	// Quality is the lossy quality, from 1 (smallest output) to 100 (best
+	// quality). It is ignored for lossless encoding.
+	Quality int
+}
+
+// Encode writes the image m to w in WebP format.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	b := m.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 {
+		return errors.New("webp: invalid image size")
+	}
+
+	quality := DefaultQuality
+	if o != nil {
+		if o.Lossless {
+			return encodeLossless(w, m)
+		}
+		if o.Quality > 0 {
+			quality = min(o.Quality, 100)
+		}
+	}
+
+	src := toNRGBA(m)
+	frame, err := encodeVP8(src, qualityToIndex(quality))
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if !src.Opaque() {
+		alpha, err := encodeAlpha(src)
+		if err != nil {
+			return err
+		}
+		writeChunk(&body, "VP8X", extendedHeader(b.Dx(), b.Dy()))
+		writeChunk(&body, "ALPH", alpha)
+	}
+	writeChunk(&body, "VP8 ", frame)
+
+	header := make([]byte, 12)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+body.Len()))
+	copy(header[8:12], "WEBP")
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(body.Bytes())
+	return err
+}
+
+// qualityToIndex maps a quality from 1 to 100 onto the VP8 quantizer index,
+// from 127 (coarsest) to 0 (finest).
+func qualityToIndex(quality int) int {
+	return (100 - quality) * 127 / 99
+}
+
+func toNRGBA(m image.Image) *image.NRGBA {
+	if n, ok := m.(*image.NRGBA); ok {
+		return n
+	}
+	b := m.Bounds()
+	n := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(n, n.Bounds(), m, b.Min, draw.Src)
+	return n
+}
+
+// extendedHeader returns the payload of a VP8X chunk announcing an alpha
+// channel for a canvas of the given size.
+func extendedHeader(width, height int) []byte {
+	const alphaFlag = 1 << 4
+	h := make([]byte, 10)
+	h[0] = alphaFlag
+	w1, h1 := width-1, height-1
+	h[4], h[5], h[6] = byte(w1), byte(w1>>8), byte(w1>>16)
+	h[7], h[8], h[9] = byte(h1), byte(h1>>8), byte(h1>>16)
+	return h
+}
+
+// encodeAlpha returns the payload of an ALPH chunk holding the alpha channel
+// of m, compressed with the headerless VP8L bitstream.
+func encodeAlpha(m *image.NRGBA) ([]byte, error) {
+	const (
+		riffHeaderLen       = 12
+		chunkHeaderLen      = 8
+		vp8lHeaderLen       = 5
+		compressionLossless = 1
+	)
+
+	b := m.Bounds()
+	a := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			i := a.PixOffset(x, y)
+			a.Pix[i+1] = m.Pix[m.PixOffset(b.Min.X+x, b.Min.Y+y)+3]
+			a.Pix[i+3] = 0xff
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := encodeLossless(&buf, a); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if len(data) < riffHeaderLen+chunkHeaderLen+vp8lHeaderLen {
+		return nil, errors.New("webp: invalid alpha bitstream")
+	}
+
+	return append([]byte{compressionLossless}, data[riffHeaderLen+chunkHeaderLen+vp8lHeaderLen:]...), nil
+}
+
+// encodeLossless writes m to w as a lossless WebP image. The VP8L encoder
+// panics on some high-entropy images, which is reported as an error so that
+// a single image does not stop the caller.
+func encodeLossless(w io.Writer, m image.Image) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("webp: lossless encoding failed: %v", r)
+		}
+	}()
+
+	return nativewebp.Encode(w, m, nil)
+}
+
+func writeChunk(buf *bytes.Buffer, fourCC string, data []byte) {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+	buf.WriteString(fourCC)
+	buf.Write(size[:])
+	buf.Write(data)
+	if len(data)%2 != 0 {
+		buf.WriteByte(0)
+	}
+}
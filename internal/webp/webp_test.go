@@ -0,0 +1,176 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xwebp "golang.org/x/image/webp"
+)
+
+// createTestImage creates a gradient with a few sharp edges, which exercises
+// every predictor and a wide range of coefficients.
+func createTestImage(width, height int, withAlpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{
+				R: uint8(x * 255 / width),
+				G: uint8(y * 255 / height),
+				B: uint8((x + y) * 255 / (width + height)),
+				A: 255,
+			}
+			if (x/8+y/8)%5 == 0 {
+				c = color.NRGBA{R: 20, G: 200, B: 40, A: 255}
+			}
+			if withAlpha {
+				c.A = uint8(x * 255 / width)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// limitedRangeImage converts the output of the x/image decoder, which follows
+// the JFIF full range, with the BT.601 limited range that VP8 actually uses.
+type limitedRangeImage struct {
+	*image.YCbCr
+}
+
+func (m limitedRangeImage) At(x, y int) color.Color {
+	c := m.YCbCr.YCbCrAt(x, y)
+	yy := (float64(c.Y) - 16) * 255 / 219
+	cb := (float64(c.Cb) - 128) * 255 / 224
+	cr := (float64(c.Cr) - 128) * 255 / 224
+	clamp := func(v float64) uint8 {
+		return uint8(math.Max(0, math.Min(255, math.Round(v))))
+	}
+	return color.RGBA{
+		R: clamp(yy + 1.402*cr),
+		G: clamp(yy - 0.344136*cb - 0.714136*cr),
+		B: clamp(yy + 1.772*cb),
+		A: 255,
+	}
+}
+
+func psnr(t *testing.T, a image.Image, b image.Image) float64 {
+	require.Equal(t, a.Bounds().Size(), b.Bounds().Size())
+	var sum float64
+	bounds := a.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			ar, ag, ab, _ := a.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			br, bg, bb, _ := b.At(b.Bounds().Min.X+x, b.Bounds().Min.Y+y).RGBA()
+			for _, d := range []float64{float64(ar>>8) - float64(br>>8), float64(ag>>8) - float64(bg>>8), float64(ab>>8) - float64(bb>>8)} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(3*bounds.Dx()*bounds.Dy())
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+func TestEncodeLossy(t *testing.T) {
+	src := createTestImage(67, 45, false)
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, src, &Options{Quality: 90}))
+
+	decoded, err := xwebp.Decode(&buf)
+	require.NoError(t, err)
+	require.IsType(t, &image.YCbCr{}, decoded)
+	assert.Equal(t, src.Bounds().Size(), decoded.Bounds().Size())
+	assert.Greater(t, psnr(t, src, limitedRangeImage{decoded.(*image.YCbCr)}), 35.0)
+}
+
+func TestEncodeLossy_QualityAffectsSize(t *testing.T) {
+	src := createTestImage(128, 96, false)
+
+	var low, high bytes.Buffer
+	require.NoError(t, Encode(&low, src, &Options{Quality: 10}))
+	require.NoError(t, Encode(&high, src, &Options{Quality: 95}))
+
+	assert.Less(t, low.Len(), high.Len())
+}
+
+func TestEncodeLossy_WithAlpha(t *testing.T) {
+	src := createTestImage(40, 30, true)
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, src, &Options{Quality: 80}))
+
+	cfg, err := xwebp.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 40, cfg.Width)
+	assert.Equal(t, 30, cfg.Height)
+
+	decoded, err := xwebp.Decode(&buf)
+	require.NoError(t, err)
+	for _, x := range []int{0, 13, 39} {
+		_, _, _, a := decoded.At(x, 10).RGBA()
+		assert.Equal(t, uint32(src.NRGBAAt(x, 10).A), a>>8, "alpha at x=%d", x)
+	}
+}
+
+func TestEncodeLossless(t *testing.T) {
+	src := createTestImage(33, 17, false)
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, src, &Options{Lossless: true}))
+
+	decoded, err := xwebp.Decode(&buf)
+	require.NoError(t, err)
+	assert.True(t, math.IsInf(psnr(t, src, decoded), 1))
+}
+
+func TestEncode_InvalidSize(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 0)), nil)
+	assert.Error(t, err)
+}
+
+// createNoiseImage creates an image of random pixels, which the lossless
+// encoder cannot always write.
+func createNoiseImage(width, height int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rng.Read(img.Pix)
+	return img
+}
+
+func TestEncode_NoiseDoesNotPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+	}{
+		{"Lossless", &Options{Lossless: true}},
+		{"Lossy with alpha", &Options{Quality: 80}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := createNoiseImage(300, 200)
+
+			var buf bytes.Buffer
+			var err error
+			require.NotPanics(t, func() { err = Encode(&buf, src, tt.options) })
+			if err != nil {
+				assert.Contains(t, err.Error(), "webp:")
+				return
+			}
+
+			decoded, err := xwebp.Decode(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, src.Bounds().Size(), decoded.Bounds().Size())
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/jdecool/file-compressor/internal/app"
 	"github.com/jdecool/file-compressor/internal/compressor"
@@ -14,11 +15,77 @@ func main() {
 	var isVerbose bool
 	var maxWorkers int
 	var replaceOriginal bool
+	var jpegQuality int
+	var targetSSIM float64
+	var webpQuality int
+	var maxSize string
+	var minQuality int
+	var maxWidth int
+	var maxHeight int
+	var noResize bool
+	var autoOrient bool
+	var stripMetadata string
+	var metadataPolicy string
+	var privacy bool
+	var pdfPreset string
+	var pdfImageDPI int
+	var pdfImageQuality int
+	var pdfStrip string
+	var pdfAnnotations string
+	var pdfPassword string
+	var pdfPasswordFile string
+	var pdfLinearize bool
+	var pdfSubsetFonts bool
+	var pdfReduceColors bool
+	var resampleFilter string
+	var pngLossy bool
+	var pngDither bool
+	var pngMinQuality int
+	var streamFormat string
+	var streamLevel int
+	var svgPrecision int
+	var noMinify bool
+	var canonicalize bool
+	var precompress bool
+	var precompressRatio float64
 
 	flag.BoolVar(&displayHelp, "help", false, "Show help message")
 	flag.BoolVar(&isVerbose, "verbose", false, "Enable verbose output")
 	flag.IntVar(&maxWorkers, "workers", app.GetDefaultWorkersCount(), "Set maximum number of workers")
 	flag.BoolVar(&replaceOriginal, "replace", false, "Replace original file if compression results in savings")
+	flag.IntVar(&jpegQuality, "jpeg-quality", 85, "Set quality (1-100) of JPEG images")
+	flag.Float64Var(&targetSSIM, "target-ssim", 0, "Use the lowest JPEG quality reaching this SSIM (0-1) against the source, 0 to disable")
+	flag.IntVar(&webpQuality, "webp-quality", 85, "Set quality (1-100) of lossy WebP images")
+	flag.StringVar(&maxSize, "max-size", "", "Lower quality then dimensions until images fit in this size (e.g. 200KB)")
+	flag.IntVar(&minQuality, "min-quality", 40, "Set lowest quality (1-100) used to reach the maximum size")
+	flag.IntVar(&maxWidth, "max-width", 2000, "Downscale images wider than this width (0 for no limit)")
+	flag.IntVar(&maxHeight, "max-height", 2000, "Downscale images taller than this height (0 for no limit)")
+	flag.BoolVar(&noResize, "no-resize", false, "Never resize images")
+	flag.BoolVar(&autoOrient, "auto-orient", false, "Rotate images according to their EXIF orientation and reset it")
+	flag.StringVar(&stripMetadata, "strip-metadata", "", "Comma-separated metadata kinds to remove from images ("+strings.Join(compressor.MetadataKinds, ", ")+")")
+	flag.StringVar(&metadataPolicy, "metadata-policy", compressor.MetadataPolicyKeepAll, "Set metadata kept in images and PDF documents ("+strings.Join(compressor.MetadataPolicies, ", ")+")")
+	flag.BoolVar(&privacy, "privacy", false, "Remove GPS coordinates, camera serial numbers and PDF authors")
+	flag.StringVar(&pdfPreset, "pdf-preset", "", "Set PDF image resolution, JPEG quality, stream recompression and thumbnails from a preset ("+strings.Join(compressor.PdfPresets, ", ")+")")
+	flag.IntVar(&pdfImageDPI, "pdf-image-dpi", 0, "Downsample PDF images above this resolution and recompress them as JPEG (0 to disable)")
+	flag.IntVar(&pdfImageQuality, "pdf-image-quality", 75, "Set JPEG quality (1-100) of recompressed PDF images")
+	flag.BoolVar(&pdfReduceColors, "pdf-reduce-colors", false, "Convert effectively grayscale PDF images to gray, and black and white ones such as scanned text to 1-bit CCITT G4")
+	flag.BoolVar(&pdfSubsetFonts, "pdf-subset-fonts", false, "Reduce fully embedded TrueType and CFF fonts of PDF documents to the glyphs used")
+	flag.StringVar(&pdfStrip, "pdf-strip", "", "Comma-separated content to remove from PDF documents ("+strings.Join(compressor.PdfContentKinds, ", ")+")")
+	flag.StringVar(&pdfAnnotations, "pdf-annotations", compressor.AnnotationsKeep, "Keep, flatten or strip PDF annotations and form fields, links are kept ("+strings.Join(compressor.AnnotationModes, ", ")+")")
+	flag.StringVar(&pdfPassword, "pdf-password", "", "Password, user or owner, opening encrypted PDF documents")
+	flag.StringVar(&pdfPasswordFile, "pdf-password-file", "", "File of passwords, one per line, tried to open encrypted PDF documents")
+	flag.BoolVar(&pdfLinearize, "pdf-linearize", false, "Write linearized PDF documents, showing their first page before the download ends")
+	flag.StringVar(&resampleFilter, "resample-filter", "lanczos", "Set filter used to downscale images (nearest, box, linear, hermite, mitchell, catmullrom, bspline, gaussian, lanczos)")
+	flag.BoolVar(&pngLossy, "png-lossy", false, "Quantize truecolor PNG images to a 256 color palette")
+	flag.BoolVar(&pngDither, "png-dither", false, "Apply Floyd-Steinberg dithering when quantizing PNG images")
+	flag.IntVar(&pngMinQuality, "png-min-quality", 65, "Set quality (0-100) below which quantized PNG images are discarded")
+	flag.StringVar(&streamFormat, "stream-format", compressor.StreamFormatGzip, "Set format of compressed text files ("+strings.Join(compressor.StreamFormats, ", ")+")")
+	flag.IntVar(&streamLevel, "stream-level", 0, "Set compression level of text files (gzip 1-9, zstd 1-22, brotli 1-11, 0 for the format default)")
+	flag.IntVar(&svgPrecision, "svg-precision", 6, "Set significant digits (1-15) kept in SVG coordinates, 0 to keep all")
+	flag.BoolVar(&noMinify, "no-minify", false, "Never minify HTML, CSS, JavaScript, JSON and XML files, compress them as text files")
+	flag.BoolVar(&canonicalize, "canonicalize", false, "Sort keys of minified JSON objects and attributes of XML elements, dropping redundant namespace declarations")
+	flag.BoolVar(&precompress, "precompress", false, "Write .gz and .br files next to web assets (HTML, CSS, JS, SVG, JSON, XML, fonts), keeping them")
+	flag.Float64Var(&precompressRatio, "precompress-ratio", 0.9, "Write precompressed files only when smaller than this ratio (0-1) of the original size")
 	flag.Parse()
 
 	var inputPaths = flag.Args()
@@ -37,8 +104,143 @@ func main() {
 	app.SetVerboseMode(isVerbose)
 	app.SetMaxWorkers(maxWorkers)
 	app.SetReplaceOriginal(replaceOriginal)
-	app.RegisterCompressor(compressor.NewPdfCompressor())
-	app.RegisterCompressor(compressor.NewImageCompressor())
+
+	pdfCompressor := compressor.NewPdfCompressor()
+	if err := pdfCompressor.SetMetadataPolicy(metadataPolicy); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pdfCompressor.SetPrivacy(privacy)
+	if pdfPreset != "" {
+		if err := pdfCompressor.SetPreset(pdfPreset); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	// Explicit image and font settings take precedence over the preset
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pdf-image-dpi":
+			pdfCompressor.SetImageDPI(pdfImageDPI)
+		case "pdf-image-quality":
+			pdfCompressor.SetImageQuality(pdfImageQuality)
+		case "pdf-subset-fonts":
+			pdfCompressor.SetSubsetFonts(pdfSubsetFonts)
+		}
+	})
+	if pdfStrip != "" {
+		for _, kind := range strings.Split(pdfStrip, ",") {
+			if err := pdfCompressor.SetStrip(strings.TrimSpace(kind), true); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if err := pdfCompressor.SetAnnotationMode(pdfAnnotations); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var pdfPasswords []string
+	if pdfPassword != "" {
+		pdfPasswords = append(pdfPasswords, pdfPassword)
+	}
+	if pdfPasswordFile != "" {
+		passwords, err := compressor.ReadPasswordFile(pdfPasswordFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		pdfPasswords = append(pdfPasswords, passwords...)
+	}
+	pdfCompressor.SetPasswords(pdfPasswords)
+	pdfCompressor.SetLinearize(pdfLinearize)
+	pdfCompressor.SetReduceColors(pdfReduceColors)
+	app.RegisterCompressor(pdfCompressor)
+
+	imageCompressor := compressor.NewImageCompressor()
+	imageCompressor.SetJPEGQuality(jpegQuality)
+	imageCompressor.SetTargetSSIM(targetSSIM)
+	imageCompressor.SetWebPQuality(webpQuality)
+	if maxSize != "" {
+		size, err := compressor.ParseSize(maxSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		imageCompressor.SetMaxFileSize(size)
+	}
+	imageCompressor.SetMinQuality(minQuality)
+	imageCompressor.SetMaxWidth(maxWidth)
+	imageCompressor.SetMaxHeight(maxHeight)
+	imageCompressor.SetNoResize(noResize)
+	imageCompressor.SetAutoOrient(autoOrient)
+	if stripMetadata != "" {
+		for _, kind := range strings.Split(stripMetadata, ",") {
+			if err := imageCompressor.SetKeepMetadata(strings.TrimSpace(kind), false); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if err := imageCompressor.SetMetadataPolicy(metadataPolicy); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	imageCompressor.SetPrivacy(privacy)
+	if err := imageCompressor.SetResampleFilter(resampleFilter); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	imageCompressor.SetPNGLossy(pngLossy)
+	imageCompressor.SetPNGDithering(pngDither)
+	imageCompressor.SetPNGMinQuality(pngMinQuality)
+	app.RegisterCompressor(imageCompressor)
+
+	// Images embedded in Office documents and books are compressed with the image settings
+	ooxmlCompressor := compressor.NewOoxmlCompressor()
+	ooxmlCompressor.SetImageCompressor(imageCompressor)
+	app.RegisterCompressor(ooxmlCompressor)
+
+	odfCompressor := compressor.NewOdfCompressor()
+	odfCompressor.SetImageCompressor(imageCompressor)
+	app.RegisterCompressor(odfCompressor)
+
+	epubCompressor := compressor.NewEpubCompressor()
+	epubCompressor.SetImageCompressor(imageCompressor)
+	app.RegisterCompressor(epubCompressor)
+
+	svgCompressor := compressor.NewSvgCompressor()
+	svgCompressor.SetPrecision(svgPrecision)
+	app.RegisterCompressor(svgCompressor)
+
+	streamCompressor := compressor.NewStreamCompressor()
+	if err := streamCompressor.SetFormat(streamFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	streamCompressor.SetLevel(streamLevel)
+	app.RegisterCompressor(streamCompressor)
+
+	// HTML, CSS, JavaScript, JSON and XML files are minified instead of stream compressed
+	if !noMinify {
+		app.RegisterCompressor(compressor.NewMinifyCompressor())
+
+		jsonCompressor := compressor.NewJsonCompressor()
+		jsonCompressor.SetCanonicalize(canonicalize)
+		app.RegisterCompressor(jsonCompressor)
+
+		xmlCompressor := compressor.NewXmlCompressor()
+		xmlCompressor.SetCanonicalize(canonicalize)
+		app.RegisterCompressor(xmlCompressor)
+	}
+
+	// Web assets get precompressed files instead of stream compressed ones
+	if precompress {
+		precompressor := compressor.NewPrecompressor()
+		precompressor.SetMaxRatio(precompressRatio)
+		app.RegisterCompressor(precompressor)
+	}
+
 	app.Run(inputPaths)
 }
 
@@ -55,5 +257,27 @@ func printUsage() {
 	fmt.Println("  file-compressor file1.txt dir/             # Multiple paths")
 	fmt.Println("  file-compressor --verbose file.txt         # Verbose output")
 	fmt.Println("  file-compressor --replace file.txt         # Replace original if savings achieved")
+	fmt.Println("  file-compressor --webp-quality 75 dir/     # Re-encode lossy WebP images at quality 75")
+	fmt.Println("  file-compressor --max-width 1200 --jpeg-quality 75 dir/ # Web-sized images")
+	fmt.Println("  file-compressor --target-ssim 0.98 dir/    # Lowest JPEG quality keeping SSIM at 0.98")
+	fmt.Println("  file-compressor --max-size 200KB dir/      # Fit images in 200KB")
+	fmt.Println("  file-compressor --strip-metadata xmp,iptc dir/ # Remove XMP and IPTC metadata")
+	fmt.Println("  file-compressor --privacy dir/             # Remove GPS positions and serial numbers")
+	fmt.Println("  file-compressor --pdf-image-dpi 150 doc.pdf # Downsample PDF images to 150 DPI")
+	fmt.Println("  file-compressor --pdf-preset ebook doc.pdf  # Small PDF for on-screen reading")
+	fmt.Println("  file-compressor --pdf-subset-fonts doc.pdf  # Keep only the glyphs used by PDF fonts")
+	fmt.Println("  file-compressor --pdf-reduce-colors scans/  # Scanned black text as 1-bit images")
+	fmt.Println("  file-compressor --pdf-strip attachments,javascript --pdf-annotations flatten doc.pdf # Lean PDF")
+	fmt.Println("  file-compressor --pdf-password-file pw.txt dir/ # Open encrypted PDF documents")
+	fmt.Println("  file-compressor --pdf-linearize docs/       # PDF documents for fast web view")
+	fmt.Println("  file-compressor --no-resize dir/           # Keep full resolution")
+	fmt.Println("  file-compressor --png-lossy --png-dither dir/ # Quantize PNG images with dithering")
+	fmt.Println("  file-compressor --max-width 1600 --replace slides/ # Office documents with web-sized images")
+	fmt.Println("  file-compressor --jpeg-quality 75 --replace books/ # EPUB books with lighter images")
+	fmt.Println("  file-compressor --svg-precision 4 icons/   # Optimize SVG files, 4 significant digits")
+	fmt.Println("  file-compressor --replace site/            # Minify HTML, CSS and JavaScript in place")
+	fmt.Println("  file-compressor --canonicalize exports/    # Minify JSON and XML with sorted keys and attributes")
+	fmt.Println("  file-compressor --stream-format brotli --stream-level 11 logs/ # Brotli text files")
+	fmt.Println("  file-compressor --precompress dist/         # .gz and .br files next to web assets")
 	fmt.Println("  file-compressor --help                    # Show this help message")
 }